@@ -8,6 +8,7 @@ require (
 	github.com/charmbracelet/lipgloss v0.8.0
 	github.com/spf13/cobra v1.7.0
 	golang.org/x/crypto v0.14.0
+	golang.org/x/sys v0.13.0
 	golang.org/x/term v0.13.0
 )
 
@@ -30,6 +31,5 @@ require (
 	github.com/sahilm/fuzzy v0.1.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
 	golang.org/x/sync v0.1.0 // indirect
-	golang.org/x/sys v0.13.0 // indirect
 	golang.org/x/text v0.13.0 // indirect
 )
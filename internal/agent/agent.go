@@ -0,0 +1,331 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"goingenv/pkg/secret"
+)
+
+const (
+	// DefaultTTL is how long the agent keeps a key when no TTL is given
+	DefaultTTL = 15 * time.Minute
+	// SocketEnv names the environment variable that overrides the socket path
+	SocketEnv = "GOINGENV_AGENT_SOCK"
+
+	socketName = "agent.sock"
+
+	// maxRequestSize limits a single request line; requests only carry a key
+	// ID and a key
+	maxRequestSize = 4096
+	// purgeInterval is how often expired keys are wiped
+	purgeInterval = 10 * time.Second
+)
+
+// Operations understood by the agent
+const (
+	opGet    = "get"
+	opPut    = "put"
+	opLock   = "lock"
+	opStatus = "status"
+	opStop   = "stop"
+)
+
+// ErrNotRunning is returned by the client when no agent listens on the socket
+var ErrNotRunning = errors.New("key agent is not running")
+
+// request is one line sent to the agent
+type request struct {
+	Op  string `json:"op"`
+	ID  string `json:"id,omitempty"`
+	Key []byte `json:"key,omitempty"`
+}
+
+// response is the agent's answer to a request
+type response struct {
+	Key    []byte  `json:"key,omitempty"`
+	Status *Status `json:"status,omitempty"`
+	Error  string  `json:"error,omitempty"`
+}
+
+// Status describes a running agent
+type Status struct {
+	PID     int           `json:"pid"`
+	Keys    int           `json:"keys"`
+	TTL     time.Duration `json:"ttl"`
+	Started time.Time     `json:"started"`
+}
+
+// cachedKey is a key held by the agent until it expires
+type cachedKey struct {
+	key     []byte
+	expires time.Time
+}
+
+// Server holds derived keys in memory and hands them out over a Unix
+// socket. Every key is wiped once its TTL has passed, when the agent is
+// locked and when it stops.
+type Server struct {
+	ttl     time.Duration
+	started time.Time
+
+	mu       sync.Mutex
+	keys     map[string]cachedKey
+	listener net.Listener
+	done     chan struct{}
+	closed   bool
+}
+
+// NewServer creates an agent that keeps every key for ttl
+func NewServer(ttl time.Duration) *Server {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &Server{
+		ttl:     ttl,
+		started: time.Now(),
+		keys:    make(map[string]cachedKey),
+		done:    make(chan struct{}),
+	}
+}
+
+// SocketPath returns the socket the agent listens on. GOINGENV_AGENT_SOCK
+// overrides the default, which lives in $XDG_RUNTIME_DIR or, failing that,
+// in a per-user directory under the system temp directory.
+func SocketPath() string {
+	if path := os.Getenv(SocketEnv); path != "" {
+		return path
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "goingenv", socketName)
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("goingenv-%d", os.Getuid()), socketName)
+}
+
+// Listen creates the socket at path. Its directory is created readable by
+// the current user only and the socket itself gets mode 0600, so other users
+// can neither connect nor replace it. A socket left behind by an agent that
+// is no longer running is removed first.
+func Listen(path string) (net.Listener, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	if err := checkSocketDir(dir); err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Lstat(path); err == nil {
+		if _, err := NewClient(path).Status(); err == nil {
+			return nil, fmt.Errorf("a key agent is already running on %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to restrict socket permissions: %w", err)
+	}
+
+	return listener, nil
+}
+
+// Serve answers requests on listener until Close is called or a client
+// asks the agent to stop
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return listener.Close()
+	}
+	s.listener = listener
+	s.mu.Unlock()
+
+	go s.purgeExpired()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				return nil
+			default:
+				return fmt.Errorf("failed to accept connection: %w", err)
+			}
+		}
+		go s.handle(conn)
+	}
+}
+
+// Close stops the agent and wipes every key it holds
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	s.lockLocked()
+
+	if s.listener != nil {
+		return s.listener.Close()
+	}
+	return nil
+}
+
+// handle answers the requests sent over one connection
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, maxRequestSize), maxRequestSize)
+	encoder := json.NewEncoder(conn)
+
+	for scanner.Scan() {
+		var req request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			encoder.Encode(response{Error: "invalid request"})
+			return
+		}
+
+		resp := s.answer(req)
+		secret.Wipe(req.Key)
+		err := encoder.Encode(resp)
+		secret.Wipe(resp.Key)
+		if err != nil || req.Op == opStop {
+			break
+		}
+	}
+
+	if errors.Is(scanner.Err(), bufio.ErrTooLong) {
+		encoder.Encode(response{Error: "request too large"})
+	}
+}
+
+// answer carries out a single request
+func (s *Server) answer(req request) response {
+	switch req.Op {
+	case opGet:
+		if key := s.get(req.ID); key != nil {
+			return response{Key: key}
+		}
+		return response{}
+	case opPut:
+		if req.ID == "" || len(req.Key) == 0 {
+			return response{Error: "put needs an id and a key"}
+		}
+		s.put(req.ID, req.Key)
+		return response{}
+	case opLock:
+		s.mu.Lock()
+		s.lockLocked()
+		s.mu.Unlock()
+		return response{}
+	case opStatus:
+		return response{Status: s.status()}
+	case opStop:
+		go s.Close()
+		return response{}
+	default:
+		return response{Error: fmt.Sprintf("unknown operation %q", req.Op)}
+	}
+}
+
+// get returns a copy of the key cached under id, or nil if there is none or
+// it has expired
+func (s *Server) get(id string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cached, ok := s.keys[id]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(cached.expires) {
+		secret.Wipe(cached.key)
+		delete(s.keys, id)
+		return nil
+	}
+	return append([]byte(nil), cached.key...)
+}
+
+// put caches a copy of key under id for the agent's TTL
+func (s *Server) put(id string, key []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if old, ok := s.keys[id]; ok {
+		secret.Wipe(old.key)
+	}
+	s.keys[id] = cachedKey{
+		key:     append([]byte(nil), key...),
+		expires: time.Now().Add(s.ttl),
+	}
+}
+
+// status reports the agent's PID, key count and TTL
+func (s *Server) status() *Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	keys := 0
+	for _, cached := range s.keys {
+		if !now.After(cached.expires) {
+			keys++
+		}
+	}
+
+	return &Status{
+		PID:     os.Getpid(),
+		Keys:    keys,
+		TTL:     s.ttl,
+		Started: s.started,
+	}
+}
+
+// lockLocked wipes and forgets every key. The caller must hold s.mu.
+func (s *Server) lockLocked() {
+	for id, cached := range s.keys {
+		secret.Wipe(cached.key)
+		delete(s.keys, id)
+	}
+}
+
+// purgeExpired wipes expired keys until the agent stops, so a key does not
+// stay in memory just because nobody asked for it again
+func (s *Server) purgeExpired() {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for id, cached := range s.keys {
+				if now.After(cached.expires) {
+					secret.Wipe(cached.key)
+					delete(s.keys, id)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"goingenv/pkg/secret"
+)
+
+// dialTimeout bounds how long a command waits for the agent, so a hung
+// agent never blocks a command that could fall back to a password prompt
+const dialTimeout = 2 * time.Second
+
+// Client talks to a key agent. It implements types.KeyCache, so the crypto
+// service can look up and store derived keys through it. A client whose
+// agent is not running simply reports ErrNotRunning.
+type Client struct {
+	path string
+}
+
+// NewClient creates a client for the agent listening on path
+func NewClient(path string) *Client {
+	return &Client{path: path}
+}
+
+// Path returns the socket path of the agent
+func (c *Client) Path() string {
+	return c.path
+}
+
+// GetKey returns the key cached under id, or nil if the agent does not hold
+// it
+func (c *Client) GetKey(id string) ([]byte, error) {
+	resp, err := c.call(request{Op: opGet, ID: id})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Key, nil
+}
+
+// PutKey asks the agent to hold key under id until its TTL passes
+func (c *Client) PutKey(id string, key []byte) error {
+	req := request{Op: opPut, ID: id, Key: append([]byte(nil), key...)}
+	defer secret.Wipe(req.Key)
+	_, err := c.call(req)
+	return err
+}
+
+// Lock makes the agent forget every key it holds
+func (c *Client) Lock() error {
+	_, err := c.call(request{Op: opLock})
+	return err
+}
+
+// Status returns the state of the agent
+func (c *Client) Status() (*Status, error) {
+	resp, err := c.call(request{Op: opStatus})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status == nil {
+		return nil, fmt.Errorf("agent sent no status")
+	}
+	return resp.Status, nil
+}
+
+// Stop makes the agent wipe its keys and exit
+func (c *Client) Stop() error {
+	_, err := c.call(request{Op: opStop})
+	return err
+}
+
+// call sends one request over a fresh connection and reads the answer
+func (c *Client) call(req request) (*response, error) {
+	if _, err := os.Lstat(c.path); err != nil {
+		return nil, ErrNotRunning
+	}
+	if err := checkSocketDir(filepath.Dir(c.path)); err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout("unix", c.path, dialTimeout)
+	if err != nil {
+		return nil, ErrNotRunning
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	data = append(data, '\n')
+	_, err = conn.Write(data)
+	secret.Wipe(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request to key agent: %w", err)
+	}
+
+	var resp response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to read key agent response: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+
+	return &resp, nil
+}
+
+// checkSocketDir refuses a socket directory that belongs to another user or
+// that other users can write to or list, since anyone who could place their own socket there would receive
+// the keys sent to the agent
+func checkSocketDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("failed to check socket directory: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("socket directory %s is not a directory", dir)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("socket directory %s is accessible by other users (mode %04o); restrict it with: chmod 700 %s", dir, info.Mode().Perm(), dir)
+	}
+	return checkOwner(dir, info)
+}
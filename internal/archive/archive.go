@@ -2,18 +2,29 @@ package archive
 
 import (
 	"archive/tar"
+	"bufio"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"goingenv/internal/config"
+	"goingenv/internal/staging"
+	"goingenv/pkg/secret"
 	"goingenv/pkg/types"
+	"goingenv/pkg/utils"
 )
 
+// ArchiveFileMode is the permission of newly packed archives. Archives are
+// encrypted, but their size and key slots are still nobody else's business.
+const ArchiveFileMode os.FileMode = 0600
+
 // Service implements the Archiver interface
 type Service struct {
 	crypto types.Cryptor
@@ -51,31 +62,56 @@ func (s *Service) Pack(opts types.PackOptions) error {
 		Version:     "1.0.0", // You might want to make this configurable
 	}
 
-	// Create temporary file for the tar archive
-	tmpFile, err := os.CreateTemp("", "goingenv-*.tar")
+	// Write to a temporary file that only replaces the output once the
+	// archive is complete, so a crash or full disk never leaves a
+	// truncated archive behind
+	err := utils.WriteAtomic(opts.OutputPath, ArchiveFileMode, func(w io.Writer) error {
+		return s.writeArchive(w, archive, opts)
+	})
 	if err != nil {
+		if _, ok := err.(*types.ArchiveError); ok {
+			return err
+		}
 		return &types.ArchiveError{
 			Operation: "pack",
 			Path:      opts.OutputPath,
-			Err:       fmt.Errorf("failed to create temporary file: %w", err),
+			Err:       fmt.Errorf("failed to write encrypted file: %w", err),
 		}
 	}
-	defer os.Remove(tmpFile.Name())
-	defer tmpFile.Close()
 
-	// Create tar writer
-	tarWriter := tar.NewWriter(tmpFile)
-	defer tarWriter.Close()
+	return nil
+}
+
+// writeArchive encrypts the metadata into the archive index and streams the
+// tar of the files through the encryptor into w, so that memory use does not
+// depend on the archive size
+func (s *Service) writeArchive(w io.Writer, archive types.Archive, opts types.PackOptions) error {
+	metadataJSON, err := json.Marshal(archive)
+	if err != nil {
+		return &types.ArchiveError{
+			Operation: "pack",
+			Path:      opts.OutputPath,
+			Err:       fmt.Errorf("failed to marshal metadata: %w", err),
+		}
+	}
 
-	// Write metadata first
-	if err := s.writeMetadata(tarWriter, archive); err != nil {
+	encWriter, err := s.encryptTo(w, types.EncryptOptions{
+		Password:   opts.Password,
+		KeyFile:    opts.KeyFile,
+		Recipients: opts.Recipients,
+		Index:      metadataJSON,
+	}, opts.Sign)
+	if err != nil {
 		return &types.ArchiveError{
 			Operation: "pack",
 			Path:      opts.OutputPath,
-			Err:       fmt.Errorf("failed to write metadata: %w", err),
+			Err:       fmt.Errorf("failed to encrypt data: %w", err),
 		}
 	}
 
+	// Create tar writer
+	tarWriter := tar.NewWriter(encWriter)
+
 	// Write files to tar
 	for _, file := range opts.Files {
 		if err := s.writeFileToTar(tarWriter, file); err != nil {
@@ -96,70 +132,124 @@ func (s *Service) Pack(opts types.PackOptions) error {
 		}
 	}
 
-	// Read tar data
-	if _, err := tmpFile.Seek(0, 0); err != nil {
+	// Close the encryptor to write the final chunk and the signature
+	if err := encWriter.Close(); err != nil {
 		return &types.ArchiveError{
 			Operation: "pack",
 			Path:      opts.OutputPath,
-			Err:       fmt.Errorf("failed to seek to beginning: %w", err),
+			Err:       fmt.Errorf("failed to encrypt data: %w", err),
 		}
 	}
 
-	tarData, err := io.ReadAll(tmpFile)
-	if err != nil {
+	return nil
+}
+
+// Unpack decrypts and extracts files from an archive. The archive is
+// decrypted once: opts.Confirm, if set, sees the metadata from the index
+// before the files that follow it are extracted.
+func (s *Service) Unpack(opts types.UnpackOptions) error {
+	if err := opts.Select.Validate(); err != nil {
 		return &types.ArchiveError{
-			Operation: "pack",
-			Path:      opts.OutputPath,
-			Err:       fmt.Errorf("failed to read tar data: %w", err),
+			Operation: "unpack",
+			Path:      opts.ArchivePath,
+			Err:       err,
 		}
 	}
 
-	// Encrypt the data
-	encryptedData, err := s.crypto.Encrypt(tarData, opts.Password)
+	// Open encrypted file
+	archiveFile, content, _, err := s.openArchive(opts.ArchivePath, opts.Trust)
 	if err != nil {
 		return &types.ArchiveError{
-			Operation: "pack",
-			Path:      opts.OutputPath,
-			Err:       fmt.Errorf("failed to encrypt data: %w", err),
+			Operation: "unpack",
+			Path:      opts.ArchivePath,
+			Err:       err,
 		}
 	}
+	defer archiveFile.Close()
 
-	// Write encrypted data to output file
-	if err := os.WriteFile(opts.OutputPath, encryptedData, 0644); err != nil {
+	// Decrypt the data as it is read
+	archive, tarReader, tarData, err := s.openPayload(content, types.DecryptOptions{
+		Password:    opts.Password,
+		KeyFile:     opts.KeyFile,
+		RecoveryKey: opts.RecoveryKey,
+		Identities:  opts.Identities,
+		Passphrase:  opts.Passphrase,
+	})
+	if err != nil {
 		return &types.ArchiveError{
-			Operation: "pack",
-			Path:      opts.OutputPath,
-			Err:       fmt.Errorf("failed to write encrypted file: %w", err),
+			Operation: "unpack",
+			Path:      opts.ArchivePath,
+			Err:       err,
 		}
 	}
 
-	return nil
-}
+	overwrite := opts.Overwrite
+	if opts.Confirm != nil {
+		proceed, confirmed, err := opts.Confirm(archive)
+		if err != nil {
+			return err
+		}
+		if !proceed {
+			return nil
+		}
+		overwrite = overwrite || confirmed
+	}
 
-// Unpack decrypts and extracts files from an archive
-func (s *Service) Unpack(opts types.UnpackOptions) error {
-	// Read encrypted file
-	encryptedData, err := os.ReadFile(opts.ArchivePath)
-	if err != nil {
+	// Every explicitly named file must be in the archive
+	if missing := opts.Select.MissingPaths(archive.Files); len(missing) > 0 {
 		return &types.ArchiveError{
 			Operation: "unpack",
 			Path:      opts.ArchivePath,
-			Err:       fmt.Errorf("failed to read archive: %w", err),
+			Err:       fmt.Errorf("archive does not contain %s", strings.Join(missing, ", ")),
 		}
 	}
 
-	// Decrypt the data
-	tarData, err := s.crypto.Decrypt(encryptedData, opts.Password)
+	tx, err := staging.Begin(opts.TargetDir, ".goingenv-unpack-")
 	if err != nil {
 		return &types.ArchiveError{
 			Operation: "unpack",
-			Path:      opts.ArchivePath,
-			Err:       fmt.Errorf("failed to decrypt archive: %w", err),
+			Path:      opts.TargetDir,
+			Err:       err,
 		}
 	}
 
-	// Create tar reader
-	tarReader := tar.NewReader(strings.NewReader(string(tarData)))
+	// Nothing in the target changes until every file is extracted, verified
+	// and the whole archive authenticated
+	err = s.stageFiles(tx, archive, tarReader, tarData, opts.ArchivePath, opts.Select, overwrite)
+	if err == nil {
+		if err = tx.Commit(opts.Backup, opts.Journal); err != nil {
+			err = &types.ArchiveError{
+				Operation: "unpack",
+				Path:      opts.TargetDir,
+				Err:       err,
+			}
+		}
+	}
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return &types.ArchiveError{
+				Operation: "unpack",
+				Path:      opts.TargetDir,
+				Err:       fmt.Errorf("%v; rollback failed: %w", err, rollbackErr),
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// stageFiles extracts the selected files of an archive into the staging
+// directory of tx and checks each one against the size and checksum in the
+// metadata. Files that already exist are skipped unless overwrite is set.
+// Entries that are not selected are still validated, and the whole payload is
+// read, so an archive is accepted or refused the same way whatever is
+// selected.
+func (s *Service) stageFiles(tx *staging.Transaction, archive *types.Archive, tarReader *tar.Reader, tarData io.Reader, archivePath string, selection *types.FileSelection, overwrite bool) error {
+	expected := make(map[string]types.EnvFile, len(archive.Files))
+	for _, file := range archive.Files {
+		expected[path.Clean(filepath.ToSlash(file.RelativePath))] = file
+	}
 
 	for {
 		header, err := tarReader.Next()
@@ -169,47 +259,57 @@ func (s *Service) Unpack(opts types.UnpackOptions) error {
 		if err != nil {
 			return &types.ArchiveError{
 				Operation: "unpack",
-				Path:      opts.ArchivePath,
+				Path:      archivePath,
 				Err:       fmt.Errorf("failed to read tar header: %w", err),
 			}
 		}
 
-		// Skip metadata file
-		if header.Name == "metadata.json" {
-			continue
+		// Every entry must be a regular file that stays inside the target
+		// and is listed once in the metadata
+		targetPath, err := entryPath(tx.Root(), header)
+		if err == nil {
+			err = staging.CheckNoSymlinks(tx.Root(), targetPath)
 		}
-
-		targetPath := filepath.Join(opts.TargetDir, header.Name)
-
-		// Create directory if needed
-		if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+		name := path.Clean(header.Name)
+		file, listed := expected[name]
+		if err == nil && !listed {
+			err = fmt.Errorf("entry is not listed in the archive metadata")
+		}
+		if err != nil {
 			return &types.ArchiveError{
 				Operation: "unpack",
-				Path:      targetPath,
-				Err:       fmt.Errorf("failed to create directory: %w", err),
+				Path:      archivePath,
+				Err:       fmt.Errorf("refusing to extract %q: %w", header.Name, err),
 			}
 		}
+		delete(expected, name)
+
+		if !selection.Selects(name) {
+			continue
+		}
 
 		// Handle existing files
-		if _, err := os.Stat(targetPath); err == nil {
-			if !opts.Overwrite {
-				fmt.Printf("Skipping existing file: %s\n", targetPath)
-				continue
-			}
-			if opts.Backup {
-				backupPath := targetPath + ".backup"
-				if err := os.Rename(targetPath, backupPath); err != nil {
-					return &types.ArchiveError{
-						Operation: "unpack",
-						Path:      targetPath,
-						Err:       fmt.Errorf("failed to create backup: %w", err),
-					}
-				}
+		if _, err := os.Lstat(targetPath); err == nil && !overwrite {
+			fmt.Printf("Skipping existing file: %s\n", targetPath)
+			continue
+		}
+
+		if err := checkEntrySize(file, header); err != nil {
+			return &types.ArchiveError{
+				Operation: "unpack",
+				Path:      targetPath,
+				Err:       fmt.Errorf("failed to extract file: %w", err),
 			}
 		}
 
 		// Extract file
-		if err := s.extractFile(tarReader, targetPath, header); err != nil {
+		staged := tx.Stage(targetPath)
+		size, checksum, err := s.extractFile(tarReader, staged.Path, header, file.Size)
+		if err == nil {
+			err = verifyFile(file, size, checksum)
+		}
+		staged.Checksum = checksum
+		if err != nil {
 			return &types.ArchiveError{
 				Operation: "unpack",
 				Path:      targetPath,
@@ -218,71 +318,280 @@ func (s *Service) Unpack(opts types.UnpackOptions) error {
 		}
 	}
 
+	// Read to the end of the stream so a truncated archive is reported
+	if _, err := io.Copy(io.Discard, tarData); err != nil {
+		return &types.ArchiveError{
+			Operation: "unpack",
+			Path:      archivePath,
+			Err:       fmt.Errorf("failed to decrypt archive: %w", err),
+		}
+	}
+
+	if len(expected) > 0 {
+		var missing []string
+		for name := range expected {
+			missing = append(missing, name)
+		}
+		sort.Strings(missing)
+		return &types.ArchiveError{
+			Operation: "unpack",
+			Path:      archivePath,
+			Err:       fmt.Errorf("archive is missing files listed in its metadata: %s", strings.Join(missing, ", ")),
+		}
+	}
+
+	return nil
+}
+
+// verifyFile checks an extracted file against its metadata. Archives from
+// older versions may not record a checksum.
+func verifyFile(file types.EnvFile, size int64, checksum string) error {
+	if size != file.Size {
+		return fmt.Errorf("size mismatch (expected %d, got %d)", file.Size, size)
+	}
+	if file.Checksum != "" && checksum != file.Checksum {
+		return fmt.Errorf("checksum mismatch")
+	}
 	return nil
 }
 
-// List returns the contents of an archive without extracting
-func (s *Service) List(archivePath, password string) (*types.Archive, error) {
-	// Read encrypted file
-	encryptedData, err := os.ReadFile(archivePath)
+// checkEntrySize compares the size of a tar entry with its metadata before
+// anything is read. Both come from the archive, so neither is trusted alone.
+func checkEntrySize(file types.EnvFile, header *tar.Header) error {
+	if file.Size < 0 {
+		return fmt.Errorf("invalid size %d in the archive metadata", file.Size)
+	}
+	if header.Size != file.Size {
+		return fmt.Errorf("size mismatch (expected %d, got %d)", file.Size, header.Size)
+	}
+	return nil
+}
+
+// wipingBuffer collects plaintext like bytes.Buffer, but wipes the old
+// contents whenever it grows, so no copies are left behind for the garbage
+// collector
+type wipingBuffer struct {
+	data []byte
+}
+
+func (b *wipingBuffer) Write(p []byte) (int, error) {
+	if len(b.data)+len(p) > cap(b.data) {
+		grown := make([]byte, len(b.data), 2*cap(b.data)+len(p))
+		copy(grown, b.data)
+		secret.Wipe(b.data)
+		b.data = grown
+	}
+	b.data = append(b.data, p...)
+	return len(p), nil
+}
+
+// Bytes returns the data written so far
+func (b *wipingBuffer) Bytes() []byte {
+	return b.data
+}
+
+// List returns the contents of an archive without extracting. Only the
+// header and the index are decrypted, so listing takes the same time however
+// large the archive is.
+func (s *Service) List(opts types.ListOptions) (*types.Archive, error) {
+	// Open encrypted file
+	archiveFile, content, _, err := s.openArchive(opts.ArchivePath, opts.Trust)
 	if err != nil {
 		return nil, &types.ArchiveError{
 			Operation: "list",
-			Path:      archivePath,
-			Err:       fmt.Errorf("failed to read archive: %w", err),
+			Path:      opts.ArchivePath,
+			Err:       err,
 		}
 	}
+	defer archiveFile.Close()
 
-	// Decrypt the data
-	tarData, err := s.crypto.Decrypt(encryptedData, password)
+	archive, _, _, err := s.openPayload(content, types.DecryptOptions{
+		Password:    opts.Password,
+		KeyFile:     opts.KeyFile,
+		RecoveryKey: opts.RecoveryKey,
+		Identities:  opts.Identities,
+		Passphrase:  opts.Passphrase,
+	})
 	if err != nil {
 		return nil, &types.ArchiveError{
 			Operation: "list",
-			Path:      archivePath,
-			Err:       fmt.Errorf("failed to decrypt archive: %w", err),
+			Path:      opts.ArchivePath,
+			Err:       err,
 		}
 	}
 
-	// Create tar reader
-	tarReader := tar.NewReader(strings.NewReader(string(tarData)))
+	return archive, nil
+}
 
-	// Read metadata (should be first entry)
-	header, err := tarReader.Next()
+// ReadFiles decrypts the selected files of an archive into memory without
+// writing anything to disk. The contents are keyed by relative path with
+// forward slashes and checked against the metadata like an unpack; they are
+// plaintext, so callers should wipe them with secret.Wipe when done.
+func (s *Service) ReadFiles(opts types.ReadOptions) (*types.Archive, map[string][]byte, error) {
+	if err := opts.Select.Validate(); err != nil {
+		return nil, nil, &types.ArchiveError{
+			Operation: "read",
+			Path:      opts.ArchivePath,
+			Err:       err,
+		}
+	}
+
+	// Open encrypted file
+	archiveFile, content, _, err := s.openArchive(opts.ArchivePath, opts.Trust)
 	if err != nil {
-		return nil, &types.ArchiveError{
-			Operation: "list",
-			Path:      archivePath,
-			Err:       fmt.Errorf("failed to read metadata: %w", err),
+		return nil, nil, &types.ArchiveError{
+			Operation: "read",
+			Path:      opts.ArchivePath,
+			Err:       err,
 		}
 	}
+	defer archiveFile.Close()
 
-	if header.Name != "metadata.json" {
-		return nil, &types.ArchiveError{
-			Operation: "list",
-			Path:      archivePath,
-			Err:       fmt.Errorf("invalid archive format: missing metadata"),
+	archive, tarReader, tarData, err := s.openPayload(content, types.DecryptOptions{
+		Password:    opts.Password,
+		KeyFile:     opts.KeyFile,
+		RecoveryKey: opts.RecoveryKey,
+		Identities:  opts.Identities,
+		Passphrase:  opts.Passphrase,
+	})
+	if err != nil {
+		return nil, nil, &types.ArchiveError{
+			Operation: "read",
+			Path:      opts.ArchivePath,
+			Err:       err,
+		}
+	}
+
+	// Every explicitly named file must be in the archive
+	if missing := opts.Select.MissingPaths(archive.Files); len(missing) > 0 {
+		return nil, nil, &types.ArchiveError{
+			Operation: "read",
+			Path:      opts.ArchivePath,
+			Err:       fmt.Errorf("archive does not contain %s", strings.Join(missing, ", ")),
 		}
 	}
 
-	metadataBytes, err := io.ReadAll(tarReader)
+	contents, err := s.readFiles(archive, tarReader, tarData, opts.Select)
 	if err != nil {
-		return nil, &types.ArchiveError{
-			Operation: "list",
-			Path:      archivePath,
-			Err:       fmt.Errorf("failed to read metadata: %w", err),
+		for _, data := range contents {
+			secret.Wipe(data)
+		}
+		return nil, nil, &types.ArchiveError{
+			Operation: "read",
+			Path:      opts.ArchivePath,
+			Err:       err,
 		}
 	}
 
-	var archive types.Archive
-	if err := json.Unmarshal(metadataBytes, &archive); err != nil {
-		return nil, &types.ArchiveError{
-			Operation: "list",
-			Path:      archivePath,
-			Err:       fmt.Errorf("failed to unmarshal metadata: %w", err),
+	return archive, contents, nil
+}
+
+// readFiles reads the selected files of an archive into memory, validating
+// every entry the way stageFiles does
+func (s *Service) readFiles(archive *types.Archive, tarReader *tar.Reader, tarData io.Reader, selection *types.FileSelection) (map[string][]byte, error) {
+	expected := make(map[string]types.EnvFile, len(archive.Files))
+	for _, file := range archive.Files {
+		expected[path.Clean(filepath.ToSlash(file.RelativePath))] = file
+	}
+
+	contents := make(map[string][]byte)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return contents, fmt.Errorf("failed to read tar header: %w", err)
+		}
+
+		// The same entries are refused as by unpack, although nothing is
+		// written
+		_, err = entryPath(".", header)
+		name := path.Clean(header.Name)
+		file, listed := expected[name]
+		if err == nil && !listed {
+			err = fmt.Errorf("entry is not listed in the archive metadata")
+		}
+		if err != nil {
+			return contents, fmt.Errorf("refusing to read %q: %w", header.Name, err)
+		}
+		delete(expected, name)
+
+		if !selection.Selects(name) {
+			continue
+		}
+
+		// Read at most one byte more than the metadata promises, so a
+		// mismatch is caught without reading an oversized entry. The sizes
+		// come from the archive, so the buffer grows as data arrives rather
+		// than being reserved up front.
+		if err := checkEntrySize(file, header); err != nil {
+			return contents, fmt.Errorf("failed to read file %s: %w", name, err)
+		}
+		data := &wipingBuffer{}
+		hash := sha256.New()
+		size, err := io.Copy(io.MultiWriter(data, hash), io.LimitReader(tarReader, file.Size+1))
+		contents[name] = data.Bytes()
+		if err == nil {
+			err = verifyFile(file, size, fmt.Sprintf("%x", hash.Sum(nil)))
+		}
+		if err != nil {
+			return contents, fmt.Errorf("failed to read file %s: %w", name, err)
+		}
+	}
+
+	// Read to the end of the stream so a truncated archive is reported
+	if _, err := io.Copy(io.Discard, tarData); err != nil {
+		return contents, fmt.Errorf("failed to decrypt archive: %w", err)
+	}
+
+	if len(expected) > 0 {
+		var missing []string
+		for name := range expected {
+			missing = append(missing, name)
+		}
+		sort.Strings(missing)
+		return contents, fmt.Errorf("archive is missing files listed in its metadata: %s", strings.Join(missing, ", "))
+	}
+
+	return contents, nil
+}
+
+// openPayload unlocks an archive and returns its metadata, a tar reader
+// positioned at the first file, and the decrypted payload under it. The
+// metadata comes from the index segment; archives written before it existed
+// keep it in a metadata.json entry at the start of the payload instead. The
+// payload is only decrypted as the tar reader is used.
+func (s *Service) openPayload(content io.Reader, opts types.DecryptOptions) (*types.Archive, *tar.Reader, io.Reader, error) {
+	index, tarData, err := s.crypto.DecryptIndex(content, opts)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to decrypt archive: %w", err)
+	}
+
+	// Create tar reader
+	tarReader := tar.NewReader(tarData)
+
+	if len(index) == 0 {
+		// Read metadata (first entry of archives without an index)
+		header, err := tarReader.Next()
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to read metadata: %w", err)
+		}
+		if header.Name != "metadata.json" {
+			return nil, nil, nil, fmt.Errorf("invalid archive format: missing metadata")
+		}
+		index, err = io.ReadAll(tarReader)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to read metadata: %w", err)
 		}
 	}
 
-	return &archive, nil
+	var archive types.Archive
+	if err := json.Unmarshal(index, &archive); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to unmarshal metadata: %w", err)
+	}
+
+	return &archive, tarReader, tarData, nil
 }
 
 // GetAvailableArchives returns a list of available archive files
@@ -311,30 +620,249 @@ func (s *Service) GetAvailableArchives(dir string) ([]string, error) {
 	return archives, nil
 }
 
-// writeMetadata writes archive metadata to tar
-func (s *Service) writeMetadata(tarWriter *tar.Writer, archive types.Archive) error {
-	metadataJSON, err := json.Marshal(archive)
+// ReadHeader returns the encoded encryption header of an archive, without
+// reading or decrypting the payload that follows it
+func (s *Service) ReadHeader(archivePath string) ([]byte, error) {
+	archiveFile, err := os.Open(archivePath)
 	if err != nil {
-		return fmt.Errorf("failed to marshal metadata: %w", err)
+		return nil, &types.ArchiveError{
+			Operation: "read header",
+			Path:      archivePath,
+			Err:       fmt.Errorf("failed to read archive: %w", err),
+		}
 	}
+	defer archiveFile.Close()
 
-	header := &tar.Header{
-		Name: "metadata.json",
-		Mode: 0644,
-		Size: int64(len(metadataJSON)),
+	header, err := s.crypto.ReadHeader(bufio.NewReader(archiveFile))
+	if err != nil {
+		return nil, &types.ArchiveError{
+			Operation: "read header",
+			Path:      archivePath,
+			Err:       err,
+		}
 	}
 
-	if err := tarWriter.WriteHeader(header); err != nil {
-		return fmt.Errorf("failed to write metadata header: %w", err)
+	return header, nil
+}
+
+// ReplaceHeader rewrites an archive with a new encryption header in front of
+// its unchanged payload. The new file is written next to the archive and
+// renamed over it, so the archive is never left half-written. Any signature
+// no longer matches the new header and is dropped, unless sign selects a key
+// to sign the rewritten archive with. trust, if set, is checked before the
+// payload is copied.
+func (s *Service) ReplaceHeader(archivePath string, header []byte, sign types.SignOptions, trust types.TrustFunc) error {
+	archiveFile, content, _, err := s.openArchive(archivePath, trust)
+	if err != nil {
+		return &types.ArchiveError{
+			Operation: "replace header",
+			Path:      archivePath,
+			Err:       err,
+		}
+	}
+	defer archiveFile.Close()
+
+	info, err := archiveFile.Stat()
+	if err != nil {
+		return &types.ArchiveError{
+			Operation: "replace header",
+			Path:      archivePath,
+			Err:       err,
+		}
+	}
+
+	// Skip the old header; the payload is copied as-is
+	payload := bufio.NewReader(content)
+	if _, err := s.crypto.ReadHeader(payload); err != nil {
+		return &types.ArchiveError{
+			Operation: "replace header",
+			Path:      archivePath,
+			Err:       err,
+		}
+	}
+
+	err = utils.WriteAtomic(archivePath, info.Mode().Perm(), func(w io.Writer) error {
+		out, err := s.signTo(w, sign)
+		if err != nil {
+			return err
+		}
+		if _, err := out.Write(header); err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, payload); err != nil {
+			return err
+		}
+		return out.Close()
+	})
+	if err != nil {
+		return &types.ArchiveError{
+			Operation: "replace header",
+			Path:      archivePath,
+			Err:       fmt.Errorf("failed to write archive: %w", err),
+		}
+	}
+
+	return nil
+}
+
+// Rekey re-encrypts an archive under a new random key for the new password,
+// key file and recipients. The decrypted index and payload are passed through
+// unchanged, so CreatedAt, Description and the file list are kept.
+// The archive is only replaced once the whole payload has been authenticated
+// and re-encrypted.
+func (s *Service) Rekey(opts types.RekeyOptions) error {
+	archiveFile, content, _, err := s.openArchive(opts.ArchivePath, opts.Trust)
+	if err != nil {
+		return &types.ArchiveError{
+			Operation: "rekey",
+			Path:      opts.ArchivePath,
+			Err:       err,
+		}
+	}
+	defer archiveFile.Close()
+
+	info, err := archiveFile.Stat()
+	if err != nil {
+		return &types.ArchiveError{
+			Operation: "rekey",
+			Path:      opts.ArchivePath,
+			Err:       err,
+		}
 	}
 
-	if _, err := tarWriter.Write(metadataJSON); err != nil {
-		return fmt.Errorf("failed to write metadata: %w", err)
+	index, payload, err := s.crypto.DecryptIndex(content, types.DecryptOptions{
+		Password:   opts.Password,
+		KeyFile:    opts.KeyFile,
+		Identities: opts.Identities,
+		Passphrase: opts.Passphrase,
+	})
+	if err != nil {
+		return &types.ArchiveError{
+			Operation: "rekey",
+			Path:      opts.ArchivePath,
+			Err:       fmt.Errorf("failed to decrypt archive: %w", err),
+		}
+	}
+
+	err = utils.WriteAtomic(opts.ArchivePath, info.Mode().Perm(), func(w io.Writer) error {
+		encWriter, err := s.encryptTo(w, types.EncryptOptions{
+			Password:   opts.NewPassword,
+			KeyFile:    opts.NewKeyFile,
+			Recipients: opts.Recipients,
+			Index:      index,
+		}, opts.Sign)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(encWriter, payload); err != nil {
+			return err
+		}
+		return encWriter.Close()
+	})
+	if err != nil {
+		return &types.ArchiveError{
+			Operation: "rekey",
+			Path:      opts.ArchivePath,
+			Err:       fmt.Errorf("failed to re-encrypt archive: %w", err),
+		}
 	}
 
 	return nil
 }
 
+// VerifySignature verifies the signature of an archive and returns its
+// signer, or nil if the archive is not signed. An archive whose signature
+// does not match its contents is an error.
+func (s *Service) VerifySignature(archivePath string) (*types.SignatureInfo, error) {
+	archiveFile, _, signer, err := s.openArchive(archivePath, nil)
+	if err != nil {
+		return nil, &types.ArchiveError{
+			Operation: "verify signature",
+			Path:      archivePath,
+			Err:       err,
+		}
+	}
+	archiveFile.Close()
+
+	return signer, nil
+}
+
+// openArchive opens an archive and verifies its signature, if any, then
+// checks the signer with trust unless it is nil. It returns the file, a
+// reader of the encrypted content without the signature trailer, and the
+// signer. The caller must close the file.
+func (s *Service) openArchive(archivePath string, trust types.TrustFunc) (*os.File, io.Reader, *types.SignatureInfo, error) {
+	archiveFile, err := os.Open(archivePath)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	info, err := archiveFile.Stat()
+	if err != nil {
+		archiveFile.Close()
+		return nil, nil, nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	signer, contentSize, err := s.crypto.ReadSignature(archiveFile, info.Size())
+	if err != nil {
+		archiveFile.Close()
+		return nil, nil, nil, err
+	}
+	if trust != nil {
+		if err := trust(signer); err != nil {
+			archiveFile.Close()
+			return nil, nil, nil, err
+		}
+	}
+
+	return archiveFile, io.NewSectionReader(archiveFile, 0, contentSize), signer, nil
+}
+
+// encryptTo returns a writer that encrypts into w and, if sign selects a key,
+// signs the encrypted archive. Closing it finishes both.
+func (s *Service) encryptTo(w io.Writer, enc types.EncryptOptions, sign types.SignOptions) (io.WriteCloser, error) {
+	signed, err := s.signTo(w, sign)
+	if err != nil {
+		return nil, err
+	}
+
+	encWriter, err := s.crypto.EncryptStream(signed, enc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &chainWriter{WriteCloser: encWriter, next: signed}, nil
+}
+
+// signTo returns a writer that signs everything written to w when sign
+// selects a key, and otherwise passes it through unchanged
+func (s *Service) signTo(w io.Writer, sign types.SignOptions) (io.WriteCloser, error) {
+	if sign.KeyPath == "" {
+		return nopWriteCloser{w}, nil
+	}
+	return s.crypto.SignStream(w, sign)
+}
+
+// chainWriter closes a second writer after the first
+type chainWriter struct {
+	io.WriteCloser
+	next io.Closer
+}
+
+func (c *chainWriter) Close() error {
+	if err := c.WriteCloser.Close(); err != nil {
+		return err
+	}
+	return c.next.Close()
+}
+
+// nopWriteCloser adds a no-op Close to an io.Writer
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
 // writeFileToTar writes a file to the tar archive
 func (s *Service) writeFileToTar(tarWriter *tar.Writer, file types.EnvFile) error {
 	fileInfo, err := os.Stat(file.Path)
@@ -343,7 +871,7 @@ func (s *Service) writeFileToTar(tarWriter *tar.Writer, file types.EnvFile) erro
 	}
 
 	header := &tar.Header{
-		Name:    file.RelativePath,
+		Name:    filepath.ToSlash(file.RelativePath),
 		Mode:    int64(fileInfo.Mode()),
 		Size:    fileInfo.Size(),
 		ModTime: fileInfo.ModTime(),
@@ -366,26 +894,35 @@ func (s *Service) writeFileToTar(tarWriter *tar.Writer, file types.EnvFile) erro
 	return nil
 }
 
-// extractFile extracts a single file from tar to the filesystem
-func (s *Service) extractFile(tarReader *tar.Reader, targetPath string, header *tar.Header) error {
-	file, err := os.Create(targetPath)
+// extractFile extracts a single file from tar to the filesystem and returns
+// its size and SHA-256 checksum. At most one byte more than the size in the
+// metadata is written, so an oversized entry cannot fill the disk before it
+// is caught.
+func (s *Service) extractFile(tarReader *tar.Reader, targetPath string, header *tar.Header, size int64) (int64, string, error) {
+	file, err := os.OpenFile(targetPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
 	if err != nil {
-		return fmt.Errorf("failed to create file %s: %w", targetPath, err)
+		return 0, "", fmt.Errorf("failed to create file %s: %w", targetPath, err)
 	}
 	defer file.Close()
 
-	if _, err := io.Copy(file, tarReader); err != nil {
-		return fmt.Errorf("failed to extract file %s: %w", targetPath, err)
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(file, hash), io.LimitReader(tarReader, size+1))
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to extract file %s: %w", targetPath, err)
+	}
+	if written > size {
+		return 0, "", fmt.Errorf("failed to extract file %s: larger than the %d bytes in the archive metadata", targetPath, size)
 	}
 
-	// Set file permissions and modification time
-	if err := os.Chmod(targetPath, os.FileMode(header.Mode)); err != nil {
-		return fmt.Errorf("failed to set permissions: %w", err)
+	// Set file permissions, without setuid, setgid or sticky bits, and
+	// modification time
+	if err := os.Chmod(targetPath, os.FileMode(header.Mode).Perm()); err != nil {
+		return 0, "", fmt.Errorf("failed to set permissions: %w", err)
 	}
 
 	if err := os.Chtimes(targetPath, time.Now(), header.ModTime); err != nil {
-		return fmt.Errorf("failed to set modification time: %w", err)
+		return 0, "", fmt.Errorf("failed to set modification time: %w", err)
 	}
 
-	return nil
+	return written, fmt.Sprintf("%x", hash.Sum(nil)), nil
 }
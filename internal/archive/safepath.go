@@ -0,0 +1,67 @@
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"goingenv/internal/config"
+)
+
+// reservedDirs are directories no entry may be written into, at any depth:
+// the goingenv directory holds the trusted signers, recipients, backups and
+// journal, and a git directory holds hooks that git would run
+var reservedDirs = []string{config.GetGoingEnvDir(), ".git"}
+
+// entryPath validates a tar entry and returns the path it is extracted to
+// under root. Archives only ever hold regular files with relative paths, so
+// anything else is refused: a crafted archive could otherwise use "..",
+// absolute paths, symlinks or hardlinks to write outside root, create
+// devices and FIFOs, or replace files in reservedDirs.
+func entryPath(root string, header *tar.Header) (string, error) {
+	switch header.Typeflag {
+	case tar.TypeReg:
+		// tar.Reader reports the old TypeRegA as TypeReg
+	case tar.TypeSymlink:
+		return "", fmt.Errorf("symbolic link to %q is not allowed", header.Linkname)
+	case tar.TypeLink:
+		return "", fmt.Errorf("hard link to %q is not allowed", header.Linkname)
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		return "", fmt.Errorf("device and FIFO entries are not allowed")
+	case tar.TypeDir:
+		return "", fmt.Errorf("directory entries are not allowed")
+	default:
+		return "", fmt.Errorf("entry type %q is not allowed", header.Typeflag)
+	}
+
+	name := header.Name
+	if name == "" || strings.ContainsRune(name, 0) {
+		return "", fmt.Errorf("invalid entry name")
+	}
+
+	// Check both separators, so names are judged the same on every platform
+	if strings.HasPrefix(name, "/") || strings.HasPrefix(name, `\`) ||
+		filepath.IsAbs(name) || filepath.VolumeName(filepath.FromSlash(name)) != "" {
+		return "", fmt.Errorf("absolute path is not allowed")
+	}
+	for _, element := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if element == ".." {
+			return "", fmt.Errorf("path with .. is not allowed")
+		}
+		// Case-insensitive file systems open .GIT as .git
+		for _, dir := range reservedDirs {
+			if strings.EqualFold(element, dir) {
+				return "", fmt.Errorf("path inside %s is not allowed", dir)
+			}
+		}
+	}
+
+	target := filepath.Join(root, filepath.FromSlash(name))
+	rel, err := filepath.Rel(root, target)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path leaves the target directory")
+	}
+
+	return target, nil
+}
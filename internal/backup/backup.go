@@ -0,0 +1,448 @@
+// Package backup keeps copies of the files that unpacking and restoring
+// replace, in snapshots under .goingenv/backups.
+//
+// Each snapshot is a directory named after the time it was taken. It mirrors
+// the relative paths of the saved files under files/ and describes them in
+// manifest.json:
+//
+//	.goingenv/backups/20260102-150405/manifest.json
+//	.goingenv/backups/20260102-150405/files/.env
+//	.goingenv/backups/20260102-150405/files/api/.env
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"goingenv/internal/config"
+	"goingenv/internal/staging"
+	"goingenv/pkg/types"
+	"goingenv/pkg/utils"
+)
+
+const (
+	// ManifestName is the name of the manifest file in a snapshot
+	ManifestName = "manifest.json"
+	// filesDir is the directory in a snapshot that mirrors the saved files
+	filesDir = "files"
+	// idFormat is the time layout of snapshot IDs
+	idFormat = "20060102-150405"
+)
+
+// Manifest describes a snapshot
+type Manifest struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Source    string    `json:"source"`     // what replaced the files, e.g. the archive unpacked
+	TargetDir string    `json:"target_dir"` // absolute directory the relative paths are in
+	Files     []File    `json:"files"`
+}
+
+// File is a file saved in a snapshot. Path is the original location.
+type File struct {
+	types.EnvFile
+	Mode os.FileMode `json:"mode"`
+}
+
+// Store manages the snapshots in a directory
+type Store struct {
+	dir string
+}
+
+// NewStore creates a store for the snapshots in dir
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// DefaultDir returns the backup directory of the current project
+func DefaultDir() string {
+	return filepath.Join(config.GetGoingEnvDir(), "backups")
+}
+
+// Dir returns the directory of the store
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// NewSnapshot starts a snapshot of files about to be replaced by source.
+// Nothing is written until the first file is added.
+func (s *Store) NewSnapshot(source string) *Snapshot {
+	return &Snapshot{store: s, source: source}
+}
+
+// List returns the manifests of all snapshots, oldest first. Directories
+// without a manifest, such as a snapshot interrupted by a crash, are left out.
+func (s *Store) List() ([]*Manifest, error) {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup directory: %w", err)
+	}
+
+	var manifests []*Manifest
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		manifest, err := s.Load(entry.Name())
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, manifest)
+	}
+
+	sort.Slice(manifests, func(i, j int) bool {
+		if !manifests[i].CreatedAt.Equal(manifests[j].CreatedAt) {
+			return manifests[i].CreatedAt.Before(manifests[j].CreatedAt)
+		}
+		return manifests[i].ID < manifests[j].ID
+	})
+	return manifests, nil
+}
+
+// Load returns the manifest of the snapshot with the given ID
+func (s *Store) Load(id string) (*Manifest, error) {
+	if id == "" || filepath.Base(id) != id || id == "." || id == ".." {
+		return nil, fmt.Errorf("invalid backup ID %q", id)
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dir, id, ManifestName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("backup %s not found: %w", id, err)
+		}
+		return nil, fmt.Errorf("failed to read backup %s: %w", id, err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest of backup %s: %w", id, err)
+	}
+	manifest.ID = id
+
+	return &manifest, nil
+}
+
+// Restore copies files of the snapshot with the given ID back to where they
+// were taken from, or every file if paths is empty. All selected copies are
+// checked against their checksums before anything is written, and are then
+// written like an unpack: staged next to their targets, refused if a path
+// leads through a symbolic link, and moved into place together or not at
+// all. Files that would be replaced are first saved in snapshot, if it is not
+// nil, so that the restore itself can be undone.
+func (s *Store) Restore(id string, paths []string, snapshot *Snapshot) ([]File, error) {
+	manifest, err := s.Load(id)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := selectFiles(manifest.Files, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		if !filepath.IsLocal(filepath.FromSlash(file.RelativePath)) {
+			return nil, fmt.Errorf("backup %s lists unsafe path %q", id, file.RelativePath)
+		}
+		checksum, err := utils.CalculateFileChecksum(s.filePath(id, file.RelativePath))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read backup of %s: %w", file.RelativePath, err)
+		}
+		if checksum != file.Checksum {
+			return nil, fmt.Errorf("backup of %s is corrupted: checksum mismatch", file.RelativePath)
+		}
+	}
+
+	tx, err := staging.Begin(manifest.TargetDir, ".goingenv-restore-")
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.stageFiles(tx, id, files)
+	if err == nil {
+		// A nil *Snapshot must not become a non-nil interface
+		var backup types.BackupSnapshot
+		if snapshot != nil {
+			backup = snapshot
+		}
+		err = tx.Commit(backup, nil)
+	}
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("%v; rollback failed: %w", err, rollbackErr)
+		}
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// stageFiles copies the saved files of a snapshot into the staging directory
+// of tx, checking each copy against its checksum again
+func (s *Store) stageFiles(tx *staging.Transaction, id string, files []File) error {
+	for _, file := range files {
+		target := filepath.Join(tx.Root(), filepath.FromSlash(file.RelativePath))
+		if err := staging.CheckNoSymlinks(tx.Root(), target); err != nil {
+			return fmt.Errorf("refusing to restore %s: %w", target, err)
+		}
+
+		staged := tx.Stage(target)
+		_, checksum, err := copyFile(s.filePath(id, file.RelativePath), staged.Path, file.Mode.Perm())
+		if err == nil && checksum != file.Checksum {
+			err = fmt.Errorf("checksum mismatch")
+		}
+		if err == nil {
+			err = os.Chtimes(staged.Path, time.Now(), file.ModTime)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restore %s: %w", target, err)
+		}
+		staged.Checksum = checksum
+	}
+	return nil
+}
+
+// Prune removes every snapshot except the keep newest ones, and with
+// olderThan set also those taken more than olderThan before now. It returns
+// the manifests of the removed snapshots.
+func (s *Store) Prune(keep int, olderThan time.Duration, now time.Time) ([]*Manifest, error) {
+	manifests, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []*Manifest
+	for i, manifest := range manifests {
+		tooMany := i < len(manifests)-keep
+		tooOld := olderThan > 0 && now.Sub(manifest.CreatedAt) > olderThan
+		if !tooMany && !tooOld {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(s.dir, manifest.ID)); err != nil {
+			return removed, fmt.Errorf("failed to remove backup %s: %w", manifest.ID, err)
+		}
+		removed = append(removed, manifest)
+	}
+
+	return removed, nil
+}
+
+// filePath returns where a file of a snapshot is stored
+func (s *Store) filePath(id, relativePath string) string {
+	return filepath.Join(s.dir, id, filesDir, filepath.FromSlash(relativePath))
+}
+
+// Snapshot collects copies of files before they are replaced. It implements
+// types.BackupSnapshot.
+type Snapshot struct {
+	store     *Store
+	source    string
+	id        string
+	createdAt time.Time
+	targetDir string
+	files     []File
+	committed bool
+}
+
+// ID returns the ID of the snapshot, or "" if no file was added
+func (b *Snapshot) ID() string {
+	return b.id
+}
+
+// Files returns the files saved in the snapshot
+func (b *Snapshot) Files() []File {
+	return b.files
+}
+
+// Add copies the file at relativePath under root into the snapshot. All
+// files of a snapshot must be under the same root.
+func (b *Snapshot) Add(root, relativePath string) error {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+	if b.id == "" {
+		if err := b.create(); err != nil {
+			return err
+		}
+		b.targetDir = root
+	} else if root != b.targetDir {
+		return fmt.Errorf("snapshot is of %s, not %s", b.targetDir, root)
+	}
+
+	source := filepath.Join(root, relativePath)
+	info, err := os.Lstat(source)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", source)
+	}
+
+	relativePath = filepath.ToSlash(relativePath)
+	dest := b.store.filePath(b.id, relativePath)
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	size, checksum, err := copyFile(source, dest, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	b.files = append(b.files, File{
+		EnvFile: types.EnvFile{
+			Path:         source,
+			RelativePath: relativePath,
+			Size:         size,
+			ModTime:      info.ModTime(),
+			Checksum:     checksum,
+		},
+		Mode: info.Mode().Perm(),
+	})
+	return nil
+}
+
+// Commit writes the manifest, which makes the snapshot visible. A snapshot
+// without files is not written at all.
+func (b *Snapshot) Commit() error {
+	if b.id == "" || b.committed {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(Manifest{
+		ID:        b.id,
+		CreatedAt: b.createdAt,
+		Source:    b.source,
+		TargetDir: b.targetDir,
+		Files:     b.files,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	if err := utils.WriteFileAtomic(filepath.Join(b.store.dir, b.id, ManifestName), data, 0600); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	b.committed = true
+	return nil
+}
+
+// Discard removes the snapshot, committed or not
+func (b *Snapshot) Discard() error {
+	if b.id == "" {
+		return nil
+	}
+	if err := os.RemoveAll(filepath.Join(b.store.dir, b.id)); err != nil {
+		return fmt.Errorf("failed to remove backup %s: %w", b.id, err)
+	}
+	b.id, b.files, b.committed = "", nil, false
+	return nil
+}
+
+// create makes the snapshot directory, named after the current time with a
+// counter added if a snapshot was already taken in the same second
+func (b *Snapshot) create() error {
+	if err := os.MkdirAll(b.store.dir, 0700); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	// Backups hold plaintext secrets and must never be committed
+	gitignore := filepath.Join(b.store.dir, ".gitignore")
+	if _, err := os.Stat(gitignore); os.IsNotExist(err) {
+		if err := utils.WriteFileAtomic(gitignore, []byte("# Backups hold plaintext environment files\n*\n"), 0644); err != nil {
+			return fmt.Errorf("failed to create .gitignore: %w", err)
+		}
+	}
+
+	b.createdAt = time.Now()
+	base := b.createdAt.Format(idFormat)
+	for n := 1; ; n++ {
+		id := base
+		if n > 1 {
+			id = fmt.Sprintf("%s-%d", base, n)
+		}
+		err := os.Mkdir(filepath.Join(b.store.dir, id), 0700)
+		if err == nil {
+			b.id = id
+			return nil
+		}
+		if !os.IsExist(err) {
+			return fmt.Errorf("failed to create backup: %w", err)
+		}
+	}
+}
+
+// selectFiles returns the files named in paths, or all files if paths is
+// empty
+func selectFiles(files []File, paths []string) ([]File, error) {
+	if len(paths) == 0 {
+		return files, nil
+	}
+
+	selection := &types.FileSelection{Paths: paths}
+	if missing := selection.MissingPaths(fileList(files)); len(missing) > 0 {
+		return nil, fmt.Errorf("backup does not contain %v", missing)
+	}
+
+	var selected []File
+	for _, file := range files {
+		if selection.Selects(file.RelativePath) {
+			selected = append(selected, file)
+		}
+	}
+	return selected, nil
+}
+
+// fileList returns the EnvFiles of files
+func fileList(files []File) []types.EnvFile {
+	list := make([]types.EnvFile, len(files))
+	for i, file := range files {
+		list[i] = file.EnvFile
+	}
+	return list
+}
+
+// copyFile copies source to a new file dest with the given permissions and
+// returns its size and SHA-256 checksum
+func copyFile(source, dest string, perm os.FileMode) (int64, string, error) {
+	in, err := os.Open(source)
+	if err != nil {
+		return 0, "", err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return 0, "", err
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(out, hash), in)
+	if err == nil {
+		err = out.Chmod(perm)
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
+	return size, fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
@@ -0,0 +1,225 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"goingenv/internal/agent"
+)
+
+// agentStartTimeout is how long 'agent start' waits for the agent to answer
+const agentStartTimeout = 3 * time.Second
+
+// newAgentCommand creates the agent command and its subcommands
+func newAgentCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "agent",
+		Short: "Cache archive keys so passwords are not asked for every command",
+		Long: `Run a key agent that keeps the keys derived from your passwords and key files
+in memory, so unpack, list and the interactive mode only ask for the password
+of an archive once.
+
+The agent listens on a Unix socket that only your user can open. Each key is
+forgotten when its TTL runs out, when you run 'goingenv agent lock' and when
+the agent stops; the agent never writes keys to disk. While a key is cached,
+anyone who can run commands as your user can open that archive without the
+password, so lock the agent before leaving your machine unattended.
+
+The socket lives in $XDG_RUNTIME_DIR/goingenv, or in a per-user directory in
+the system temp directory. Set GOINGENV_AGENT_SOCK to use another path; its
+directory must not be accessible by other users.
+
+Examples:
+  goingenv agent start              # Start in the background, keys expire after 15m
+  goingenv agent start --ttl 1h
+  goingenv agent status
+  goingenv agent lock               # Forget all cached keys
+  goingenv agent stop`,
+	}
+
+	cmd.AddCommand(newAgentStartCommand())
+	cmd.AddCommand(newAgentServeCommand())
+	cmd.AddCommand(newAgentStatusCommand())
+	cmd.AddCommand(newAgentLockCommand())
+	cmd.AddCommand(newAgentStopCommand())
+
+	return cmd
+}
+
+// newAgentStartCommand creates the agent start command
+func newAgentStartCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "start",
+		Short: "Start the key agent in the background",
+		RunE:  runAgentStartCommand,
+	}
+
+	cmd.Flags().Duration("ttl", agent.DefaultTTL, "How long each key is kept")
+
+	return cmd
+}
+
+// newAgentServeCommand creates the agent serve command
+func newAgentServeCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Run the key agent in the foreground",
+		Long: `Run the key agent in the foreground until it is stopped, interrupted or
+terminated. Use this to run the agent under a service manager; 'goingenv agent
+start' runs it in the background.`,
+		RunE: runAgentServeCommand,
+	}
+
+	cmd.Flags().Duration("ttl", agent.DefaultTTL, "How long each key is kept")
+
+	return cmd
+}
+
+// newAgentStatusCommand creates the agent status command
+func newAgentStatusCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "Show whether the key agent is running",
+		RunE:  runAgentStatusCommand,
+	}
+}
+
+// newAgentLockCommand creates the agent lock command
+func newAgentLockCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "lock",
+		Short: "Make the key agent forget all cached keys",
+		RunE:  runAgentLockCommand,
+	}
+}
+
+// newAgentStopCommand creates the agent stop command
+func newAgentStopCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "stop",
+		Short: "Forget all cached keys and stop the key agent",
+		RunE:  runAgentStopCommand,
+	}
+}
+
+// runAgentStartCommand starts 'goingenv agent serve' in the background and
+// waits until it answers
+func runAgentStartCommand(cmd *cobra.Command, args []string) error {
+	ttl, _ := cmd.Flags().GetDuration("ttl")
+	if ttl <= 0 {
+		return fmt.Errorf("--ttl must be positive")
+	}
+
+	client := agent.NewClient(agent.SocketPath())
+	if status, err := client.Status(); err == nil {
+		return fmt.Errorf("key agent is already running (pid %d)", status.PID)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find goingenv executable: %w", err)
+	}
+
+	// Standard streams are left unset, so they are connected to the null
+	// device and the agent keeps running after this terminal closes
+	serve := exec.Command(executable, "agent", "serve", "--ttl", ttl.String())
+	if err := serve.Start(); err != nil {
+		return fmt.Errorf("failed to start key agent: %w", err)
+	}
+	pid := serve.Process.Pid
+	serve.Process.Release()
+
+	deadline := time.Now().Add(agentStartTimeout)
+	for {
+		if _, err := client.Status(); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("key agent (pid %d) did not start; run 'goingenv agent serve' to see why", pid)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	fmt.Printf("✅ Key agent started (pid %d)\n", pid)
+	fmt.Printf("Socket: %s\n", client.Path())
+	fmt.Printf("Keys expire after %s; run 'goingenv agent lock' to forget them sooner.\n", ttl)
+
+	return nil
+}
+
+// runAgentServeCommand runs the agent until it is stopped or signalled
+func runAgentServeCommand(cmd *cobra.Command, args []string) error {
+	ttl, _ := cmd.Flags().GetDuration("ttl")
+	if ttl <= 0 {
+		return fmt.Errorf("--ttl must be positive")
+	}
+
+	socketPath := agent.SocketPath()
+	listener, err := agent.Listen(socketPath)
+	if err != nil {
+		return err
+	}
+
+	server := agent.NewServer(ttl)
+
+	// Keep running when the terminal that started the agent closes, and
+	// wipe the keys on interrupt or termination
+	signal.Ignore(syscall.SIGHUP)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		server.Close()
+	}()
+
+	fmt.Printf("Key agent listening on %s (keys expire after %s)\n", socketPath, ttl)
+
+	return server.Serve(listener)
+}
+
+// runAgentStatusCommand prints the state of the agent
+func runAgentStatusCommand(cmd *cobra.Command, args []string) error {
+	client := agent.NewClient(agent.SocketPath())
+	status, err := client.Status()
+	if errors.Is(err, agent.ErrNotRunning) {
+		fmt.Println("Key agent is not running")
+		fmt.Println("Start it with: goingenv agent start")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Key agent is running (pid %d)\n", status.PID)
+	fmt.Printf("Socket: %s\n", client.Path())
+	fmt.Printf("Started: %s\n", status.Started.Format("2006-01-02 15:04:05"))
+	fmt.Printf("Key TTL: %s\n", status.TTL)
+	fmt.Printf("Cached keys: %d\n", status.Keys)
+
+	return nil
+}
+
+// runAgentLockCommand makes the agent forget every key
+func runAgentLockCommand(cmd *cobra.Command, args []string) error {
+	if err := agent.NewClient(agent.SocketPath()).Lock(); err != nil {
+		return err
+	}
+	fmt.Println("🔒 Key agent locked; all cached keys were wiped")
+	return nil
+}
+
+// runAgentStopCommand stops the agent
+func runAgentStopCommand(cmd *cobra.Command, args []string) error {
+	if err := agent.NewClient(agent.SocketPath()).Stop(); err != nil {
+		return err
+	}
+	fmt.Println("Key agent stopped")
+	return nil
+}
@@ -0,0 +1,224 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"goingenv/internal/backup"
+	"goingenv/internal/config"
+	"goingenv/pkg/types"
+	"goingenv/pkg/utils"
+)
+
+// newBackupsCommand creates the backups command and its subcommands
+func newBackupsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "backups",
+		Short: "List, restore and prune backups of replaced files",
+		Long: `Manage the backups that 'unpack --backup' and 'backups restore' take of the
+files they replace.
+
+Every backup is a snapshot in .goingenv/backups/<timestamp>/ that mirrors the
+relative paths of the saved files and lists them in manifest.json. Each unpack
+takes a new snapshot, so several unpacks in a row can be undone one by one.
+Backups hold plaintext environment files; they are created readable by you
+only and ignored by git.
+
+Examples:
+  goingenv backups list
+  goingenv backups restore latest
+  goingenv backups restore 20260102-150405 api/.env
+  goingenv backups prune --keep 5
+  goingenv backups prune --older-than 720h`,
+	}
+
+	cmd.AddCommand(newBackupsListCommand())
+	cmd.AddCommand(newBackupsRestoreCommand())
+	cmd.AddCommand(newBackupsPruneCommand())
+
+	return cmd
+}
+
+// newBackupsListCommand creates the backups list command
+func newBackupsListCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List backups, oldest first",
+		RunE:  runBackupsListCommand,
+	}
+
+	cmd.Flags().BoolP("verbose", "v", false, "List the files in each backup")
+
+	return cmd
+}
+
+// newBackupsRestoreCommand creates the backups restore command
+func newBackupsRestoreCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "restore <id|latest> [files...]",
+		Short: "Restore the files of a backup",
+		Long: `Copy the files of a backup back to where they were taken from, or only
+the files named after the backup ID. Every copy is checked against the
+checksum in the manifest before anything is written.
+
+The files a restore replaces are saved in a new backup first, so a restore
+can be undone like an unpack. Use --no-backup to skip this.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: runBackupsRestoreCommand,
+	}
+
+	cmd.Flags().Bool("no-backup", false, "Do not save the files that are replaced")
+
+	return cmd
+}
+
+// newBackupsPruneCommand creates the backups prune command
+func newBackupsPruneCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Remove old backups",
+		Long: `Remove every backup except the newest ones, and with --older-than also the
+backups taken longer ago than the given duration, however many are left.`,
+		RunE: runBackupsPruneCommand,
+	}
+
+	cmd.Flags().Int("keep", 10, "Number of newest backups to keep")
+	cmd.Flags().Duration("older-than", 0, "Also remove backups older than this, e.g. 720h for 30 days")
+
+	return cmd
+}
+
+// runBackupsListCommand executes the backups list command
+func runBackupsListCommand(cmd *cobra.Command, args []string) error {
+	store, err := setupBackupsCommand()
+	if err != nil {
+		return err
+	}
+	verbose, _ := cmd.Flags().GetBool("verbose")
+
+	manifests, err := store.List()
+	if err != nil {
+		return err
+	}
+	if len(manifests) == 0 {
+		fmt.Printf("No backups in %s\n", store.Dir())
+		return nil
+	}
+
+	fmt.Printf("Backups in %s:\n\n", store.Dir())
+	fmt.Printf("%-20s %-19s %5s  %s\n", "ID", "Created", "Files", "Source")
+	for _, manifest := range manifests {
+		fmt.Printf("%-20s %-19s %5d  %s\n",
+			manifest.ID,
+			manifest.CreatedAt.Format("2006-01-02 15:04:05"),
+			len(manifest.Files),
+			manifest.Source)
+
+		if verbose {
+			for _, file := range manifest.Files {
+				fmt.Printf("  • %s (%s)\n", file.RelativePath, utils.FormatSize(file.Size))
+			}
+			fmt.Printf("  Target: %s\n", manifest.TargetDir)
+		}
+	}
+
+	return nil
+}
+
+// runBackupsRestoreCommand executes the backups restore command
+func runBackupsRestoreCommand(cmd *cobra.Command, args []string) error {
+	store, err := setupBackupsCommand()
+	if err != nil {
+		return err
+	}
+	noBackup, _ := cmd.Flags().GetBool("no-backup")
+
+	id := args[0]
+	if id == "latest" {
+		manifests, err := store.List()
+		if err != nil {
+			return err
+		}
+		if len(manifests) == 0 {
+			return fmt.Errorf("no backups in %s", store.Dir())
+		}
+		id = manifests[len(manifests)-1].ID
+	}
+
+	var snapshot *backup.Snapshot
+	if !noBackup {
+		snapshot = store.NewSnapshot("restore of " + id)
+	}
+
+	files, err := store.Restore(id, args[1:], snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to restore backup: %w", err)
+	}
+
+	fmt.Printf("✅ Restored %d files from backup %s\n", len(files), id)
+	for _, file := range files {
+		fmt.Printf("  • %s\n", file.RelativePath)
+	}
+	if snapshot != nil && snapshot.ID() != "" {
+		displayBackupSnapshot(snapshot)
+	}
+
+	return nil
+}
+
+// runBackupsPruneCommand executes the backups prune command
+func runBackupsPruneCommand(cmd *cobra.Command, args []string) error {
+	store, err := setupBackupsCommand()
+	if err != nil {
+		return err
+	}
+	keep, _ := cmd.Flags().GetInt("keep")
+	olderThan, _ := cmd.Flags().GetDuration("older-than")
+	if keep < 0 {
+		return fmt.Errorf("--keep must not be negative")
+	}
+
+	removed, err := store.Prune(keep, olderThan, time.Now())
+	for _, manifest := range removed {
+		fmt.Printf("  • Removed %s (%d files, %s)\n", manifest.ID, len(manifest.Files), manifest.Source)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ Removed %d backups\n", len(removed))
+	return nil
+}
+
+// Helper functions
+
+// setupBackupsCommand checks that the project is initialized and returns
+// its backup store
+func setupBackupsCommand() (*backup.Store, error) {
+	if !config.IsInitialized() {
+		return nil, fmt.Errorf("goingenv is not initialized in this directory. Run 'goingenv init' first")
+	}
+	return backup.NewStore(backup.DefaultDir()), nil
+}
+
+// newBackupSnapshot sets opts to save the files it replaces in a new backup
+// and returns the backup, if enabled is set
+func newBackupSnapshot(opts *types.UnpackOptions, enabled bool) *backup.Snapshot {
+	if !enabled {
+		return nil
+	}
+	snapshot := backup.NewStore(backup.DefaultDir()).NewSnapshot("unpack of " + opts.ArchivePath)
+	opts.Backup = snapshot
+	return snapshot
+}
+
+// displayBackupSnapshot reports the backup of the files an operation
+// replaced, if it saved any
+func displayBackupSnapshot(snapshot *backup.Snapshot) {
+	if snapshot == nil || snapshot.ID() == "" {
+		return
+	}
+	fmt.Printf("📋 Saved %d replaced files in backup %s\n", len(snapshot.Files()), snapshot.ID())
+}
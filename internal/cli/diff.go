@@ -0,0 +1,266 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/spf13/cobra"
+
+	"goingenv/internal/config"
+	"goingenv/pkg/dotenv"
+	"goingenv/pkg/password"
+	"goingenv/pkg/secret"
+	"goingenv/pkg/types"
+)
+
+// maskedValue is shown in place of values unless --show-values is given
+const maskedValue = "********"
+
+// newDiffCommand creates the diff command
+func newDiffCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "diff [files...]",
+		Short: "Compare the environment files with an archive, key by key",
+		Long: `Show which variables an unpack would change.
+
+The archive is decrypted in memory and nothing is written. Each file in it is
+parsed as a dotenv file and compared with the file of the same name in the
+target directory (default: current directory):
+
+  + KEY   is only in the archive and would be added
+  - KEY   is only in the working file and would be lost with --overwrite
+  ~ KEY   has a different value in the archive
+
+Values are masked unless --show-values is given. Files that cannot be parsed
+are only reported as different. Files named as arguments, or matching
+--include, are compared and all others skipped, as with unpack.
+
+Examples:
+  goingenv diff                                  # Compare with the most recent archive
+  goingenv diff -f backup-prod.enc .env          # Compare one file
+  goingenv diff --show-values                    # Print the values that differ
+  goingenv diff --identity ~/.ssh/id_ed25519     # Decrypt with an SSH key`,
+		RunE: runDiffCommand,
+	}
+
+	cmd.Flags().String("password-env", "", "Read password from environment variable")
+	cmd.Flags().String("key-file", "", "Key file to decrypt with, alone or together with the password")
+	cmd.Flags().StringSlice("identity", nil, "Identity file (goingenv or OpenSSH ed25519 key) to decrypt with instead of a password (repeatable)")
+	cmd.Flags().StringP("file", "f", "", "Archive file to compare with (default: most recent)")
+	cmd.Flags().StringP("target", "t", "", "Directory to compare (default: current directory)")
+	cmd.Flags().Bool("show-values", false, "Show the values of changed variables instead of masking them")
+	cmd.Flags().StringSliceP("include", "i", nil, "Only compare files matching these patterns")
+	cmd.Flags().StringSliceP("exclude", "e", nil, "Skip files matching these patterns")
+	cmd.Flags().Bool("allow-untrusted", false, "Open archives that are unsigned or not signed by a trusted signer, with a warning")
+
+	return cmd
+}
+
+// runDiffCommand executes the diff command
+func runDiffCommand(cmd *cobra.Command, args []string) error {
+	// Check if GoingEnv is initialized
+	if !config.IsInitialized() {
+		return fmt.Errorf("goingenv is not initialized in this directory. Run 'goingenv init' first")
+	}
+
+	// Initialize application
+	app, err := NewApp()
+	if err != nil {
+		return fmt.Errorf("failed to initialize application: %w", err)
+	}
+
+	// Parse flags
+	archiveFile, _ := cmd.Flags().GetString("file")
+	if archiveFile == "" {
+		// Find the most recent archive
+		archives, err := app.Archiver.GetAvailableArchives("")
+		if err != nil {
+			return fmt.Errorf("failed to find archives: %w", err)
+		}
+		if len(archives) == 0 {
+			return fmt.Errorf("no archives found in %s directory. Use -f flag to specify an archive", config.GetGoingEnvDir())
+		}
+		archiveFile = archives[len(archives)-1] // Use the last one (most recent)
+		fmt.Printf("Using most recent archive: %s\n", filepath.Base(archiveFile))
+	}
+
+	// Verify archive exists
+	if _, err := os.Stat(archiveFile); os.IsNotExist(err) {
+		return fmt.Errorf("archive file not found: %s", archiveFile)
+	}
+
+	// Check the signature before asking for any password
+	allowUntrusted, _ := cmd.Flags().GetBool("allow-untrusted")
+	trust, err := checkArchiveSignature(app, archiveFile, allowUntrusted)
+	if err != nil {
+		return err
+	}
+
+	passwordEnv, _ := cmd.Flags().GetString("password-env")
+	keyFilePath, _ := cmd.Flags().GetString("key-file")
+	identities, _ := cmd.Flags().GetStringSlice("identity")
+	targetDir, _ := cmd.Flags().GetString("target")
+	if targetDir == "" {
+		targetDir = "."
+	}
+	showValues, _ := cmd.Flags().GetBool("show-values")
+	includePatterns, _ := cmd.Flags().GetStringSlice("include")
+	excludePatterns, _ := cmd.Flags().GetStringSlice("exclude")
+
+	var selection *types.FileSelection
+	if len(args) > 0 || len(includePatterns) > 0 || len(excludePatterns) > 0 {
+		selection = &types.FileSelection{
+			Paths:   args,
+			Include: includePatterns,
+			Exclude: excludePatterns,
+		}
+		if err := selection.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// Get password using secure methods, unless identities or a key file are given
+	passwordOpts := password.Options{PasswordEnv: passwordEnv, KeyFile: keyFilePath}
+	keyFile, err := getKeyFile(passwordOpts)
+	if err != nil {
+		return err
+	}
+	defer password.ClearKeyFile(keyFile)
+
+	key, err := getDecryptPassword(app, passwordOpts, identities, archiveFile)
+	if err != nil {
+		return err
+	}
+
+	// Ensure password is cleared from memory when done
+	defer key.Destroy()
+
+	fmt.Printf("Reading archive: %s\n", filepath.Base(archiveFile))
+	archive, contents, err := app.Archiver.ReadFiles(types.ReadOptions{
+		ArchivePath: archiveFile,
+		Password:    key,
+		KeyFile:     keyFile,
+		Identities:  identities,
+		Passphrase:  newPassphrasePrompt(),
+		Select:      selection,
+		Trust:       trust,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+	defer func() {
+		for _, data := range contents {
+			secret.Wipe(data)
+		}
+	}()
+
+	fmt.Printf("Comparing %s with %s\n\n", filepath.Base(archiveFile), targetDir)
+
+	var names []string
+	for name := range contents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var differing, unchanged int
+	counts := make(map[string]int)
+	for _, name := range names {
+		same, err := diffEnvFile(name, contents[name], targetDir, showValues, counts)
+		if err != nil {
+			return err
+		}
+		if same {
+			unchanged++
+			continue
+		}
+		differing++
+	}
+
+	// Summary
+	if differing == 0 {
+		fmt.Printf("✅ All %d files match the archive\n", unchanged)
+		return nil
+	}
+	fmt.Printf("%d of %d files differ: %d variables added, %d removed, %d changed\n",
+		differing, len(names), counts[dotenv.Added], counts[dotenv.Removed], counts[dotenv.Changed])
+	if skipped := len(archive.Files) - len(names); skipped > 0 {
+		fmt.Printf("%d files in the archive were not compared\n", skipped)
+	}
+
+	return nil
+}
+
+// Helper functions
+
+// diffEnvFile compares one archived file with the working file of the same
+// name, prints the variables that differ and adds them to counts by kind, and
+// reports whether the files are identical. The parsed values are wiped before
+// it returns.
+func diffEnvFile(name string, archived []byte, targetDir string, showValues bool, counts map[string]int) (bool, error) {
+	working, err := os.ReadFile(filepath.Join(targetDir, filepath.FromSlash(name)))
+	if err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	defer secret.Wipe(working)
+
+	if err == nil && bytes.Equal(working, archived) {
+		return true, nil
+	}
+
+	newVars, newErr := dotenv.Parse(archived)
+	defer dotenv.Wipe(newVars)
+	oldVars := map[string][]byte{}
+	var oldErr error
+	if err == nil {
+		oldVars, oldErr = dotenv.Parse(working)
+		defer dotenv.Wipe(oldVars)
+	}
+
+	if err != nil {
+		fmt.Printf("📄 %s (only in the archive, would be created)\n", name)
+	} else {
+		fmt.Printf("📄 %s\n", name)
+	}
+
+	if newErr != nil || oldErr != nil {
+		parseErr := newErr
+		side := "archived"
+		if parseErr == nil {
+			parseErr, side = oldErr, "working"
+		}
+		fmt.Printf("  Contents differ; the %s file is not a dotenv file (%v)\n\n", side, parseErr)
+		return false, nil
+	}
+
+	changes := dotenv.Diff(oldVars, newVars)
+	for _, change := range changes {
+		counts[change.Kind]++
+		switch change.Kind {
+		case dotenv.Added:
+			fmt.Printf("  + %s = %s\n", change.Key, displayEnvValue(change.New, showValues))
+		case dotenv.Removed:
+			fmt.Printf("  - %s = %s\n", change.Key, displayEnvValue(change.Old, showValues))
+		case dotenv.Changed:
+			fmt.Printf("  ~ %s: %s → %s\n", change.Key,
+				displayEnvValue(change.Old, showValues), displayEnvValue(change.New, showValues))
+		}
+	}
+	if len(changes) == 0 {
+		fmt.Printf("  Same variables; only formatting or comments differ\n")
+	}
+	fmt.Println()
+
+	return false, nil
+}
+
+// displayEnvValue quotes a value, or masks it unless show is set. Empty
+// values are shown either way, since being unset is rarely a secret.
+func displayEnvValue(value []byte, show bool) string {
+	if show || len(value) == 0 {
+		return fmt.Sprintf("%q", value)
+	}
+	return maskedValue
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"goingenv/internal/config"
+	"goingenv/pkg/utils"
 )
 
 // newInitCommand creates the init command
@@ -117,7 +118,7 @@ func ensureProjectGitignore() error {
 	content += "\n# goingenv directory\n.goingenv/\n"
 
 	// Write back to .gitignore
-	if err := os.WriteFile(gitignorePath, []byte(content), 0644); err != nil {
+	if err := utils.WriteFileAtomic(gitignorePath, []byte(content), 0644); err != nil {
 		return fmt.Errorf("failed to write .gitignore: %w", err)
 	}
 
@@ -0,0 +1,150 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"goingenv/internal/crypto"
+	"goingenv/pkg/types"
+)
+
+// newKDFCommand creates the kdf command and its subcommands
+func newKDFCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "kdf",
+		Short: "Tune the key derivation function to this machine",
+		Long: `Tools for the key derivation function (KDF) that turns passwords into
+archive keys. The KDF is deliberately slow so that guessing passwords is
+expensive; how slow depends on the parameters and on the machine.`,
+	}
+
+	cmd.AddCommand(newKDFBenchmarkCommand())
+
+	return cmd
+}
+
+// newKDFBenchmarkCommand creates the kdf benchmark command
+func newKDFBenchmarkCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "benchmark",
+		Short: "Time the KDFs and suggest parameters for a target unlock time",
+		Long: `Time the supported KDFs on this machine and suggest parameters that make
+unlocking an archive take about the target time.
+
+PBKDF2 is tuned by its iteration count. Argon2id keeps its memory cost and
+parallelism and is tuned by its number of passes; if a single pass already
+takes longer than the target, memory is reduced instead, down to 19 MiB.
+Suggestions never go below these minimums.
+
+With --save the suggestion for the configured KDF (or the one given with
+--kdf) is written to the kdf section of ~/.goingenv.json, and archives packed
+afterwards use it. Existing archives keep the parameters in their header.
+
+Tune on the slowest machine that has to open the archives: a phone or CI
+runner can take several times longer than a developer workstation.
+
+Examples:
+  goingenv kdf benchmark                        # Both KDFs, 500ms target
+  goingenv kdf benchmark --target 1s --save
+  goingenv kdf benchmark --kdf argon2id --memory 256 --save`,
+		RunE: runKDFBenchmarkCommand,
+	}
+
+	cmd.Flags().Duration("target", 500*time.Millisecond, "Target time to derive one key")
+	cmd.Flags().String("kdf", "", "Only benchmark this KDF (argon2id or pbkdf2)")
+	cmd.Flags().Uint32("memory", 0, "Argon2id memory cost in MiB (default: configured value)")
+	cmd.Flags().Bool("save", false, "Save the suggested parameters to the configuration")
+
+	return cmd
+}
+
+// runKDFBenchmarkCommand executes the kdf benchmark command
+func runKDFBenchmarkCommand(cmd *cobra.Command, args []string) error {
+	target, _ := cmd.Flags().GetDuration("target")
+	kdfName, _ := cmd.Flags().GetString("kdf")
+	memoryMiB, _ := cmd.Flags().GetUint32("memory")
+	save, _ := cmd.Flags().GetBool("save")
+
+	if target <= 0 {
+		return fmt.Errorf("--target must be positive")
+	}
+
+	app, err := NewApp()
+	if err != nil {
+		return fmt.Errorf("failed to initialize application: %w", err)
+	}
+
+	configuredKDF, configuredParams, err := crypto.KDFFromConfig(app.Config.KDF)
+	if err != nil {
+		return fmt.Errorf("invalid kdf configuration: %w", err)
+	}
+
+	kdfs := []crypto.KDFID{crypto.KDFArgon2id, crypto.KDFPBKDF2SHA256}
+	selected := configuredKDF
+	if kdfName != "" {
+		if selected, err = crypto.ParseKDFName(kdfName); err != nil {
+			return fmt.Errorf("invalid --kdf value: %w", err)
+		}
+		kdfs = []crypto.KDFID{selected}
+	}
+
+	fmt.Printf("Calibrating for %v per key on this machine...\n", target)
+
+	var suggested crypto.KDFParams
+	for _, kdf := range kdfs {
+		base := crypto.DefaultKDFParams(kdf)
+		fmt.Printf("\n%s\n", kdf)
+		if kdf == configuredKDF {
+			base = configuredParams
+			elapsed, err := crypto.MeasureKDF(kdf, configuredParams)
+			if err != nil {
+				return fmt.Errorf("failed to time %s: %w", kdf, err)
+			}
+			fmt.Printf("  Configured: %-30s %v\n", configuredParams.String(kdf), elapsed.Round(time.Millisecond))
+		}
+		if memoryMiB > 0 {
+			base.Memory = memoryMiB * 1024
+		}
+
+		params, elapsed, err := crypto.CalibrateKDF(kdf, base, target)
+		if err != nil {
+			return fmt.Errorf("failed to calibrate %s: %w", kdf, err)
+		}
+		fmt.Printf("  Suggested:  %-30s %v\n", params.String(kdf), elapsed.Round(time.Millisecond))
+
+		if kdf == selected {
+			suggested = params
+		}
+	}
+
+	if !save {
+		fmt.Println("\nRun with --save to use the suggested parameters for new archives.")
+		return nil
+	}
+
+	app.Config.KDF = kdfConfig(selected, suggested)
+	if err := app.ConfigMgr.Save(app.Config); err != nil {
+		return fmt.Errorf("failed to save configuration: %w", err)
+	}
+	fmt.Printf("\n✅ New archives will use %s with %s\n", selected, suggested.String(selected))
+
+	return nil
+}
+
+// kdfConfig converts a KDF and its parameters to their configuration form
+func kdfConfig(kdf crypto.KDFID, params crypto.KDFParams) types.KDFConfig {
+	if kdf == crypto.KDFArgon2id {
+		return types.KDFConfig{
+			Algorithm:   crypto.KDFNameArgon2id,
+			Time:        params.Time,
+			Memory:      params.Memory,
+			Parallelism: params.Parallelism,
+		}
+	}
+	return types.KDFConfig{
+		Algorithm:  crypto.KDFNamePBKDF2,
+		Iterations: params.Iterations,
+	}
+}
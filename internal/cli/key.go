@@ -0,0 +1,381 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"goingenv/internal/config"
+	"goingenv/internal/crypto"
+	"goingenv/pkg/password"
+	"goingenv/pkg/secret"
+	"goingenv/pkg/types"
+)
+
+// newKeyCommand creates the key command and its subcommands
+func newKeyCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "key",
+		Short: "Manage the key slots of an archive",
+		Long: `Manage the passwords and recipients that can open an existing archive.
+
+Every archive is encrypted with a random key that is stored in one or more key
+slots in the archive header, each wrapped by a different password or
+recipient public key. Adding or removing a slot only rewrites the header; the
+encrypted files are not touched and no one else's password is needed. A
+changed header no longer matches the archive's signature, so pass --sign to
+sign the updated archive again. The signature is checked against
+.goingenv/trusted_signers first, as for unpack, so archives from untrusted
+signers are not changed unless --allow-untrusted is given.
+
+Removing a slot stops its password from opening the archive file from now on,
+but anyone who already decrypted the archive (or kept an old copy of it) still
+has access to its contents.
+
+Examples:
+  goingenv key list -f .goingenv/team.enc
+  goingenv key add -f .goingenv/team.enc
+  goingenv key add -f .goingenv/team.enc --new-key-file ci.key
+  goingenv key add -f .goingenv/team.enc --recipient x25519:...
+  goingenv key remove -f .goingenv/team.enc --slot 1`,
+	}
+
+	cmd.AddCommand(newKeyListCommand())
+	cmd.AddCommand(newKeyAddCommand())
+	cmd.AddCommand(newKeyRemoveCommand())
+
+	return cmd
+}
+
+// newKeyListCommand creates the key list command
+func newKeyListCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the key slots of an archive",
+		Long: `List the key slots of an archive. Key slots are stored unencrypted in the
+archive header, so no password is needed.`,
+		RunE: runKeyListCommand,
+	}
+
+	cmd.Flags().StringP("file", "f", "", "Archive file (required)")
+
+	return cmd
+}
+
+// newKeyAddCommand creates the key add command
+func newKeyAddCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "add",
+		Short: "Add a password or recipient to an archive",
+		Long: `Add a key slot to an archive. The archive is unlocked with an existing
+password, key file or identity, then a slot for the new password is added.
+With --recipient, slots for the given public keys are added instead.
+
+With --new-key-file, the new slot is opened by that key file alone, or by the
+key file together with a password if --new-password-env is also given.`,
+		RunE: runKeyAddCommand,
+	}
+
+	cmd.Flags().StringP("file", "f", "", "Archive file (required)")
+	cmd.Flags().String("password-env", "", "Read an existing password from environment variable")
+	cmd.Flags().String("key-file", "", "Existing key file to unlock the archive with")
+	cmd.Flags().StringSlice("identity", nil, "Identity file (goingenv or OpenSSH ed25519 key) to unlock the archive with instead of a password (repeatable)")
+	cmd.Flags().String("new-password-env", "", "Read the new password from environment variable")
+	cmd.Flags().String("new-key-file", "", "Add a slot for this key file")
+	cmd.Flags().StringSlice("recipient", nil, "Add a slot for this recipient public key instead of a password (repeatable)")
+	cmd.Flags().String("sign", "", "Sign the updated archive with this OpenSSH ed25519 private key")
+	cmd.Flags().Bool("allow-untrusted", false, "Open archives that are unsigned or not signed by a trusted signer, with a warning")
+
+	return cmd
+}
+
+// newKeyRemoveCommand creates the key remove command
+func newKeyRemoveCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "remove",
+		Short: "Remove a key slot from an archive",
+		Long: `Remove a key slot from an archive. The archive is unlocked with any of its
+passwords or identities, including the one in the slot being removed. The
+last slot cannot be removed. Use 'goingenv key list' to find slot numbers.`,
+		RunE: runKeyRemoveCommand,
+	}
+
+	cmd.Flags().StringP("file", "f", "", "Archive file (required)")
+	cmd.Flags().Int("slot", -1, "Number of the key slot to remove (required)")
+	cmd.Flags().String("password-env", "", "Read an existing password from environment variable")
+	cmd.Flags().String("key-file", "", "Existing key file to unlock the archive with")
+	cmd.Flags().StringSlice("identity", nil, "Identity file (goingenv or OpenSSH ed25519 key) to unlock the archive with instead of a password (repeatable)")
+	cmd.Flags().String("sign", "", "Sign the updated archive with this OpenSSH ed25519 private key")
+	cmd.Flags().Bool("allow-untrusted", false, "Open archives that are unsigned or not signed by a trusted signer, with a warning")
+
+	return cmd
+}
+
+// runKeyListCommand executes the key list command
+func runKeyListCommand(cmd *cobra.Command, args []string) error {
+	app, archiveFile, err := setupKeyCommand(cmd)
+	if err != nil {
+		return err
+	}
+
+	header, err := app.Archiver.ReadHeader(archiveFile)
+	if err != nil {
+		return fmt.Errorf("failed to read archive header: %w", err)
+	}
+
+	slots, err := app.Crypto.ListKeySlots(header)
+	if err != nil {
+		return fmt.Errorf("failed to read key slots: %w", err)
+	}
+
+	fmt.Printf("Key slots of %s:\n", filepath.Base(archiveFile))
+	displayKeySlots(slots)
+
+	return nil
+}
+
+// runKeyAddCommand executes the key add command
+func runKeyAddCommand(cmd *cobra.Command, args []string) error {
+	app, archiveFile, err := setupKeyCommand(cmd)
+	if err != nil {
+		return err
+	}
+
+	passwordEnv, _ := cmd.Flags().GetString("password-env")
+	keyFilePath, _ := cmd.Flags().GetString("key-file")
+	identities, _ := cmd.Flags().GetStringSlice("identity")
+	passphrase := newPassphrasePrompt()
+	newPasswordEnv, _ := cmd.Flags().GetString("new-password-env")
+	newKeyFilePath, _ := cmd.Flags().GetString("new-key-file")
+	recipients, _ := cmd.Flags().GetStringSlice("recipient")
+	signKey, _ := cmd.Flags().GetString("sign")
+	allowUntrusted, _ := cmd.Flags().GetBool("allow-untrusted")
+
+	if _, err := crypto.ParseRecipients(recipients); err != nil {
+		return fmt.Errorf("invalid recipient: %w", err)
+	}
+
+	// Check the signature before asking for any password
+	trust, err := checkArchiveSignature(app, archiveFile, allowUntrusted)
+	if err != nil {
+		return err
+	}
+
+	header, err := app.Archiver.ReadHeader(archiveFile)
+	if err != nil {
+		return fmt.Errorf("failed to read archive header: %w", err)
+	}
+
+	passwordOpts := password.Options{
+		PasswordEnv: passwordEnv,
+		Prompt:      "Enter an existing password: ",
+		KeyFile:     keyFilePath,
+	}
+	keyFile, err := getKeyFile(passwordOpts)
+	if err != nil {
+		return err
+	}
+	defer password.ClearKeyFile(keyFile)
+
+	key, err := getDecryptPassword(app, passwordOpts, identities, archiveFile)
+	if err != nil {
+		return err
+	}
+	defer key.Destroy()
+
+	add := types.EncryptOptions{Recipients: recipients}
+	if len(recipients) == 0 {
+		add.KeyFile, err = getKeyFile(password.Options{KeyFile: newKeyFilePath})
+		if err != nil {
+			return err
+		}
+		defer password.ClearKeyFile(add.KeyFile)
+
+		if len(add.KeyFile) == 0 || newPasswordEnv != "" {
+			add.Password, err = getNewPassword(app, newPasswordEnv)
+			if err != nil {
+				return err
+			}
+			defer add.Password.Destroy()
+		}
+	}
+
+	header, err = app.Crypto.AddKeySlot(header, types.DecryptOptions{
+		Password:   key,
+		KeyFile:    keyFile,
+		Identities: identities,
+		Passphrase: passphrase,
+	}, add)
+	if err != nil {
+		return fmt.Errorf("failed to add key slot (check password): %w", err)
+	}
+
+	if err := replaceKeyHeader(app, archiveFile, header, types.SignOptions{KeyPath: signKey, Passphrase: passphrase}, trust); err != nil {
+		return err
+	}
+
+	switch {
+	case len(recipients) > 0:
+		fmt.Printf("✅ Added %d recipient(s) to %s\n", len(recipients), filepath.Base(archiveFile))
+	case len(add.KeyFile) > 0:
+		fmt.Printf("✅ Added key file %s to %s\n", newKeyFilePath, filepath.Base(archiveFile))
+	default:
+		fmt.Printf("✅ Added a new password to %s\n", filepath.Base(archiveFile))
+	}
+
+	return nil
+}
+
+// runKeyRemoveCommand executes the key remove command
+func runKeyRemoveCommand(cmd *cobra.Command, args []string) error {
+	app, archiveFile, err := setupKeyCommand(cmd)
+	if err != nil {
+		return err
+	}
+
+	slot, _ := cmd.Flags().GetInt("slot")
+	passwordEnv, _ := cmd.Flags().GetString("password-env")
+	keyFilePath, _ := cmd.Flags().GetString("key-file")
+	identities, _ := cmd.Flags().GetStringSlice("identity")
+	passphrase := newPassphrasePrompt()
+	signKey, _ := cmd.Flags().GetString("sign")
+	allowUntrusted, _ := cmd.Flags().GetBool("allow-untrusted")
+
+	if slot < 0 {
+		return fmt.Errorf("key slot is required. Use --slot (see 'goingenv key list')")
+	}
+
+	// Check the signature before asking for any password
+	trust, err := checkArchiveSignature(app, archiveFile, allowUntrusted)
+	if err != nil {
+		return err
+	}
+
+	header, err := app.Archiver.ReadHeader(archiveFile)
+	if err != nil {
+		return fmt.Errorf("failed to read archive header: %w", err)
+	}
+
+	passwordOpts := password.Options{
+		PasswordEnv: passwordEnv,
+		Prompt:      "Enter an existing password: ",
+		KeyFile:     keyFilePath,
+	}
+	keyFile, err := getKeyFile(passwordOpts)
+	if err != nil {
+		return err
+	}
+	defer password.ClearKeyFile(keyFile)
+
+	key, err := getDecryptPassword(app, passwordOpts, identities, archiveFile)
+	if err != nil {
+		return err
+	}
+	defer key.Destroy()
+
+	header, err = app.Crypto.RemoveKeySlot(header, types.DecryptOptions{
+		Password:   key,
+		KeyFile:    keyFile,
+		Identities: identities,
+		Passphrase: passphrase,
+	}, slot)
+	if err != nil {
+		return fmt.Errorf("failed to remove key slot: %w", err)
+	}
+
+	if err := replaceKeyHeader(app, archiveFile, header, types.SignOptions{KeyPath: signKey, Passphrase: passphrase}, trust); err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ Removed key slot %d from %s\n", slot, filepath.Base(archiveFile))
+
+	return nil
+}
+
+// Helper functions
+
+// setupKeyCommand performs the checks shared by the key subcommands and
+// returns the application and the archive to work on
+func setupKeyCommand(cmd *cobra.Command) (*types.App, string, error) {
+	if !config.IsInitialized() {
+		return nil, "", fmt.Errorf("goingenv is not initialized in this directory. Run 'goingenv init' first")
+	}
+
+	app, err := NewApp()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to initialize application: %w", err)
+	}
+
+	archiveFile, _ := cmd.Flags().GetString("file")
+	if archiveFile == "" {
+		return nil, "", fmt.Errorf("archive file is required. Use -f flag")
+	}
+	if _, err := os.Stat(archiveFile); os.IsNotExist(err) {
+		return nil, "", fmt.Errorf("archive file not found: %s", archiveFile)
+	}
+
+	return app, archiveFile, nil
+}
+
+// replaceKeyHeader writes an updated header into an archive that trust
+// accepts, warning when that drops a signature because no signing key was
+// given
+func replaceKeyHeader(app *types.App, archiveFile string, header []byte, sign types.SignOptions, trust types.TrustFunc) error {
+	// Note the signer of the file that is rewritten, for the warning below
+	var signer *types.SignatureInfo
+	check := func(opened *types.SignatureInfo) error {
+		signer = opened
+		return trust(opened)
+	}
+
+	if err := app.Archiver.ReplaceHeader(archiveFile, header, sign, check); err != nil {
+		return fmt.Errorf("failed to update archive: %w", err)
+	}
+
+	warnDroppedSignature(signer, sign)
+
+	return nil
+}
+
+// warnDroppedSignature warns that rewriting an archive signed by signer
+// removed its signature, because sign selects no key to sign it again
+func warnDroppedSignature(signer *types.SignatureInfo, sign types.SignOptions) {
+	if signer != nil && sign.KeyPath == "" {
+		fmt.Printf("⚠️  Removed the signature by %s; use --sign to sign the archive again\n", signer.Fingerprint)
+	}
+}
+
+// getNewPassword reads a new password that meets the password policy,
+// asking twice when it is typed in
+func getNewPassword(app *types.App, passwordEnv string) (*secret.Buffer, error) {
+	opts := password.Options{
+		PasswordEnv: passwordEnv,
+		Prompt:      "Enter new password: ",
+	}
+	if err := password.ValidatePasswordOptions(opts); err != nil {
+		return nil, fmt.Errorf("invalid password options: %w", err)
+	}
+
+	newPassword, err := password.GetNewPassword(opts, app.Config.PasswordPolicy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get new password: %w", err)
+	}
+
+	return newPassword, nil
+}
+
+// displayKeySlots prints one line per key slot
+func displayKeySlots(slots []types.KeySlotInfo) {
+	for _, slot := range slots {
+		switch {
+		case slot.KDF != "":
+			fmt.Printf("  %d: %-16s %s\n", slot.Index, slot.Type, slot.KDF)
+		case slot.Recipient != "":
+			fmt.Printf("  %d: %-16s %s\n", slot.Index, slot.Type, slot.Recipient)
+		default:
+			fmt.Printf("  %d: %s\n", slot.Index, slot.Type)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"goingenv/internal/config"
+	"goingenv/internal/crypto"
+)
+
+// newKeygenCommand creates the keygen command
+func newKeygenCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "keygen",
+		Short: "Generate an X25519 identity for recipient encryption",
+		Long: `Generate a new X25519 key pair for public-key archive encryption.
+
+The private key (identity) is written to the output file, or to standard output
+if no file is given. The public key is printed so it can be added to the
+project's .goingenv/recipients file; archives packed afterwards can then be
+opened with 'goingenv unpack --identity <file>' instead of a shared password.
+
+Examples:
+  goingenv keygen -o ~/.config/goingenv/identity.txt
+  goingenv keygen > identity.txt`,
+		RunE: runKeygenCommand,
+	}
+
+	cmd.Flags().StringP("output", "o", "", "Write the identity to this file (default: standard output)")
+
+	return cmd
+}
+
+// runKeygenCommand executes the keygen command
+func runKeygenCommand(cmd *cobra.Command, args []string) error {
+	output, _ := cmd.Flags().GetString("output")
+
+	identity, err := crypto.GenerateX25519Identity()
+	if err != nil {
+		return fmt.Errorf("failed to generate identity: %w", err)
+	}
+	publicKey := identity.Recipient().String()
+
+	content := fmt.Sprintf("# created: %s\n# public key: %s\n%s\n",
+		time.Now().Format(time.RFC3339), publicKey, identity.String())
+
+	if output == "" {
+		fmt.Print(content)
+		fmt.Fprintf(os.Stderr, "Public key: %s\n", publicKey)
+		return nil
+	}
+
+	// Never overwrite an existing identity; that would lock its owner out
+	file, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create identity file: %w", err)
+	}
+	if _, err := file.WriteString(content); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write identity file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write identity file: %w", err)
+	}
+
+	fmt.Printf("✅ Identity written to %s\n", output)
+	fmt.Printf("Public key: %s\n", publicKey)
+	fmt.Printf("\nAdd the public key to %s to receive future archives.\n", config.GetRecipientsPath())
+
+	return nil
+}
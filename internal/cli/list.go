@@ -33,12 +33,16 @@ The list command will:
 Examples:
   goingenv list -f backup.enc                           # Interactive password prompt
   goingenv list --password-env MY_PASSWORD --all        # List all archives with env password
-  goingenv list -f archive.enc --pattern "*.env.prod*"  # Filter files by pattern`,
+  goingenv list -f archive.enc --pattern "*.env.prod*"  # Filter files by pattern
+  goingenv list -f archive.enc --identity identity.txt  # Decrypt with a private key
+  goingenv list -f archive.enc --key-file ci.key        # Decrypt with a key file`,
 		RunE: runListCommand,
 	}
 
 	// Add flags
 	cmd.Flags().String("password-env", "", "Read password from environment variable")
+	cmd.Flags().String("key-file", "", "Key file to decrypt with, alone or together with the password")
+	cmd.Flags().StringSlice("identity", nil, "Identity file (goingenv or OpenSSH ed25519 key) to decrypt with instead of a password (repeatable)")
 	cmd.Flags().StringP("file", "f", "", "Archive file to list (required unless --all is used)")
 	cmd.Flags().Bool("all", false, "List contents of all available archives")
 	cmd.Flags().BoolP("verbose", "v", false, "Show detailed file information")
@@ -50,6 +54,7 @@ Examples:
 	cmd.Flags().Bool("reverse", false, "Reverse sort order")
 	cmd.Flags().StringP("format", "", "table", "Output format: table, json, csv")
 	cmd.Flags().IntP("limit", "l", 0, "Limit number of files to show (0 = no limit)")
+	cmd.Flags().Bool("allow-untrusted", false, "Open archives that are unsigned or not signed by a trusted signer, with a warning")
 
 	return cmd
 }
@@ -70,6 +75,9 @@ func runListCommand(cmd *cobra.Command, args []string) error {
 	// Parse flags
 	archiveFile, _ := cmd.Flags().GetString("file")
 	passwordEnv, _ := cmd.Flags().GetString("password-env")
+	keyFilePath, _ := cmd.Flags().GetString("key-file")
+	identities, _ := cmd.Flags().GetStringSlice("identity")
+	passphrase := newPassphrasePrompt()
 	listAll, _ := cmd.Flags().GetBool("all")
 	verbose, _ := cmd.Flags().GetBool("verbose")
 	showSizes, _ := cmd.Flags().GetBool("sizes")
@@ -80,15 +88,22 @@ func runListCommand(cmd *cobra.Command, args []string) error {
 	reverse, _ := cmd.Flags().GetBool("reverse")
 	format, _ := cmd.Flags().GetString("format")
 	limit, _ := cmd.Flags().GetInt("limit")
+	allowUntrusted, _ := cmd.Flags().GetBool("allow-untrusted")
 
 	// Prepare password options
 	passwordOpts := password.Options{
 		PasswordEnv: passwordEnv,
+		KeyFile:     keyFilePath,
 	}
+	keyFile, err := getKeyFile(passwordOpts)
+	if err != nil {
+		return err
+	}
+	defer password.ClearKeyFile(keyFile)
 
 	// Handle --all flag
 	if listAll {
-		return listAllArchives(app, passwordOpts, verbose)
+		return listAllArchives(app, passwordOpts, keyFile, identities, passphrase, verbose, allowUntrusted)
 	}
 
 	// Require archive file if not listing all
@@ -101,23 +116,31 @@ func runListCommand(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("archive file not found: %s", archiveFile)
 	}
 
-	// Validate password options
-	if err := password.ValidatePasswordOptions(passwordOpts); err != nil {
-		return fmt.Errorf("invalid password options: %w", err)
+	// Check the signature before asking for any password
+	trust, err := checkArchiveSignature(app, archiveFile, allowUntrusted)
+	if err != nil {
+		return err
 	}
 
-	// Get password using new secure methods
-	key, err := password.GetPassword(passwordOpts)
+	// Get password using secure methods, unless identities or a key file are given
+	key, err := getDecryptPassword(app, passwordOpts, identities, archiveFile)
 	if err != nil {
-		return fmt.Errorf("failed to get password: %w", err)
+		return err
 	}
 
 	// Ensure password is cleared from memory when done
-	defer password.ClearPassword(&key)
+	defer key.Destroy()
 
 	// List archive contents
 	fmt.Printf("Reading archive: %s\n", filepath.Base(archiveFile))
-	archive, err := app.Archiver.List(archiveFile, key)
+	archive, err := app.Archiver.List(types.ListOptions{
+		ArchivePath: archiveFile,
+		Password:    key,
+		KeyFile:     keyFile,
+		Identities:  identities,
+		Passphrase:  passphrase,
+		Trust:       trust,
+	})
 	if err != nil {
 		return fmt.Errorf("failed to read archive (check password): %w", err)
 	}
@@ -159,7 +182,7 @@ func runListCommand(cmd *cobra.Command, args []string) error {
 }
 
 // listAllArchives lists contents of all available archives
-func listAllArchives(app *types.App, passwordOpts password.Options, verbose bool) error {
+func listAllArchives(app *types.App, passwordOpts password.Options, keyFile []byte, identities []string, passphrase types.PassphraseFunc, verbose, allowUntrusted bool) error {
 	archives, err := app.Archiver.GetAvailableArchives("")
 	if err != nil {
 		return fmt.Errorf("failed to find archives: %w", err)
@@ -181,11 +204,25 @@ func listAllArchives(app *types.App, passwordOpts password.Options, verbose bool
 			fmt.Printf("    Modified: %s\n", info.ModTime().Format("2006-01-02 15:04:05"))
 		}
 
-		if verbose && passwordOpts.PasswordEnv != "" {
-			// Try to read archive contents if password options are provided
-			if key, err := password.GetPassword(passwordOpts); err == nil {
-				defer password.ClearPassword(&key)
-				if archive, err := app.Archiver.List(archivePath, key); err == nil {
+		if verbose && (passwordOpts.PasswordEnv != "" || len(keyFile) > 0 || len(identities) > 0 || agentHoldsKeys(app, []string{archivePath})) {
+			trust, err := checkArchiveSignature(app, archivePath, allowUntrusted)
+			if err != nil {
+				fmt.Printf("    Status: %v\n\n", err)
+				continue
+			}
+
+			// Try to read archive contents if password options, a key file, identities or an agent key are available
+			if key, err := getDecryptPassword(app, passwordOpts, identities, archivePath); err == nil {
+				defer key.Destroy()
+				listOpts := types.ListOptions{
+					ArchivePath: archivePath,
+					Password:    key,
+					KeyFile:     keyFile,
+					Identities:  identities,
+					Passphrase:  passphrase,
+					Trust:       trust,
+				}
+				if archive, err := app.Archiver.List(listOpts); err == nil {
 					fmt.Printf("    Created: %s\n", archive.CreatedAt.Format("2006-01-02 15:04:05"))
 					fmt.Printf("    Files: %d\n", len(archive.Files))
 					fmt.Printf("    Total size: %s\n", utils.FormatSize(archive.TotalSize))
@@ -203,8 +240,8 @@ func listAllArchives(app *types.App, passwordOpts password.Options, verbose bool
 		fmt.Println()
 	}
 
-	if passwordOpts.PasswordEnv == "" && verbose {
-		fmt.Println("💡 Tip: Provide a password with --password-env to see detailed archive information")
+	if passwordOpts.PasswordEnv == "" && len(keyFile) == 0 && len(identities) == 0 && verbose {
+		fmt.Println("💡 Tip: Provide a password with --password-env, a --key-file or an --identity to see detailed archive information")
 	}
 
 	return nil
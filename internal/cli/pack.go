@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
@@ -11,7 +12,9 @@ import (
 	"golang.org/x/term"
 
 	"goingenv/internal/config"
+	"goingenv/internal/crypto"
 	"goingenv/pkg/password"
+	"goingenv/pkg/secret"
 	"goingenv/pkg/types"
 	"goingenv/pkg/utils"
 )
@@ -26,19 +29,58 @@ func newPackCommand() *cobra.Command {
 The pack command will:
 - Scan for common environment file patterns (.env, .env.local, etc.)
 - Calculate checksums for integrity verification
-- Encrypt files using AES-256-GCM with PBKDF2 key derivation
+- Compress the files with gzip
+- Encrypt files using AES-256-GCM or XChaCha20-Poly1305 with Argon2id or PBKDF2 key derivation
 - Store the encrypted archive in the .goingenv directory
 
 Examples:
   goingenv pack                                    # Interactive password prompt
   goingenv pack --password-env MY_PASSWORD        # Read from environment variable
   goingenv pack -d /path/to/project -o backup.enc # Specify directory and output
-  goingenv pack -d . --depth 5                    # Custom scan depth`,
+  goingenv pack -d . --depth 5                    # Custom scan depth
+  goingenv pack --kdf pbkdf2                      # Override the configured KDF
+  goingenv pack --cipher xchacha20-poly1305       # Override the configured cipher
+  goingenv pack --recipient-ssh teammate.pub      # Also encrypt to an SSH key
+  goingenv pack --sign ~/.ssh/id_ed25519          # Sign the archive
+  goingenv pack --key-file ci.key                 # Encrypt with a key file instead of a password
+  goingenv pack --key-file usb.key --with-password # Require the key file and a password
+  goingenv pack --generate-password               # Encrypt with a new diceware passphrase
+
+Recipients:
+  If .goingenv/recipients lists public keys (see 'goingenv keygen'), the archive
+  is encrypted to every listed recipient and no password is asked for. Use
+  --with-password to protect it with a password as well.
+
+  OpenSSH ed25519 public keys ("ssh-ed25519 AAAA...") can be listed there too,
+  or given with --recipient-ssh; their owners unpack with
+  'goingenv unpack --identity ~/.ssh/id_ed25519'.
+
+Key files:
+  --key-file mixes the contents of any file into key derivation, so CI runners
+  can mount a secret file instead of exporting a password variable. The key
+  file is used alone unless --with-password or --password-env is also given,
+  in which case both are needed to open the archive. Keep the key file exactly
+  as it is: changing a single byte makes the archive unreadable.
+
+Password policy:
+  New passwords must meet the password_policy in ~/.goingenv.json (by default
+  "strong" and at least 12 characters). A typed password that falls short is
+  explained and asked for again, and every typed password is asked for twice.
+
+  --generate-password skips the prompt: it encrypts with a random six-word
+  passphrase (about 77 bits, see 'goingenv genpass') and prints it once the
+  archive is written. Store it right away; it is not shown again.
+
+Signing:
+  --sign signs the encrypted archive with an OpenSSH ed25519 private key, so
+  teammates can check who published it. List trusted signers' public keys in
+  .goingenv/trusted_signers to make unpack refuse unsigned or foreign archives.`,
 		RunE: runPackCommand,
 	}
 
 	// Add flags
 	cmd.Flags().String("password-env", "", "Read password from environment variable")
+	cmd.Flags().String("key-file", "", "Key file to encrypt with, alone or together with a password")
 	cmd.Flags().StringP("directory", "d", "", "Directory to scan (default: current directory)")
 	cmd.Flags().StringP("output", "o", "", "Output archive name (default: auto-generated with timestamp)")
 	cmd.Flags().IntP("depth", "", 0, "Maximum directory depth to scan (default: from config)")
@@ -46,6 +88,13 @@ Examples:
 	cmd.Flags().StringSliceP("exclude", "e", nil, "Additional patterns to exclude")
 	cmd.Flags().BoolP("dry-run", "", false, "Show what would be packed without creating archive")
 	cmd.Flags().BoolP("verbose", "v", false, "Show detailed information during packing")
+	cmd.Flags().String("kdf", "", "Key derivation function: argon2id, pbkdf2 (default: from config)")
+	cmd.Flags().String("cipher", "", "Cipher: aes-256-gcm, xchacha20-poly1305 (default: from config)")
+	cmd.Flags().String("compression", "", "Compression: gzip, none (default: from config)")
+	cmd.Flags().Bool("with-password", false, "Also require a password when encrypting to recipients or a key file")
+	cmd.Flags().Bool("generate-password", false, "Encrypt with a generated diceware passphrase and print it")
+	cmd.Flags().StringSlice("recipient-ssh", nil, "Also encrypt to the ed25519 keys in this SSH public key file (repeatable)")
+	cmd.Flags().String("sign", "", "Sign the archive with this OpenSSH ed25519 private key")
 
 	return cmd
 }
@@ -80,29 +129,125 @@ func runPackCommand(cmd *cobra.Command, args []string) error {
 	}
 
 	passwordEnv, _ := cmd.Flags().GetString("password-env")
+	keyFilePath, _ := cmd.Flags().GetString("key-file")
 	depth, _ := cmd.Flags().GetInt("depth")
 	includePatterns, _ := cmd.Flags().GetStringSlice("include")
 	excludePatterns, _ := cmd.Flags().GetStringSlice("exclude")
 	dryRun, _ := cmd.Flags().GetBool("dry-run")
 	verbose, _ := cmd.Flags().GetBool("verbose")
+	kdfName, _ := cmd.Flags().GetString("kdf")
+	cipherName, _ := cmd.Flags().GetString("cipher")
+	compressionName, _ := cmd.Flags().GetString("compression")
+	withPassword, _ := cmd.Flags().GetBool("with-password")
+	generatePassword, _ := cmd.Flags().GetBool("generate-password")
+	sshKeyFiles, _ := cmd.Flags().GetStringSlice("recipient-ssh")
+	signKey, _ := cmd.Flags().GetString("sign")
+
+	if generatePassword && passwordEnv != "" {
+		return fmt.Errorf("--generate-password and --password-env cannot be combined")
+	}
 
-	// Get password using secure methods
-	passwordOpts := password.Options{
-		PasswordEnv: passwordEnv,
+	if signKey != "" {
+		if _, err := os.Stat(signKey); err != nil {
+			return fmt.Errorf("signing key not found: %s", signKey)
+		}
 	}
 
-	// Validate password options
-	if err := password.ValidatePasswordOptions(passwordOpts); err != nil {
-		return fmt.Errorf("invalid password options: %w", err)
+	// Override the configured KDF for this archive if requested
+	if kdfName != "" {
+		kdf, err := crypto.ParseKDFName(kdfName)
+		if err != nil {
+			return fmt.Errorf("invalid --kdf value: %w", err)
+		}
+		if configured, _ := crypto.ParseKDFName(app.Config.KDF.Algorithm); configured != kdf {
+			// Parameters from config belong to a different KDF; use defaults
+			app.Config.KDF = types.KDFConfig{Algorithm: kdfName}
+		}
 	}
 
-	key, err := password.GetPassword(passwordOpts)
+	// Override the configured cipher for this archive if requested
+	if cipherName != "" {
+		if _, err := crypto.ParseCipherName(cipherName); err != nil {
+			return fmt.Errorf("invalid --cipher value: %w", err)
+		}
+		app.Config.Cipher = cipherName
+	}
+
+	// Override the configured compression for this archive if requested
+	if compressionName != "" {
+		if _, err := crypto.ParseCompressionName(compressionName); err != nil {
+			return fmt.Errorf("invalid --compression value: %w", err)
+		}
+		app.Config.Compression = compressionName
+	}
+
+	// Encrypt to the project's recipients, if any
+	recipients, err := config.LoadRecipients()
 	if err != nil {
-		return fmt.Errorf("failed to get password: %w", err)
+		return err
+	}
+	if _, err := crypto.ParseRecipients(recipients); err != nil {
+		return fmt.Errorf("invalid recipient in %s: %w", config.GetRecipientsPath(), err)
 	}
+	projectRecipients := len(recipients)
+
+	// Add SSH public keys given on the command line
+	for _, path := range sshKeyFiles {
+		keys, err := config.ReadPublicKeysFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read SSH public key: %w", err)
+		}
+		if len(keys) == 0 {
+			return fmt.Errorf("no SSH public key found in %s", path)
+		}
+		for _, line := range keys {
+			if !strings.HasPrefix(line, crypto.SSHEd25519Prefix) {
+				return fmt.Errorf("%s: only ssh-ed25519 public keys are supported", path)
+			}
+		}
+		if _, err := crypto.ParseRecipients(keys); err != nil {
+			return fmt.Errorf("invalid SSH public key in %s: %w", path, err)
+		}
+		recipients = append(recipients, keys...)
+	}
+
+	keyFile, err := getKeyFile(password.Options{KeyFile: keyFilePath})
+	if err != nil {
+		return err
+	}
+	defer password.ClearKeyFile(keyFile)
+
+	// A password is only needed without recipients or a key file, or when
+	// explicitly requested
+	var key *secret.Buffer
+	if generatePassword {
+		// Generated passphrases have known entropy, so the policy's
+		// estimator is not consulted
+		key, err = crypto.GeneratePassphrase(crypto.DefaultPassphraseWords, "-")
+		if err != nil {
+			return fmt.Errorf("failed to generate password: %w", err)
+		}
+		defer key.Destroy()
+	} else if (len(recipients) == 0 && len(keyFile) == 0) || withPassword || passwordEnv != "" {
+		// Get password using secure methods
+		passwordOpts := password.Options{
+			PasswordEnv: passwordEnv,
+		}
+
+		// Validate password options
+		if err := password.ValidatePasswordOptions(passwordOpts); err != nil {
+			return fmt.Errorf("invalid password options: %w", err)
+		}
 
-	// Ensure password is cleared from memory when done
-	defer password.ClearPassword(&key)
+		// New passwords must meet the password policy and are confirmed
+		key, err = password.GetNewPassword(passwordOpts, app.Config.PasswordPolicy)
+		if err != nil {
+			return fmt.Errorf("failed to get password: %w", err)
+		}
+
+		// Ensure password is cleared from memory when done
+		defer key.Destroy()
+	}
 
 	// Prepare scan options
 	scanOpts := types.ScanOptions{
@@ -131,6 +276,24 @@ func runPackCommand(cmd *cobra.Command, args []string) error {
 		fmt.Printf("Maximum depth: %d\n", scanOpts.MaxDepth)
 		fmt.Printf("Include patterns: %v\n", scanOpts.Patterns)
 		fmt.Printf("Exclude patterns: %v\n", scanOpts.ExcludePatterns)
+		if cipherID, err := crypto.ParseCipherName(app.Config.Cipher); err == nil {
+			fmt.Printf("Cipher: %s\n", cipherID)
+		}
+		if compression, err := crypto.ParseCompressionName(app.Config.Compression); err == nil {
+			fmt.Printf("Compression: %s\n", compression)
+		}
+		if kdf, params, err := crypto.KDFFromConfig(app.Config.KDF); err == nil && (!key.IsEmpty() || len(keyFile) > 0) {
+			fmt.Printf("Key derivation: %s (%s)\n", kdf, params.String(kdf))
+		}
+		if len(keyFile) > 0 {
+			fmt.Printf("Key file: %s\n", keyFilePath)
+		}
+		if projectRecipients > 0 {
+			fmt.Printf("Recipients: %d from %s\n", projectRecipients, config.GetRecipientsPath())
+		}
+		if len(recipients) > projectRecipients {
+			fmt.Printf("SSH recipients: %d\n", len(recipients)-projectRecipients)
+		}
 		fmt.Println()
 	}
 
@@ -187,8 +350,11 @@ func runPackCommand(cmd *cobra.Command, args []string) error {
 		Files:      files,
 		OutputPath: output,
 		Password:   key,
+		KeyFile:    keyFile,
+		Recipients: recipients,
 		Description: fmt.Sprintf("Environment files archive created on %s from %s",
 			time.Now().Format("2006-01-02 15:04:05"), directory),
+		Sign: types.SignOptions{KeyPath: signKey, Passphrase: newPassphrasePrompt()},
 	}
 
 	if verbose {
@@ -206,14 +372,19 @@ func runPackCommand(cmd *cobra.Command, args []string) error {
 	// Success message
 	fmt.Printf("✅ Successfully packed %d files to %s\n", len(files), output)
 
+	if generatePassword {
+		fmt.Printf("\nGenerated password (%.1f bits), store it now, it is not shown again:\n  ",
+			crypto.PassphraseEntropy(crypto.DefaultPassphraseWords))
+		os.Stdout.Write(key.Bytes())
+		fmt.Println()
+	}
+
 	if verbose {
 		fmt.Printf("Operation completed in %v\n", duration)
 
 		// Show archive info
 		if info, err := os.Stat(output); err == nil {
-			compressionRatio := float64(info.Size()) / float64(totalSize) * 100
-			fmt.Printf("Archive size: %s (%.1f%% of original)\n",
-				utils.FormatSize(info.Size()), compressionRatio)
+			displayArchiveSize(info.Size(), totalSize)
 		}
 
 		fmt.Printf("Archive checksum: calculating...\n")
@@ -222,6 +393,13 @@ func runPackCommand(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	if len(recipients) > 0 {
+		fmt.Printf("🔑 Encrypted to %d recipient(s)\n", len(recipients))
+	}
+	if len(keyFile) > 0 {
+		fmt.Printf("🔑 Encrypted with key file %s; keep it unchanged and back it up\n", keyFilePath)
+	}
+
 	// Security reminder
 	fmt.Println("\n🔒 Security reminder:")
 	fmt.Println("   • Store your password securely")
@@ -230,3 +408,17 @@ func runPackCommand(cmd *cobra.Command, args []string) error {
 
 	return nil
 }
+
+// displayArchiveSize prints the archive size next to the size of the packed
+// files. The header, key slots, index and tar headers add a fixed overhead
+// of a few hundred bytes or more, so archives of a few small files are larger
+// than the files, and no ratio is shown for them.
+func displayArchiveSize(archiveSize, filesSize int64) {
+	if filesSize > 0 && archiveSize < filesSize {
+		fmt.Printf("Archive size: %s (%.1f%% of %s of files)\n",
+			utils.FormatSize(archiveSize), float64(archiveSize)/float64(filesSize)*100, utils.FormatSize(filesSize))
+		return
+	}
+	fmt.Printf("Archive size: %s for %s of files (including fixed header and tar overhead)\n",
+		utils.FormatSize(archiveSize), utils.FormatSize(filesSize))
+}
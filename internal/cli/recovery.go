@@ -0,0 +1,467 @@
+package cli
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"goingenv/internal/config"
+	"goingenv/internal/crypto"
+	"goingenv/pkg/password"
+	"goingenv/pkg/types"
+)
+
+// newRecoveryCommand creates the recovery command and its subcommands
+func newRecoveryCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "recovery",
+		Short: "Split a recovery key into shares for emergency access",
+		Long: `Protect archives against losing the only person who knows the password.
+
+'goingenv recovery split' adds a recovery key slot to archives and splits the
+recovery key into printable shares with Shamir's secret sharing. Any threshold
+of the shares rebuild the key; fewer reveal nothing about it. Give each share
+to a different person and keep them offline.
+
+'goingenv recovery combine' rebuilds the recovery key from the shares and
+unpacks an archive with it, or sets a new password on the archives.
+
+Examples:
+  goingenv recovery split --shares 5 --threshold 3        # All archives
+  goingenv recovery split -f .goingenv/prod.enc --shares 3 --threshold 2
+  goingenv recovery combine                               # Prompt for shares, unpack
+  goingenv recovery combine --set-password                # Set a new password instead`,
+	}
+
+	cmd.AddCommand(newRecoverySplitCommand())
+	cmd.AddCommand(newRecoveryCombineCommand())
+
+	return cmd
+}
+
+// newRecoverySplitCommand creates the recovery split command
+func newRecoverySplitCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "split",
+		Short: "Add a recovery key to archives and split it into shares",
+		Long: `Generate a recovery key, add a recovery slot for it to archives and print
+the key as shares, any threshold of which rebuild it.
+
+Without -f, the same recovery key is added to every archive in the .goingenv
+directory, so one set of shares recovers the whole project. The archives are
+unlocked with an existing password, key file or identity. No archive is
+changed unless all of them could be unlocked, and none is changed if one is
+refused by the trusted signers check, as for unpack. If writing an archive
+fails, the shares are still printed for the archives already updated.
+
+The shares are printed once and not stored anywhere. Running split again
+creates a new recovery key; remove old recovery slots with 'goingenv key
+remove' to retire old shares. 'goingenv rekey' cannot keep recovery slots and
+refuses archives that have them unless --drop-recovery is given, after which
+the shares no longer work and split has to be run again.`,
+		RunE: runRecoverySplitCommand,
+	}
+
+	cmd.Flags().StringP("file", "f", "", "Archive file (default: all archives)")
+	cmd.Flags().Int("shares", 5, "Number of shares to create")
+	cmd.Flags().Int("threshold", 3, "Number of shares needed to rebuild the recovery key")
+	cmd.Flags().String("password-env", "", "Read an existing password from environment variable")
+	cmd.Flags().String("key-file", "", "Existing key file to unlock the archives with")
+	cmd.Flags().StringSlice("identity", nil, "Identity file (goingenv or OpenSSH ed25519 key) to unlock the archives with instead of a password (repeatable)")
+	cmd.Flags().String("sign", "", "Sign the updated archives with this OpenSSH ed25519 private key")
+	cmd.Flags().Bool("allow-untrusted", false, "Open archives that are unsigned or not signed by a trusted signer, with a warning")
+
+	return cmd
+}
+
+// newRecoveryCombineCommand creates the recovery combine command
+func newRecoveryCombineCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "combine",
+		Short: "Rebuild a recovery key from shares and decrypt with it",
+		Long: `Rebuild the recovery key from shares and use it in place of the password.
+
+By default the archive (-f, or the most recent one) is unpacked with the
+recovery key. With --set-password, a new password slot is added instead to
+the archive, or to every archive with a matching recovery slot when -f is not
+given, so the team can go back to using passwords.
+
+Shares are read from --share flags, or asked for one at a time until the
+threshold is reached. Typed shares are not echoed.`,
+		RunE: runRecoveryCombineCommand,
+	}
+
+	cmd.Flags().StringP("file", "f", "", "Archive file (default: most recent, or all with --set-password)")
+	cmd.Flags().StringArray("share", nil, "Recovery share (repeatable; prompted for when omitted)")
+	cmd.Flags().StringP("target", "t", "", "Target directory for extraction (default: current directory)")
+	cmd.Flags().Bool("overwrite", false, "Overwrite existing files")
+	cmd.Flags().Bool("backup", true, "Save existing files in .goingenv/backups before overwriting them, so 'goingenv undo' can restore them")
+	cmd.Flags().Bool("set-password", false, "Add a new password to the archives instead of unpacking")
+	cmd.Flags().String("new-password-env", "", "Read the new password from environment variable (implies --set-password)")
+	cmd.Flags().String("sign", "", "Sign the updated archives with this OpenSSH ed25519 private key")
+	cmd.Flags().Bool("allow-untrusted", false, "Open archives that are unsigned or not signed by a trusted signer, with a warning")
+
+	return cmd
+}
+
+// runRecoverySplitCommand executes the recovery split command
+func runRecoverySplitCommand(cmd *cobra.Command, args []string) error {
+	// Check if GoingEnv is initialized
+	if !config.IsInitialized() {
+		return fmt.Errorf("goingenv is not initialized in this directory. Run 'goingenv init' first")
+	}
+
+	// Initialize application
+	app, err := NewApp()
+	if err != nil {
+		return fmt.Errorf("failed to initialize application: %w", err)
+	}
+
+	// Parse flags
+	archiveFile, _ := cmd.Flags().GetString("file")
+	shares, _ := cmd.Flags().GetInt("shares")
+	threshold, _ := cmd.Flags().GetInt("threshold")
+	passwordEnv, _ := cmd.Flags().GetString("password-env")
+	keyFilePath, _ := cmd.Flags().GetString("key-file")
+	identities, _ := cmd.Flags().GetStringSlice("identity")
+	passphrase := newPassphrasePrompt()
+	signKey, _ := cmd.Flags().GetString("sign")
+	allowUntrusted, _ := cmd.Flags().GetBool("allow-untrusted")
+
+	archives, err := recoveryArchives(app, archiveFile)
+	if err != nil {
+		return err
+	}
+
+	trust, err := checkArchiveSignatures(app, archives, allowUntrusted)
+	if err != nil {
+		return err
+	}
+
+	// Generate and split the recovery key before touching any archive, so
+	// invalid share counts are reported first
+	recoveryKey, err := crypto.NewRecoveryKey()
+	if err != nil {
+		return err
+	}
+	defer password.ClearKeyFile(recoveryKey)
+
+	shareTexts, err := crypto.SplitRecoveryKey(recoveryKey, shares, threshold)
+	if err != nil {
+		return fmt.Errorf("failed to split recovery key: %w", err)
+	}
+
+	passwordOpts := password.Options{
+		PasswordEnv: passwordEnv,
+		Prompt:      "Enter an existing password: ",
+		KeyFile:     keyFilePath,
+	}
+	keyFile, err := getKeyFile(passwordOpts)
+	if err != nil {
+		return err
+	}
+	defer password.ClearKeyFile(keyFile)
+
+	key, err := getDecryptPassword(app, passwordOpts, identities, archives...)
+	if err != nil {
+		return err
+	}
+	defer key.Destroy()
+
+	unlock := types.DecryptOptions{
+		Password:   key,
+		KeyFile:    keyFile,
+		Identities: identities,
+		Passphrase: passphrase,
+	}
+
+	// Add the recovery slot to every header in memory first, so the shares
+	// are only printed for a complete set of archives
+	headers := make([][]byte, len(archives))
+	for i, archivePath := range archives {
+		header, err := app.Archiver.ReadHeader(archivePath)
+		if err != nil {
+			return fmt.Errorf("failed to read archive header of %s: %w", filepath.Base(archivePath), err)
+		}
+		headers[i], err = app.Crypto.AddKeySlot(header, unlock, types.EncryptOptions{RecoveryKey: recoveryKey})
+		if err != nil {
+			return fmt.Errorf("failed to add recovery slot to %s (check password): %w", filepath.Base(archivePath), err)
+		}
+	}
+
+	// Once an archive has the slot, the shares must be printed even if a
+	// later archive cannot be written, or that slot could never be used
+	sign := types.SignOptions{KeyPath: signKey, Passphrase: passphrase}
+	var updated int
+	var writeErr error
+	for i, archivePath := range archives {
+		if err := replaceKeyHeader(app, archivePath, headers[i], sign, trust[archivePath]); err != nil {
+			writeErr = fmt.Errorf("%s: %w", filepath.Base(archivePath), err)
+			break
+		}
+		updated++
+		fmt.Printf("✅ Added recovery slot to %s\n", filepath.Base(archivePath))
+	}
+	if updated == 0 {
+		return writeErr
+	}
+
+	fmt.Printf("\n🔑 Recovery key %x: any %d of these %d shares rebuild it\n\n", crypto.RecoveryKeyID(recoveryKey), threshold, shares)
+	for i, text := range shareTexts {
+		fmt.Printf("  Share %d: %s\n", i+1, text)
+	}
+
+	if writeErr != nil {
+		fmt.Printf("\n⚠️  Only %d of %d archives have the recovery slot; these shares open only those marked ✅ above\n", updated, len(archives))
+		return writeErr
+	}
+
+	fmt.Println("\n💡 Next steps:")
+	fmt.Println("   • Give each share to a different person and store it offline")
+	fmt.Println("   • The shares are not saved anywhere; this is the only time they are shown")
+	fmt.Printf("   • Recover with 'goingenv recovery combine' and any %d shares\n", threshold)
+
+	return nil
+}
+
+// runRecoveryCombineCommand executes the recovery combine command
+func runRecoveryCombineCommand(cmd *cobra.Command, args []string) error {
+	// Check if GoingEnv is initialized
+	if !config.IsInitialized() {
+		return fmt.Errorf("goingenv is not initialized in this directory. Run 'goingenv init' first")
+	}
+
+	// Initialize application
+	app, err := NewApp()
+	if err != nil {
+		return fmt.Errorf("failed to initialize application: %w", err)
+	}
+
+	// Parse flags
+	archiveFile, _ := cmd.Flags().GetString("file")
+	shareTexts, _ := cmd.Flags().GetStringArray("share")
+	targetDir, _ := cmd.Flags().GetString("target")
+	if targetDir == "" {
+		targetDir = "."
+	}
+	overwrite, _ := cmd.Flags().GetBool("overwrite")
+	backup, _ := cmd.Flags().GetBool("backup")
+	setPassword, _ := cmd.Flags().GetBool("set-password")
+	newPasswordEnv, _ := cmd.Flags().GetString("new-password-env")
+	signKey, _ := cmd.Flags().GetString("sign")
+	allowUntrusted, _ := cmd.Flags().GetBool("allow-untrusted")
+	setPassword = setPassword || newPasswordEnv != ""
+
+	var archives []string
+	if setPassword {
+		archives, err = recoveryArchives(app, archiveFile)
+	} else {
+		archiveFile, err = resolveArchiveFile(app, archiveFile)
+		archives = []string{archiveFile}
+	}
+	if err != nil {
+		return err
+	}
+
+	trust, err := checkArchiveSignatures(app, archives, allowUntrusted)
+	if err != nil {
+		return err
+	}
+
+	recoveryKey, err := getRecoveryKey(shareTexts)
+	if err != nil {
+		return err
+	}
+	defer password.ClearKeyFile(recoveryKey)
+	fmt.Println("✅ Rebuilt the recovery key")
+
+	if setPassword {
+		return setRecoveredPassword(app, archives, trust, recoveryKey, newPasswordEnv, signKey)
+	}
+
+	archive, err := app.Archiver.List(types.ListOptions{
+		ArchivePath: archiveFile,
+		RecoveryKey: recoveryKey,
+		Trust:       trust[archiveFile],
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	unpackOpts := types.UnpackOptions{
+		ArchivePath: archiveFile,
+		RecoveryKey: recoveryKey,
+		TargetDir:   targetDir,
+		Overwrite:   overwrite,
+		Trust:       trust[archiveFile],
+	}
+	snapshot := newBackupSnapshot(&unpackOpts, backup)
+	recorder := newUnpackJournal(&unpackOpts, snapshot)
+
+	if err := app.Archiver.Unpack(unpackOpts); err != nil {
+		return fmt.Errorf("error unpacking files: %w", err)
+	}
+
+	fmt.Printf("✅ Successfully extracted %d files from %s\n", len(archive.Files), filepath.Base(archiveFile))
+	displayBackupSnapshot(snapshot)
+
+	fmt.Println("\n💡 Next steps:")
+	fmt.Println("   • Run 'goingenv recovery combine --set-password' to give the archives a new password")
+	displayUndoHint(recorder)
+
+	return nil
+}
+
+// Helper functions
+
+// setRecoveredPassword adds a slot for a new password to every archive that
+// the recovery key opens, checking each with its TrustFunc from trust.
+// Headers are updated only after all of them were unlocked.
+func setRecoveredPassword(app *types.App, archives []string, trust map[string]types.TrustFunc, recoveryKey []byte, newPasswordEnv, signKey string) error {
+	newKey, err := getNewPassword(app, newPasswordEnv)
+	if err != nil {
+		return err
+	}
+	defer newKey.Destroy()
+
+	// Without -f, only archives that have a slot for this recovery key are
+	// updated; others were not covered by the split
+	if len(archives) > 1 {
+		var matching []string
+		for _, archivePath := range archives {
+			if hasRecoverySlot(app, archivePath, recoveryKey) {
+				matching = append(matching, archivePath)
+			} else {
+				fmt.Printf("➖ %s: no slot for this recovery key\n", filepath.Base(archivePath))
+			}
+		}
+		if len(matching) == 0 {
+			return fmt.Errorf("no archive has a slot for this recovery key")
+		}
+		archives = matching
+	}
+
+	unlock := types.DecryptOptions{RecoveryKey: recoveryKey}
+	headers := make([][]byte, len(archives))
+	for i, archivePath := range archives {
+		header, err := app.Archiver.ReadHeader(archivePath)
+		if err != nil {
+			return fmt.Errorf("failed to read archive header of %s: %w", filepath.Base(archivePath), err)
+		}
+		headers[i], err = app.Crypto.AddKeySlot(header, unlock, types.EncryptOptions{Password: newKey})
+		if err != nil {
+			return fmt.Errorf("failed to set password on %s: %w", filepath.Base(archivePath), err)
+		}
+	}
+
+	sign := types.SignOptions{KeyPath: signKey, Passphrase: newPassphrasePrompt()}
+	for i, archivePath := range archives {
+		if err := replaceKeyHeader(app, archivePath, headers[i], sign, trust[archivePath]); err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(archivePath), err)
+		}
+		fmt.Printf("✅ Added the new password to %s\n", filepath.Base(archivePath))
+	}
+
+	fmt.Println("\n💡 Next steps:")
+	fmt.Println("   • Remove the old password slot with 'goingenv key remove' if it may be compromised")
+	fmt.Println("   • Consider 'goingenv recovery split' to issue fresh shares, since these were revealed")
+
+	return nil
+}
+
+// hasRecoverySlot reports whether an archive has a slot for the recovery
+// key. Key slots are public header data, so no key is needed to check.
+func hasRecoverySlot(app *types.App, archivePath string, recoveryKey []byte) bool {
+	header, err := app.Archiver.ReadHeader(archivePath)
+	if err != nil {
+		return false
+	}
+	slots, err := app.Crypto.ListKeySlots(header)
+	if err != nil {
+		return false
+	}
+
+	id := hex.EncodeToString(crypto.RecoveryKeyID(recoveryKey))
+	for _, slot := range slots {
+		if slot.Type == crypto.SlotRecovery.String() && slot.Recipient == id {
+			return true
+		}
+	}
+	return false
+}
+
+// getRecoveryKey rebuilds the recovery key from the given shares, or from
+// shares typed in one at a time until the threshold of the first is reached
+func getRecoveryKey(shareTexts []string) ([]byte, error) {
+	if len(shareTexts) == 0 {
+		threshold := 0
+		for i := 1; threshold == 0 || len(shareTexts) < threshold; i++ {
+			input, err := password.GetPassword(password.Options{
+				Prompt: fmt.Sprintf("Enter recovery share %d: ", i),
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to read recovery share: %w", err)
+			}
+			text := string(input.Bytes())
+			input.Destroy()
+			share, err := crypto.ParseRecoveryShare(text)
+			if err != nil {
+				fmt.Printf("⚠️  %v; try again\n", err)
+				i--
+				continue
+			}
+			threshold = share.Threshold
+			shareTexts = append(shareTexts, text)
+		}
+	}
+
+	recoveryKey, err := crypto.CombineRecoveryShares(shareTexts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rebuild recovery key: %w", err)
+	}
+	return recoveryKey, nil
+}
+
+// recoveryArchives returns the archive given with -f, or every archive in
+// the .goingenv directory
+func recoveryArchives(app *types.App, archiveFile string) ([]string, error) {
+	if archiveFile != "" {
+		if _, err := os.Stat(archiveFile); os.IsNotExist(err) {
+			return nil, fmt.Errorf("archive file not found: %s", archiveFile)
+		}
+		return []string{archiveFile}, nil
+	}
+
+	archives, err := app.Archiver.GetAvailableArchives("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to find archives: %w", err)
+	}
+	if len(archives) == 0 {
+		return nil, fmt.Errorf("no archives found in %s directory", config.GetGoingEnvDir())
+	}
+	return archives, nil
+}
+
+// resolveArchiveFile returns the archive given with -f, or the most recent
+// archive in the .goingenv directory
+func resolveArchiveFile(app *types.App, archiveFile string) (string, error) {
+	if archiveFile == "" {
+		archives, err := app.Archiver.GetAvailableArchives("")
+		if err != nil {
+			return "", fmt.Errorf("failed to find archives: %w", err)
+		}
+		if len(archives) == 0 {
+			return "", fmt.Errorf("no archives found in %s directory. Use -f flag to specify an archive", config.GetGoingEnvDir())
+		}
+		archiveFile = archives[len(archives)-1]
+		fmt.Printf("Using most recent archive: %s\n", filepath.Base(archiveFile))
+	}
+
+	if _, err := os.Stat(archiveFile); os.IsNotExist(err) {
+		return "", fmt.Errorf("archive file not found: %s", archiveFile)
+	}
+	return archiveFile, nil
+}
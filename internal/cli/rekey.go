@@ -0,0 +1,279 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"goingenv/internal/config"
+	"goingenv/internal/crypto"
+	"goingenv/pkg/password"
+	"goingenv/pkg/secret"
+	"goingenv/pkg/types"
+)
+
+// newRekeyCommand creates the rekey command
+func newRekeyCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rekey",
+		Short: "Re-encrypt archives with a new password",
+		Long: `Re-encrypt every archive in the .goingenv directory with a new password.
+
+The rekey command will:
+- Decrypt each archive with the current password (or identity)
+- Re-encrypt it under a fresh random key for the new password and for the
+  recipients currently listed in .goingenv/recipients
+- Keep each archive's creation date, description and file list unchanged
+- Replace each archive atomically, so an interrupted run never leaves a
+  half-rotated archive behind
+
+An archive unlocked with --key-file is re-encrypted for the same key file
+together with the new password, or for the key file alone with --no-password,
+so the key file stays a required factor. Use --new-key-file to replace it.
+
+Recovery slots cannot be carried over, since the recovery key is not known
+here, so the recovery shares of a rekeyed archive stop working. Archives with
+recovery slots are refused unless --drop-recovery is given; run 'goingenv
+recovery split' afterwards to issue new shares.
+
+Re-encrypting invalidates any signature, so pass --sign to sign the new
+archives. Without it a warning is printed for each signature removed, and
+signed archives are left unchanged if .goingenv/trusted_signers lists keys.
+Signatures are checked against that file first, as for unpack, so archives
+from untrusted signers are not signed again unless --allow-untrusted is
+given. Archives that the current password does not open are reported and
+left untouched. Unlike 'goingenv key remove', rekeying changes the key the
+files are encrypted with, so old key slots and recipients lose access
+entirely.
+
+Examples:
+  goingenv rekey                                          # Interactive prompts
+  goingenv rekey -f .goingenv/prod.enc                    # Rekey a single archive
+  goingenv rekey --password-env OLD_PW --new-password-env NEW_PW
+  goingenv rekey --key-file ci.key --new-key-file ci-2.key --no-password  # Rotate a key file`,
+		RunE: runRekeyCommand,
+	}
+
+	cmd.Flags().StringP("file", "f", "", "Archive file to rekey (default: all archives)")
+	cmd.Flags().String("password-env", "", "Read the current password from environment variable")
+	cmd.Flags().String("key-file", "", "Current key file to decrypt with")
+	cmd.Flags().StringSlice("identity", nil, "Identity file (goingenv or OpenSSH ed25519 key) to decrypt with instead of a password (repeatable)")
+	cmd.Flags().String("new-password-env", "", "Read the new password from environment variable")
+	cmd.Flags().String("new-key-file", "", "Key file for the re-encrypted archives (default: the current --key-file)")
+	cmd.Flags().Bool("drop-recovery", false, "Rekey archives with recovery slots, which removes them and invalidates their shares")
+	cmd.Flags().Bool("no-password", false, "Encrypt only to the key file or the recipients file, without a new password")
+	cmd.Flags().String("sign", "", "Sign the re-encrypted archives with this OpenSSH ed25519 private key")
+	cmd.Flags().Bool("allow-untrusted", false, "Open archives that are unsigned or not signed by a trusted signer, with a warning")
+	cmd.Flags().BoolP("verbose", "v", false, "Show detailed information")
+
+	return cmd
+}
+
+// runRekeyCommand executes the rekey command
+func runRekeyCommand(cmd *cobra.Command, args []string) error {
+	// Check if GoingEnv is initialized
+	if !config.IsInitialized() {
+		return fmt.Errorf("goingenv is not initialized in this directory. Run 'goingenv init' first")
+	}
+
+	// Initialize application
+	app, err := NewApp()
+	if err != nil {
+		return fmt.Errorf("failed to initialize application: %w", err)
+	}
+
+	// Parse flags
+	archiveFile, _ := cmd.Flags().GetString("file")
+	passwordEnv, _ := cmd.Flags().GetString("password-env")
+	keyFilePath, _ := cmd.Flags().GetString("key-file")
+	identities, _ := cmd.Flags().GetStringSlice("identity")
+	passphrase := newPassphrasePrompt()
+	newPasswordEnv, _ := cmd.Flags().GetString("new-password-env")
+	newKeyFilePath, _ := cmd.Flags().GetString("new-key-file")
+	noPassword, _ := cmd.Flags().GetBool("no-password")
+	dropRecovery, _ := cmd.Flags().GetBool("drop-recovery")
+	verbose, _ := cmd.Flags().GetBool("verbose")
+	signKey, _ := cmd.Flags().GetString("sign")
+	allowUntrusted, _ := cmd.Flags().GetBool("allow-untrusted")
+
+	var archives []string
+	if archiveFile != "" {
+		if _, err := os.Stat(archiveFile); os.IsNotExist(err) {
+			return fmt.Errorf("archive file not found: %s", archiveFile)
+		}
+		archives = []string{archiveFile}
+	} else {
+		archives, err = app.Archiver.GetAvailableArchives("")
+		if err != nil {
+			return fmt.Errorf("failed to find archives: %w", err)
+		}
+		if len(archives) == 0 {
+			return fmt.Errorf("no archives found in %s directory", config.GetGoingEnvDir())
+		}
+	}
+
+	// Check the signatures before asking for any password
+	trust, err := checkArchiveSignatures(app, archives, allowUntrusted)
+	if err != nil {
+		return err
+	}
+
+	// Once trusted signers are listed, an archive rekeyed without --sign
+	// could only be opened with --allow-untrusted
+	trusted, err := config.LoadTrustedSigners()
+	if err != nil {
+		return err
+	}
+	requireSign := signKey == "" && len(trusted) > 0
+
+	// Recovery slots are lost on rekeying, which breaks shares handed out
+	// earlier, so only go ahead when asked to
+	for _, archivePath := range archives {
+		count, err := countRecoverySlots(app, archivePath)
+		if err != nil {
+			return fmt.Errorf("failed to read key slots of %s: %w", filepath.Base(archivePath), err)
+		}
+		if count == 0 {
+			continue
+		}
+		if !dropRecovery {
+			return fmt.Errorf("%s has %d recovery slot(s) that rekeying removes, so its recovery shares would stop working. "+
+				"Use --drop-recovery to rekey anyway, then 'goingenv recovery split' to issue new shares", filepath.Base(archivePath), count)
+		}
+		fmt.Printf("⚠️  %s: removing %d recovery slot(s); its recovery shares stop working\n", filepath.Base(archivePath), count)
+	}
+
+	// Load recipients so they keep access to the re-encrypted archives
+	recipients, err := config.LoadRecipients()
+	if err != nil {
+		return fmt.Errorf("failed to read recipients: %w", err)
+	}
+	if _, err := crypto.ParseRecipients(recipients); err != nil {
+		return fmt.Errorf("invalid recipient in %s: %w", config.GetRecipientsPath(), err)
+	}
+	if noPassword && len(recipients) == 0 && keyFilePath == "" && newKeyFilePath == "" {
+		return fmt.Errorf("--no-password requires a key file or recipients in %s", config.GetRecipientsPath())
+	}
+
+	// Get the current password, unless identities or a key file are given
+	passwordOpts := password.Options{
+		PasswordEnv: passwordEnv,
+		Prompt:      "Enter current password: ",
+		KeyFile:     keyFilePath,
+	}
+	keyFile, err := getKeyFile(passwordOpts)
+	if err != nil {
+		return err
+	}
+	defer password.ClearKeyFile(keyFile)
+
+	// Keep the current key file as a factor unless another one is given
+	newKeyFile := keyFile
+	if newKeyFilePath != "" {
+		newKeyFile, err = getKeyFile(password.Options{KeyFile: newKeyFilePath})
+		if err != nil {
+			return err
+		}
+		defer password.ClearKeyFile(newKeyFile)
+	}
+
+	key, err := getDecryptPassword(app, passwordOpts, identities, archives...)
+	if err != nil {
+		return err
+	}
+	defer key.Destroy()
+
+	var newKey *secret.Buffer
+	if !noPassword {
+		newKey, err = getNewPassword(app, newPasswordEnv)
+		if err != nil {
+			return err
+		}
+		defer newKey.Destroy()
+
+		if newKey.Equal(key) && bytes.Equal(newKeyFile, keyFile) {
+			return fmt.Errorf("new password must differ from the current password")
+		}
+	}
+
+	if verbose {
+		fmt.Printf("Archives: %d\n", len(archives))
+		if len(recipients) > 0 {
+			fmt.Printf("Recipients: %d from %s\n", len(recipients), config.GetRecipientsPath())
+		}
+		fmt.Println()
+	}
+
+	// Rekey archives one at a time; each is replaced atomically
+	sign := types.SignOptions{KeyPath: signKey, Passphrase: passphrase}
+	var failed int
+	for _, archivePath := range archives {
+		// Note the signer of the file that is rekeyed, for the warning below
+		var signer *types.SignatureInfo
+		check := trust[archivePath]
+		err := app.Archiver.Rekey(types.RekeyOptions{
+			ArchivePath: archivePath,
+			Password:    key,
+			KeyFile:     keyFile,
+			Identities:  identities,
+			Passphrase:  passphrase,
+			NewPassword: newKey,
+			NewKeyFile:  newKeyFile,
+			Recipients:  recipients,
+			Sign:        sign,
+			Trust: func(opened *types.SignatureInfo) error {
+				signer = opened
+				if opened != nil && requireSign {
+					return fmt.Errorf("rekeying would remove the signature by %s and %s lists trusted signers. Use --sign to sign it again",
+						opened.Fingerprint, config.GetTrustedSignersPath())
+				}
+				return check(opened)
+			},
+		})
+		if err != nil {
+			failed++
+			fmt.Printf("❌ %s: %v\n", filepath.Base(archivePath), err)
+			continue
+		}
+		fmt.Printf("✅ %s\n", filepath.Base(archivePath))
+		warnDroppedSignature(signer, sign)
+	}
+
+	fmt.Printf("\nRekeyed %d of %d archives\n", len(archives)-failed, len(archives))
+
+	if failed > 0 {
+		return fmt.Errorf("%d archive(s) could not be rekeyed and were left unchanged", failed)
+	}
+
+	fmt.Println("\n💡 Next steps:")
+	fmt.Println("   • Share the new password with the remaining team members")
+	fmt.Println("   • Rotate the secrets themselves if a departing member had access to them")
+
+	return nil
+}
+
+// Helper functions
+
+// countRecoverySlots returns the number of recovery slots of an archive. Key
+// slots are public header data, so no key is needed to count them.
+func countRecoverySlots(app *types.App, archivePath string) (int, error) {
+	header, err := app.Archiver.ReadHeader(archivePath)
+	if err != nil {
+		return 0, err
+	}
+	slots, err := app.Crypto.ListKeySlots(header)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	for _, slot := range slots {
+		if slot.Type == crypto.SlotRecovery.String() {
+			count++
+		}
+	}
+	return count, nil
+}
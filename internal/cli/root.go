@@ -6,6 +6,7 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/spf13/cobra"
 
+	"goingenv/internal/agent"
 	"goingenv/internal/archive"
 	"goingenv/internal/config"
 	"goingenv/internal/crypto"
@@ -26,7 +27,8 @@ func NewApp() (*types.App, error) {
 	}
 
 	// Initialize services
-	cryptoService := crypto.NewService()
+	cryptoService := crypto.NewServiceWithConfig(cfg)
+	cryptoService.SetKeyCache(agent.NewClient(agent.SocketPath()))
 	scannerService := scanner.NewService(cfg)
 	archiverService := archive.NewService(cryptoService)
 
@@ -63,7 +65,18 @@ backup, transfer, and restore your environment configurations.`,
 	rootCmd.AddCommand(newPackCommand())
 	rootCmd.AddCommand(newUnpackCommand())
 	rootCmd.AddCommand(newListCommand())
+	rootCmd.AddCommand(newDiffCommand())
 	rootCmd.AddCommand(newStatusCommand())
+	rootCmd.AddCommand(newKeygenCommand())
+	rootCmd.AddCommand(newGenpassCommand())
+	rootCmd.AddCommand(newKDFCommand())
+	rootCmd.AddCommand(newKeyCommand())
+	rootCmd.AddCommand(newRekeyCommand())
+	rootCmd.AddCommand(newVerifySignatureCommand())
+	rootCmd.AddCommand(newRecoveryCommand())
+	rootCmd.AddCommand(newAgentCommand())
+	rootCmd.AddCommand(newBackupsCommand())
+	rootCmd.AddCommand(newUndoCommand())
 
 	return rootCmd
 }
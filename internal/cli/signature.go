@@ -0,0 +1,204 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"goingenv/internal/config"
+	"goingenv/internal/crypto"
+	"goingenv/pkg/types"
+)
+
+// newVerifySignatureCommand creates the verify-signature command
+func newVerifySignatureCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "verify-signature",
+		Short: "Check who signed an archive",
+		Long: `Verify the signatures of archives and check the signers against the
+trusted signers file (.goingenv/trusted_signers).
+
+Archives are signed with 'goingenv pack --sign ~/.ssh/id_ed25519'. The
+signature covers the whole encrypted archive, so no password is needed to
+verify it. List the SSH public keys of the people allowed to publish archives
+in .goingenv/trusted_signers, one per line; the comment after each key names
+the signer. Once that file lists any keys, commands that open or rewrite
+archives refuse those that are unsigned or signed by anyone else.
+
+Examples:
+  goingenv verify-signature                       # Check all archives
+  goingenv verify-signature -f .goingenv/prod.enc # Check a single archive`,
+		RunE: runVerifySignatureCommand,
+	}
+
+	cmd.Flags().StringP("file", "f", "", "Archive file to verify (default: all archives)")
+
+	return cmd
+}
+
+// runVerifySignatureCommand executes the verify-signature command
+func runVerifySignatureCommand(cmd *cobra.Command, args []string) error {
+	// Check if GoingEnv is initialized
+	if !config.IsInitialized() {
+		return fmt.Errorf("goingenv is not initialized in this directory. Run 'goingenv init' first")
+	}
+
+	// Initialize application
+	app, err := NewApp()
+	if err != nil {
+		return fmt.Errorf("failed to initialize application: %w", err)
+	}
+
+	archiveFile, _ := cmd.Flags().GetString("file")
+
+	var archives []string
+	if archiveFile != "" {
+		if _, err := os.Stat(archiveFile); os.IsNotExist(err) {
+			return fmt.Errorf("archive file not found: %s", archiveFile)
+		}
+		archives = []string{archiveFile}
+	} else {
+		archives, err = app.Archiver.GetAvailableArchives("")
+		if err != nil {
+			return fmt.Errorf("failed to find archives: %w", err)
+		}
+		if len(archives) == 0 {
+			return fmt.Errorf("no archives found in %s directory", config.GetGoingEnvDir())
+		}
+	}
+
+	trusted, err := config.LoadTrustedSigners()
+	if err != nil {
+		return err
+	}
+	if _, _, err := crypto.FindTrustedSigner(nil, trusted); err != nil {
+		return fmt.Errorf("invalid key in %s: %w", config.GetTrustedSignersPath(), err)
+	}
+
+	var failed int
+	for _, archivePath := range archives {
+		name := filepath.Base(archivePath)
+
+		signer, err := app.Archiver.VerifySignature(archivePath)
+		if err != nil {
+			failed++
+			fmt.Printf("❌ %s: %v\n", name, err)
+			continue
+		}
+		if signer == nil {
+			if len(trusted) > 0 {
+				failed++
+				fmt.Printf("❌ %s: not signed\n", name)
+			} else {
+				fmt.Printf("➖ %s: not signed\n", name)
+			}
+			continue
+		}
+
+		comment, ok, _ := crypto.FindTrustedSigner(signer, trusted)
+		switch {
+		case ok:
+			fmt.Printf("✅ %s: signed by %s\n", name, describeSigner(signer, comment))
+		case len(trusted) > 0:
+			failed++
+			fmt.Printf("❌ %s: signed by untrusted key %s\n", name, signer.Fingerprint)
+		default:
+			fmt.Printf("⚠️  %s: signed by %s (no trusted signers configured)\n", name, signer.Fingerprint)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d archive(s) failed signature verification", failed, len(archives))
+	}
+
+	return nil
+}
+
+// Helper functions
+
+// checkArchiveSignature verifies the signature of an archive before it is
+// opened and applies the trusted signers policy. A signature that does not
+// verify is always an error. When .goingenv/trusted_signers lists keys,
+// archives that are unsigned or signed by another key are refused unless
+// allowUntrusted is set, in which case only a warning is printed.
+//
+// The check runs before any password is asked for. The returned TrustFunc
+// goes into the options of the call that decrypts or rewrites the archive,
+// which checks it again on the file it actually opens.
+func checkArchiveSignature(app *types.App, archivePath string, allowUntrusted bool) (types.TrustFunc, error) {
+	signer, err := app.Archiver.VerifySignature(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("signature check failed: %w", err)
+	}
+
+	trusted, err := config.LoadTrustedSigners()
+	if err != nil {
+		return nil, err
+	}
+
+	comment, ok, err := crypto.FindTrustedSigner(signer, trusted)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key in %s: %w", config.GetTrustedSignersPath(), err)
+	}
+	switch {
+	case ok:
+		fmt.Printf("🔏 Signed by %s\n", describeSigner(signer, comment))
+		return sameSigner(archivePath, signer), nil
+	case len(trusted) == 0:
+		if signer != nil {
+			fmt.Printf("⚠️  Signed by unknown key %s (add it to %s to trust it)\n",
+				signer.Fingerprint, config.GetTrustedSignersPath())
+		}
+		return sameSigner(archivePath, signer), nil
+	}
+
+	problem := "is not signed"
+	if signer != nil {
+		problem = fmt.Sprintf("is signed by untrusted key %s", signer.Fingerprint)
+	}
+	if allowUntrusted {
+		fmt.Printf("⚠️  %s %s\n", filepath.Base(archivePath), problem)
+		return sameSigner(archivePath, signer), nil
+	}
+	return nil, fmt.Errorf("%s %s and %s lists trusted signers. Use --allow-untrusted to open it anyway",
+		filepath.Base(archivePath), problem, config.GetTrustedSignersPath())
+}
+
+// checkArchiveSignatures runs checkArchiveSignature on every archive and
+// returns their TrustFuncs by path. It fails on the first archive refused.
+func checkArchiveSignatures(app *types.App, archives []string, allowUntrusted bool) (map[string]types.TrustFunc, error) {
+	trust := make(map[string]types.TrustFunc, len(archives))
+	for _, archivePath := range archives {
+		check, err := checkArchiveSignature(app, archivePath, allowUntrusted)
+		if err != nil {
+			return nil, err
+		}
+		trust[archivePath] = check
+	}
+	return trust, nil
+}
+
+// sameSigner returns a TrustFunc that only accepts an archive signed by the
+// same key as signer, or an unsigned one if signer is nil, so that the file
+// that is decrypted has passed checkArchiveSignature even if the archive was
+// replaced after the check
+func sameSigner(archivePath string, signer *types.SignatureInfo) types.TrustFunc {
+	return func(opened *types.SignatureInfo) error {
+		if opened == nil && signer == nil ||
+			opened != nil && signer != nil && opened.PublicKey == signer.PublicKey {
+			return nil
+		}
+		return fmt.Errorf("%s changed after its signature was checked", filepath.Base(archivePath))
+	}
+}
+
+// describeSigner names a signer by the comment of its trusted signers entry
+// and its fingerprint
+func describeSigner(signer *types.SignatureInfo, comment string) string {
+	if comment == "" {
+		return signer.Fingerprint
+	}
+	return fmt.Sprintf("%s (%s)", comment, signer.Fingerprint)
+}
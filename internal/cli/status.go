@@ -11,6 +11,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"goingenv/internal/config"
+	"goingenv/internal/crypto"
 	"goingenv/internal/scanner"
 	"goingenv/pkg/types"
 	"goingenv/pkg/utils"
@@ -310,6 +311,15 @@ func displayConfigInfo(app *types.App, verbose bool) {
 
 	fmt.Printf("Scan depth: %d directories\n", config.DefaultDepth)
 	fmt.Printf("Max file size: %s\n", utils.FormatSize(config.MaxFileSize))
+	if cipherID, err := crypto.ParseCipherName(config.Cipher); err == nil {
+		fmt.Printf("Cipher: %s\n", cipherID)
+	}
+	if compression, err := crypto.ParseCompressionName(config.Compression); err == nil {
+		fmt.Printf("Compression: %s\n", compression)
+	}
+	if kdf, params, err := crypto.KDFFromConfig(config.KDF); err == nil {
+		fmt.Printf("Key derivation: %s (%s)\n", kdf, params.String(kdf))
+	}
 
 	fmt.Printf("\nFile patterns (%d):\n", len(config.EnvPatterns))
 	for i, pattern := range config.EnvPatterns {
@@ -397,7 +407,7 @@ func displayStatsAndAnalysis(app *types.App, directory string, verbose bool) err
 		fmt.Printf("  Storage used: %s across %d archives\n",
 			utils.FormatSize(totalArchiveSize), len(archives))
 
-		// Estimate compression ratio
+		// Compare the average archive with the files it would hold today
 		if len(files) > 0 {
 			var totalFileSize int64
 			for _, file := range files {
@@ -405,8 +415,8 @@ func displayStatsAndAnalysis(app *types.App, directory string, verbose bool) err
 			}
 
 			if totalFileSize > 0 && len(archives) > 0 {
-				avgCompressionRatio := float64(totalArchiveSize) / float64(totalFileSize) * 100
-				fmt.Printf("  Estimated compression: %.1f%% of original size\n", avgCompressionRatio)
+				avgCompressionRatio := float64(totalArchiveSize) / float64(len(archives)) / float64(totalFileSize) * 100
+				fmt.Printf("  Average archive size: %.1f%% of the current files\n", avgCompressionRatio)
 			}
 		}
 	}
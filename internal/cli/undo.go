@@ -0,0 +1,156 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"goingenv/internal/backup"
+	"goingenv/internal/config"
+	"goingenv/internal/journal"
+	"goingenv/pkg/types"
+)
+
+// newUndoCommand creates the undo command
+func newUndoCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "undo",
+		Short: "Undo the most recent unpack",
+		Long: `Put the files back the way they were before the most recent unpack.
+
+Every unpack records in .goingenv/journal which files it created and which it
+replaced, with checksums before and after and the backup in .goingenv/backups
+that holds the replaced versions. Undo removes the created files, restores the
+replaced ones from the backup after checking their checksums, and removes
+directories the unpack created if they are empty. Running undo again undoes
+the unpack before that.
+
+Files that changed since the unpack are left alone unless --force is given.
+An unpack run with --backup=false that replaced files cannot be undone; undo
+skips it with a warning and undoes the unpack before it, whose files the
+skipped unpack may have changed.
+Whatever undo removes or overwrites is saved in a new backup first, so it can
+be brought back with 'goingenv backups restore'.
+
+Examples:
+  goingenv undo --dry-run    # Show what would be undone
+  goingenv undo
+  goingenv undo --force      # Also undo files edited since the unpack`,
+		RunE: runUndoCommand,
+	}
+
+	cmd.Flags().Bool("dry-run", false, "Show what would be undone without doing it")
+	cmd.Flags().Bool("force", false, "Undo files that changed since the unpack")
+
+	return cmd
+}
+
+// runUndoCommand executes the undo command
+func runUndoCommand(cmd *cobra.Command, args []string) error {
+	// Check if GoingEnv is initialized
+	if !config.IsInitialized() {
+		return fmt.Errorf("goingenv is not initialized in this directory. Run 'goingenv init' first")
+	}
+
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	force, _ := cmd.Flags().GetBool("force")
+
+	j := journal.New(journal.DefaultDir())
+	store := backup.NewStore(backup.DefaultDir())
+
+	entry, skipped, err := j.Last()
+	if err != nil {
+		return err
+	}
+	for _, irreversible := range skipped {
+		fmt.Printf("⚠️  Skipping unpack %s of %s: it replaced files without a backup (--backup=false) and cannot be undone\n",
+			irreversible.ID, irreversible.Archive)
+	}
+	if entry == nil {
+		fmt.Println("Nothing to undo: the journal has no unpack left to undo")
+		return nil
+	}
+	if len(skipped) > 0 {
+		fmt.Println()
+	}
+
+	plan, err := j.Check(entry, store)
+	if err != nil {
+		return fmt.Errorf("cannot undo unpack %s: %w", entry.ID, err)
+	}
+
+	fmt.Printf("Unpack %s of %s at %s\n", entry.ID, entry.Archive, entry.CreatedAt.Format("2006-01-02 15:04:05"))
+	fmt.Printf("Target directory: %s\n", entry.TargetDir)
+	displayUndoPlan(plan)
+
+	if dryRun {
+		fmt.Printf("\nDry run completed. Nothing was changed.\n")
+		return nil
+	}
+
+	snapshot, err := j.Undo(entry, store, force)
+	var changed *journal.ChangedError
+	if errors.As(err, &changed) {
+		fmt.Printf("\n⚠️  %d files changed since the unpack:\n", len(changed.Files))
+		for _, file := range changed.Files {
+			fmt.Printf("  • %s\n", file)
+		}
+		return fmt.Errorf("refusing to undo changed files; use --force to undo them anyway")
+	}
+	if err != nil {
+		if snapshot != nil {
+			displayBackupSnapshot(snapshot)
+		}
+		return fmt.Errorf("failed to undo unpack %s: %w", entry.ID, err)
+	}
+
+	fmt.Printf("\n✅ Undid unpack %s\n", entry.ID)
+	displayBackupSnapshot(snapshot)
+
+	return nil
+}
+
+// Helper functions
+
+// displayUndoPlan shows the changes undo would make
+func displayUndoPlan(plan *journal.Plan) {
+	if len(plan.Restore) > 0 {
+		fmt.Printf("\nFiles to restore from backup:\n")
+		for _, file := range plan.Restore {
+			fmt.Printf("  • %s\n", file)
+		}
+	}
+	if len(plan.Remove) > 0 {
+		fmt.Printf("\nFiles to remove:\n")
+		for _, file := range plan.Remove {
+			fmt.Printf("  • %s\n", file)
+		}
+	}
+	if len(plan.Changed) > 0 {
+		fmt.Printf("\nChanged since the unpack (needs --force):\n")
+		for _, file := range plan.Changed {
+			fmt.Printf("  • %s\n", file)
+		}
+	}
+}
+
+// newUnpackJournal sets opts to record the unpack in the journal, with the
+// replaced files saved in snapshot, which may be nil
+func newUnpackJournal(opts *types.UnpackOptions, snapshot *backup.Snapshot) *journal.Recorder {
+	recorder := journal.New(journal.DefaultDir()).NewUnpack(opts.ArchivePath, snapshot)
+	opts.Journal = recorder
+	return recorder
+}
+
+// displayUndoHint tells whether the unpack recorded by recorder can be undone
+func displayUndoHint(recorder *journal.Recorder) {
+	entry := recorder.Entry()
+	switch {
+	case entry == nil:
+	case entry.Reversible():
+		fmt.Println("   • Run 'goingenv undo' to put the previous files back")
+	default:
+		fmt.Println("   • Files were replaced without a backup, so 'goingenv undo' cannot undo this unpack")
+	}
+}
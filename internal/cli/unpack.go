@@ -4,12 +4,15 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 
 	"goingenv/internal/config"
+	"goingenv/internal/crypto"
 	"goingenv/pkg/password"
+	"goingenv/pkg/secret"
 	"goingenv/pkg/types"
 	"goingenv/pkg/utils"
 )
@@ -17,35 +20,56 @@ import (
 // newUnpackCommand creates the unpack command
 func newUnpackCommand() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "unpack",
+		Use:   "unpack [files...]",
 		Short: "Unpack and decrypt archived files",
 		Long: `Decrypt and extract files from an encrypted archive.
 
 The unpack command will:
 - Decrypt the specified archive using the provided password
-- Verify file integrity using stored checksums
-- Extract files to the specified directory (default: current directory)
-- Optionally create backups of existing files before overwriting
+- Extract files to a staging area and verify them using stored checksums
+- Move them into the specified directory (default: current directory) only
+  once every file has been verified, leaving the directory untouched otherwise
+- Save existing files in .goingenv/backups before overwriting them, and record
+  the unpack in .goingenv/journal so 'goingenv undo' can reverse it
+
+Files named as arguments, or matching --include, are extracted and all others
+are left in the archive; --exclude skips matching files. Patterns are matched
+against the whole relative path, so "*" does not match files in subdirectories.
 
 Examples:
   goingenv unpack                                         # Interactive password prompt
   goingenv unpack --password-env MY_PASSWORD             # Read from environment variable
   goingenv unpack -f backup-prod.enc --target /path/to/extract  # Specify archive and target
-  goingenv unpack -f archive.enc --overwrite --backup    # Overwrite with backup`,
+  goingenv unpack -f archive.enc --overwrite             # Overwrite, keeping a backup
+  goingenv unpack --overwrite --backup=false              # Overwrite without a backup
+  goingenv unpack .env api/.env                           # Only extract these files
+  goingenv unpack --include ".env.*" --exclude "*.local"  # Select files by pattern
+  goingenv unpack --identity ~/.config/goingenv/identity.txt  # Decrypt with a private key
+  goingenv unpack --identity ~/.ssh/id_ed25519            # Decrypt with an SSH key
+  goingenv unpack --key-file /run/secrets/goingenv.key     # Decrypt with a key file
+
+Signatures:
+  Signed archives are verified before they are decrypted. If
+  .goingenv/trusted_signers lists any keys, archives that are unsigned or signed
+  by another key are refused unless --allow-untrusted is given.`,
 		RunE: runUnpackCommand,
 	}
 
 	// Add flags
 	cmd.Flags().String("password-env", "", "Read password from environment variable")
+	cmd.Flags().String("key-file", "", "Key file to decrypt with, alone or together with the password")
+	cmd.Flags().StringSlice("identity", nil, "Identity file (goingenv or OpenSSH ed25519 key) to decrypt with instead of a password (repeatable)")
 	cmd.Flags().StringP("file", "f", "", "Archive file to unpack (default: most recent)")
 	cmd.Flags().StringP("target", "t", "", "Target directory for extraction (default: current directory)")
 	cmd.Flags().Bool("overwrite", false, "Overwrite existing files without prompting")
-	cmd.Flags().Bool("backup", false, "Create backups of existing files before overwriting")
+	cmd.Flags().Bool("backup", true, "Save existing files in .goingenv/backups before overwriting them, so 'goingenv undo' can restore them")
 	cmd.Flags().Bool("verify", true, "Verify file checksums after extraction")
+	_ = cmd.Flags().MarkDeprecated("verify", "checksums are always verified before files are written")
 	cmd.Flags().BoolP("verbose", "v", false, "Show detailed information during unpacking")
 	cmd.Flags().BoolP("dry-run", "", false, "Show what would be extracted without actually doing it")
 	cmd.Flags().StringSliceP("include", "i", nil, "Only extract files matching these patterns")
 	cmd.Flags().StringSliceP("exclude", "e", nil, "Skip files matching these patterns")
+	cmd.Flags().Bool("allow-untrusted", false, "Open archives that are unsigned or not signed by a trusted signer, with a warning")
 
 	return cmd
 }
@@ -83,7 +107,17 @@ func runUnpackCommand(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("archive file not found: %s", archiveFile)
 	}
 
+	// Check the signature before asking for any password
+	allowUntrusted, _ := cmd.Flags().GetBool("allow-untrusted")
+	trust, err := checkArchiveSignature(app, archiveFile, allowUntrusted)
+	if err != nil {
+		return err
+	}
+
 	passwordEnv, _ := cmd.Flags().GetString("password-env")
+	keyFilePath, _ := cmd.Flags().GetString("key-file")
+	identities, _ := cmd.Flags().GetStringSlice("identity")
+	passphrase := newPassphrasePrompt()
 	targetDir, _ := cmd.Flags().GetString("target")
 	if targetDir == "" {
 		targetDir = "."
@@ -91,29 +125,39 @@ func runUnpackCommand(cmd *cobra.Command, args []string) error {
 
 	overwrite, _ := cmd.Flags().GetBool("overwrite")
 	backup, _ := cmd.Flags().GetBool("backup")
-	verify, _ := cmd.Flags().GetBool("verify")
 	verbose, _ := cmd.Flags().GetBool("verbose")
 	dryRun, _ := cmd.Flags().GetBool("dry-run")
 	includePatterns, _ := cmd.Flags().GetStringSlice("include")
 	excludePatterns, _ := cmd.Flags().GetStringSlice("exclude")
 
-	// Get password using secure methods
-	passwordOpts := password.Options{
-		PasswordEnv: passwordEnv,
+	// The archiver only extracts the selected files
+	var selection *types.FileSelection
+	if len(args) > 0 || len(includePatterns) > 0 || len(excludePatterns) > 0 {
+		selection = &types.FileSelection{
+			Paths:   args,
+			Include: includePatterns,
+			Exclude: excludePatterns,
+		}
+		if err := selection.Validate(); err != nil {
+			return err
+		}
 	}
 
-	// Validate password options
-	if err := password.ValidatePasswordOptions(passwordOpts); err != nil {
-		return fmt.Errorf("invalid password options: %w", err)
+	// Get password using secure methods, unless identities or a key file are given
+	passwordOpts := password.Options{PasswordEnv: passwordEnv, KeyFile: keyFilePath}
+	keyFile, err := getKeyFile(passwordOpts)
+	if err != nil {
+		return err
 	}
+	defer password.ClearKeyFile(keyFile)
 
-	key, err := password.GetPassword(passwordOpts)
+	key, err := getDecryptPassword(app, passwordOpts, identities, archiveFile)
 	if err != nil {
-		return fmt.Errorf("failed to get password: %w", err)
+		return err
 	}
 
 	// Ensure password is cleared from memory when done
-	defer password.ClearPassword(&key)
+	defer key.Destroy()
 
 	if verbose {
 		fmt.Printf("Archive: %s\n", archiveFile)
@@ -123,111 +167,122 @@ func runUnpackCommand(cmd *cobra.Command, args []string) error {
 		fmt.Println()
 	}
 
-	// First, list the archive contents to show what will be extracted
+	// The archive is previewed and extracted in a single decryption pass:
+	// Unpack calls confirm with the archive index before extracting anything
 	fmt.Printf("Reading archive: %s\n", filepath.Base(archiveFile))
-	archive, err := app.Archiver.List(archiveFile, key)
-	if err != nil {
-		return fmt.Errorf("failed to read archive (check password): %w", err)
-	}
-
-	// Filter files if patterns are specified
-	filesToExtract := archive.Files
-	if len(includePatterns) > 0 || len(excludePatterns) > 0 {
-		filesToExtract = filterFiles(archive.Files, includePatterns, excludePatterns)
-	}
+	var filesToExtract []types.EnvFile
+	var conflicts []string
+	var startTime time.Time
+	opened, extracted := false, false
+	confirm := func(archive *types.Archive) (bool, bool, error) {
+		opened = true
+		if missing := selection.MissingPaths(archive.Files); len(missing) > 0 {
+			return false, false, fmt.Errorf("archive does not contain %s", strings.Join(missing, ", "))
+		}
+		filesToExtract = selection.Filter(archive.Files)
 
-	// Display archive information
-	fmt.Printf("Archive created: %s\n", archive.CreatedAt.Format("2006-01-02 15:04:05"))
-	fmt.Printf("Archive version: %s\n", archive.Version)
-	if archive.Description != "" {
-		fmt.Printf("Description: %s\n", archive.Description)
-	}
-	fmt.Printf("Files to extract: %d of %d total\n", len(filesToExtract), len(archive.Files))
-
-	if verbose || len(filesToExtract) <= 20 {
-		fmt.Println("\nFiles to extract:")
-		for i, file := range filesToExtract {
-			if i < 20 {
-				fmt.Printf("  • %s (%s) - %s\n",
-					file.RelativePath,
-					utils.FormatSize(file.Size),
-					file.ModTime.Format("2006-01-02 15:04:05"))
-			} else if i == 20 {
-				fmt.Printf("  • ... and %d more files\n", len(filesToExtract)-20)
-				break
+		// Display archive information
+		fmt.Printf("Archive created: %s\n", archive.CreatedAt.Format("2006-01-02 15:04:05"))
+		fmt.Printf("Archive version: %s\n", archive.Version)
+		if archive.Description != "" {
+			fmt.Printf("Description: %s\n", archive.Description)
+		}
+		fmt.Printf("Files to extract: %d of %d total\n", len(filesToExtract), len(archive.Files))
+
+		if verbose || len(filesToExtract) <= 20 {
+			fmt.Println("\nFiles to extract:")
+			for i, file := range filesToExtract {
+				if i < 20 {
+					fmt.Printf("  • %s (%s) - %s\n",
+						file.RelativePath,
+						utils.FormatSize(file.Size),
+						file.ModTime.Format("2006-01-02 15:04:05"))
+				} else if i == 20 {
+					fmt.Printf("  • ... and %d more files\n", len(filesToExtract)-20)
+					break
+				}
 			}
 		}
-	}
 
-	// Check for conflicts with existing files
-	conflicts := checkFileConflicts(filesToExtract, targetDir)
-	if len(conflicts) > 0 && !overwrite {
-		fmt.Printf("\n⚠️  Found %d existing files that would be overwritten:\n", len(conflicts))
-		for i, conflict := range conflicts {
-			if i < 10 {
-				fmt.Printf("  • %s\n", conflict)
-			} else if i == 10 {
-				fmt.Printf("  • ... and %d more files\n", len(conflicts)-10)
-				break
+		// Check for conflicts with existing files
+		confirmed := false
+		conflicts = checkFileConflicts(filesToExtract, targetDir)
+		if len(conflicts) > 0 && !overwrite {
+			fmt.Printf("\n⚠️  Found %d existing files that would be overwritten:\n", len(conflicts))
+			for i, conflict := range conflicts {
+				if i < 10 {
+					fmt.Printf("  • %s\n", conflict)
+				} else if i == 10 {
+					fmt.Printf("  • ... and %d more files\n", len(conflicts)-10)
+					break
+				}
+			}
+
+			if !dryRun {
+				fmt.Printf("\nUse --overwrite to replace existing files, or --backup to create backups.\n")
+				fmt.Printf("Continue anyway? [y/N]: ")
+				var response string
+				fmt.Scanln(&response)
+				if response != "y" && response != "Y" && response != "yes" {
+					fmt.Println("Operation cancelled.")
+					return false, false, nil
+				}
+				confirmed = true // User confirmed
 			}
 		}
 
-		if !dryRun {
-			fmt.Printf("\nUse --overwrite to replace existing files, or --backup to create backups.\n")
-			fmt.Printf("Continue anyway? [y/N]: ")
-			var response string
-			fmt.Scanln(&response)
-			if response != "y" && response != "Y" && response != "yes" {
-				fmt.Println("Operation cancelled.")
-				return nil
+		// Dry run - stop here if requested
+		if dryRun {
+			fmt.Printf("\nDry run completed. %d files would be extracted to %s\n",
+				len(filesToExtract), targetDir)
+			if len(conflicts) > 0 {
+				fmt.Printf("%d existing files would be affected\n", len(conflicts))
 			}
-			overwrite = true // User confirmed
+			return false, false, nil
 		}
-	}
 
-	// Dry run - exit here if requested
-	if dryRun {
-		fmt.Printf("\nDry run completed. %d files would be extracted to %s\n",
-			len(filesToExtract), targetDir)
-		if len(conflicts) > 0 {
-			fmt.Printf("%d existing files would be affected\n", len(conflicts))
+		if verbose {
+			fmt.Printf("\nExtracting files to %s...\n", targetDir)
 		}
-		return nil
+		extracted = true
+		startTime = time.Now()
+		return true, confirmed, nil
 	}
 
 	// Prepare unpack options
 	unpackOpts := types.UnpackOptions{
 		ArchivePath: archiveFile,
 		Password:    key,
+		KeyFile:     keyFile,
+		Identities:  identities,
+		Passphrase:  passphrase,
 		TargetDir:   targetDir,
 		Overwrite:   overwrite,
-		Backup:      backup,
-	}
-
-	if verbose {
-		fmt.Printf("\nExtracting files to %s...\n", targetDir)
+		Select:      selection,
+		Confirm:     confirm,
+		Trust:       trust,
 	}
+	snapshot := newBackupSnapshot(&unpackOpts, backup)
+	recorder := newUnpackJournal(&unpackOpts, snapshot)
 
 	// Unpack files
-	startTime := time.Now()
 	err = app.Archiver.Unpack(unpackOpts)
 	if err != nil {
+		if !opened {
+			return fmt.Errorf("failed to read archive (check password): %w", err)
+		}
+		if !extracted {
+			return err
+		}
 		return fmt.Errorf("error unpacking files: %w", err)
 	}
+	if !extracted {
+		return nil
+	}
 	duration := time.Since(startTime)
 
-	// Verify extracted files if requested
-	if verify {
-		fmt.Printf("Verifying extracted files...\n")
-		verifyErrors := verifyExtractedFiles(filesToExtract, targetDir)
-		if len(verifyErrors) > 0 {
-			fmt.Printf("⚠️  Verification warnings:\n")
-			for _, verifyErr := range verifyErrors {
-				fmt.Printf("  • %s\n", verifyErr)
-			}
-		} else if verbose {
-			fmt.Printf("✅ All files verified successfully\n")
-		}
+	if verbose {
+		fmt.Printf("✅ All files verified against their checksums\n")
 	}
 
 	// Success message
@@ -239,62 +294,126 @@ func runUnpackCommand(cmd *cobra.Command, args []string) error {
 	}
 
 	// Show summary of what was done
-	if len(conflicts) > 0 {
-		if backup {
-			fmt.Printf("📋 Created backups for %d existing files\n", len(conflicts))
-		} else {
-			fmt.Printf("📝 Overwrote %d existing files\n", len(conflicts))
-		}
+	if snapshot != nil && snapshot.ID() != "" {
+		displayBackupSnapshot(snapshot)
+	} else if len(conflicts) > 0 {
+		fmt.Printf("📝 Overwrote %d existing files\n", len(conflicts))
 	}
 
 	// Helpful next steps
 	fmt.Println("\n💡 Next steps:")
 	fmt.Println("   • Review extracted files for correctness")
 	fmt.Println("   • Update any file permissions if needed")
-	if len(conflicts) > 0 && backup {
-		fmt.Println("   • Remove .backup files once you've verified the extraction")
-	}
+	displayUndoHint(recorder)
 
 	return nil
 }
 
 // Helper functions
 
-// filterFiles filters files based on include/exclude patterns
-func filterFiles(files []types.EnvFile, includePatterns, excludePatterns []string) []types.EnvFile {
-	var filtered []types.EnvFile
+// getDecryptPassword returns the password to open the given archives with.
+// When identity files are given, a password is only read if one was
+// explicitly requested through an environment variable. The same holds for a
+// key file, as long as every archive has a slot the key file opens alone, and
+// for archives whose keys are all held by the key agent.
+func getDecryptPassword(app *types.App, passwordOpts password.Options, identities []string, archives ...string) (*secret.Buffer, error) {
+	if passwordOpts.PasswordEnv == "" {
+		if len(identities) > 0 {
+			return nil, nil
+		}
+		if passwordOpts.KeyFile != "" && keyFileOpensAlone(app, archives) {
+			return nil, nil
+		}
+	}
 
-	for _, file := range files {
-		// Check include patterns
-		if len(includePatterns) > 0 {
-			included := false
-			for _, pattern := range includePatterns {
-				if matched, _ := filepath.Match(pattern, file.RelativePath); matched {
-					included = true
-					break
-				}
-			}
-			if !included {
-				continue
-			}
+	// Validate password options
+	if err := password.ValidatePasswordOptions(passwordOpts); err != nil {
+		return nil, fmt.Errorf("invalid password options: %w", err)
+	}
+
+	passwordOpts.Cached = func() bool {
+		return agentHoldsKeys(app, archives)
+	}
+
+	key, err := password.GetPassword(passwordOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get password: %w", err)
+	}
+
+	return key, nil
+}
+
+// getKeyFile returns the digest of the key file selected in passwordOpts, or
+// nil if there is none
+func getKeyFile(passwordOpts password.Options) ([]byte, error) {
+	if passwordOpts.KeyFile == "" {
+		return nil, nil
+	}
+	return password.ReadKeyFile(passwordOpts.KeyFile)
+}
+
+// keyFileOpensAlone reports whether every archive has a key slot that needs
+// only a key file. Key slots are public header data, so no key is needed to
+// check.
+func keyFileOpensAlone(app *types.App, archives []string) bool {
+	for _, archivePath := range archives {
+		header, err := app.Archiver.ReadHeader(archivePath)
+		if err != nil {
+			return false
+		}
+		slots, err := app.Crypto.ListKeySlots(header)
+		if err != nil {
+			return false
 		}
 
-		// Check exclude patterns
-		excluded := false
-		for _, pattern := range excludePatterns {
-			if matched, _ := filepath.Match(pattern, file.RelativePath); matched {
-				excluded = true
+		found := false
+		for _, slot := range slots {
+			if slot.Type == crypto.SlotKeyFile.String() {
+				found = true
 				break
 			}
 		}
-		if excluded {
-			continue
+		if !found {
+			return false
 		}
+	}
+	return true
+}
 
-		filtered = append(filtered, file)
+// agentHoldsKeys reports whether the key agent holds a key for every
+// archive, so none of them needs a password
+func agentHoldsKeys(app *types.App, archives []string) bool {
+	if len(archives) == 0 {
+		return false
 	}
+	for _, archivePath := range archives {
+		header, err := app.Archiver.ReadHeader(archivePath)
+		if err != nil || !app.Crypto.HasCachedKey(header) {
+			return false
+		}
+	}
+	return true
+}
 
-	return filtered
+// newPassphrasePrompt returns a PassphraseFunc that asks for the passphrase
+// of an encrypted identity file, such as a protected SSH key. Answers are
+// remembered for the life of the process, so a command that opens the
+// archive twice only asks once.
+func newPassphrasePrompt() types.PassphraseFunc {
+	answers := make(map[string]*secret.Buffer)
+	return func(path string) (*secret.Buffer, error) {
+		if passphrase, ok := answers[path]; ok {
+			return passphrase, nil
+		}
+		passphrase, err := password.GetPassword(password.Options{
+			Prompt: fmt.Sprintf("Enter passphrase for %s: ", path),
+		})
+		if err != nil {
+			return nil, err
+		}
+		answers[path] = passphrase
+		return passphrase, nil
+	}
 }
 
 // checkFileConflicts checks for existing files that would be overwritten
@@ -310,40 +429,3 @@ func checkFileConflicts(files []types.EnvFile, targetDir string) []string {
 
 	return conflicts
 }
-
-// verifyExtractedFiles verifies that extracted files match their expected checksums
-func verifyExtractedFiles(files []types.EnvFile, targetDir string) []string {
-	var errors []string
-
-	for _, file := range files {
-		targetPath := filepath.Join(targetDir, file.RelativePath)
-
-		// Check if file exists
-		info, err := os.Stat(targetPath)
-		if err != nil {
-			errors = append(errors, fmt.Sprintf("%s: file not found after extraction", file.RelativePath))
-			continue
-		}
-
-		// Check file size
-		if info.Size() != file.Size {
-			errors = append(errors, fmt.Sprintf("%s: size mismatch (expected %d, got %d)",
-				file.RelativePath, file.Size, info.Size()))
-			continue
-		}
-
-		// Calculate and verify checksum
-		actualChecksum, err := utils.CalculateFileChecksum(targetPath)
-		if err != nil {
-			errors = append(errors, fmt.Sprintf("%s: failed to calculate checksum: %v",
-				file.RelativePath, err))
-			continue
-		}
-
-		if actualChecksum != file.Checksum {
-			errors = append(errors, fmt.Sprintf("%s: checksum mismatch", file.RelativePath))
-		}
-	}
-
-	return errors
-}
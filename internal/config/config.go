@@ -5,14 +5,20 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"goingenv/internal/crypto"
+	"goingenv/pkg/password"
 	"goingenv/pkg/types"
+	"goingenv/pkg/utils"
 )
 
 const (
-	ConfigFileName     = ".goingenv.json"
-	DefaultMaxFileSize = 10 * 1024 * 1024 // 10MB
+	ConfigFileName         = ".goingenv.json"
+	RecipientsFileName     = "recipients"
+	TrustedSignersFileName = "trusted_signers"
+	DefaultMaxFileSize     = 10 * 1024 * 1024 // 10MB
 )
 
 // Manager implements the ConfigManager interface
@@ -67,7 +73,7 @@ func (m *Manager) Save(config *types.Config) error {
 		return fmt.Errorf("failed to marshal config: %w", err)
 	}
 
-	if err := os.WriteFile(m.configPath, data, 0644); err != nil {
+	if err := utils.WriteFileAtomic(m.configPath, data, 0644); err != nil {
 		return fmt.Errorf("failed to write config file: %w", err)
 	}
 
@@ -96,6 +102,18 @@ func (m *Manager) GetDefault() *types.Config {
 			`coverage/`,
 		},
 		MaxFileSize: DefaultMaxFileSize,
+		Cipher:      crypto.CipherNameAES256GCM,
+		Compression: crypto.CompressionNameGzip,
+		KDF: types.KDFConfig{
+			Algorithm:   crypto.KDFNameArgon2id,
+			Time:        crypto.Argon2Time,
+			Memory:      crypto.Argon2Memory,
+			Parallelism: crypto.Argon2Parallelism,
+		},
+		PasswordPolicy: types.PasswordPolicy{
+			MinScore:  password.DefaultMinScore,
+			MinLength: password.DefaultMinLength,
+		},
 	}
 }
 
@@ -125,6 +143,38 @@ func (m *Manager) Validate(config *types.Config) error {
 		}
 	}
 
+	if _, err := crypto.ParseCipherName(config.Cipher); err != nil {
+		return &types.ValidationError{
+			Field:   "Cipher",
+			Value:   config.Cipher,
+			Message: err.Error(),
+		}
+	}
+
+	if _, err := crypto.ParseCompressionName(config.Compression); err != nil {
+		return &types.ValidationError{
+			Field:   "Compression",
+			Value:   config.Compression,
+			Message: err.Error(),
+		}
+	}
+
+	if _, _, err := crypto.KDFFromConfig(config.KDF); err != nil {
+		return &types.ValidationError{
+			Field:   "KDF",
+			Value:   config.KDF,
+			Message: err.Error(),
+		}
+	}
+
+	if err := password.ValidatePolicy(config.PasswordPolicy); err != nil {
+		return &types.ValidationError{
+			Field:   "PasswordPolicy",
+			Value:   config.PasswordPolicy,
+			Message: err.Error(),
+		}
+	}
+
 	return nil
 }
 
@@ -153,7 +203,7 @@ func EnsureGoingEnvDir() error {
 	gitignorePath := filepath.Join(dir, ".gitignore")
 	if _, err := os.Stat(gitignorePath); os.IsNotExist(err) {
 		gitignoreContent := "# GoingEnv directory gitignore\n# This allows *.enc files to be committed for safe env transfer\n# Ignore temporary files\n*.tmp\n*.temp\n"
-		if err := os.WriteFile(gitignorePath, []byte(gitignoreContent), 0644); err != nil {
+		if err := utils.WriteFileAtomic(gitignorePath, []byte(gitignoreContent), 0644); err != nil {
 			return fmt.Errorf("failed to create .gitignore: %w", err)
 		}
 	}
@@ -161,6 +211,63 @@ func EnsureGoingEnvDir() error {
 	return nil
 }
 
+// GetRecipientsPath returns the path of the committed recipients file
+func GetRecipientsPath() string {
+	return filepath.Join(GetGoingEnvDir(), RecipientsFileName)
+}
+
+// LoadRecipients reads the recipient public keys listed in the recipients file.
+// A missing file means the project has no recipients.
+func LoadRecipients() ([]string, error) {
+	recipients, err := ReadPublicKeysFile(GetRecipientsPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recipients file: %w", err)
+	}
+	return recipients, nil
+}
+
+// GetTrustedSignersPath returns the path of the committed trusted signers file
+func GetTrustedSignersPath() string {
+	return filepath.Join(GetGoingEnvDir(), TrustedSignersFileName)
+}
+
+// LoadTrustedSigners reads the SSH public keys whose archive signatures are
+// trusted. A missing file means signatures are not enforced.
+func LoadTrustedSigners() ([]string, error) {
+	signers, err := ReadPublicKeysFile(GetTrustedSignersPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trusted signers file: %w", err)
+	}
+	return signers, nil
+}
+
+// ReadPublicKeysFile reads one public key per line from a file such as the
+// recipients file or an SSH .pub file. Blank lines and lines starting with #
+// are ignored.
+func ReadPublicKeysFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+
+	return keys, nil
+}
+
 // GetDefaultArchivePath generates a default archive path with timestamp
 func GetDefaultArchivePath() string {
 	return filepath.Join(GetGoingEnvDir(), fmt.Sprintf("archive-%s.enc",
@@ -201,7 +308,7 @@ func InitializeProject() error {
 	gitignorePath := filepath.Join(dir, ".gitignore")
 	gitignoreContent := "# GoingEnv directory gitignore\n# This allows *.enc files to be committed for safe env transfer\n# Ignore temporary files\n*.tmp\n*.temp\n"
 
-	if err := os.WriteFile(gitignorePath, []byte(gitignoreContent), 0644); err != nil {
+	if err := utils.WriteFileAtomic(gitignorePath, []byte(gitignoreContent), 0644); err != nil {
 		return fmt.Errorf("failed to create .gitignore: %w", err)
 	}
 
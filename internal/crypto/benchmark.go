@@ -0,0 +1,138 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"fmt"
+	"time"
+
+	"goingenv/pkg/secret"
+)
+
+const (
+	// MinCalibratedPBKDF2Iterations is the lowest PBKDF2 iteration count
+	// CalibrateKDF suggests, however fast the target
+	MinCalibratedPBKDF2Iterations = PBKDF2Iterations
+	// MinCalibratedArgon2Memory is the lowest Argon2id memory cost in KiB
+	// CalibrateKDF falls back to on slow machines (19 MiB)
+	MinCalibratedArgon2Memory = 19 * 1024
+
+	// pbkdf2ProbeIterations is the iteration count of the first PBKDF2 probe
+	pbkdf2ProbeIterations = 10_000
+	// minProbeDuration is the shortest probe trusted for extrapolation;
+	// shorter runs are dominated by timer resolution and scheduling noise
+	minProbeDuration = 20 * time.Millisecond
+)
+
+// benchmarkPassword is the fixed input of timed derivations; its value does
+// not affect the cost
+var benchmarkPassword = []byte("goingenv kdf benchmark")
+
+// MeasureKDF returns how long one key derivation with the given KDF and
+// parameters takes on this machine
+func MeasureKDF(kdf KDFID, params KDFParams) (time.Duration, error) {
+	salt := make([]byte, SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return 0, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	start := time.Now()
+	key, err := deriveKey(benchmarkPassword, kdf, params, salt)
+	elapsed := time.Since(start)
+	if err != nil {
+		return 0, err
+	}
+	secret.Wipe(key)
+
+	return elapsed, nil
+}
+
+// CalibrateKDF finds cost parameters for kdf whose derivation takes about
+// target on this machine, and returns them with their measured duration.
+//
+// PBKDF2 scales its iteration count. Argon2id keeps the memory and
+// parallelism of base and scales the number of passes; if a single pass
+// already takes longer than target, memory is halved down to
+// MinCalibratedArgon2Memory instead. Suggestions never go below these
+// minimums, so a very short target can yield a longer duration.
+func CalibrateKDF(kdf KDFID, base KDFParams, target time.Duration) (KDFParams, time.Duration, error) {
+	if target <= 0 {
+		return KDFParams{}, 0, fmt.Errorf("target duration must be positive")
+	}
+
+	var params KDFParams
+	switch kdf {
+	case KDFPBKDF2SHA256:
+		probe := KDFParams{Iterations: pbkdf2ProbeIterations}
+		elapsed, err := measureProbe(kdf, probe)
+		for err == nil && elapsed < minProbeDuration && probe.Iterations*10 <= maxPBKDF2Iterations {
+			probe.Iterations *= 10
+			elapsed, err = measureProbe(kdf, probe)
+		}
+		if err != nil {
+			return KDFParams{}, 0, err
+		}
+		params.Iterations = uint32(clampScale(float64(probe.Iterations), target, elapsed,
+			MinCalibratedPBKDF2Iterations, maxPBKDF2Iterations))
+
+	case KDFArgon2id:
+		params = KDFParams{Time: 1, Memory: base.Memory, Parallelism: base.Parallelism}
+		if params.Memory == 0 {
+			params.Memory = Argon2Memory
+		}
+		if params.Parallelism == 0 {
+			params.Parallelism = Argon2Parallelism
+		}
+		elapsed, err := measureProbe(kdf, params)
+		for err == nil && elapsed > target && params.Memory/2 >= MinCalibratedArgon2Memory {
+			params.Memory /= 2
+			elapsed, err = measureProbe(kdf, params)
+		}
+		if err != nil {
+			return KDFParams{}, 0, err
+		}
+		params.Time = uint32(clampScale(1, target, elapsed, 1, maxArgon2Time))
+
+	default:
+		return KDFParams{}, 0, fmt.Errorf("unsupported KDF: %s", kdf)
+	}
+
+	// Time the suggestion itself rather than trusting the extrapolation
+	elapsed, err := MeasureKDF(kdf, params)
+	if err != nil {
+		return KDFParams{}, 0, err
+	}
+
+	return params, elapsed, nil
+}
+
+// measureProbe returns the faster of two derivations. The first run of a
+// size pays for page faults and cache misses that later runs do not, which
+// would otherwise skew the extrapolation towards cheaper parameters.
+func measureProbe(kdf KDFID, params KDFParams) (time.Duration, error) {
+	first, err := MeasureKDF(kdf, params)
+	if err != nil {
+		return 0, err
+	}
+	second, err := MeasureKDF(kdf, params)
+	if err != nil {
+		return 0, err
+	}
+	return min(first, second), nil
+}
+
+// clampScale scales a cost measured to take elapsed so that it takes about
+// target, assuming linear cost, and clamps the result to [lo, hi]
+func clampScale(cost float64, target, elapsed time.Duration, lo, hi float64) float64 {
+	if elapsed <= 0 {
+		return hi
+	}
+	scaled := cost * float64(target) / float64(elapsed)
+	if scaled < lo {
+		return lo
+	}
+	if scaled > hi {
+		return hi
+	}
+	// Round to keep suggested values readable
+	return float64(int64(scaled + 0.5))
+}
@@ -0,0 +1,93 @@
+package crypto
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMeasureKDF(t *testing.T) {
+	elapsed, err := MeasureKDF(KDFPBKDF2SHA256, KDFParams{Iterations: 1000})
+	if err != nil {
+		t.Fatalf("MeasureKDF() error = %v", err)
+	}
+	if elapsed <= 0 {
+		t.Errorf("MeasureKDF() = %v, want a positive duration", elapsed)
+	}
+
+	if _, err := MeasureKDF(KDFArgon2id, KDFParams{Time: 0, Memory: 1024, Parallelism: 1}); err == nil {
+		t.Error("MeasureKDF() should reject invalid parameters")
+	}
+}
+
+func TestCalibrateKDF(t *testing.T) {
+	t.Run("PBKDF2 never goes below the minimum", func(t *testing.T) {
+		params, elapsed, err := CalibrateKDF(KDFPBKDF2SHA256, KDFParams{}, time.Microsecond)
+		if err != nil {
+			t.Fatalf("CalibrateKDF() error = %v", err)
+		}
+		if params.Iterations != MinCalibratedPBKDF2Iterations {
+			t.Errorf("Iterations = %d, want %d", params.Iterations, MinCalibratedPBKDF2Iterations)
+		}
+		if elapsed <= 0 {
+			t.Errorf("elapsed = %v, want a positive duration", elapsed)
+		}
+	})
+
+	t.Run("Argon2id keeps parallelism and minimum memory", func(t *testing.T) {
+		base := KDFParams{Memory: MinCalibratedArgon2Memory, Parallelism: 1}
+		params, _, err := CalibrateKDF(KDFArgon2id, base, time.Microsecond)
+		if err != nil {
+			t.Fatalf("CalibrateKDF() error = %v", err)
+		}
+		want := KDFParams{Time: 1, Memory: MinCalibratedArgon2Memory, Parallelism: 1}
+		if params != want {
+			t.Errorf("CalibrateKDF() = %+v, want %+v", params, want)
+		}
+	})
+
+	t.Run("Suggested parameters are valid", func(t *testing.T) {
+		for _, kdf := range []KDFID{KDFPBKDF2SHA256, KDFArgon2id} {
+			base := KDFParams{Memory: MinCalibratedArgon2Memory, Parallelism: 1}
+			params, _, err := CalibrateKDF(kdf, base, 50*time.Millisecond)
+			if err != nil {
+				t.Fatalf("CalibrateKDF(%s) error = %v", kdf, err)
+			}
+			if err := validateKDFParams(kdf, params); err != nil {
+				t.Errorf("CalibrateKDF(%s) = %+v: %v", kdf, params, err)
+			}
+		}
+	})
+
+	t.Run("Invalid input", func(t *testing.T) {
+		if _, _, err := CalibrateKDF(KDFPBKDF2SHA256, KDFParams{}, 0); err == nil {
+			t.Error("CalibrateKDF() should reject a zero target")
+		}
+		if _, _, err := CalibrateKDF(KDFID(99), KDFParams{}, time.Second); err == nil {
+			t.Error("CalibrateKDF() should reject an unknown KDF")
+		}
+	})
+}
+
+func TestClampScale(t *testing.T) {
+	tests := []struct {
+		name    string
+		cost    float64
+		target  time.Duration
+		elapsed time.Duration
+		want    float64
+	}{
+		{"Scales linearly", 1000, 500 * time.Millisecond, 100 * time.Millisecond, 5000},
+		{"Rounds to nearest", 30, 500 * time.Millisecond, 400 * time.Millisecond, 38},
+		{"Clamps to minimum", 1000, time.Millisecond, time.Second, 10},
+		{"Clamps to maximum", 1000, time.Hour, time.Millisecond, 1_000_000},
+		{"Zero elapsed", 1000, time.Second, 0, 1_000_000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := clampScale(tt.cost, tt.target, tt.elapsed, 10, 1_000_000); got != tt.want {
+				t.Errorf("clampScale() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"goingenv/pkg/secret"
+	"goingenv/pkg/types"
+)
+
+const keyCacheContext = "goingenv key cache v1"
+
+// SetKeyCache makes the service look up password and key file slots in
+// cache before running the KDF, and store the keys it derives there. Errors
+// from the cache are ignored: a cache that is unavailable behaves like an
+// empty one.
+func (s *Service) SetKeyCache(cache types.KeyCache) {
+	s.cache = cache
+}
+
+// HasCachedKey reports whether the key cache holds a key that opens the
+// given archive header, so callers can skip asking for a password
+func (s *Service) HasCachedKey(headerBytes []byte) bool {
+	if s.cache == nil {
+		return false
+	}
+	header, n, err := ParseHeader(headerBytes)
+	if err != nil || n != len(headerBytes) || header.Version < FormatVersion3 {
+		return false
+	}
+
+	fileKey := s.unlockCached(header)
+	if fileKey == nil {
+		return false
+	}
+	return verifyHeader(header, fileKey) == nil
+}
+
+// unlockCached returns the file key from the first password or key file
+// slot whose key-encryption key is in the cache, or nil if there is none
+func (s *Service) unlockCached(header *Header) []byte {
+	if s.cache == nil {
+		return nil
+	}
+
+	for i := range header.Slots {
+		slot := &header.Slots[i]
+		if !slot.usesKDF() {
+			continue
+		}
+		kek, err := s.cache.GetKey(slot.cacheID())
+		if err != nil || kek == nil {
+			continue
+		}
+		fileKey, err := unwrapKey(kek, slot.WrappedKey)
+		secret.Wipe(kek)
+		if err == nil {
+			return fileKey
+		}
+	}
+	return nil
+}
+
+// cacheKEK stores the key-encryption key derived for a slot in the cache
+func (s *Service) cacheKEK(slot *KeySlot, kek []byte) {
+	if s.cache == nil {
+		return
+	}
+	_ = s.cache.PutKey(slot.cacheID(), kek)
+}
+
+// usesKDF reports whether the slot is opened with a key derived from a
+// password or key file
+func (s *KeySlot) usesKDF() bool {
+	switch s.Type {
+	case SlotPassword, SlotKeyFile, SlotPasswordKeyFile:
+		return true
+	default:
+		return false
+	}
+}
+
+// cacheID identifies a slot in the key cache. The salt and wrapped key are
+// random per slot, so the ID never matches another archive or a re-added
+// slot, and it reveals nothing about the key.
+func (s *KeySlot) cacheID() string {
+	h := sha256.New()
+	h.Write([]byte(keyCacheContext))
+	h.Write([]byte{byte(s.Type)})
+	h.Write(s.Salt)
+	h.Write(s.WrappedKey)
+	return hex.EncodeToString(h.Sum(nil))
+}
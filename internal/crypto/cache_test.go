@@ -0,0 +1,76 @@
+package crypto
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"goingenv/pkg/secret"
+	"goingenv/pkg/types"
+)
+
+// mapKeyCache is an in-memory KeyCache for tests
+type mapKeyCache map[string][]byte
+
+func (c mapKeyCache) GetKey(id string) ([]byte, error) {
+	if key, ok := c[id]; ok {
+		return append([]byte(nil), key...), nil
+	}
+	return nil, nil
+}
+
+func (c mapKeyCache) PutKey(id string, key []byte) error {
+	c[id] = append([]byte(nil), key...)
+	return nil
+}
+
+func TestService_KeyCache(t *testing.T) {
+	service := fastService()
+	cache := mapKeyCache{}
+	service.SetKeyCache(cache)
+	data := []byte("API_KEY=cached")
+
+	encrypted := encryptStream(t, service, data, "password")
+	header, _ := splitArchive(t, service, encrypted)
+
+	if service.HasCachedKey(header) {
+		t.Fatal("Expected no cached key before the first decryption")
+	}
+	if _, err := service.DecryptStream(bytes.NewReader(encrypted), types.DecryptOptions{}); err == nil {
+		t.Fatal("Expected decryption without a password or cached key to fail")
+	}
+
+	// A wrong password caches nothing
+	if _, err := service.DecryptStream(bytes.NewReader(encrypted), types.DecryptOptions{Password: secret.FromString("wrong")}); err == nil {
+		t.Fatal("Expected decryption with a wrong password to fail")
+	}
+	if len(cache) != 0 {
+		t.Fatalf("Expected a wrong password to cache nothing, got %d keys", len(cache))
+	}
+
+	if _, err := service.DecryptStream(bytes.NewReader(encrypted), types.DecryptOptions{Password: secret.FromString("password")}); err != nil {
+		t.Fatalf("Decrypt with the password failed: %v", err)
+	}
+	if len(cache) != 1 || !service.HasCachedKey(header) {
+		t.Fatalf("Expected the password slot's key to be cached, got %d keys", len(cache))
+	}
+
+	r, err := service.DecryptStream(bytes.NewReader(encrypted), types.DecryptOptions{})
+	if err != nil {
+		t.Fatalf("Decrypt with the cached key failed: %v", err)
+	}
+	decrypted, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Reading decrypted data failed: %v", err)
+	}
+	if !bytes.Equal(data, decrypted) {
+		t.Error("Decrypted data doesn't match original")
+	}
+
+	// Another archive under the same password has its own salt
+	other := encryptStream(t, service, data, "password")
+	otherHeader, _ := splitArchive(t, service, other)
+	if service.HasCachedKey(otherHeader) {
+		t.Error("Expected the cached key not to open another archive")
+	}
+}
@@ -0,0 +1,132 @@
+package crypto
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"goingenv/pkg/secret"
+	"goingenv/pkg/types"
+)
+
+func TestParseCipherName(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    CipherID
+		wantErr bool
+	}{
+		{"", CipherAES256GCM, false},
+		{"aes-256-gcm", CipherAES256GCM, false},
+		{" XChaCha20-Poly1305 ", CipherXChaCha20Poly1305, false},
+		{"chacha20", 0, true},
+		{"aes-128-gcm", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCipherName(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCipherName(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseCipherName(%q) = %s, want %s", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCipherSuites(t *testing.T) {
+	suites := CipherSuites()
+	if len(suites) < 2 || suites[0].ID != CipherAES256GCM {
+		t.Fatalf("CipherSuites() = %v, want AES-256-GCM first", suites)
+	}
+
+	wantNonce := map[CipherID]int{CipherAES256GCM: 12, CipherXChaCha20Poly1305: 24}
+	for _, suite := range suites {
+		aead, err := newAEAD(suite.ID, make([]byte, KeySize))
+		if err != nil {
+			t.Fatalf("newAEAD(%s) error = %v", suite.ID, err)
+		}
+		if aead.NonceSize() != wantNonce[suite.ID] {
+			t.Errorf("%s nonce size = %d, want %d", suite.ID, aead.NonceSize(), wantNonce[suite.ID])
+		}
+		if id, err := ParseCipherName(suite.Name); err != nil || id != suite.ID {
+			t.Errorf("ParseCipherName(%q) = %s, %v; want %s", suite.Name, id, err, suite.ID)
+		}
+	}
+
+	if _, err := LookupCipherSuite(CipherID(0)); err == nil {
+		t.Error("LookupCipherSuite() should reject an unknown cipher")
+	}
+}
+
+func TestService_CipherRoundTrip(t *testing.T) {
+	// Several chunks, so chunk nonces are exercised beyond the first
+	data := bytes.Repeat([]byte("API_KEY=cipher round trip\n"), 3*ChunkSize/16)
+	index := []byte(`{"files":[]}`)
+	password := "cipher round trip"
+
+	for _, suite := range CipherSuites() {
+		t.Run(suite.Name, func(t *testing.T) {
+			service := NewServiceWithConfig(&types.Config{
+				Cipher: suite.Name,
+				KDF:    types.KDFConfig{Algorithm: KDFNamePBKDF2, Iterations: 1000},
+			})
+
+			var buf bytes.Buffer
+			w, err := service.EncryptStream(&buf, types.EncryptOptions{
+				Password: secret.FromString(password),
+				Index:    index,
+			})
+			if err != nil {
+				t.Fatalf("EncryptStream failed: %v", err)
+			}
+			if _, err := w.Write(data); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+			encrypted := buf.Bytes()
+
+			header, _, err := ParseHeader(encrypted)
+			if err != nil {
+				t.Fatalf("ParseHeader failed: %v", err)
+			}
+			if header.Cipher != suite.ID {
+				t.Errorf("Cipher = %s, want %s", header.Cipher, suite.ID)
+			}
+
+			// Decryption is driven by the header, not by the decrypting service's config
+			gotIndex, r, err := NewService().DecryptIndex(bytes.NewReader(encrypted),
+				types.DecryptOptions{Password: secret.FromString(password)})
+			if err != nil {
+				t.Fatalf("DecryptIndex failed: %v", err)
+			}
+			if !bytes.Equal(gotIndex, index) {
+				t.Errorf("index = %q, want %q", gotIndex, index)
+			}
+			decrypted, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("reading payload failed: %v", err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Error("Decrypted data doesn't match original")
+			}
+
+			// The cipher byte is covered by the header MAC
+			tampered := append([]byte(nil), encrypted...)
+			tampered[len(Magic)+1] ^= byte(CipherAES256GCM ^ CipherXChaCha20Poly1305)
+			if _, err := NewService().Decrypt(tampered, secret.FromString(password)); err == nil {
+				t.Error("Decrypt should fail when the cipher is changed")
+			}
+		})
+	}
+}
+
+func TestService_EncryptRejectsUnknownCipher(t *testing.T) {
+	service := NewServiceWithConfig(&types.Config{Cipher: "rot13"})
+	if _, err := service.Encrypt([]byte("data"), secret.FromString("password")); err == nil {
+		t.Error("Encrypt should fail with an unknown cipher")
+	}
+}
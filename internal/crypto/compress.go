@@ -0,0 +1,124 @@
+package crypto
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"goingenv/pkg/types"
+)
+
+// CompressionID identifies the codec the payload is compressed with before
+// it is encrypted
+type CompressionID uint8
+
+const (
+	// CompressionNone stores the payload as it is. Archives written before
+	// format version 5 are uncompressed.
+	CompressionNone CompressionID = 0
+	// CompressionGzip compresses the payload with gzip (RFC 1952)
+	CompressionGzip CompressionID = 1
+
+	// CompressionNameNone is the configuration name of CompressionNone
+	CompressionNameNone = "none"
+	// CompressionNameGzip is the configuration name of CompressionGzip
+	CompressionNameGzip = "gzip"
+)
+
+// String returns a human-readable name for the codec
+func (c CompressionID) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionGzip:
+		return "gzip"
+	default:
+		return fmt.Sprintf("unknown compression (%d)", uint8(c))
+	}
+}
+
+// ParseCompressionName maps a configuration name to a codec identifier.
+// An empty name selects gzip.
+func ParseCompressionName(name string) (CompressionID, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", CompressionNameGzip:
+		return CompressionGzip, nil
+	case CompressionNameNone:
+		return CompressionNone, nil
+	default:
+		return 0, fmt.Errorf("unknown compression %q (supported: %s, %s)", name, CompressionNameGzip, CompressionNameNone)
+	}
+}
+
+// newCompressor returns a writer that compresses into w with the given
+// codec. Closing it flushes the codec and then closes w.
+func newCompressor(codec CompressionID, w io.WriteCloser) (io.WriteCloser, error) {
+	switch codec {
+	case CompressionNone:
+		return w, nil
+	case CompressionGzip:
+		return &compressWriter{WriteCloser: gzip.NewWriter(w), dst: w}, nil
+	default:
+		return nil, fmt.Errorf("unsupported compression: %s", codec)
+	}
+}
+
+// newDecompressor returns a reader of the data in r decompressed with the
+// given codec
+func newDecompressor(codec CompressionID, r io.Reader) (io.Reader, error) {
+	switch codec {
+	case CompressionNone:
+		return r, nil
+	case CompressionGzip:
+		return &lazyReader{open: func() (io.Reader, error) {
+			zr, err := gzip.NewReader(r)
+			if err != nil {
+				if _, ok := err.(*types.CryptoError); ok {
+					return nil, err
+				}
+				return nil, &types.CryptoError{
+					Operation: "decrypt",
+					Err:       fmt.Errorf("failed to decompress payload: %w", err),
+				}
+			}
+			return zr, nil
+		}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported compression: %s", codec)
+	}
+}
+
+// compressWriter closes the codec before the writer it compresses into
+type compressWriter struct {
+	io.WriteCloser
+	dst io.WriteCloser
+}
+
+// Close flushes the codec and closes the underlying writer
+func (w *compressWriter) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		return err
+	}
+	return w.dst.Close()
+}
+
+// lazyReader opens its reader on the first Read. A gzip reader reads the
+// gzip header as soon as it is created, which would decrypt the first
+// payload chunk even for callers that only want the index.
+type lazyReader struct {
+	open func() (io.Reader, error)
+	r    io.Reader
+	err  error
+}
+
+// Read opens the reader if necessary and reads from it
+func (l *lazyReader) Read(p []byte) (int, error) {
+	if l.r == nil && l.err == nil {
+		l.r, l.err = l.open()
+	}
+	if l.err != nil {
+		return 0, l.err
+	}
+	return l.r.Read(p)
+}
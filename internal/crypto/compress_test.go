@@ -0,0 +1,162 @@
+package crypto
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"goingenv/pkg/secret"
+	"goingenv/pkg/types"
+)
+
+func TestParseCompressionName(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    CompressionID
+		wantErr bool
+	}{
+		{"", CompressionGzip, false},
+		{"gzip", CompressionGzip, false},
+		{" GZIP ", CompressionGzip, false},
+		{"none", CompressionNone, false},
+		{"zstd", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCompressionName(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCompressionName(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseCompressionName(%q) = %s, want %s", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_CompressionRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("DATABASE_URL=postgres://localhost/app\n"), 4*ChunkSize/32)
+	password := "compression round trip"
+
+	tests := []struct {
+		name        string
+		compression string
+		want        CompressionID
+	}{
+		{"Default", "", CompressionGzip},
+		{"Gzip", CompressionNameGzip, CompressionGzip},
+		{"None", CompressionNameNone, CompressionNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewServiceWithConfig(&types.Config{
+				Compression: tt.compression,
+				KDF:         types.KDFConfig{Algorithm: KDFNamePBKDF2, Iterations: 1000},
+			})
+
+			encrypted := encryptStream(t, service, data, password)
+
+			header, _, err := ParseHeader(encrypted)
+			if err != nil {
+				t.Fatalf("ParseHeader failed: %v", err)
+			}
+			if header.Compression != tt.want {
+				t.Errorf("Compression = %s, want %s", header.Compression, tt.want)
+			}
+
+			compressed := len(encrypted) < len(data)/10
+			if compressed != (tt.want == CompressionGzip) {
+				t.Errorf("archive is %d bytes for %d bytes of data; compressed = %v", len(encrypted), len(data), compressed)
+			}
+
+			// Decompression is driven by the header, not by the decrypting service's config
+			decrypted, err := decryptStream(NewService(), encrypted, password)
+			if err != nil {
+				t.Fatalf("DecryptStream failed: %v", err)
+			}
+			if !bytes.Equal(decrypted, data) {
+				t.Error("Decrypted data doesn't match original")
+			}
+		})
+	}
+}
+
+func TestService_DecryptVersion4Uncompressed(t *testing.T) {
+	service := NewService()
+	data := []byte("API_KEY=written-before-compression")
+	index := []byte(`{"files":[]}`)
+	password := "version four"
+
+	fileKey, err := newFileKey()
+	if err != nil {
+		t.Fatalf("newFileKey failed: %v", err)
+	}
+	slot, err := newPasswordSlot(fileKey, secret.FromString(password), nil, KDFPBKDF2SHA256, KDFParams{Iterations: 1000})
+	if err != nil {
+		t.Fatalf("newPasswordSlot failed: %v", err)
+	}
+
+	// Version 4 headers have no compression field, so the codec is ignored
+	header := &Header{
+		Version:     FormatVersion4,
+		Cipher:      CipherAES256GCM,
+		Compression: CompressionGzip,
+		Slots:       []KeySlot{slot},
+	}
+
+	var buf bytes.Buffer
+	w, err := service.writeStream(&buf, header, fileKey, index)
+	if err != nil {
+		t.Fatalf("writeStream failed: %v", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	parsed, _, err := ParseHeader(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ParseHeader failed: %v", err)
+	}
+	if parsed.Version != FormatVersion4 || parsed.Compression != CompressionNone {
+		t.Errorf("header = version %d, %s; want version 4, none", parsed.Version, parsed.Compression)
+	}
+
+	gotIndex, r, err := service.DecryptIndex(bytes.NewReader(buf.Bytes()), types.DecryptOptions{Password: secret.FromString(password)})
+	if err != nil {
+		t.Fatalf("DecryptIndex failed: %v", err)
+	}
+	if !bytes.Equal(gotIndex, index) {
+		t.Errorf("index = %q, want %q", gotIndex, index)
+	}
+	decrypted, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading payload failed: %v", err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Errorf("Version 4 decryption mismatch: got %q", decrypted)
+	}
+}
+
+func TestService_CompressedStreamDetectsTampering(t *testing.T) {
+	service := NewServiceWithConfig(&types.Config{
+		KDF: types.KDFConfig{Algorithm: KDFNamePBKDF2, Iterations: 1000},
+	})
+	password := "compressed tamper"
+	encrypted := encryptStream(t, service, bytes.Repeat([]byte("KEY=value\n"), 1000), password)
+
+	truncated := encrypted[:len(encrypted)-1]
+	if _, err := decryptStream(service, truncated, password); err == nil {
+		t.Error("Expected a truncated compressed archive to fail")
+	}
+
+	flipped := append([]byte(nil), encrypted...)
+	flipped[len(flipped)-20] ^= 0x01
+	if _, err := decryptStream(service, flipped, password); err == nil {
+		t.Error("Expected a modified compressed archive to fail")
+	}
+}
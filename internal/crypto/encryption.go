@@ -1,12 +1,15 @@
 package crypto
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
+	"bufio"
+	"bytes"
 	"crypto/rand"
 	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
 
+	"goingenv/pkg/secret"
 	"goingenv/pkg/types"
 
 	"golang.org/x/crypto/pbkdf2"
@@ -15,7 +18,7 @@ import (
 const (
 	// SaltSize is the size of the salt in bytes
 	SaltSize = 32
-	// NonceSize is the size of the nonce in bytes
+	// NonceSize is the size of the AES-GCM nonce of headerless archives in bytes
 	NonceSize = 12
 	// KeySize is the size of the encryption key in bytes
 	KeySize = 32
@@ -24,15 +27,53 @@ const (
 )
 
 // Service implements the Cryptor interface
-type Service struct{}
+type Service struct {
+	config *types.Config
+	cache  types.KeyCache
+}
 
-// NewService creates a new crypto service
+// NewService creates a new crypto service that derives keys with PBKDF2
 func NewService() *Service {
 	return &Service{}
 }
 
-// Encrypt encrypts data using AES-256-GCM with PBKDF2 key derivation
-func (s *Service) Encrypt(data []byte, password string) ([]byte, error) {
+// NewServiceWithConfig creates a crypto service that derives keys with the
+// KDF selected in config. The config is read on every Encrypt call, so later
+// changes (such as a command-line override) take effect immediately.
+func NewServiceWithConfig(config *types.Config) *Service {
+	return &Service{
+		config: config,
+	}
+}
+
+// kdfSettings returns the KDF and parameters to use for new archives
+func (s *Service) kdfSettings() (KDFID, KDFParams, error) {
+	if s.config == nil {
+		return KDFPBKDF2SHA256, DefaultKDFParams(KDFPBKDF2SHA256), nil
+	}
+	return KDFFromConfig(s.config.KDF)
+}
+
+// cipherSetting returns the cipher suite to use for new archives
+func (s *Service) cipherSetting() (CipherID, error) {
+	if s.config == nil {
+		return CipherAES256GCM, nil
+	}
+	return ParseCipherName(s.config.Cipher)
+}
+
+// compressionSetting returns the codec to compress new archives with
+func (s *Service) compressionSetting() (CompressionID, error) {
+	if s.config == nil {
+		return CompressionGzip, nil
+	}
+	return ParseCompressionName(s.config.Compression)
+}
+
+// Encrypt encrypts data with the configured cipher suite and key derivation
+// function. It is a convenience wrapper around
+// EncryptStream for callers that already hold the whole payload in memory.
+func (s *Service) Encrypt(data []byte, password *secret.Buffer) ([]byte, error) {
 	if len(data) == 0 {
 		return nil, &types.CryptoError{
 			Operation: "encrypt",
@@ -40,66 +81,203 @@ func (s *Service) Encrypt(data []byte, password string) ([]byte, error) {
 		}
 	}
 
-	if password == "" {
+	var buf bytes.Buffer
+	w, err := s.EncryptStream(&buf, types.EncryptOptions{Password: password})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, &types.CryptoError{
+			Operation: "encrypt",
+			Err:       err,
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, &types.CryptoError{
+			Operation: "encrypt",
+			Err:       err,
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// EncryptStream writes an archive header to w and returns a writer that
+// compresses everything written to it with the configured codec and encrypts
+// it in authenticated chunks of ChunkSize bytes, so memory use does not grow
+// with the payload. The payload is
+// encrypted with a random file key, wrapped once for the password and key
+// file (if set), once for the recovery key (if set) and once for every
+// recipient. opts.Index is sealed in its own segment between the header and
+// the payload, where DecryptIndex can read it without decrypting the
+// payload. The returned writer must be closed to write the final chunk;
+// closing it does not close w.
+func (s *Service) EncryptStream(w io.Writer, opts types.EncryptOptions) (io.WriteCloser, error) {
+	if opts.Password.IsEmpty() && len(opts.KeyFile) == 0 && len(opts.RecoveryKey) == 0 && len(opts.Recipients) == 0 {
 		return nil, &types.CryptoError{
 			Operation: "encrypt",
 			Err:       fmt.Errorf("password cannot be empty"),
 		}
 	}
 
-	// Generate random salt
-	salt := make([]byte, SaltSize)
-	if _, err := rand.Read(salt); err != nil {
+	recipients, err := ParseRecipients(opts.Recipients)
+	if err != nil {
 		return nil, &types.CryptoError{
 			Operation: "encrypt",
-			Err:       fmt.Errorf("failed to generate salt: %w", err),
+			Err:       err,
 		}
 	}
 
-	// Derive key using PBKDF2
-	key := pbkdf2.Key([]byte(password), salt, PBKDF2Iterations, KeySize, sha256.New)
+	fileKey, err := newFileKey()
+	if err != nil {
+		return nil, &types.CryptoError{
+			Operation: "encrypt",
+			Err:       err,
+		}
+	}
 
-	// Create AES cipher
-	block, err := aes.NewCipher(key)
+	cipherID, err := s.cipherSetting()
 	if err != nil {
 		return nil, &types.CryptoError{
 			Operation: "encrypt",
-			Err:       fmt.Errorf("failed to create cipher: %w", err),
+			Err:       fmt.Errorf("invalid cipher configuration: %w", err),
 		}
 	}
 
-	// Create GCM mode
-	gcm, err := cipher.NewGCM(block)
+	compression, err := s.compressionSetting()
 	if err != nil {
 		return nil, &types.CryptoError{
 			Operation: "encrypt",
-			Err:       fmt.Errorf("failed to create GCM: %w", err),
+			Err:       fmt.Errorf("invalid compression configuration: %w", err),
 		}
 	}
 
-	// Generate random nonce
-	nonce := make([]byte, NonceSize)
-	if _, err := rand.Read(nonce); err != nil {
+	header := &Header{
+		Version:     FormatVersion,
+		Cipher:      cipherID,
+		Compression: compression,
+	}
+
+	if !opts.Password.IsEmpty() || len(opts.KeyFile) > 0 {
+		kdf, params, err := s.kdfSettings()
+		if err != nil {
+			return nil, &types.CryptoError{
+				Operation: "encrypt",
+				Err:       fmt.Errorf("invalid KDF configuration: %w", err),
+			}
+		}
+
+		slot, err := newPasswordSlot(fileKey, opts.Password, opts.KeyFile, kdf, params)
+		if err != nil {
+			return nil, &types.CryptoError{
+				Operation: "encrypt",
+				Err:       err,
+			}
+		}
+		header.Slots = append(header.Slots, slot)
+	}
+
+	if len(opts.RecoveryKey) > 0 {
+		slot, err := newRecoverySlot(fileKey, opts.RecoveryKey)
+		if err != nil {
+			return nil, &types.CryptoError{
+				Operation: "encrypt",
+				Err:       err,
+			}
+		}
+		header.Slots = append(header.Slots, slot)
+	}
+
+	for _, recipient := range recipients {
+		slot, err := recipient.wrap(fileKey)
+		if err != nil {
+			return nil, &types.CryptoError{
+				Operation: "encrypt",
+				Err:       err,
+			}
+		}
+		header.Slots = append(header.Slots, slot)
+	}
+
+	return s.writeStream(w, header, fileKey, opts.Index)
+}
+
+// writeStream generates the stream nonce, seals the header with the file key,
+// writes it and, from version 4, the index segment to w and returns the chunk
+// writer for the payload, compressing from version 5
+func (s *Service) writeStream(w io.Writer, header *Header, fileKey, index []byte) (io.WriteCloser, error) {
+	// Generate random stream nonce
+	header.Nonce = make([]byte, StreamNonceSize)
+	if _, err := rand.Read(header.Nonce); err != nil {
 		return nil, &types.CryptoError{
 			Operation: "encrypt",
 			Err:       fmt.Errorf("failed to generate nonce: %w", err),
 		}
 	}
 
-	// Encrypt data
-	ciphertext := gcm.Seal(nil, nonce, data, nil)
+	headerBytes, err := sealHeader(header, fileKey)
+	if err != nil {
+		return nil, &types.CryptoError{
+			Operation: "encrypt",
+			Err:       err,
+		}
+	}
+
+	_, payloadKey, err := streamKeys(fileKey, header)
+	if err != nil {
+		return nil, &types.CryptoError{
+			Operation: "encrypt",
+			Err:       err,
+		}
+	}
+
+	aead, err := newAEAD(header.Cipher, payloadKey)
+	if err != nil {
+		return nil, &types.CryptoError{
+			Operation: "encrypt",
+			Err:       err,
+		}
+	}
+
+	if _, err := w.Write(headerBytes); err != nil {
+		return nil, &types.CryptoError{
+			Operation: "encrypt",
+			Err:       fmt.Errorf("failed to write header: %w", err),
+		}
+	}
 
-	// Combine salt + nonce + ciphertext
-	result := make([]byte, SaltSize+NonceSize+len(ciphertext))
-	copy(result[:SaltSize], salt)
-	copy(result[SaltSize:SaltSize+NonceSize], nonce)
-	copy(result[SaltSize+NonceSize:], ciphertext)
+	if header.Version >= FormatVersion4 {
+		indexCipher, err := indexAEAD(fileKey, header)
+		if err != nil {
+			return nil, &types.CryptoError{
+				Operation: "encrypt",
+				Err:       err,
+			}
+		}
+		if err := writeIndex(w, indexCipher, index); err != nil {
+			return nil, &types.CryptoError{
+				Operation: "encrypt",
+				Err:       err,
+			}
+		}
+	}
 
-	return result, nil
+	var payload io.WriteCloser = newStreamWriter(aead, w)
+	if header.Version >= FormatVersion5 {
+		if payload, err = newCompressor(header.Compression, payload); err != nil {
+			return nil, &types.CryptoError{
+				Operation: "encrypt",
+				Err:       err,
+			}
+		}
+	}
+
+	return payload, nil
 }
 
-// Decrypt decrypts data using AES-256-GCM with PBKDF2 key derivation
-func (s *Service) Decrypt(data []byte, password string) ([]byte, error) {
+// Decrypt decrypts data produced by Encrypt. It is a convenience wrapper
+// around DecryptStream that returns the whole payload.
+func (s *Service) Decrypt(data []byte, password *secret.Buffer) ([]byte, error) {
 	if len(data) < SaltSize+NonceSize {
 		return nil, &types.CryptoError{
 			Operation: "decrypt",
@@ -107,36 +285,307 @@ func (s *Service) Decrypt(data []byte, password string) ([]byte, error) {
 		}
 	}
 
-	if password == "" {
-		return nil, &types.CryptoError{
+	r, err := s.DecryptStream(bytes.NewReader(data), types.DecryptOptions{Password: password})
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return plaintext, nil
+}
+
+// DecryptStream reads the archive header from r and returns a reader of the
+// decrypted payload, skipping the index segment. See DecryptIndex.
+func (s *Service) DecryptStream(r io.Reader, opts types.DecryptOptions) (io.Reader, error) {
+	_, payload, err := s.DecryptIndex(r, opts)
+	return payload, err
+}
+
+// DecryptIndex reads the archive header and index segment from r and
+// returns the decrypted index and a reader of the decrypted payload. The file
+// key is unwrapped from the first key slot that one of the identities, the
+// recovery key, the password or the key file opens. Nothing beyond the index
+// is read until the payload reader is used, so the index of a large archive
+// is as quick to read as that of a small one. Archives written before
+// version 4 have no index, and nil is returned for it.
+//
+// Chunked payloads are decrypted incrementally and the reader returns an
+// error if the stream was truncated, reordered or extended; callers must
+// read until io.EOF to be sure the whole payload is authentic. Older
+// single-shot and headerless archives are decrypted in memory.
+func (s *Service) DecryptIndex(r io.Reader, opts types.DecryptOptions) ([]byte, io.Reader, error) {
+	if opts.Password.IsEmpty() && len(opts.KeyFile) == 0 && len(opts.RecoveryKey) == 0 && len(opts.Identities) == 0 && s.cache == nil {
+		return nil, nil, &types.CryptoError{
 			Operation: "decrypt",
 			Err:       fmt.Errorf("password cannot be empty"),
 		}
 	}
 
-	// Extract salt, nonce, and ciphertext
-	salt := data[:SaltSize]
-	nonce := data[SaltSize : SaltSize+NonceSize]
-	ciphertext := data[SaltSize+NonceSize:]
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(len(Magic)); !HasHeader(magic) {
+		data, err := io.ReadAll(br)
+		if err != nil {
+			return nil, nil, &types.CryptoError{
+				Operation: "decrypt",
+				Err:       fmt.Errorf("failed to read encrypted data: %w", err),
+			}
+		}
+		if len(data) < SaltSize+NonceSize {
+			return nil, nil, &types.CryptoError{
+				Operation: "decrypt",
+				Err:       fmt.Errorf("invalid encrypted data: too short"),
+			}
+		}
+		if opts.Password.IsEmpty() {
+			return nil, nil, errPasswordOnly()
+		}
+		plaintext, err := s.decryptLegacy(data, opts.Password)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, bytes.NewReader(plaintext), nil
+	}
 
-	// Derive key using PBKDF2
-	key := pbkdf2.Key([]byte(password), salt, PBKDF2Iterations, KeySize, sha256.New)
+	header, err := ReadHeader(br)
+	if err != nil {
+		return nil, nil, &types.CryptoError{
+			Operation: "decrypt",
+			Err:       fmt.Errorf("invalid archive header: %w", err),
+		}
+	}
 
-	// Create AES cipher
-	block, err := aes.NewCipher(key)
+	var fileKey []byte
+	if header.Version >= FormatVersion3 {
+		fileKey, err = s.unlock(header, opts)
+	} else if opts.Password.IsEmpty() {
+		err = errPasswordOnly()
+	} else {
+		fileKey, err = deriveKey(opts.Password.Bytes(), header.KDF, header.Params, header.Salt)
+	}
+	if err != nil {
+		if _, ok := err.(*types.CryptoError); ok {
+			return nil, nil, err
+		}
+		return nil, nil, &types.CryptoError{
+			Operation: "decrypt",
+			Err:       err,
+		}
+	}
+
+	if header.Version == FormatVersion1 {
+		payload, err := s.decryptVersion1(br, header, fileKey)
+		return nil, payload, err
+	}
+
+	return s.readStream(br, header, fileKey)
+}
+
+// readStream verifies the header MAC with the file key, reads the index
+// segment of version 4 and later archives and returns it with the chunk
+// reader for the payload, decompressing it from version 5
+func (s *Service) readStream(r io.Reader, header *Header, fileKey []byte) ([]byte, io.Reader, error) {
+	if err := verifyHeader(header, fileKey); err != nil {
+		return nil, nil, &types.CryptoError{
+			Operation: "decrypt",
+			Err:       err,
+		}
+	}
+
+	_, payloadKey, err := streamKeys(fileKey, header)
+	if err != nil {
+		return nil, nil, &types.CryptoError{
+			Operation: "decrypt",
+			Err:       err,
+		}
+	}
+
+	aead, err := newAEAD(header.Cipher, payloadKey)
+	if err != nil {
+		return nil, nil, &types.CryptoError{
+			Operation: "decrypt",
+			Err:       err,
+		}
+	}
+
+	var index []byte
+	if header.Version >= FormatVersion4 {
+		indexCipher, err := indexAEAD(fileKey, header)
+		if err != nil {
+			return nil, nil, &types.CryptoError{
+				Operation: "decrypt",
+				Err:       err,
+			}
+		}
+		index, err = readIndex(r, indexCipher)
+		if err != nil {
+			return nil, nil, &types.CryptoError{
+				Operation: "decrypt",
+				Err:       err,
+			}
+		}
+	}
+
+	var payload io.Reader = newStreamReader(aead, r)
+	if header.Version >= FormatVersion5 {
+		if payload, err = newDecompressor(header.Compression, payload); err != nil {
+			return nil, nil, &types.CryptoError{
+				Operation: "decrypt",
+				Err:       err,
+			}
+		}
+	}
+
+	return index, payload, nil
+}
+
+// unlock returns the file key from the first key slot opened by one of the
+// identities, the recovery key, a key cached in the key agent or the
+// password. Identities, the recovery key and the agent are tried first
+// because matching them against a slot is cheap, while every password
+// attempt runs the KDF. A key derived from the password is handed to the
+// agent, if one is running, so the next command can skip the KDF and the
+// prompt.
+func (s *Service) unlock(header *Header, opts types.DecryptOptions) ([]byte, error) {
+	identities, err := LoadIdentities(opts.Identities, opts.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range header.Slots {
+		for _, identity := range identities {
+			fileKey, err := identity.unwrap(&header.Slots[i])
+			if err == nil {
+				return fileKey, nil
+			}
+			if !errors.Is(err, errSlotMismatch) {
+				return nil, err
+			}
+		}
+	}
+
+	if len(opts.RecoveryKey) > 0 {
+		for i := range header.Slots {
+			fileKey, err := header.Slots[i].unlockRecovery(opts.RecoveryKey)
+			if err == nil {
+				return fileKey, nil
+			}
+			if !errors.Is(err, errSlotMismatch) {
+				return nil, err
+			}
+		}
+	}
+
+	if fileKey := s.unlockCached(header); fileKey != nil {
+		return fileKey, nil
+	}
+
+	if !opts.Password.IsEmpty() || len(opts.KeyFile) > 0 {
+		for i := range header.Slots {
+			slot := &header.Slots[i]
+			kek, err := slot.passwordKEK(opts.Password, opts.KeyFile)
+			if err == nil {
+				var fileKey []byte
+				if fileKey, err = unwrapKey(kek, slot.WrappedKey); err == nil {
+					s.cacheKEK(slot, kek)
+					return fileKey, nil
+				}
+			}
+			if !errors.Is(err, errSlotMismatch) {
+				return nil, err
+			}
+		}
+	}
+
+	if len(identities) > 0 {
+		return nil, fmt.Errorf("decryption failed: no key slot matches the given identities or password")
+	}
+	if len(opts.RecoveryKey) > 0 && opts.Password.IsEmpty() && len(opts.KeyFile) == 0 {
+		return nil, fmt.Errorf("decryption failed: archive has no recovery slot for this recovery key")
+	}
+	if len(opts.KeyFile) > 0 {
+		return nil, fmt.Errorf("decryption failed: invalid password or key file, or corrupted data")
+	}
+	if opts.Password.IsEmpty() {
+		return nil, fmt.Errorf("decryption failed: the key agent holds no key for this archive; a password is required")
+	}
+	return nil, fmt.Errorf("decryption failed: invalid password or corrupted data")
+}
+
+// errPasswordOnly reports that an archive predating key slots was opened
+// without a password
+func errPasswordOnly() error {
+	return &types.CryptoError{
+		Operation: "decrypt",
+		Err:       fmt.Errorf("archive is protected by a password only; identities, key files and recovery keys cannot open it"),
+	}
+}
+
+// decryptVersion1 decrypts a version 1 archive, whose payload is a single
+// AEAD ciphertext authenticated together with the header
+func (s *Service) decryptVersion1(r io.Reader, header *Header, key []byte) (io.Reader, error) {
+	headerBytes, err := header.MarshalBinary()
+	if err != nil {
+		return nil, &types.CryptoError{
+			Operation: "decrypt",
+			Err:       fmt.Errorf("invalid archive header: %w", err),
+		}
+	}
+
+	aead, err := newAEAD(header.Cipher, key)
 	if err != nil {
 		return nil, &types.CryptoError{
 			Operation: "decrypt",
-			Err:       fmt.Errorf("failed to create cipher: %w", err),
+			Err:       err,
+		}
+	}
+
+	if len(header.Nonce) != aead.NonceSize() {
+		return nil, &types.CryptoError{
+			Operation: "decrypt",
+			Err:       fmt.Errorf("invalid archive header: bad nonce size"),
 		}
 	}
 
-	// Create GCM mode
-	gcm, err := cipher.NewGCM(block)
+	ciphertext, err := io.ReadAll(r)
 	if err != nil {
 		return nil, &types.CryptoError{
 			Operation: "decrypt",
-			Err:       fmt.Errorf("failed to create GCM: %w", err),
+			Err:       fmt.Errorf("failed to read encrypted data: %w", err),
+		}
+	}
+
+	// Decrypt data, verifying the header was not tampered with
+	plaintext, err := aead.Open(nil, header.Nonce, ciphertext, headerBytes)
+	if err != nil {
+		return nil, &types.CryptoError{
+			Operation: "decrypt",
+			Err:       fmt.Errorf("decryption failed: invalid password or corrupted data"),
+		}
+	}
+
+	return bytes.NewReader(plaintext), nil
+}
+
+// decryptLegacy decrypts archives written before the header was introduced,
+// laid out as salt || nonce || ciphertext
+func (s *Service) decryptLegacy(data []byte, password *secret.Buffer) ([]byte, error) {
+	// Extract salt, nonce, and ciphertext
+	salt := data[:SaltSize]
+	nonce := data[SaltSize : SaltSize+NonceSize]
+	ciphertext := data[SaltSize+NonceSize:]
+
+	// Derive key using PBKDF2
+	key := pbkdf2.Key(password.Bytes(), salt, PBKDF2Iterations, KeySize, sha256.New)
+
+	gcm, err := newAEAD(CipherAES256GCM, key)
+	if err != nil {
+		return nil, &types.CryptoError{
+			Operation: "decrypt",
+			Err:       err,
 		}
 	}
 
@@ -153,7 +602,7 @@ func (s *Service) Decrypt(data []byte, password string) ([]byte, error) {
 }
 
 // ValidatePassword validates if a password can decrypt the given data
-func (s *Service) ValidatePassword(data []byte, password string) error {
+func (s *Service) ValidatePassword(data []byte, password *secret.Buffer) error {
 	_, err := s.Decrypt(data, password)
 	if err != nil {
 		if cryptoErr, ok := err.(*types.CryptoError); ok {
@@ -169,36 +618,3 @@ func (s *Service) ValidatePassword(data []byte, password string) error {
 	}
 	return nil
 }
-
-// GenerateSecurePassword generates a cryptographically secure random password
-func GenerateSecurePassword(length int) (string, error) {
-	if length < 8 {
-		return "", fmt.Errorf("password length must be at least 8 characters")
-	}
-
-	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!@#$%^&*"
-	password := make([]byte, length)
-
-	for i := range password {
-		randomByte := make([]byte, 1)
-		if _, err := rand.Read(randomByte); err != nil {
-			return "", fmt.Errorf("failed to generate random password: %w", err)
-		}
-		password[i] = charset[int(randomByte[0])%len(charset)]
-	}
-
-	return string(password), nil
-}
-
-// EstimateDecryptionTime estimates the time needed to decrypt data (for UI progress)
-func EstimateDecryptionTime(dataSize int64) int {
-	// Very rough estimate: ~1MB per second for decryption
-	const decryptionSpeed = 1024 * 1024 // bytes per second
-
-	estimatedSeconds := int(dataSize / decryptionSpeed)
-	if estimatedSeconds < 1 {
-		estimatedSeconds = 1
-	}
-
-	return estimatedSeconds
-}
@@ -2,10 +2,15 @@ package crypto
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
 	"strings"
 	"testing"
 
+	"goingenv/pkg/secret"
 	"goingenv/pkg/types"
+
+	"golang.org/x/crypto/pbkdf2"
 )
 
 func TestService_EncryptDecrypt(t *testing.T) {
@@ -46,7 +51,7 @@ func TestService_EncryptDecrypt(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// Test encryption
-			encrypted, err := service.Encrypt(tt.data, tt.password)
+			encrypted, err := service.Encrypt(tt.data, secret.FromString(tt.password))
 			if err != nil {
 				t.Fatalf("Encrypt failed: %v", err)
 			}
@@ -56,13 +61,13 @@ func TestService_EncryptDecrypt(t *testing.T) {
 				t.Error("Encrypted data is identical to original data")
 			}
 
-			// Encrypted data should be longer due to salt, nonce, and auth tag
-			if len(encrypted) <= len(tt.data) {
-				t.Error("Encrypted data should be longer than original")
+			// Compression can shrink repetitive data, but never below the header
+			if len(encrypted) <= len(Magic)+HeaderMACSize {
+				t.Error("Encrypted data should include the archive header")
 			}
 
 			// Test decryption
-			decrypted, err := service.Decrypt(encrypted, tt.password)
+			decrypted, err := service.Decrypt(encrypted, secret.FromString(tt.password))
 			if err != nil {
 				t.Fatalf("Decrypt failed: %v", err)
 			}
@@ -100,7 +105,7 @@ func TestService_EncryptErrors(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := service.Encrypt(tt.data, tt.password)
+			_, err := service.Encrypt(tt.data, secret.FromString(tt.password))
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Encrypt() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -153,7 +158,7 @@ func TestService_DecryptErrors(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := service.Decrypt(tt.data, tt.password)
+			_, err := service.Decrypt(tt.data, secret.FromString(tt.password))
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Decrypt() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -175,7 +180,7 @@ func TestService_ValidatePassword(t *testing.T) {
 	password := "correct password 123"
 
 	// Encrypt data first
-	encrypted, err := service.Encrypt(data, password)
+	encrypted, err := service.Encrypt(data, secret.FromString(password))
 	if err != nil {
 		t.Fatalf("Failed to encrypt test data: %v", err)
 	}
@@ -214,7 +219,7 @@ func TestService_ValidatePassword(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := service.ValidatePassword(tt.data, tt.password)
+			err := service.ValidatePassword(tt.data, secret.FromString(tt.password))
 			if (err != nil) != tt.wantErr {
 				t.Errorf("ValidatePassword() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -222,73 +227,6 @@ func TestService_ValidatePassword(t *testing.T) {
 	}
 }
 
-func TestGenerateSecurePassword(t *testing.T) {
-	tests := []struct {
-		name    string
-		length  int
-		wantErr bool
-	}{
-		{"Valid length", 12, false},
-		{"Minimum length", 8, false},
-		{"Long password", 64, false},
-		{"Too short", 7, true},
-		{"Zero length", 0, true},
-		{"Negative length", -5, true},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			password, err := GenerateSecurePassword(tt.length)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("GenerateSecurePassword() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-
-			if !tt.wantErr {
-				if len(password) != tt.length {
-					t.Errorf("Password length = %d, want %d", len(password), tt.length)
-				}
-
-				// Check that password contains only allowed characters
-				allowedChars := "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!@#$%^&*"
-				for _, char := range password {
-					if !strings.ContainsRune(allowedChars, char) {
-						t.Errorf("Password contains invalid character: %c", char)
-					}
-				}
-
-				// For short passwords, complexity might be limited by randomness
-				// Just check that password contains valid characters
-				if tt.length >= 12 {
-					// Only check complexity for longer passwords
-					hasLower := strings.ContainsAny(password, "abcdefghijklmnopqrstuvwxyz")
-					hasUpper := strings.ContainsAny(password, "ABCDEFGHIJKLMNOPQRSTUVWXYZ")
-					hasDigit := strings.ContainsAny(password, "0123456789")
-					hasSpecial := strings.ContainsAny(password, "!@#$%^&*")
-
-					complexityCount := 0
-					if hasLower {
-						complexityCount++
-					}
-					if hasUpper {
-						complexityCount++
-					}
-					if hasDigit {
-						complexityCount++
-					}
-					if hasSpecial {
-						complexityCount++
-					}
-
-					if complexityCount < 2 {
-						t.Error("Generated long password should contain at least 2 character types")
-					}
-				}
-			}
-		})
-	}
-}
-
 func TestPasswordStrength(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -320,7 +258,7 @@ func TestService_EncryptionConsistency(t *testing.T) {
 	// Encrypt the same data multiple times
 	var encrypted [][]byte
 	for i := 0; i < 5; i++ {
-		enc, err := service.Encrypt(data, password)
+		enc, err := service.Encrypt(data, secret.FromString(password))
 		if err != nil {
 			t.Fatalf("Encryption %d failed: %v", i, err)
 		}
@@ -338,7 +276,7 @@ func TestService_EncryptionConsistency(t *testing.T) {
 
 	// But all should decrypt to the same original data
 	for i, enc := range encrypted {
-		decrypted, err := service.Decrypt(enc, password)
+		decrypted, err := service.Decrypt(enc, secret.FromString(password))
 		if err != nil {
 			t.Errorf("Decryption %d failed: %v", i, err)
 		}
@@ -348,6 +286,367 @@ func TestService_EncryptionConsistency(t *testing.T) {
 	}
 }
 
+func TestService_EncryptWritesHeader(t *testing.T) {
+	service := NewService()
+
+	encrypted, err := service.Encrypt([]byte("KEY=value"), secret.FromString("header password"))
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+
+	header, _, err := ParseHeader(encrypted)
+	if err != nil {
+		t.Fatalf("Encrypted output has no valid header: %v", err)
+	}
+
+	if header.Cipher != CipherAES256GCM {
+		t.Errorf("Cipher = %s, want %s", header.Cipher, CipherAES256GCM)
+	}
+	if len(header.Slots) != 1 || header.Slots[0].Type != SlotPassword {
+		t.Fatalf("Slots = %+v, want a single password slot", header.Slots)
+	}
+	if header.Slots[0].KDF != KDFPBKDF2SHA256 {
+		t.Errorf("KDF = %s, want %s", header.Slots[0].KDF, KDFPBKDF2SHA256)
+	}
+	if header.Slots[0].Params.Iterations != PBKDF2Iterations {
+		t.Errorf("Iterations = %d, want %d", header.Slots[0].Params.Iterations, PBKDF2Iterations)
+	}
+}
+
+func TestService_DecryptLegacyFormat(t *testing.T) {
+	service := NewService()
+	data := []byte("DATABASE_URL=postgres://localhost/legacy")
+	password := "legacy password"
+
+	legacy := encryptLegacy(t, data, password)
+	if HasHeader(legacy) {
+		t.Fatal("Legacy fixture unexpectedly has a header")
+	}
+
+	decrypted, err := service.Decrypt(legacy, secret.FromString(password))
+	if err != nil {
+		t.Fatalf("Decrypt of legacy archive failed: %v", err)
+	}
+	if !bytes.Equal(data, decrypted) {
+		t.Errorf("Legacy decryption mismatch: got %q", decrypted)
+	}
+
+	if _, err := service.Decrypt(legacy, secret.FromString("wrong")); err == nil {
+		t.Error("Expected legacy decryption with wrong password to fail")
+	}
+}
+
+func TestService_DecryptVersion1Format(t *testing.T) {
+	service := NewService()
+	data := []byte("API_KEY=single-shot")
+	password := "version one"
+
+	salt := make([]byte, SaltSize)
+	nonce := make([]byte, NonceSize)
+	if _, err := rand.Read(salt); err != nil {
+		t.Fatalf("rand.Read failed: %v", err)
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		t.Fatalf("rand.Read failed: %v", err)
+	}
+
+	header := &Header{
+		Version: FormatVersion1,
+		Cipher:  CipherAES256GCM,
+		KDF:     KDFPBKDF2SHA256,
+		Params:  KDFParams{Iterations: 1000},
+		Salt:    salt,
+		Nonce:   nonce,
+	}
+	headerBytes, err := header.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	key := pbkdf2.Key([]byte(password), salt, 1000, KeySize, sha256.New)
+	aead, err := newAEAD(CipherAES256GCM, key)
+	if err != nil {
+		t.Fatalf("newAEAD failed: %v", err)
+	}
+	archive := append(headerBytes, aead.Seal(nil, nonce, data, headerBytes)...)
+
+	decrypted, err := service.Decrypt(archive, secret.FromString(password))
+	if err != nil {
+		t.Fatalf("Decrypt of version 1 archive failed: %v", err)
+	}
+	if !bytes.Equal(data, decrypted) {
+		t.Errorf("Version 1 decryption mismatch: got %q", decrypted)
+	}
+}
+
+func TestService_DecryptVersion2Format(t *testing.T) {
+	service := NewService()
+	data := []byte("API_KEY=password-keyed-stream")
+	password := "version two"
+
+	header := &Header{
+		Version: FormatVersion2,
+		Cipher:  CipherAES256GCM,
+		KDF:     KDFPBKDF2SHA256,
+		Params:  KDFParams{Iterations: 1000},
+		Salt:    make([]byte, SaltSize),
+	}
+	if _, err := rand.Read(header.Salt); err != nil {
+		t.Fatalf("rand.Read failed: %v", err)
+	}
+	key := pbkdf2.Key([]byte(password), header.Salt, 1000, KeySize, sha256.New)
+
+	var buf bytes.Buffer
+	w, err := service.writeStream(&buf, header, key, nil)
+	if err != nil {
+		t.Fatalf("writeStream failed: %v", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	decrypted, err := service.Decrypt(buf.Bytes(), secret.FromString(password))
+	if err != nil {
+		t.Fatalf("Decrypt of version 2 archive failed: %v", err)
+	}
+	if !bytes.Equal(data, decrypted) {
+		t.Errorf("Version 2 decryption mismatch: got %q", decrypted)
+	}
+}
+
+func TestService_DecryptRejectsTamperedHeader(t *testing.T) {
+	service := NewService()
+	password := "tamper password"
+
+	encrypted := mustEncrypt([]byte("SECRET=1"), password)
+
+	_, headerLen, err := ParseHeader(encrypted)
+	if err != nil {
+		t.Fatalf("ParseHeader failed: %v", err)
+	}
+	payload := encrypted[headerLen:]
+
+	// rewrite re-encodes the header after modify, keeping the original MAC
+	rewrite := func(modify func(h *Header)) []byte {
+		h, _, _ := ParseHeader(encrypted)
+		modify(h)
+		out, err := h.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary failed: %v", err)
+		}
+		return append(out, payload...)
+	}
+
+	// Changing the salt changes the key-encryption key, so the slot no longer opens
+	tampered := rewrite(func(h *Header) { h.Slots[0].Salt[0] ^= 0x01 })
+	if _, err := service.Decrypt(tampered, secret.FromString(password)); err == nil {
+		t.Error("Expected decryption of tampered header to fail")
+	}
+
+	// Changing the iteration count must not go unnoticed either
+	weakened := rewrite(func(h *Header) { h.Slots[0].Params.Iterations-- })
+	if _, err := service.Decrypt(weakened, secret.FromString(password)); err == nil {
+		t.Error("Expected decryption with modified KDF parameters to fail")
+	}
+
+	// Adding a slot that opens with another password is caught by the MAC
+	attacker, err := newPasswordSlot(make([]byte, KeySize), secret.FromString("attacker"), nil, KDFPBKDF2SHA256, KDFParams{Iterations: 1000})
+	if err != nil {
+		t.Fatalf("newPasswordSlot failed: %v", err)
+	}
+	extended := rewrite(func(h *Header) { h.Slots = append(h.Slots, attacker) })
+	if _, err := service.Decrypt(extended, secret.FromString(password)); err == nil {
+		t.Error("Expected decryption with an injected key slot to fail")
+	}
+
+	// The stream nonce and the MAC itself are covered as well
+	for _, offset := range []int{headerLen - HeaderMACSize - 1, headerLen - 1} {
+		tampered := append([]byte{}, encrypted...)
+		tampered[offset] ^= 0x01
+		if _, err := service.Decrypt(tampered, secret.FromString(password)); err == nil {
+			t.Errorf("Expected decryption with byte %d modified to fail", offset)
+		}
+	}
+}
+
+func TestService_KDFRoundTrip(t *testing.T) {
+	data := []byte("DATABASE_URL=postgres://localhost/kdf\nAPI_KEY=secret")
+	password := "kdf round trip"
+
+	tests := []struct {
+		name    string
+		kdf     types.KDFConfig
+		wantKDF KDFID
+		want    KDFParams
+	}{
+		{
+			name:    "PBKDF2 default",
+			kdf:     types.KDFConfig{},
+			wantKDF: KDFPBKDF2SHA256,
+			want:    KDFParams{Iterations: PBKDF2Iterations},
+		},
+		{
+			name:    "PBKDF2 custom iterations",
+			kdf:     types.KDFConfig{Algorithm: KDFNamePBKDF2, Iterations: 2000},
+			wantKDF: KDFPBKDF2SHA256,
+			want:    KDFParams{Iterations: 2000},
+		},
+		{
+			name:    "Argon2id",
+			kdf:     types.KDFConfig{Algorithm: KDFNameArgon2id, Time: 1, Memory: 1024, Parallelism: 2},
+			wantKDF: KDFArgon2id,
+			want:    KDFParams{Time: 1, Memory: 1024, Parallelism: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewServiceWithConfig(&types.Config{KDF: tt.kdf})
+
+			encrypted, err := service.Encrypt(data, secret.FromString(password))
+			if err != nil {
+				t.Fatalf("Encrypt failed: %v", err)
+			}
+
+			header, _, err := ParseHeader(encrypted)
+			if err != nil {
+				t.Fatalf("ParseHeader failed: %v", err)
+			}
+			slot := header.Slots[0]
+			if slot.KDF != tt.wantKDF {
+				t.Errorf("KDF = %s, want %s", slot.KDF, tt.wantKDF)
+			}
+			if slot.Params != tt.want {
+				t.Errorf("Params = %+v, want %+v", slot.Params, tt.want)
+			}
+
+			// Decryption is driven by the header, not by the decrypting service's config
+			decrypted, err := NewService().Decrypt(encrypted, secret.FromString(password))
+			if err != nil {
+				t.Fatalf("Decrypt failed: %v", err)
+			}
+			if !bytes.Equal(data, decrypted) {
+				t.Errorf("Decrypted data doesn't match original")
+			}
+
+			if _, err := service.Decrypt(encrypted, secret.FromString("wrong password")); err == nil {
+				t.Error("Expected decryption with wrong password to fail")
+			}
+		})
+	}
+}
+
+func TestKDFFromConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     types.KDFConfig
+		wantKDF KDFID
+		want    KDFParams
+		wantErr bool
+	}{
+		{
+			name:    "Empty selects PBKDF2",
+			cfg:     types.KDFConfig{},
+			wantKDF: KDFPBKDF2SHA256,
+			want:    KDFParams{Iterations: PBKDF2Iterations},
+		},
+		{
+			name:    "Argon2id fills defaults",
+			cfg:     types.KDFConfig{Algorithm: "Argon2id"},
+			wantKDF: KDFArgon2id,
+			want:    KDFParams{Time: Argon2Time, Memory: Argon2Memory, Parallelism: Argon2Parallelism},
+		},
+		{
+			name:    "Argon2id partial override",
+			cfg:     types.KDFConfig{Algorithm: KDFNameArgon2id, Memory: 128 * 1024},
+			wantKDF: KDFArgon2id,
+			want:    KDFParams{Time: Argon2Time, Memory: 128 * 1024, Parallelism: Argon2Parallelism},
+		},
+		{
+			name:    "Unknown algorithm",
+			cfg:     types.KDFConfig{Algorithm: "scrypt"},
+			wantErr: true,
+		},
+		{
+			name:    "Argon2id memory below lanes",
+			cfg:     types.KDFConfig{Algorithm: KDFNameArgon2id, Memory: 8, Parallelism: 4},
+			wantErr: true,
+		},
+		{
+			name:    "Argon2id memory too large",
+			cfg:     types.KDFConfig{Algorithm: KDFNameArgon2id, Memory: maxArgon2Memory + 1},
+			wantErr: true,
+		},
+		{
+			name:    "PBKDF2 iterations too large",
+			cfg:     types.KDFConfig{Algorithm: KDFNamePBKDF2, Iterations: maxPBKDF2Iterations + 1},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kdf, params, err := KDFFromConfig(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("KDFFromConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if kdf != tt.wantKDF || params != tt.want {
+				t.Errorf("KDFFromConfig() = %s %+v, want %s %+v", kdf, params, tt.wantKDF, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_DecryptRejectsExcessiveKDFCost(t *testing.T) {
+	tests := []struct {
+		name   string
+		kdf    KDFID
+		params KDFParams
+	}{
+		{"Argon2id memory", KDFArgon2id, KDFParams{Time: 1, Memory: maxArgon2Memory + 1, Parallelism: 1}},
+		{"Argon2id time", KDFArgon2id, KDFParams{Time: maxArgon2Time + 1, Memory: 8, Parallelism: 1}},
+		{"PBKDF2 iterations", KDFPBKDF2SHA256, KDFParams{Iterations: maxPBKDF2Iterations + 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := &Header{
+				Version: FormatVersion,
+				Cipher:  CipherAES256GCM,
+				Slots: []KeySlot{{
+					Type:       SlotPassword,
+					KDF:        tt.kdf,
+					Params:     tt.params,
+					Salt:       make([]byte, SaltSize),
+					WrappedKey: make([]byte, KeySize+16),
+				}},
+				Nonce: make([]byte, StreamNonceSize),
+				MAC:   make([]byte, HeaderMACSize),
+			}
+			encoded, err := header.MarshalBinary()
+			if err != nil {
+				t.Fatalf("MarshalBinary failed: %v", err)
+			}
+			data := append(encoded, make([]byte, 32)...)
+
+			_, err = NewService().Decrypt(data, secret.FromString("password"))
+			if err == nil || !strings.Contains(err.Error(), "must be between") {
+				t.Errorf("Expected the excessive cost to be refused, got %v", err)
+			}
+		})
+	}
+
+	// The limits are low enough for an ordinary machine to attempt an unlock
+	if maxArgon2Memory > 1024*1024 || maxArgon2Time > 16 {
+		t.Errorf("Argon2id limits of %d KiB and %d passes are too high to be safe", maxArgon2Memory, maxArgon2Time)
+	}
+}
+
 func BenchmarkEncrypt(b *testing.B) {
 	service := NewService()
 	data := []byte("benchmark test data for encryption performance")
@@ -355,7 +654,7 @@ func BenchmarkEncrypt(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, err := service.Encrypt(data, password)
+		_, err := service.Encrypt(data, secret.FromString(password))
 		if err != nil {
 			b.Fatalf("Encryption failed: %v", err)
 		}
@@ -367,14 +666,14 @@ func BenchmarkDecrypt(b *testing.B) {
 	data := []byte("benchmark test data for decryption performance")
 	password := "benchmarkpassword123"
 
-	encrypted, err := service.Encrypt(data, password)
+	encrypted, err := service.Encrypt(data, secret.FromString(password))
 	if err != nil {
 		b.Fatalf("Setup encryption failed: %v", err)
 	}
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, err := service.Decrypt(encrypted, password)
+		_, err := service.Decrypt(encrypted, secret.FromString(password))
 		if err != nil {
 			b.Fatalf("Decryption failed: %v", err)
 		}
@@ -384,13 +683,37 @@ func BenchmarkDecrypt(b *testing.B) {
 // Helper functions for tests
 func mustEncrypt(data []byte, password string) []byte {
 	service := NewService()
-	encrypted, err := service.Encrypt(data, password)
+	encrypted, err := service.Encrypt(data, secret.FromString(password))
 	if err != nil {
 		panic(err)
 	}
 	return encrypted
 }
 
+// encryptLegacy produces the headerless salt || nonce || ciphertext layout
+func encryptLegacy(t *testing.T, data []byte, password string) []byte {
+	t.Helper()
+
+	salt := make([]byte, SaltSize)
+	nonce := make([]byte, NonceSize)
+	if _, err := rand.Read(salt); err != nil {
+		t.Fatalf("Failed to generate salt: %v", err)
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		t.Fatalf("Failed to generate nonce: %v", err)
+	}
+
+	key := pbkdf2.Key([]byte(password), salt, PBKDF2Iterations, KeySize, sha256.New)
+	gcm, err := newAEAD(CipherAES256GCM, key)
+	if err != nil {
+		t.Fatalf("Failed to create cipher: %v", err)
+	}
+
+	result := append([]byte{}, salt...)
+	result = append(result, nonce...)
+	return gcm.Seal(result, nonce, data, nil)
+}
+
 func corruptData(data []byte) []byte {
 	if len(data) == 0 {
 		return data
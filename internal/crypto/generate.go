@@ -0,0 +1,141 @@
+package crypto
+
+import (
+	"bufio"
+	"crypto/rand"
+	_ "embed"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+
+	"goingenv/pkg/secret"
+)
+
+const (
+	// PasswordCharset is the character set of generated passwords
+	PasswordCharset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!@#$%^&*"
+	// AlphanumericCharset is PasswordCharset without symbols, for systems
+	// that reject them
+	AlphanumericCharset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	// MinPasswordLength is the shortest password that can be generated
+	MinPasswordLength = 8
+	// MinPassphraseWords is the fewest words a passphrase can have
+	MinPassphraseWords = 4
+	// DefaultPassphraseWords gives about 77 bits of entropy
+	DefaultPassphraseWords = 6
+)
+
+//go:embed wordlist.txt
+var wordListFile string
+
+// wordList holds the diceware words, in list order
+var wordList = loadDicewareWords(wordListFile)
+
+// GenerateSecurePassword generates a cryptographically secure random password
+// of length characters from PasswordCharset
+func GenerateSecurePassword(length int) (*secret.Buffer, error) {
+	return GeneratePassword(length, PasswordCharset)
+}
+
+// GeneratePassword generates a random password of length characters, each
+// drawn uniformly from charset
+func GeneratePassword(length int, charset string) (*secret.Buffer, error) {
+	if length < MinPasswordLength {
+		return nil, fmt.Errorf("password length must be at least %d characters", MinPasswordLength)
+	}
+	if len(charset) < 2 {
+		return nil, fmt.Errorf("character set must have at least 2 characters")
+	}
+
+	password := secret.New(length)
+	buf := password.Bytes()
+	for i := range buf {
+		n, err := randomIndex(len(charset))
+		if err != nil {
+			password.Destroy()
+			return nil, fmt.Errorf("failed to generate random password: %w", err)
+		}
+		buf[i] = charset[n]
+	}
+
+	return password, nil
+}
+
+// GeneratePassphrase generates a diceware passphrase of words words from
+// the embedded EFF large wordlist, joined by separator
+func GeneratePassphrase(words int, separator string) (*secret.Buffer, error) {
+	if words < MinPassphraseWords {
+		return nil, fmt.Errorf("passphrase must have at least %d words", MinPassphraseWords)
+	}
+
+	chosen := make([]string, words)
+	length := len(separator) * (words - 1)
+	for i := range chosen {
+		n, err := randomIndex(len(wordList))
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate random passphrase: %w", err)
+		}
+		chosen[i] = wordList[n]
+		length += len(chosen[i])
+	}
+
+	// Build the passphrase in place; the words themselves are public
+	passphrase := secret.New(length)
+	buf := passphrase.Bytes()[:0]
+	for i, word := range chosen {
+		if i > 0 {
+			buf = append(buf, separator...)
+		}
+		buf = append(buf, word...)
+	}
+
+	return passphrase, nil
+}
+
+// PasswordEntropy returns the entropy in bits of a password of length
+// characters generated from charset
+func PasswordEntropy(length int, charset string) float64 {
+	return float64(length) * math.Log2(float64(len(charset)))
+}
+
+// PassphraseEntropy returns the entropy in bits of a generated passphrase
+// of words words
+func PassphraseEntropy(words int) float64 {
+	return float64(words) * math.Log2(float64(len(wordList)))
+}
+
+// randomIndex returns a uniformly random integer in [0, n). Random values
+// from the top, incomplete multiple of n are rejected and drawn again;
+// reducing them modulo n would favour the smallest results.
+func randomIndex(n int) (int, error) {
+	if n <= 0 || uint64(n) > math.MaxUint32 {
+		return 0, fmt.Errorf("invalid range %d", n)
+	}
+
+	limit := math.MaxUint32 + 1 - (math.MaxUint32+1)%uint64(n)
+	var b [4]byte
+	for {
+		if _, err := rand.Read(b[:]); err != nil {
+			return 0, err
+		}
+		if v := uint64(binary.BigEndian.Uint32(b[:])); v < limit {
+			return int(v % uint64(n)), nil
+		}
+	}
+}
+
+// loadDicewareWords parses the embedded wordlist, skipping comments and
+// blank lines
+func loadDicewareWords(data string) []string {
+	var words []string
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	return words
+}
@@ -0,0 +1,154 @@
+package crypto
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestGenerateSecurePassword(t *testing.T) {
+	tests := []struct {
+		name    string
+		length  int
+		wantErr bool
+	}{
+		{"Valid length", 12, false},
+		{"Minimum length", 8, false},
+		{"Long password", 64, false},
+		{"Too short", 7, true},
+		{"Zero length", 0, true},
+		{"Negative length", -5, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generated, err := GenerateSecurePassword(tt.length)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GenerateSecurePassword() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			defer generated.Destroy()
+			password := string(generated.Bytes())
+
+			if !tt.wantErr {
+				if len(password) != tt.length {
+					t.Errorf("Password length = %d, want %d", len(password), tt.length)
+				}
+
+				// Check that password contains only allowed characters
+				allowedChars := "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!@#$%^&*"
+				for _, char := range password {
+					if !strings.ContainsRune(allowedChars, char) {
+						t.Errorf("Password contains invalid character: %c", char)
+					}
+				}
+
+				// For short passwords, complexity might be limited by randomness
+				// Just check that password contains valid characters
+				if tt.length >= 12 {
+					// Only check complexity for longer passwords
+					hasLower := strings.ContainsAny(password, "abcdefghijklmnopqrstuvwxyz")
+					hasUpper := strings.ContainsAny(password, "ABCDEFGHIJKLMNOPQRSTUVWXYZ")
+					hasDigit := strings.ContainsAny(password, "0123456789")
+					hasSpecial := strings.ContainsAny(password, "!@#$%^&*")
+
+					complexityCount := 0
+					if hasLower {
+						complexityCount++
+					}
+					if hasUpper {
+						complexityCount++
+					}
+					if hasDigit {
+						complexityCount++
+					}
+					if hasSpecial {
+						complexityCount++
+					}
+
+					if complexityCount < 2 {
+						t.Error("Generated long password should contain at least 2 character types")
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestGeneratePassword(t *testing.T) {
+	generated, err := GeneratePassword(32, "ab")
+	if err != nil {
+		t.Fatalf("GeneratePassword failed: %v", err)
+	}
+	defer generated.Destroy()
+	if strings.Trim(string(generated.Bytes()), "ab") != "" {
+		t.Errorf("Password %q uses characters outside the set", generated.Bytes())
+	}
+
+	if _, err := GeneratePassword(16, "a"); err == nil {
+		t.Error("Expected a one-character set to be rejected")
+	}
+	if got := PasswordEntropy(20, AlphanumericCharset); math.Abs(got-119.1) > 0.1 {
+		t.Errorf("PasswordEntropy() = %.1f, want 119.1", got)
+	}
+}
+
+func TestGeneratePassphrase(t *testing.T) {
+	if len(wordList) != 7776 {
+		t.Fatalf("Expected 7776 words in the wordlist, got %d", len(wordList))
+	}
+	known := make(map[string]bool, len(wordList))
+	for _, word := range wordList {
+		known[word] = true
+	}
+
+	generated, err := GeneratePassphrase(DefaultPassphraseWords, "-")
+	if err != nil {
+		t.Fatalf("GeneratePassphrase failed: %v", err)
+	}
+	defer generated.Destroy()
+
+	words := strings.Split(string(generated.Bytes()), "-")
+	if len(words) != DefaultPassphraseWords {
+		t.Fatalf("Expected %d words, got %q", DefaultPassphraseWords, generated.Bytes())
+	}
+	for _, word := range words {
+		if !known[word] {
+			t.Errorf("Passphrase word %q is not in the wordlist", word)
+		}
+	}
+
+	if _, err := GeneratePassphrase(MinPassphraseWords-1, " "); err == nil {
+		t.Error("Expected a passphrase below the minimum word count to be rejected")
+	}
+	if got := PassphraseEntropy(6); math.Abs(got-77.5) > 0.1 {
+		t.Errorf("PassphraseEntropy(6) = %.1f, want 77.5", got)
+	}
+}
+
+func TestRandomIndex(t *testing.T) {
+	// 3 does not divide 2^32, so modulo reduction would be biased; every
+	// value must still turn up in range
+	counts := make([]int, 3)
+	for i := 0; i < 3000; i++ {
+		n, err := randomIndex(3)
+		if err != nil {
+			t.Fatalf("randomIndex failed: %v", err)
+		}
+		if n < 0 || n >= 3 {
+			t.Fatalf("randomIndex(3) = %d, out of range", n)
+		}
+		counts[n]++
+	}
+	for value, count := range counts {
+		if count < 800 || count > 1200 {
+			t.Errorf("Value %d drawn %d times out of 3000", value, count)
+		}
+	}
+
+	for _, n := range []int{0, -1} {
+		if _, err := randomIndex(n); err == nil {
+			t.Errorf("Expected randomIndex(%d) to fail", n)
+		}
+	}
+}
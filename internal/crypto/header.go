@@ -0,0 +1,397 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Magic identifies an archive that starts with a self-describing header.
+// Archives written before the header existed are raw salt || nonce || ciphertext.
+var Magic = []byte("GOINGENV")
+
+const (
+	// FormatVersion1 encrypts the whole payload in a single AEAD call,
+	// using the header as additional data
+	FormatVersion1 uint8 = 1
+	// FormatVersion2 encrypts the payload as a stream of authenticated chunks
+	// and protects the header with a MAC
+	FormatVersion2 uint8 = 2
+	// FormatVersion3 encrypts the stream with a random file key that is
+	// wrapped in one key slot per password or recipient
+	FormatVersion3 uint8 = 3
+	// FormatVersion4 follows the header with an encrypted index segment, so
+	// the archive metadata can be read without decrypting the payload
+	FormatVersion4 uint8 = 4
+	// FormatVersion5 records the codec the payload was compressed with
+	FormatVersion5 uint8 = 5
+	// FormatVersion is the header version written by this build
+	FormatVersion = FormatVersion5
+
+	// HeaderMACSize is the size of the HMAC-SHA256 header MAC
+	HeaderMACSize = 32
+)
+
+// CipherID identifies the AEAD used for the payload
+type CipherID uint8
+
+const (
+	// CipherAES256GCM is AES-256 in GCM mode with a 12-byte nonce
+	CipherAES256GCM CipherID = 1
+	// CipherXChaCha20Poly1305 is XChaCha20-Poly1305 with a 24-byte nonce
+	CipherXChaCha20Poly1305 CipherID = 2
+)
+
+// String returns a human-readable name for the cipher
+func (c CipherID) String() string {
+	switch c {
+	case CipherAES256GCM:
+		return "AES-256-GCM"
+	case CipherXChaCha20Poly1305:
+		return "XChaCha20-Poly1305"
+	default:
+		return fmt.Sprintf("unknown cipher (%d)", uint8(c))
+	}
+}
+
+// KDFID identifies the key derivation function used to turn a password into a key
+type KDFID uint8
+
+const (
+	// KDFPBKDF2SHA256 is PBKDF2 with HMAC-SHA256
+	KDFPBKDF2SHA256 KDFID = 1
+	// KDFArgon2id is Argon2id (RFC 9106)
+	KDFArgon2id KDFID = 2
+)
+
+// String returns a human-readable name for the KDF
+func (k KDFID) String() string {
+	switch k {
+	case KDFPBKDF2SHA256:
+		return "PBKDF2-SHA256"
+	case KDFArgon2id:
+		return "Argon2id"
+	default:
+		return fmt.Sprintf("unknown KDF (%d)", uint8(k))
+	}
+}
+
+// KDFParams holds the cost parameters of a key derivation function.
+// Only the fields relevant to the KDF in use are set.
+type KDFParams struct {
+	Iterations  uint32 // PBKDF2
+	Time        uint32 // Argon2id
+	Memory      uint32 // Argon2id, in KiB
+	Parallelism uint8  // Argon2id
+}
+
+// String returns a human-readable summary of the parameters for the given KDF
+func (p KDFParams) String(kdf KDFID) string {
+	switch kdf {
+	case KDFPBKDF2SHA256:
+		return fmt.Sprintf("%d iterations", p.Iterations)
+	case KDFArgon2id:
+		return fmt.Sprintf("t=%d, m=%d KiB, p=%d", p.Time, p.Memory, p.Parallelism)
+	default:
+		return ""
+	}
+}
+
+// Header describes how an archive payload was encrypted
+type Header struct {
+	Version uint8
+	Cipher  CipherID
+	// Compression is the codec of the payload (version 5 and later)
+	Compression CompressionID
+	// KDF, Params and Salt derive the payload key directly from the password
+	// in versions 1 and 2; version 3 keeps them per key slot instead
+	KDF    KDFID
+	Params KDFParams
+	Salt   []byte
+	// Slots hold the wrapped file key (version 3 and later)
+	Slots []KeySlot
+	// Nonce is the AEAD nonce of the single-shot payload in version 1, and the
+	// random nonce mixed into the payload key of the chunked stream since version 2
+	Nonce []byte
+	// MAC authenticates all preceding header fields (version 2 and later)
+	MAC []byte
+}
+
+// HasHeader reports whether data starts with the archive magic bytes
+func HasHeader(data []byte) bool {
+	return bytes.HasPrefix(data, Magic)
+}
+
+// MarshalBinary encodes the header in its on-disk form.
+//
+// Layout of versions 1 and 2 (integers are big-endian):
+//
+//	magic (8) | version (1) | cipher (1) | kdf (1) |
+//	params length (2) | params | salt length (1) | salt | nonce length (1) | nonce |
+//	MAC (32, version 2 only)
+//
+// Layout of versions 3 to 5:
+//
+//	magic (8) | version (1) | cipher (1) | compression (1, version 5 only) |
+//	slot count (1) | slots (type (1) | body length (2) | body) |
+//	nonce length (1) | nonce | MAC (32)
+//
+// From version 4 the header is followed by the index segment, which is not
+// part of the header:
+//
+//	index length (4) | sealed index
+func (h *Header) MarshalBinary() ([]byte, error) {
+	out, err := h.authenticatedBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	if h.Version >= FormatVersion2 {
+		if len(h.MAC) != HeaderMACSize {
+			return nil, fmt.Errorf("header MAC must be %d bytes", HeaderMACSize)
+		}
+		out = append(out, h.MAC...)
+	}
+
+	return out, nil
+}
+
+// authenticatedBytes encodes every header field covered by the MAC
+func (h *Header) authenticatedBytes() ([]byte, error) {
+	if len(h.Salt) > 255 || len(h.Nonce) > 255 {
+		return nil, fmt.Errorf("salt and nonce must be at most 255 bytes")
+	}
+
+	var buf bytes.Buffer
+	buf.Write(Magic)
+	buf.WriteByte(h.Version)
+	buf.WriteByte(byte(h.Cipher))
+	if h.Version >= FormatVersion5 {
+		buf.WriteByte(byte(h.Compression))
+	}
+
+	if h.Version >= FormatVersion3 {
+		if len(h.Slots) == 0 || len(h.Slots) > MaxKeySlots {
+			return nil, fmt.Errorf("archive must have between 1 and %d key slots", MaxKeySlots)
+		}
+		buf.WriteByte(byte(len(h.Slots)))
+		for i := range h.Slots {
+			body, err := h.Slots[i].marshalBody()
+			if err != nil {
+				return nil, fmt.Errorf("key slot %d: %w", i, err)
+			}
+			buf.WriteByte(byte(h.Slots[i].Type))
+			_ = binary.Write(&buf, binary.BigEndian, uint16(len(body)))
+			buf.Write(body)
+		}
+	} else {
+		params, err := marshalKDFParams(h.KDF, h.Params)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteByte(byte(h.KDF))
+		_ = binary.Write(&buf, binary.BigEndian, uint16(len(params)))
+		buf.Write(params)
+		buf.WriteByte(byte(len(h.Salt)))
+		buf.Write(h.Salt)
+	}
+
+	buf.WriteByte(byte(len(h.Nonce)))
+	buf.Write(h.Nonce)
+
+	return buf.Bytes(), nil
+}
+
+// ParseHeader decodes a header from the start of data and returns it together
+// with the number of bytes it occupied
+func ParseHeader(data []byte) (*Header, int, error) {
+	r := bytes.NewReader(data)
+	header, err := ReadHeader(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	return header, len(data) - r.Len(), nil
+}
+
+// ReadHeader decodes a header from r, consuming exactly the header bytes
+func ReadHeader(r io.Reader) (*Header, error) {
+	magic := make([]byte, len(Magic))
+	if _, err := io.ReadFull(r, magic); err != nil || !bytes.Equal(magic, Magic) {
+		return nil, fmt.Errorf("missing archive magic")
+	}
+
+	var fixed struct {
+		Version uint8
+		Cipher  CipherID
+	}
+	if err := binary.Read(r, binary.BigEndian, &fixed); err != nil {
+		return nil, fmt.Errorf("truncated header")
+	}
+
+	if fixed.Version == 0 || fixed.Version > FormatVersion {
+		return nil, fmt.Errorf("unsupported archive format version %d", fixed.Version)
+	}
+
+	header := &Header{
+		Version: fixed.Version,
+		Cipher:  fixed.Cipher,
+	}
+
+	if header.Version >= FormatVersion5 {
+		var compression [1]byte
+		if _, err := io.ReadFull(r, compression[:]); err != nil {
+			return nil, fmt.Errorf("truncated header")
+		}
+		header.Compression = CompressionID(compression[0])
+	}
+
+	var err error
+	if header.Version >= FormatVersion3 {
+		header.Slots, err = readKeySlots(r)
+	} else {
+		header.KDF, header.Params, header.Salt, err = readPasswordFields(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	header.Nonce, err = readLengthPrefixed(r)
+	if err != nil {
+		return nil, fmt.Errorf("truncated nonce")
+	}
+
+	if header.Version >= FormatVersion2 {
+		header.MAC = make([]byte, HeaderMACSize)
+		if _, err := io.ReadFull(r, header.MAC); err != nil {
+			return nil, fmt.Errorf("truncated header MAC")
+		}
+	}
+
+	return header, nil
+}
+
+// readPasswordFields reads the KDF, its parameters and the salt of a
+// version 1 or 2 header
+func readPasswordFields(r io.Reader) (KDFID, KDFParams, []byte, error) {
+	var fixed struct {
+		KDF       KDFID
+		ParamsLen uint16
+	}
+	if err := binary.Read(r, binary.BigEndian, &fixed); err != nil {
+		return 0, KDFParams{}, nil, fmt.Errorf("truncated header")
+	}
+
+	params := make([]byte, fixed.ParamsLen)
+	if _, err := io.ReadFull(r, params); err != nil {
+		return 0, KDFParams{}, nil, fmt.Errorf("truncated KDF parameters")
+	}
+
+	kdfParams, err := unmarshalKDFParams(fixed.KDF, params)
+	if err != nil {
+		return 0, KDFParams{}, nil, err
+	}
+
+	salt, err := readLengthPrefixed(r)
+	if err != nil {
+		return 0, KDFParams{}, nil, fmt.Errorf("truncated salt")
+	}
+
+	return fixed.KDF, kdfParams, salt, nil
+}
+
+// readKeySlots reads the key slots of a version 3 header
+func readKeySlots(r io.Reader) ([]KeySlot, error) {
+	var count [1]byte
+	if _, err := io.ReadFull(r, count[:]); err != nil {
+		return nil, fmt.Errorf("truncated header")
+	}
+	if count[0] == 0 || int(count[0]) > MaxKeySlots {
+		return nil, fmt.Errorf("archive must have between 1 and %d key slots", MaxKeySlots)
+	}
+
+	slots := make([]KeySlot, count[0])
+	for i := range slots {
+		var fixed struct {
+			Type    SlotType
+			BodyLen uint16
+		}
+		if err := binary.Read(r, binary.BigEndian, &fixed); err != nil {
+			return nil, fmt.Errorf("truncated key slot %d", i)
+		}
+		body := make([]byte, fixed.BodyLen)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, fmt.Errorf("truncated key slot %d", i)
+		}
+		slot, err := unmarshalKeySlot(fixed.Type, body)
+		if err != nil {
+			return nil, fmt.Errorf("key slot %d: %w", i, err)
+		}
+		slots[i] = slot
+	}
+
+	return slots, nil
+}
+
+// marshalKDFParams encodes the parameters of the given KDF
+func marshalKDFParams(kdf KDFID, params KDFParams) ([]byte, error) {
+	switch kdf {
+	case KDFPBKDF2SHA256:
+		if params.Iterations == 0 {
+			return nil, fmt.Errorf("PBKDF2 iterations must be greater than 0")
+		}
+		out := make([]byte, 4)
+		binary.BigEndian.PutUint32(out, params.Iterations)
+		return out, nil
+	case KDFArgon2id:
+		if params.Time == 0 || params.Memory == 0 || params.Parallelism == 0 {
+			return nil, fmt.Errorf("argon2id time, memory and parallelism must be greater than 0")
+		}
+		out := make([]byte, 9)
+		binary.BigEndian.PutUint32(out[0:4], params.Time)
+		binary.BigEndian.PutUint32(out[4:8], params.Memory)
+		out[8] = params.Parallelism
+		return out, nil
+	default:
+		return nil, fmt.Errorf("unsupported KDF: %s", kdf)
+	}
+}
+
+// unmarshalKDFParams decodes the parameters of the given KDF
+func unmarshalKDFParams(kdf KDFID, data []byte) (KDFParams, error) {
+	switch kdf {
+	case KDFPBKDF2SHA256:
+		if len(data) != 4 {
+			return KDFParams{}, fmt.Errorf("invalid PBKDF2 parameters")
+		}
+		iterations := binary.BigEndian.Uint32(data)
+		if iterations == 0 {
+			return KDFParams{}, fmt.Errorf("invalid PBKDF2 iteration count")
+		}
+		return KDFParams{Iterations: iterations}, nil
+	case KDFArgon2id:
+		if len(data) != 9 {
+			return KDFParams{}, fmt.Errorf("invalid Argon2id parameters")
+		}
+		return KDFParams{
+			Time:        binary.BigEndian.Uint32(data[0:4]),
+			Memory:      binary.BigEndian.Uint32(data[4:8]),
+			Parallelism: data[8],
+		}, nil
+	default:
+		return KDFParams{}, fmt.Errorf("unsupported KDF: %s", kdf)
+	}
+}
+
+// readLengthPrefixed reads a single-byte length followed by that many bytes
+func readLengthPrefixed(r io.Reader) ([]byte, error) {
+	var n [1]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return nil, err
+	}
+	out := make([]byte, n[0])
+	if _, err := io.ReadFull(r, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,266 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHeader_RoundTrip(t *testing.T) {
+	header := &Header{
+		Version: FormatVersion2,
+		Cipher:  CipherAES256GCM,
+		KDF:     KDFPBKDF2SHA256,
+		Params:  KDFParams{Iterations: 12345},
+		Salt:    bytes.Repeat([]byte{0xAA}, SaltSize),
+		Nonce:   bytes.Repeat([]byte{0xBB}, StreamNonceSize),
+		MAC:     bytes.Repeat([]byte{0xCC}, HeaderMACSize),
+	}
+
+	encoded, err := header.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	if !HasHeader(encoded) {
+		t.Fatal("Encoded header does not start with magic bytes")
+	}
+
+	// Trailing payload must not be consumed by the parser
+	data := append(append([]byte{}, encoded...), []byte("payload")...)
+	parsed, n, err := ParseHeader(data)
+	if err != nil {
+		t.Fatalf("ParseHeader failed: %v", err)
+	}
+
+	if n != len(encoded) {
+		t.Errorf("ParseHeader consumed %d bytes, want %d", n, len(encoded))
+	}
+	if parsed.Version != header.Version || parsed.Cipher != header.Cipher || parsed.KDF != header.KDF {
+		t.Errorf("Parsed header = %+v, want %+v", parsed, header)
+	}
+	if parsed.Params != header.Params {
+		t.Errorf("Parsed params = %+v, want %+v", parsed.Params, header.Params)
+	}
+	if !bytes.Equal(parsed.Salt, header.Salt) || !bytes.Equal(parsed.Nonce, header.Nonce) {
+		t.Error("Parsed salt or nonce does not match")
+	}
+	if !bytes.Equal(parsed.MAC, header.MAC) {
+		t.Error("Parsed MAC does not match")
+	}
+}
+
+func TestHeader_Version1HasNoMAC(t *testing.T) {
+	header := &Header{
+		Version: FormatVersion1,
+		Cipher:  CipherAES256GCM,
+		KDF:     KDFPBKDF2SHA256,
+		Params:  KDFParams{Iterations: PBKDF2Iterations},
+		Salt:    make([]byte, SaltSize),
+		Nonce:   make([]byte, NonceSize),
+	}
+
+	encoded, err := header.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	parsed, n, err := ParseHeader(append(encoded, make([]byte, HeaderMACSize)...))
+	if err != nil {
+		t.Fatalf("ParseHeader failed: %v", err)
+	}
+	if n != len(encoded) {
+		t.Errorf("ParseHeader consumed %d bytes, want %d", n, len(encoded))
+	}
+	if parsed.MAC != nil {
+		t.Error("Version 1 header should not carry a MAC")
+	}
+
+	header.Version = FormatVersion2
+	if _, err := header.MarshalBinary(); err == nil {
+		t.Error("Expected MarshalBinary to require a MAC for version 2")
+	}
+}
+
+func TestHeader_Argon2idParams(t *testing.T) {
+	header := &Header{
+		Version: FormatVersion2,
+		Cipher:  CipherAES256GCM,
+		KDF:     KDFArgon2id,
+		Params:  KDFParams{Time: 3, Memory: 64 * 1024, Parallelism: 4},
+		Salt:    make([]byte, SaltSize),
+		Nonce:   make([]byte, StreamNonceSize),
+		MAC:     make([]byte, HeaderMACSize),
+	}
+
+	encoded, err := header.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	parsed, _, err := ParseHeader(encoded)
+	if err != nil {
+		t.Fatalf("ParseHeader failed: %v", err)
+	}
+	if parsed.KDF != KDFArgon2id || parsed.Params != header.Params {
+		t.Errorf("Parsed %s %+v, want %s %+v", parsed.KDF, parsed.Params, KDFArgon2id, header.Params)
+	}
+
+	header.Params.Parallelism = 0
+	if _, err := header.MarshalBinary(); err == nil {
+		t.Error("Expected MarshalBinary to reject zero Argon2id parallelism")
+	}
+}
+
+func TestParseHeader_Errors(t *testing.T) {
+	valid, err := (&Header{
+		Version: FormatVersion2,
+		Cipher:  CipherAES256GCM,
+		KDF:     KDFPBKDF2SHA256,
+		Params:  KDFParams{Iterations: PBKDF2Iterations},
+		Salt:    make([]byte, SaltSize),
+		Nonce:   make([]byte, StreamNonceSize),
+		MAC:     make([]byte, HeaderMACSize),
+	}).MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	withByte := func(offset int, value byte) []byte {
+		out := append([]byte{}, valid...)
+		out[offset] = value
+		return out
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"No magic", []byte("not an archive header")},
+		{"Truncated fixed fields", valid[:len(Magic)+2]},
+		{"Truncated salt", valid[:len(valid)-HeaderMACSize-StreamNonceSize-5]},
+		{"Truncated nonce", valid[:len(valid)-HeaderMACSize-1]},
+		{"Truncated MAC", valid[:len(valid)-1]},
+		{"Future version", withByte(len(Magic), FormatVersion+1)},
+		{"Zero version", withByte(len(Magic), 0)},
+		{"Unknown KDF", withByte(len(Magic)+2, 0xFF)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := ParseHeader(tt.data); err == nil {
+				t.Error("Expected ParseHeader to fail")
+			}
+		})
+	}
+}
+
+func TestHeader_KeySlotsRoundTrip(t *testing.T) {
+	header := &Header{
+		Version: FormatVersion3,
+		Cipher:  CipherAES256GCM,
+		Slots: []KeySlot{
+			{
+				Type:       SlotPassword,
+				KDF:        KDFArgon2id,
+				Params:     KDFParams{Time: 1, Memory: 1024, Parallelism: 1},
+				Salt:       bytes.Repeat([]byte{0x01}, SaltSize),
+				WrappedKey: bytes.Repeat([]byte{0x02}, KeySize+16),
+			},
+			{
+				Type:         SlotX25519,
+				Recipient:    bytes.Repeat([]byte{0x03}, 32),
+				EphemeralKey: bytes.Repeat([]byte{0x04}, 32),
+				WrappedKey:   bytes.Repeat([]byte{0x05}, KeySize+16),
+			},
+			{
+				Type: SlotType(0xEE),
+				body: []byte("from a newer build"),
+			},
+		},
+		Nonce: bytes.Repeat([]byte{0x06}, StreamNonceSize),
+		MAC:   bytes.Repeat([]byte{0x07}, HeaderMACSize),
+	}
+
+	encoded, err := header.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	parsed, n, err := ParseHeader(encoded)
+	if err != nil {
+		t.Fatalf("ParseHeader failed: %v", err)
+	}
+	if n != len(encoded) {
+		t.Errorf("ParseHeader consumed %d bytes, want %d", n, len(encoded))
+	}
+	if len(parsed.Slots) != len(header.Slots) {
+		t.Fatalf("Parsed %d slots, want %d", len(parsed.Slots), len(header.Slots))
+	}
+
+	password := parsed.Slots[0]
+	if password.Type != SlotPassword || password.KDF != KDFArgon2id || password.Params != header.Slots[0].Params ||
+		!bytes.Equal(password.Salt, header.Slots[0].Salt) || !bytes.Equal(password.WrappedKey, header.Slots[0].WrappedKey) {
+		t.Errorf("Parsed password slot = %+v, want %+v", password, header.Slots[0])
+	}
+
+	recipient := parsed.Slots[1]
+	if recipient.Type != SlotX25519 || !bytes.Equal(recipient.Recipient, header.Slots[1].Recipient) ||
+		!bytes.Equal(recipient.EphemeralKey, header.Slots[1].EphemeralKey) || !bytes.Equal(recipient.WrappedKey, header.Slots[1].WrappedKey) {
+		t.Errorf("Parsed X25519 slot = %+v, want %+v", recipient, header.Slots[1])
+	}
+
+	// Unknown slots survive a re-encode unchanged
+	reencoded, err := parsed.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary of parsed header failed: %v", err)
+	}
+	if !bytes.Equal(reencoded, encoded) {
+		t.Error("Re-encoded header differs from the original")
+	}
+
+	header.Slots = nil
+	if _, err := header.MarshalBinary(); err == nil {
+		t.Error("Expected MarshalBinary to reject a header without key slots")
+	}
+}
+
+func TestHeader_Compression(t *testing.T) {
+	header := &Header{
+		Version:     FormatVersion5,
+		Cipher:      CipherXChaCha20Poly1305,
+		Compression: CompressionGzip,
+		Slots: []KeySlot{{
+			Type:         SlotX25519,
+			Recipient:    bytes.Repeat([]byte{0x01}, 32),
+			EphemeralKey: bytes.Repeat([]byte{0x01}, 32),
+			WrappedKey:   bytes.Repeat([]byte{0x02}, KeySize+16),
+		}},
+		Nonce: bytes.Repeat([]byte{0x03}, StreamNonceSize),
+		MAC:   bytes.Repeat([]byte{0x04}, HeaderMACSize),
+	}
+
+	encoded, err := header.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	parsed, n, err := ParseHeader(encoded)
+	if err != nil {
+		t.Fatalf("ParseHeader failed: %v", err)
+	}
+	if n != len(encoded) || parsed.Cipher != header.Cipher || parsed.Compression != CompressionGzip {
+		t.Errorf("Parsed %d of %d bytes, cipher %s, compression %s", n, len(encoded), parsed.Cipher, parsed.Compression)
+	}
+
+	// Version 4 has no compression byte
+	header.Version = FormatVersion4
+	v4, err := header.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	if len(v4) != len(encoded)-1 {
+		t.Errorf("Version 4 header is %d bytes, want %d", len(v4), len(encoded)-1)
+	}
+	if parsed, _, err := ParseHeader(v4); err != nil || parsed.Compression != CompressionNone {
+		t.Errorf("ParseHeader(version 4) = %v, %v; want no compression", parsed, err)
+	}
+}
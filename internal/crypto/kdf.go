@@ -0,0 +1,130 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+
+	"goingenv/pkg/types"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// KDFNamePBKDF2 is the configuration name of PBKDF2-SHA256
+	KDFNamePBKDF2 = "pbkdf2"
+	// KDFNameArgon2id is the configuration name of Argon2id
+	KDFNameArgon2id = "argon2id"
+
+	// Argon2Time is the default number of Argon2id passes
+	Argon2Time = 3
+	// Argon2Memory is the default Argon2id memory cost in KiB (64 MiB)
+	Argon2Memory = 64 * 1024
+	// Argon2Parallelism is the default number of Argon2id lanes
+	Argon2Parallelism = 4
+
+	// Upper bounds accepted from archive headers, so that a crafted archive
+	// cannot make decryption exhaust memory or run for hours. Every unlock
+	// attempt pays this cost, so it must stay within reach of a laptop.
+	maxPBKDF2Iterations = 50_000_000
+	maxArgon2Time       = 16
+	maxArgon2Memory     = 1024 * 1024 // 1 GiB in KiB
+)
+
+// DefaultKDFParams returns the default cost parameters for a KDF
+func DefaultKDFParams(kdf KDFID) KDFParams {
+	switch kdf {
+	case KDFArgon2id:
+		return KDFParams{Time: Argon2Time, Memory: Argon2Memory, Parallelism: Argon2Parallelism}
+	default:
+		return KDFParams{Iterations: PBKDF2Iterations}
+	}
+}
+
+// ParseKDFName maps a configuration name to a KDF identifier.
+// An empty name selects PBKDF2 for configs written before the KDF was configurable.
+func ParseKDFName(name string) (KDFID, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", KDFNamePBKDF2:
+		return KDFPBKDF2SHA256, nil
+	case KDFNameArgon2id:
+		return KDFArgon2id, nil
+	default:
+		return 0, fmt.Errorf("unknown KDF %q (supported: %s, %s)", name, KDFNamePBKDF2, KDFNameArgon2id)
+	}
+}
+
+// KDFFromConfig resolves the KDF and cost parameters selected in the configuration,
+// filling unset parameters with defaults
+func KDFFromConfig(cfg types.KDFConfig) (KDFID, KDFParams, error) {
+	kdf, err := ParseKDFName(cfg.Algorithm)
+	if err != nil {
+		return 0, KDFParams{}, err
+	}
+
+	params := DefaultKDFParams(kdf)
+	switch kdf {
+	case KDFPBKDF2SHA256:
+		if cfg.Iterations != 0 {
+			params.Iterations = cfg.Iterations
+		}
+	case KDFArgon2id:
+		if cfg.Time != 0 {
+			params.Time = cfg.Time
+		}
+		if cfg.Memory != 0 {
+			params.Memory = cfg.Memory
+		}
+		if cfg.Parallelism != 0 {
+			params.Parallelism = cfg.Parallelism
+		}
+	}
+
+	if err := validateKDFParams(kdf, params); err != nil {
+		return 0, KDFParams{}, err
+	}
+
+	return kdf, params, nil
+}
+
+// validateKDFParams checks that cost parameters are usable and within safe bounds
+func validateKDFParams(kdf KDFID, params KDFParams) error {
+	switch kdf {
+	case KDFPBKDF2SHA256:
+		if params.Iterations == 0 || params.Iterations > maxPBKDF2Iterations {
+			return fmt.Errorf("PBKDF2 iterations must be between 1 and %d", maxPBKDF2Iterations)
+		}
+	case KDFArgon2id:
+		if params.Time == 0 || params.Time > maxArgon2Time {
+			return fmt.Errorf("argon2id time must be between 1 and %d", maxArgon2Time)
+		}
+		if params.Parallelism == 0 {
+			return fmt.Errorf("argon2id parallelism must be at least 1")
+		}
+		if params.Memory < 8*uint32(params.Parallelism) || params.Memory > maxArgon2Memory {
+			return fmt.Errorf("argon2id memory must be between %d and %d KiB", 8*uint32(params.Parallelism), maxArgon2Memory)
+		}
+	default:
+		return fmt.Errorf("unsupported KDF: %s", kdf)
+	}
+	return nil
+}
+
+// deriveKey derives a key from a password with the given KDF, parameters and
+// salt. The password bytes are passed to the KDF as they are, without being
+// copied.
+func deriveKey(password []byte, kdf KDFID, params KDFParams, salt []byte) ([]byte, error) {
+	if err := validateKDFParams(kdf, params); err != nil {
+		return nil, err
+	}
+
+	switch kdf {
+	case KDFPBKDF2SHA256:
+		return pbkdf2.Key(password, salt, int(params.Iterations), KeySize, sha256.New), nil
+	case KDFArgon2id:
+		return argon2.IDKey(password, salt, params.Time, params.Memory, params.Parallelism, KeySize), nil
+	default:
+		return nil, fmt.Errorf("unsupported KDF: %s", kdf)
+	}
+}
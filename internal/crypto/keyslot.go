@@ -0,0 +1,513 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"goingenv/pkg/secret"
+	"goingenv/pkg/types"
+)
+
+const (
+	// MaxKeySlots is the maximum number of key slots in an archive header
+	MaxKeySlots = 255
+
+	keyFileContext = "goingenv key file v1"
+)
+
+// errSlotMismatch is returned when a credential does not open a key slot
+var errSlotMismatch = errors.New("key slot does not match")
+
+// SlotType identifies how a key slot wraps the file key
+type SlotType uint8
+
+const (
+	// SlotPassword wraps the file key with a password-derived key
+	SlotPassword SlotType = 1
+	// SlotX25519 wraps the file key for an X25519 recipient
+	SlotX25519 SlotType = 2
+	// SlotSSHEd25519 wraps the file key for an OpenSSH ed25519 key,
+	// converted to X25519
+	SlotSSHEd25519 SlotType = 3
+	// SlotKeyFile wraps the file key with a key derived from a key file
+	SlotKeyFile SlotType = 4
+	// SlotPasswordKeyFile wraps the file key with a key derived from a
+	// password and a key file together
+	SlotPasswordKeyFile SlotType = 5
+	// SlotRecovery wraps the file key with a key derived from a recovery
+	// key that is split into Shamir shares
+	SlotRecovery SlotType = 6
+)
+
+// String returns a human-readable name for the slot type
+func (t SlotType) String() string {
+	switch t {
+	case SlotPassword:
+		return "password"
+	case SlotX25519:
+		return "x25519"
+	case SlotSSHEd25519:
+		return "ssh-ed25519"
+	case SlotKeyFile:
+		return "keyfile"
+	case SlotPasswordKeyFile:
+		return "password+keyfile"
+	case SlotRecovery:
+		return "recovery"
+	default:
+		return fmt.Sprintf("unknown (%d)", uint8(t))
+	}
+}
+
+// KeySlot holds the archive file key wrapped for one password or recipient.
+// Only the fields relevant to the slot type are set.
+type KeySlot struct {
+	Type SlotType
+
+	// Password and key file slots
+	KDF    KDFID
+	Params KDFParams
+	Salt   []byte
+
+	// X25519, SSH and recovery slots
+	Recipient    []byte // recipient public key, SSH key fingerprint or recovery key ID
+	EphemeralKey []byte // sender's ephemeral public key
+
+	// WrappedKey is the file key sealed with the slot's key-encryption key
+	WrappedKey []byte
+
+	// body keeps slots of unknown types intact when a header is rewritten
+	body []byte
+}
+
+// newFileKey generates a random key for a new archive
+func newFileKey() ([]byte, error) {
+	fileKey := make([]byte, KeySize)
+	if _, err := rand.Read(fileKey); err != nil {
+		return nil, fmt.Errorf("failed to generate file key: %w", err)
+	}
+	return fileKey, nil
+}
+
+// newPasswordSlot wraps fileKey with a key derived from password, keyFile
+// (the digest of a key file) or both. The slot type records which of them
+// are needed to open it.
+func newPasswordSlot(fileKey []byte, password *secret.Buffer, keyFile []byte, kdf KDFID, params KDFParams) (KeySlot, error) {
+	slotType := SlotPassword
+	switch {
+	case len(keyFile) > 0 && !password.IsEmpty():
+		slotType = SlotPasswordKeyFile
+	case len(keyFile) > 0:
+		slotType = SlotKeyFile
+	}
+
+	salt := make([]byte, SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return KeySlot{}, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	slotKey := slotSecret(password, keyFile)
+	kek, err := deriveKey(slotKey.Bytes(), kdf, params, salt)
+	slotKey.Destroy()
+	if err != nil {
+		return KeySlot{}, err
+	}
+
+	wrapped, err := wrapKey(kek, fileKey)
+	if err != nil {
+		return KeySlot{}, err
+	}
+
+	return KeySlot{
+		Type:       slotType,
+		KDF:        kdf,
+		Params:     params,
+		Salt:       salt,
+		WrappedKey: wrapped,
+	}, nil
+}
+
+// passwordKEK derives the key-encryption key of a password or key file
+// slot. Each slot only uses the factors its type needs, so a password given
+// together with a key file still opens a plain password slot.
+func (s *KeySlot) passwordKEK(password *secret.Buffer, keyFile []byte) ([]byte, error) {
+	switch s.Type {
+	case SlotPassword:
+		if password.IsEmpty() {
+			return nil, errSlotMismatch
+		}
+		keyFile = nil
+	case SlotKeyFile:
+		if len(keyFile) == 0 {
+			return nil, errSlotMismatch
+		}
+		password = nil
+	case SlotPasswordKeyFile:
+		if password.IsEmpty() || len(keyFile) == 0 {
+			return nil, errSlotMismatch
+		}
+	default:
+		return nil, errSlotMismatch
+	}
+
+	slotKey := slotSecret(password, keyFile)
+	defer slotKey.Destroy()
+	return deriveKey(slotKey.Bytes(), s.KDF, s.Params, s.Salt)
+}
+
+// slotSecret combines a password with the digest of a key file into the
+// secret that is passed to the KDF. Without a key file it is a copy of the
+// password itself, so plain password slots are unaffected. The caller
+// destroys the returned buffer.
+func slotSecret(password *secret.Buffer, keyFile []byte) *secret.Buffer {
+	if len(keyFile) == 0 {
+		return password.Clone()
+	}
+	mac := hmac.New(sha256.New, keyFile)
+	mac.Write([]byte(keyFileContext))
+	mac.Write(password.Bytes())
+	return secret.FromBytes(mac.Sum(nil))
+}
+
+// wrapKey seals fileKey with a key-encryption key. Every key-encryption key
+// is used exactly once, so a fixed nonce is safe.
+func wrapKey(kek, fileKey []byte) ([]byte, error) {
+	aead, err := newAEAD(CipherAES256GCM, kek)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, make([]byte, aead.NonceSize()), fileKey, nil), nil
+}
+
+// unwrapKey opens a file key sealed by wrapKey
+func unwrapKey(kek, wrapped []byte) ([]byte, error) {
+	aead, err := newAEAD(CipherAES256GCM, kek)
+	if err != nil {
+		return nil, err
+	}
+	fileKey, err := aead.Open(nil, make([]byte, aead.NonceSize()), wrapped, nil)
+	if err != nil || len(fileKey) != KeySize {
+		return nil, errSlotMismatch
+	}
+	return fileKey, nil
+}
+
+// marshalBody encodes the type-specific part of the slot.
+//
+// Password and key file slots: kdf (1) | params length (2) | params | salt length (1) | salt |
+// wrapped length (1) | wrapped key.
+// X25519 and SSH slots: recipient length (1) | recipient | ephemeral length (1) |
+// ephemeral key | wrapped length (1) | wrapped key.
+// Recovery slots: key ID length (1) | key ID | wrapped length (1) | wrapped key.
+func (s *KeySlot) marshalBody() ([]byte, error) {
+	var buf bytes.Buffer
+
+	switch s.Type {
+	case SlotPassword, SlotKeyFile, SlotPasswordKeyFile:
+		params, err := marshalKDFParams(s.KDF, s.Params)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteByte(byte(s.KDF))
+		_ = binary.Write(&buf, binary.BigEndian, uint16(len(params)))
+		buf.Write(params)
+		if err := writeLengthPrefixed(&buf, s.Salt); err != nil {
+			return nil, err
+		}
+	case SlotX25519, SlotSSHEd25519:
+		if err := writeLengthPrefixed(&buf, s.Recipient); err != nil {
+			return nil, err
+		}
+		if err := writeLengthPrefixed(&buf, s.EphemeralKey); err != nil {
+			return nil, err
+		}
+	case SlotRecovery:
+		if err := writeLengthPrefixed(&buf, s.Recipient); err != nil {
+			return nil, err
+		}
+	default:
+		if s.body == nil {
+			return nil, fmt.Errorf("unsupported key slot type: %s", s.Type)
+		}
+		return s.body, nil
+	}
+
+	if err := writeLengthPrefixed(&buf, s.WrappedKey); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// unmarshalKeySlot decodes a slot body. Slots of unknown types are kept so
+// that newer archives can still be opened through a slot this build knows.
+func unmarshalKeySlot(slotType SlotType, body []byte) (KeySlot, error) {
+	slot := KeySlot{Type: slotType}
+	r := bytes.NewReader(body)
+
+	switch slotType {
+	case SlotPassword, SlotKeyFile, SlotPasswordKeyFile:
+		kdf, params, salt, err := readPasswordFields(r)
+		if err != nil {
+			return KeySlot{}, err
+		}
+		slot.KDF, slot.Params, slot.Salt = kdf, params, salt
+	case SlotX25519, SlotSSHEd25519:
+		var err error
+		if slot.Recipient, err = readLengthPrefixed(r); err != nil {
+			return KeySlot{}, fmt.Errorf("truncated recipient")
+		}
+		if slot.EphemeralKey, err = readLengthPrefixed(r); err != nil {
+			return KeySlot{}, fmt.Errorf("truncated ephemeral key")
+		}
+	case SlotRecovery:
+		var err error
+		if slot.Recipient, err = readLengthPrefixed(r); err != nil {
+			return KeySlot{}, fmt.Errorf("truncated recovery key ID")
+		}
+	default:
+		slot.body = body
+		return slot, nil
+	}
+
+	var err error
+	if slot.WrappedKey, err = readLengthPrefixed(r); err != nil {
+		return KeySlot{}, fmt.Errorf("truncated wrapped key")
+	}
+	if r.Len() != 0 {
+		return KeySlot{}, fmt.Errorf("unexpected data after %s key slot", slotType)
+	}
+
+	return slot, nil
+}
+
+// writeLengthPrefixed writes a single-byte length followed by data
+func writeLengthPrefixed(buf *bytes.Buffer, data []byte) error {
+	if len(data) > 255 {
+		return fmt.Errorf("field must be at most 255 bytes")
+	}
+	buf.WriteByte(byte(len(data)))
+	buf.Write(data)
+	return nil
+}
+
+// ReadHeader reads the encoded archive header from r, leaving r positioned at
+// the start of the payload. Only archives with key slots are supported.
+func (s *Service) ReadHeader(r io.Reader) ([]byte, error) {
+	var raw bytes.Buffer
+	header, err := ReadHeader(io.TeeReader(r, &raw))
+	if err != nil {
+		return nil, &types.CryptoError{
+			Operation: "read header",
+			Err:       fmt.Errorf("invalid archive header: %w", err),
+		}
+	}
+	if header.Version < FormatVersion3 {
+		return nil, &types.CryptoError{
+			Operation: "read header",
+			Err:       fmt.Errorf("archive format version %d has no key slots; re-pack it to manage keys", header.Version),
+		}
+	}
+	return raw.Bytes(), nil
+}
+
+// ListKeySlots describes the key slots of an encoded header. Slots are public
+// header data, so no key is needed to list them.
+func (s *Service) ListKeySlots(headerBytes []byte) ([]types.KeySlotInfo, error) {
+	header, err := parseSlotHeader(headerBytes, "list key slots")
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]types.KeySlotInfo, len(header.Slots))
+	for i, slot := range header.Slots {
+		infos[i] = types.KeySlotInfo{
+			Index: i,
+			Type:  slot.Type.String(),
+		}
+		switch slot.Type {
+		case SlotPassword, SlotKeyFile, SlotPasswordKeyFile:
+			infos[i].KDF = fmt.Sprintf("%s (%s)", slot.KDF, slot.Params.String(slot.KDF))
+		case SlotX25519:
+			infos[i].Recipient = RecipientPrefix + base64.StdEncoding.EncodeToString(slot.Recipient)
+		case SlotSSHEd25519:
+			infos[i].Recipient = "SHA256:" + base64.RawStdEncoding.EncodeToString(slot.Recipient)
+		case SlotRecovery:
+			infos[i].Recipient = hex.EncodeToString(slot.Recipient)
+		}
+	}
+	return infos, nil
+}
+
+// AddKeySlot unlocks an encoded header with the unlock keys and returns it
+// with a new slot for the password and key file, the recovery key and every
+// recipient in add. The payload key does not change, so the payload that follows the
+// header stays valid.
+func (s *Service) AddKeySlot(headerBytes []byte, unlock types.DecryptOptions, add types.EncryptOptions) ([]byte, error) {
+	if add.Password.IsEmpty() && len(add.KeyFile) == 0 && len(add.RecoveryKey) == 0 && len(add.Recipients) == 0 {
+		return nil, &types.CryptoError{
+			Operation: "add key slot",
+			Err:       fmt.Errorf("password cannot be empty"),
+		}
+	}
+
+	header, fileKey, err := s.openSlotHeader(headerBytes, unlock, "add key slot")
+	if err != nil {
+		return nil, err
+	}
+
+	recipients, err := ParseRecipients(add.Recipients)
+	if err != nil {
+		return nil, &types.CryptoError{
+			Operation: "add key slot",
+			Err:       err,
+		}
+	}
+
+	if !add.Password.IsEmpty() || len(add.KeyFile) > 0 {
+		kdf, params, err := s.kdfSettings()
+		if err != nil {
+			return nil, &types.CryptoError{
+				Operation: "add key slot",
+				Err:       fmt.Errorf("invalid KDF configuration: %w", err),
+			}
+		}
+
+		slot, err := newPasswordSlot(fileKey, add.Password, add.KeyFile, kdf, params)
+		if err != nil {
+			return nil, &types.CryptoError{
+				Operation: "add key slot",
+				Err:       err,
+			}
+		}
+		header.Slots = append(header.Slots, slot)
+	}
+
+	if len(add.RecoveryKey) > 0 {
+		slot, err := newRecoverySlot(fileKey, add.RecoveryKey)
+		if err != nil {
+			return nil, &types.CryptoError{
+				Operation: "add key slot",
+				Err:       err,
+			}
+		}
+		header.Slots = append(header.Slots, slot)
+	}
+
+	for _, recipient := range recipients {
+		slot, err := recipient.wrap(fileKey)
+		if err != nil {
+			return nil, &types.CryptoError{
+				Operation: "add key slot",
+				Err:       err,
+			}
+		}
+		header.Slots = append(header.Slots, slot)
+	}
+
+	if len(header.Slots) > MaxKeySlots {
+		return nil, &types.CryptoError{
+			Operation: "add key slot",
+			Err:       fmt.Errorf("archive already has the maximum of %d key slots", MaxKeySlots),
+		}
+	}
+
+	return s.resealSlotHeader(header, fileKey, "add key slot")
+}
+
+// RemoveKeySlot unlocks an encoded header with the unlock keys and returns
+// it without the slot at index. The last remaining slot cannot be removed.
+func (s *Service) RemoveKeySlot(headerBytes []byte, unlock types.DecryptOptions, index int) ([]byte, error) {
+	header, fileKey, err := s.openSlotHeader(headerBytes, unlock, "remove key slot")
+	if err != nil {
+		return nil, err
+	}
+
+	if index < 0 || index >= len(header.Slots) {
+		return nil, &types.CryptoError{
+			Operation: "remove key slot",
+			Err:       fmt.Errorf("key slot %d does not exist (archive has %d)", index, len(header.Slots)),
+		}
+	}
+	if len(header.Slots) == 1 {
+		return nil, &types.CryptoError{
+			Operation: "remove key slot",
+			Err:       fmt.Errorf("cannot remove the last key slot; the archive would become unreadable"),
+		}
+	}
+
+	header.Slots = append(header.Slots[:index], header.Slots[index+1:]...)
+
+	return s.resealSlotHeader(header, fileKey, "remove key slot")
+}
+
+// parseSlotHeader decodes an encoded header that must contain key slots
+func parseSlotHeader(headerBytes []byte, operation string) (*Header, error) {
+	header, n, err := ParseHeader(headerBytes)
+	if err == nil && n != len(headerBytes) {
+		err = fmt.Errorf("unexpected data after header")
+	}
+	if err != nil {
+		return nil, &types.CryptoError{
+			Operation: operation,
+			Err:       fmt.Errorf("invalid archive header: %w", err),
+		}
+	}
+	if header.Version < FormatVersion3 {
+		return nil, &types.CryptoError{
+			Operation: operation,
+			Err:       fmt.Errorf("archive format version %d has no key slots; re-pack it to manage keys", header.Version),
+		}
+	}
+	return header, nil
+}
+
+// openSlotHeader decodes an encoded header and returns it with the file key,
+// after checking that the header was sealed with that key
+func (s *Service) openSlotHeader(headerBytes []byte, unlock types.DecryptOptions, operation string) (*Header, []byte, error) {
+	if unlock.Password.IsEmpty() && len(unlock.KeyFile) == 0 && len(unlock.RecoveryKey) == 0 && len(unlock.Identities) == 0 && s.cache == nil {
+		return nil, nil, &types.CryptoError{
+			Operation: operation,
+			Err:       fmt.Errorf("password cannot be empty"),
+		}
+	}
+
+	header, err := parseSlotHeader(headerBytes, operation)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fileKey, err := s.unlock(header, unlock)
+	if err == nil {
+		err = verifyHeader(header, fileKey)
+	}
+	if err != nil {
+		return nil, nil, &types.CryptoError{
+			Operation: operation,
+			Err:       err,
+		}
+	}
+
+	return header, fileKey, nil
+}
+
+// resealSlotHeader re-encodes a header after its slots changed. The stream
+// nonce is kept, so the payload key and therefore the payload are unchanged.
+func (s *Service) resealSlotHeader(header *Header, fileKey []byte, operation string) ([]byte, error) {
+	headerBytes, err := sealHeader(header, fileKey)
+	if err != nil {
+		return nil, &types.CryptoError{
+			Operation: operation,
+			Err:       err,
+		}
+	}
+	return headerBytes, nil
+}
@@ -0,0 +1,321 @@
+package crypto
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"goingenv/pkg/secret"
+	"goingenv/pkg/types"
+)
+
+// splitArchive separates an encrypted archive into its header and payload
+func splitArchive(t *testing.T, service *Service, encrypted []byte) ([]byte, []byte) {
+	t.Helper()
+	r := bytes.NewReader(encrypted)
+	header, err := service.ReadHeader(r)
+	if err != nil {
+		t.Fatalf("ReadHeader failed: %v", err)
+	}
+	return header, encrypted[len(encrypted)-r.Len():]
+}
+
+func TestService_AddKeySlot(t *testing.T) {
+	service := fastService()
+	data := []byte("API_KEY=shared-secret")
+	encrypted := encryptStream(t, service, data, "first password")
+	header, payload := splitArchive(t, service, encrypted)
+
+	newHeader, err := service.AddKeySlot(header,
+		types.DecryptOptions{Password: secret.FromString("first password")},
+		types.EncryptOptions{Password: secret.FromString("second password")})
+	if err != nil {
+		t.Fatalf("AddKeySlot failed: %v", err)
+	}
+	updated := append(append([]byte{}, newHeader...), payload...)
+
+	for _, password := range []string{"first password", "second password"} {
+		decrypted, err := decryptStream(service, updated, password)
+		if err != nil {
+			t.Fatalf("Decrypt with %q failed: %v", password, err)
+		}
+		if !bytes.Equal(data, decrypted) {
+			t.Errorf("Decrypt with %q returned wrong data", password)
+		}
+	}
+
+	slots, err := service.ListKeySlots(newHeader)
+	if err != nil {
+		t.Fatalf("ListKeySlots failed: %v", err)
+	}
+	if len(slots) != 2 || slots[1].Type != "password" || slots[1].KDF == "" {
+		t.Errorf("Unexpected key slots after add: %+v", slots)
+	}
+
+	if _, err := service.AddKeySlot(header,
+		types.DecryptOptions{Password: secret.FromString("wrong password")},
+		types.EncryptOptions{Password: secret.FromString("attacker password")}); err == nil {
+		t.Error("Expected AddKeySlot with a wrong password to fail")
+	}
+}
+
+func TestService_AddRecipientKeySlot(t *testing.T) {
+	service := fastService()
+	data := []byte("API_KEY=shared-secret")
+	encrypted := encryptStream(t, service, data, "password")
+	header, payload := splitArchive(t, service, encrypted)
+
+	identity := newTestIdentity(t)
+	newHeader, err := service.AddKeySlot(header,
+		types.DecryptOptions{Password: secret.FromString("password")},
+		types.EncryptOptions{Recipients: []string{identity.Recipient().String()}})
+	if err != nil {
+		t.Fatalf("AddKeySlot failed: %v", err)
+	}
+
+	slots, err := service.ListKeySlots(newHeader)
+	if err != nil {
+		t.Fatalf("ListKeySlots failed: %v", err)
+	}
+	if len(slots) != 2 || slots[1].Recipient != identity.Recipient().String() {
+		t.Errorf("Unexpected key slots after add: %+v", slots)
+	}
+
+	r, err := service.DecryptStream(bytes.NewReader(append(newHeader, payload...)),
+		types.DecryptOptions{Identities: []string{writeIdentity(t, identity)}})
+	if err != nil {
+		t.Fatalf("DecryptStream with the new identity failed: %v", err)
+	}
+	var decrypted bytes.Buffer
+	if _, err := decrypted.ReadFrom(r); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if !bytes.Equal(data, decrypted.Bytes()) {
+		t.Error("New recipient decrypted wrong data")
+	}
+}
+
+func TestService_RemoveKeySlot(t *testing.T) {
+	service := fastService()
+	data := []byte("API_KEY=shared-secret")
+	encrypted := encryptStream(t, service, data, "first password")
+	header, payload := splitArchive(t, service, encrypted)
+
+	header, err := service.AddKeySlot(header,
+		types.DecryptOptions{Password: secret.FromString("first password")},
+		types.EncryptOptions{Password: secret.FromString("second password")})
+	if err != nil {
+		t.Fatalf("AddKeySlot failed: %v", err)
+	}
+
+	// Any remaining password may remove any slot, including its own
+	removed, err := service.RemoveKeySlot(header, types.DecryptOptions{Password: secret.FromString("second password")}, 0)
+	if err != nil {
+		t.Fatalf("RemoveKeySlot failed: %v", err)
+	}
+	updated := append(append([]byte{}, removed...), payload...)
+
+	if _, err := decryptStream(service, updated, "first password"); err == nil {
+		t.Error("Expected the removed password to no longer open the archive")
+	}
+	decrypted, err := decryptStream(service, updated, "second password")
+	if err != nil {
+		t.Fatalf("Decrypt with remaining password failed: %v", err)
+	}
+	if !bytes.Equal(data, decrypted) {
+		t.Error("Decrypted wrong data after removing a slot")
+	}
+
+	tests := []struct {
+		name     string
+		header   []byte
+		password string
+		index    int
+	}{
+		{"Last slot", removed, "second password", 0},
+		{"Index out of range", header, "first password", 2},
+		{"Negative index", header, "first password", -1},
+		{"Wrong password", header, "wrong password", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := service.RemoveKeySlot(tt.header, types.DecryptOptions{Password: secret.FromString(tt.password)}, tt.index); err == nil {
+				t.Error("Expected RemoveKeySlot to fail")
+			}
+		})
+	}
+}
+
+func TestService_KeySlotsRejectTamperedHeader(t *testing.T) {
+	service := fastService()
+	encrypted := encryptStream(t, service, []byte("KEY=value"), "password")
+	header, _ := splitArchive(t, service, encrypted)
+
+	tampered := append([]byte{}, header...)
+	tampered[len(tampered)-1] ^= 0x01
+
+	if _, err := service.AddKeySlot(tampered,
+		types.DecryptOptions{Password: secret.FromString("password")},
+		types.EncryptOptions{Password: secret.FromString("new password")}); err == nil {
+		t.Error("Expected AddKeySlot to reject a header with a bad MAC")
+	}
+}
+
+func TestService_ReadHeaderRequiresKeySlots(t *testing.T) {
+	service := fastService()
+
+	// Headerless archive from before the header was introduced
+	legacy := make([]byte, SaltSize+NonceSize+32)
+	if _, err := service.ReadHeader(bytes.NewReader(legacy)); err == nil {
+		t.Error("Expected ReadHeader to reject a headerless archive")
+	}
+
+	// Version 2 archive without key slots
+	v2 := &Header{
+		Version: FormatVersion2,
+		Cipher:  CipherAES256GCM,
+		KDF:     KDFPBKDF2SHA256,
+		Params:  KDFParams{Iterations: 1000},
+		Salt:    make([]byte, SaltSize),
+		Nonce:   make([]byte, StreamNonceSize),
+		MAC:     make([]byte, HeaderMACSize),
+	}
+	encoded, err := v2.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	if _, err := service.ReadHeader(bytes.NewReader(encoded)); err == nil {
+		t.Error("Expected ReadHeader to reject a version 2 archive")
+	}
+	if _, err := service.ListKeySlots(encoded); err == nil {
+		t.Error("Expected ListKeySlots to reject a version 2 header")
+	}
+}
+
+func TestService_KeyFileSlots(t *testing.T) {
+	service := fastService()
+	data := []byte("DEPLOY_TOKEN=ci-only")
+	keyFile := bytes.Repeat([]byte{0x42}, 32)
+	otherKeyFile := bytes.Repeat([]byte{0x43}, 32)
+
+	encrypt := func(opts types.EncryptOptions) []byte {
+		var buf bytes.Buffer
+		w, err := service.EncryptStream(&buf, opts)
+		if err != nil {
+			t.Fatalf("EncryptStream failed: %v", err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+		return buf.Bytes()
+	}
+	decrypt := func(encrypted []byte, opts types.DecryptOptions) error {
+		r, err := service.DecryptStream(bytes.NewReader(encrypted), opts)
+		if err != nil {
+			return err
+		}
+		decrypted, err := io.ReadAll(r)
+		if err == nil && !bytes.Equal(data, decrypted) {
+			t.Error("Decrypted data doesn't match original")
+		}
+		return err
+	}
+
+	tests := []struct {
+		name     string
+		encrypt  types.EncryptOptions
+		slotType string
+		opens    []types.DecryptOptions
+		rejects  []types.DecryptOptions
+	}{
+		{
+			name:     "Key file only",
+			encrypt:  types.EncryptOptions{KeyFile: keyFile},
+			slotType: "keyfile",
+			opens: []types.DecryptOptions{
+				{KeyFile: keyFile},
+				{KeyFile: keyFile, Password: secret.FromString("ignored")},
+			},
+			rejects: []types.DecryptOptions{
+				{KeyFile: otherKeyFile},
+				{Password: secret.FromString("password")},
+			},
+		},
+		{
+			name:     "Password and key file",
+			encrypt:  types.EncryptOptions{Password: secret.FromString("password"), KeyFile: keyFile},
+			slotType: "password+keyfile",
+			opens: []types.DecryptOptions{
+				{Password: secret.FromString("password"), KeyFile: keyFile},
+			},
+			rejects: []types.DecryptOptions{
+				{Password: secret.FromString("password")},
+				{KeyFile: keyFile},
+				{Password: secret.FromString("password"), KeyFile: otherKeyFile},
+				{Password: secret.FromString("wrong"), KeyFile: keyFile},
+			},
+		},
+		{
+			name:     "Password slot ignores key file",
+			encrypt:  types.EncryptOptions{Password: secret.FromString("password")},
+			slotType: "password",
+			opens: []types.DecryptOptions{
+				{Password: secret.FromString("password")},
+				{Password: secret.FromString("password"), KeyFile: keyFile},
+			},
+			rejects: []types.DecryptOptions{
+				{KeyFile: keyFile},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encrypted := encrypt(tt.encrypt)
+
+			header, _ := splitArchive(t, service, encrypted)
+			slots, err := service.ListKeySlots(header)
+			if err != nil {
+				t.Fatalf("ListKeySlots failed: %v", err)
+			}
+			if len(slots) != 1 || slots[0].Type != tt.slotType || slots[0].KDF == "" {
+				t.Errorf("Unexpected key slots %+v, want one %s slot", slots, tt.slotType)
+			}
+
+			for _, opts := range tt.opens {
+				if err := decrypt(encrypted, opts); err != nil {
+					t.Errorf("Decrypt with password %q (key file: %v) failed: %v", opts.Password, len(opts.KeyFile) > 0, err)
+				}
+			}
+			for _, opts := range tt.rejects {
+				if err := decrypt(encrypted, opts); err == nil {
+					t.Errorf("Expected decrypt with password %q (key file: %v) to fail", opts.Password, len(opts.KeyFile) > 0)
+				}
+			}
+		})
+	}
+
+	t.Run("Add key file slot", func(t *testing.T) {
+		encrypted := encryptStream(t, service, data, "password")
+		header, payload := splitArchive(t, service, encrypted)
+
+		newHeader, err := service.AddKeySlot(header,
+			types.DecryptOptions{Password: secret.FromString("password")},
+			types.EncryptOptions{KeyFile: keyFile})
+		if err != nil {
+			t.Fatalf("AddKeySlot failed: %v", err)
+		}
+		updated := append(append([]byte{}, newHeader...), payload...)
+
+		if err := decrypt(updated, types.DecryptOptions{KeyFile: keyFile}); err != nil {
+			t.Errorf("Decrypt with the added key file failed: %v", err)
+		}
+		if err := decrypt(updated, types.DecryptOptions{Password: secret.FromString("password")}); err != nil {
+			t.Errorf("Decrypt with the original password failed: %v", err)
+		}
+	})
+}
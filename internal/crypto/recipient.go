@@ -0,0 +1,232 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+
+	"goingenv/pkg/types"
+)
+
+const (
+	// RecipientPrefix starts the text form of an X25519 public key
+	RecipientPrefix = "x25519:"
+	// IdentityPrefix starts the text form of an X25519 private key
+	IdentityPrefix = "x25519-secret:"
+
+	x25519KeyInfo = "goingenv x25519"
+)
+
+// Recipient is a public key an archive can be encrypted to
+type Recipient interface {
+	// String returns the text form used in the recipients file
+	String() string
+	// wrap seals the file key in a new key slot for this recipient
+	wrap(fileKey []byte) (KeySlot, error)
+}
+
+// Identity is a private key that can open the key slot of a recipient
+type Identity interface {
+	// unwrap returns the file key, or errSlotMismatch if the slot is not
+	// addressed to this identity
+	unwrap(slot *KeySlot) ([]byte, error)
+}
+
+// X25519Recipient is an X25519 public key
+type X25519Recipient struct {
+	key *ecdh.PublicKey
+}
+
+// X25519Identity is an X25519 private key
+type X25519Identity struct {
+	key *ecdh.PrivateKey
+}
+
+// GenerateX25519Identity creates a new random X25519 identity
+func GenerateX25519Identity() (*X25519Identity, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate X25519 key: %w", err)
+	}
+	return &X25519Identity{key: key}, nil
+}
+
+// Recipient returns the public key of the identity
+func (i *X25519Identity) Recipient() *X25519Recipient {
+	return &X25519Recipient{key: i.key.PublicKey()}
+}
+
+// String returns the text form stored in identity files
+func (i *X25519Identity) String() string {
+	return IdentityPrefix + base64.StdEncoding.EncodeToString(i.key.Bytes())
+}
+
+// String returns the text form stored in the recipients file
+func (r *X25519Recipient) String() string {
+	return RecipientPrefix + base64.StdEncoding.EncodeToString(r.key.Bytes())
+}
+
+func (r *X25519Recipient) wrap(fileKey []byte) (KeySlot, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return KeySlot{}, fmt.Errorf("failed to generate ephemeral key: %w", err)
+	}
+
+	kek, err := x25519KEK(ephemeral, r.key, ephemeral.PublicKey().Bytes(), r.key.Bytes(), x25519KeyInfo)
+	if err != nil {
+		return KeySlot{}, err
+	}
+
+	wrapped, err := wrapKey(kek, fileKey)
+	if err != nil {
+		return KeySlot{}, err
+	}
+
+	return KeySlot{
+		Type:         SlotX25519,
+		Recipient:    r.key.Bytes(),
+		EphemeralKey: ephemeral.PublicKey().Bytes(),
+		WrappedKey:   wrapped,
+	}, nil
+}
+
+func (i *X25519Identity) unwrap(slot *KeySlot) ([]byte, error) {
+	if slot.Type != SlotX25519 || !bytes.Equal(slot.Recipient, i.key.PublicKey().Bytes()) {
+		return nil, errSlotMismatch
+	}
+
+	ephemeral, err := ecdh.X25519().NewPublicKey(slot.EphemeralKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ephemeral key: %w", err)
+	}
+
+	kek, err := x25519KEK(i.key, ephemeral, slot.EphemeralKey, slot.Recipient, x25519KeyInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	return unwrapKey(kek, slot.WrappedKey)
+}
+
+// x25519KEK derives the key-encryption key of an X25519 slot from the shared
+// secret between priv and peer, bound to both public keys of the exchange and
+// to the slot type through info
+func x25519KEK(priv *ecdh.PrivateKey, peer *ecdh.PublicKey, ephemeral, recipient []byte, info string) ([]byte, error) {
+	shared, err := priv.ECDH(peer)
+	if err != nil {
+		return nil, fmt.Errorf("X25519 key agreement failed: %w", err)
+	}
+
+	salt := append(append([]byte{}, ephemeral...), recipient...)
+
+	kek := make([]byte, KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(info)), kek); err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	return kek, nil
+}
+
+// ParseRecipient parses the text form of a recipient public key
+func ParseRecipient(s string) (Recipient, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(s, RecipientPrefix):
+		data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, RecipientPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("invalid X25519 recipient %q: %w", s, err)
+		}
+		key, err := ecdh.X25519().NewPublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid X25519 recipient %q: %w", s, err)
+		}
+		return &X25519Recipient{key: key}, nil
+	case strings.HasPrefix(s, "ssh-"):
+		return parseSSHRecipient(s)
+	default:
+		return nil, fmt.Errorf("unknown recipient type %q (expected %s... or %s...)", s, RecipientPrefix, SSHEd25519Prefix)
+	}
+}
+
+// ParseRecipients parses a list of recipient public keys
+func ParseRecipients(lines []string) ([]Recipient, error) {
+	recipients := make([]Recipient, 0, len(lines))
+	for _, line := range lines {
+		recipient, err := ParseRecipient(line)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, recipient)
+	}
+	return recipients, nil
+}
+
+// ParseIdentities parses the identities in the contents of an identity file.
+// Blank lines and lines starting with # are ignored.
+func ParseIdentities(data []byte) ([]Identity, error) {
+	var identities []Identity
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.HasPrefix(line, IdentityPrefix) {
+			return nil, fmt.Errorf("unknown identity type (expected %s...)", IdentityPrefix)
+		}
+		keyBytes, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(line, IdentityPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("invalid X25519 identity: %w", err)
+		}
+		key, err := ecdh.X25519().NewPrivateKey(keyBytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid X25519 identity: %w", err)
+		}
+		identities = append(identities, &X25519Identity{key: key})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(identities) == 0 {
+		return nil, fmt.Errorf("no identities found")
+	}
+
+	return identities, nil
+}
+
+// LoadIdentities reads and parses identity files. A file may hold goingenv
+// identities or an OpenSSH ed25519 private key; passphrase is asked for the
+// passphrase of encrypted SSH keys and may be nil.
+func LoadIdentities(paths []string, passphrase types.PassphraseFunc) ([]Identity, error) {
+	var identities []Identity
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read identity file: %w", err)
+		}
+		if isSSHPrivateKey(data) {
+			identity, err := parseSSHIdentity(data, path, passphrase)
+			if err != nil {
+				return nil, fmt.Errorf("identity file %s: %w", path, err)
+			}
+			identities = append(identities, identity)
+			continue
+		}
+		parsed, err := ParseIdentities(data)
+		if err != nil {
+			return nil, fmt.Errorf("identity file %s: %w", path, err)
+		}
+		identities = append(identities, parsed...)
+	}
+	return identities, nil
+}
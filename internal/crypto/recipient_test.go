@@ -0,0 +1,187 @@
+package crypto
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"goingenv/pkg/secret"
+	"goingenv/pkg/types"
+)
+
+// writeIdentity stores an identity in a temporary identity file
+func writeIdentity(t *testing.T, identity *X25519Identity) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "identity.txt")
+	content := "# public key: " + identity.Recipient().String() + "\n" + identity.String() + "\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write identity: %v", err)
+	}
+	return path
+}
+
+func newTestIdentity(t *testing.T) *X25519Identity {
+	t.Helper()
+	identity, err := GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("GenerateX25519Identity failed: %v", err)
+	}
+	return identity
+}
+
+func TestService_EncryptToRecipients(t *testing.T) {
+	service := fastService()
+	data := []byte("DATABASE_URL=postgres://team@localhost/app")
+
+	alice := newTestIdentity(t)
+	bob := newTestIdentity(t)
+	mallory := newTestIdentity(t)
+
+	var buf bytes.Buffer
+	w, err := service.EncryptStream(&buf, types.EncryptOptions{
+		Recipients: []string{alice.Recipient().String(), bob.Recipient().String()},
+	})
+	if err != nil {
+		t.Fatalf("EncryptStream failed: %v", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	encrypted := buf.Bytes()
+
+	header, _, err := ParseHeader(encrypted)
+	if err != nil {
+		t.Fatalf("ParseHeader failed: %v", err)
+	}
+	if len(header.Slots) != 2 {
+		t.Fatalf("Got %d key slots, want one per recipient", len(header.Slots))
+	}
+
+	decrypt := func(opts types.DecryptOptions) ([]byte, error) {
+		r, err := service.DecryptStream(bytes.NewReader(encrypted), opts)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	}
+
+	for name, identity := range map[string]*X25519Identity{"alice": alice, "bob": bob} {
+		decrypted, err := decrypt(types.DecryptOptions{Identities: []string{writeIdentity(t, identity)}})
+		if err != nil {
+			t.Fatalf("%s could not decrypt: %v", name, err)
+		}
+		if !bytes.Equal(data, decrypted) {
+			t.Errorf("%s decrypted wrong data", name)
+		}
+	}
+
+	if _, err := decrypt(types.DecryptOptions{Identities: []string{writeIdentity(t, mallory)}}); err == nil {
+		t.Error("Expected decryption with a non-recipient identity to fail")
+	}
+	if _, err := decrypt(types.DecryptOptions{Password: secret.FromString("guess")}); err == nil {
+		t.Error("Expected password decryption of a recipient-only archive to fail")
+	}
+}
+
+func TestService_EncryptToPasswordAndRecipients(t *testing.T) {
+	service := fastService()
+	data := []byte("API_KEY=shared")
+	identity := newTestIdentity(t)
+
+	var buf bytes.Buffer
+	w, err := service.EncryptStream(&buf, types.EncryptOptions{
+		Password:   secret.FromString("team password"),
+		Recipients: []string{identity.Recipient().String()},
+	})
+	if err != nil {
+		t.Fatalf("EncryptStream failed: %v", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	decrypted, err := service.Decrypt(buf.Bytes(), secret.FromString("team password"))
+	if err != nil || !bytes.Equal(data, decrypted) {
+		t.Fatalf("Password decryption failed: %v", err)
+	}
+
+	r, err := service.DecryptStream(bytes.NewReader(buf.Bytes()), types.DecryptOptions{
+		Identities: []string{writeIdentity(t, identity)},
+	})
+	if err != nil {
+		t.Fatalf("Identity decryption failed: %v", err)
+	}
+	if decrypted, err := io.ReadAll(r); err != nil || !bytes.Equal(data, decrypted) {
+		t.Errorf("Identity decryption returned %q, %v", decrypted, err)
+	}
+}
+
+func TestService_IdentityCannotOpenPasswordOnlyArchive(t *testing.T) {
+	service := fastService()
+	encrypted := mustEncrypt([]byte("KEY=value"), "password")
+
+	_, err := service.DecryptStream(bytes.NewReader(encrypted), types.DecryptOptions{
+		Identities: []string{writeIdentity(t, newTestIdentity(t))},
+	})
+	if err == nil {
+		t.Fatal("Expected identity decryption of a password-only archive to fail")
+	}
+}
+
+func TestParseRecipient(t *testing.T) {
+	identity := newTestIdentity(t)
+	encoded := identity.Recipient().String()
+
+	recipient, err := ParseRecipient("  " + encoded + "\n")
+	if err != nil {
+		t.Fatalf("ParseRecipient failed: %v", err)
+	}
+	if recipient.String() != encoded {
+		t.Errorf("Round trip = %q, want %q", recipient.String(), encoded)
+	}
+
+	for _, invalid := range []string{
+		"",
+		"age1qyqszqgpqyqszqgpqyqszqgpqyqszqgpqyqszqgpqyqszqgpqyqs3290gq",
+		RecipientPrefix + "not base64!",
+		RecipientPrefix + "AAAA",
+	} {
+		if _, err := ParseRecipient(invalid); err == nil {
+			t.Errorf("Expected ParseRecipient(%q) to fail", invalid)
+		}
+	}
+}
+
+func TestParseIdentities(t *testing.T) {
+	first := newTestIdentity(t)
+	second := newTestIdentity(t)
+	data := strings.Join([]string{
+		"# created by goingenv keygen",
+		first.String(),
+		"",
+		second.String(),
+	}, "\n")
+
+	identities, err := ParseIdentities([]byte(data))
+	if err != nil {
+		t.Fatalf("ParseIdentities failed: %v", err)
+	}
+	if len(identities) != 2 {
+		t.Errorf("Parsed %d identities, want 2", len(identities))
+	}
+
+	for _, invalid := range []string{"", "# only a comment", "AGE-SECRET-KEY-1XYZ", IdentityPrefix + "AAAA"} {
+		if _, err := ParseIdentities([]byte(invalid)); err == nil {
+			t.Errorf("Expected ParseIdentities(%q) to fail", invalid)
+		}
+	}
+}
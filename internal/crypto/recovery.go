@@ -0,0 +1,228 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+
+	"goingenv/pkg/shamir"
+)
+
+const (
+	// RecoveryKeySize is the size of a recovery key in bytes
+	RecoveryKeySize = 32
+	// RecoveryKeyIDSize is the size of the recovery key ID stored in recovery
+	// slots and shares
+	RecoveryKeyIDSize = 8
+	// RecoverySharePrefix starts the text form of a recovery share
+	RecoverySharePrefix = "goingenv-share-"
+
+	recoveryKeyInfo      = "goingenv recovery"
+	recoveryIDInfo       = "goingenv recovery id"
+	recoveryShareVersion = 1
+	recoveryShareGroup   = 4
+	recoveryChecksumSize = 4
+)
+
+// recoveryShareEncoding is the base32 alphabet used for printable shares,
+// which avoids characters that are easily confused when copied by hand
+var recoveryShareEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// RecoveryShare is one decoded share of a split recovery key
+type RecoveryShare struct {
+	KeyID     []byte // ID of the recovery key the share belongs to
+	Threshold int    // number of shares needed to rebuild the key
+	Index     int    // x coordinate of the share, starting at 1
+	data      []byte // share bytes as produced by shamir.Split
+}
+
+// NewRecoveryKey generates a random recovery key
+func NewRecoveryKey() ([]byte, error) {
+	key := make([]byte, RecoveryKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate recovery key: %w", err)
+	}
+	return key, nil
+}
+
+// RecoveryKeyID returns the public ID of a recovery key, which recovery slots
+// and shares record so that they can be matched without the key itself
+func RecoveryKeyID(key []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(recoveryIDInfo))
+	h.Write(key)
+	return h.Sum(nil)[:RecoveryKeyIDSize]
+}
+
+// SplitRecoveryKey splits a recovery key into printable shares, any threshold
+// of which rebuild it with CombineRecoveryShares
+func SplitRecoveryKey(key []byte, shares, threshold int) ([]string, error) {
+	if len(key) != RecoveryKeySize {
+		return nil, fmt.Errorf("recovery key must be %d bytes", RecoveryKeySize)
+	}
+
+	parts, err := shamir.Split(key, shares, threshold)
+	if err != nil {
+		return nil, err
+	}
+
+	id := RecoveryKeyID(key)
+	encoded := make([]string, len(parts))
+	for i, part := range parts {
+		encoded[i] = encodeRecoveryShare(id, threshold, part)
+	}
+	return encoded, nil
+}
+
+// CombineRecoveryShares rebuilds a recovery key from at least threshold
+// shares. All shares must belong to the same key, and the result is checked
+// against the key ID so that a wrong or missing share is reported instead
+// of producing a useless key.
+func CombineRecoveryShares(shares []string) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, fmt.Errorf("no recovery shares given")
+	}
+
+	var first *RecoveryShare
+	parts := make([][]byte, len(shares))
+	for i, s := range shares {
+		share, err := ParseRecoveryShare(s)
+		if err != nil {
+			return nil, fmt.Errorf("share %d: %w", i+1, err)
+		}
+		if first == nil {
+			first = share
+		} else if !bytes.Equal(share.KeyID, first.KeyID) {
+			return nil, fmt.Errorf("share %d belongs to a different recovery key", i+1)
+		}
+		parts[i] = share.data
+	}
+
+	if len(shares) < first.Threshold {
+		return nil, fmt.Errorf("%d of %d required shares given", len(shares), first.Threshold)
+	}
+
+	key, err := shamir.Combine(parts)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(RecoveryKeyID(key), first.KeyID) {
+		return nil, fmt.Errorf("shares do not combine into the recovery key; check that none was mistyped")
+	}
+
+	return key, nil
+}
+
+// ParseRecoveryShare decodes the text form of a recovery share. Case, spaces
+// and dashes between groups are ignored, and a checksum catches typos.
+func ParseRecoveryShare(s string) (*RecoveryShare, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if !strings.HasPrefix(s, strings.ToUpper(RecoverySharePrefix)) {
+		return nil, fmt.Errorf("recovery share must start with %q", RecoverySharePrefix)
+	}
+	s = strings.TrimPrefix(s, strings.ToUpper(RecoverySharePrefix))
+	s = strings.NewReplacer("-", "", " ", "").Replace(s)
+
+	data, err := recoveryShareEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recovery share encoding")
+	}
+
+	shareSize := 1 + RecoveryKeyIDSize + 1 + RecoveryKeySize + 1 + recoveryChecksumSize
+	if len(data) != shareSize {
+		return nil, fmt.Errorf("recovery share has the wrong length; check that it was copied completely")
+	}
+
+	body, checksum := data[:len(data)-recoveryChecksumSize], data[len(data)-recoveryChecksumSize:]
+	sum := sha256.Sum256(body)
+	if !bytes.Equal(sum[:recoveryChecksumSize], checksum) {
+		return nil, fmt.Errorf("recovery share checksum mismatch; check it for typos")
+	}
+
+	if body[0] != recoveryShareVersion {
+		return nil, fmt.Errorf("unsupported recovery share version %d", body[0])
+	}
+
+	part := body[2+RecoveryKeyIDSize:]
+	return &RecoveryShare{
+		KeyID:     body[1 : 1+RecoveryKeyIDSize],
+		Threshold: int(body[1+RecoveryKeyIDSize]),
+		Index:     int(part[len(part)-1]),
+		data:      part,
+	}, nil
+}
+
+// encodeRecoveryShare formats a share as
+// version (1) | key ID (8) | threshold (1) | share (33) | checksum (4)
+// in base32, split into groups of four characters
+func encodeRecoveryShare(id []byte, threshold int, part []byte) string {
+	var buf bytes.Buffer
+	buf.WriteByte(recoveryShareVersion)
+	buf.Write(id)
+	buf.WriteByte(byte(threshold))
+	buf.Write(part)
+	sum := sha256.Sum256(buf.Bytes())
+	buf.Write(sum[:recoveryChecksumSize])
+
+	encoded := recoveryShareEncoding.EncodeToString(buf.Bytes())
+	var groups []string
+	for len(encoded) > recoveryShareGroup {
+		groups = append(groups, encoded[:recoveryShareGroup])
+		encoded = encoded[recoveryShareGroup:]
+	}
+	groups = append(groups, encoded)
+
+	return RecoverySharePrefix + strings.Join(groups, "-")
+}
+
+// newRecoverySlot wraps fileKey with a key derived from the recovery key
+func newRecoverySlot(fileKey, recoveryKey []byte) (KeySlot, error) {
+	kek, err := recoveryKEK(recoveryKey)
+	if err != nil {
+		return KeySlot{}, err
+	}
+
+	wrapped, err := wrapKey(kek, fileKey)
+	if err != nil {
+		return KeySlot{}, err
+	}
+
+	return KeySlot{
+		Type:       SlotRecovery,
+		Recipient:  RecoveryKeyID(recoveryKey),
+		WrappedKey: wrapped,
+	}, nil
+}
+
+// unlockRecovery unwraps the file key of a recovery slot
+func (s *KeySlot) unlockRecovery(recoveryKey []byte) ([]byte, error) {
+	if s.Type != SlotRecovery || !bytes.Equal(s.Recipient, RecoveryKeyID(recoveryKey)) {
+		return nil, errSlotMismatch
+	}
+
+	kek, err := recoveryKEK(recoveryKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return unwrapKey(kek, s.WrappedKey)
+}
+
+// recoveryKEK derives the key-encryption key of a recovery slot. The recovery
+// key is random, so no password-hardening KDF is needed.
+func recoveryKEK(recoveryKey []byte) ([]byte, error) {
+	if len(recoveryKey) != RecoveryKeySize {
+		return nil, fmt.Errorf("recovery key must be %d bytes", RecoveryKeySize)
+	}
+	kek := make([]byte, KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, recoveryKey, nil, []byte(recoveryKeyInfo)), kek); err != nil {
+		return nil, fmt.Errorf("failed to derive recovery key: %w", err)
+	}
+	return kek, nil
+}
@@ -0,0 +1,156 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing"
+
+	"goingenv/pkg/secret"
+	"goingenv/pkg/types"
+)
+
+func TestSplitCombineRecoveryKey(t *testing.T) {
+	key, err := NewRecoveryKey()
+	if err != nil {
+		t.Fatalf("NewRecoveryKey failed: %v", err)
+	}
+
+	shares, err := SplitRecoveryKey(key, 5, 3)
+	if err != nil {
+		t.Fatalf("SplitRecoveryKey failed: %v", err)
+	}
+	if len(shares) != 5 {
+		t.Fatalf("Expected 5 shares, got %d", len(shares))
+	}
+
+	for i, text := range shares {
+		if !strings.HasPrefix(text, RecoverySharePrefix) {
+			t.Errorf("Share %d = %q, want prefix %q", i+1, text, RecoverySharePrefix)
+		}
+		share, err := ParseRecoveryShare(text)
+		if err != nil {
+			t.Fatalf("ParseRecoveryShare failed: %v", err)
+		}
+		if share.Index != i+1 || share.Threshold != 3 || !bytes.Equal(share.KeyID, RecoveryKeyID(key)) {
+			t.Errorf("Unexpected share %+v", share)
+		}
+	}
+
+	// Any three shares, in any order and case, rebuild the key
+	combined, err := CombineRecoveryShares([]string{shares[4], strings.ToLower(shares[0]), shares[2]})
+	if err != nil {
+		t.Fatalf("CombineRecoveryShares failed: %v", err)
+	}
+	if !bytes.Equal(combined, key) {
+		t.Error("Combined key doesn't match original")
+	}
+
+	// Extra shares do no harm
+	if combined, err := CombineRecoveryShares(shares); err != nil || !bytes.Equal(combined, key) {
+		t.Errorf("CombineRecoveryShares of all shares = %v; want the original key", err)
+	}
+}
+
+func TestCombineRecoveryShares_Errors(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, RecoveryKeySize)
+	shares, err := SplitRecoveryKey(key, 5, 3)
+	if err != nil {
+		t.Fatalf("SplitRecoveryKey failed: %v", err)
+	}
+	otherShares, err := SplitRecoveryKey(bytes.Repeat([]byte{0x43}, RecoveryKeySize), 5, 3)
+	if err != nil {
+		t.Fatalf("SplitRecoveryKey failed: %v", err)
+	}
+
+	// Change one character in the middle of a share
+	typo := []byte(shares[1])
+	pos := len(RecoverySharePrefix) + 20
+	if typo[pos] == 'A' {
+		typo[pos] = 'B'
+	} else {
+		typo[pos] = 'A'
+	}
+
+	tests := []struct {
+		name   string
+		shares []string
+	}{
+		{"no shares", nil},
+		{"below threshold", shares[:2]},
+		{"typo", []string{shares[0], string(typo), shares[2]}},
+		{"truncated share", []string{shares[0], shares[1][:len(shares[1])-5], shares[2]}},
+		{"missing prefix", []string{shares[0], strings.TrimPrefix(shares[1], RecoverySharePrefix), shares[2]}},
+		{"different keys", []string{shares[0], shares[1], otherShares[2]}},
+		{"duplicate share", []string{shares[0], shares[1], shares[1]}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := CombineRecoveryShares(tt.shares); err == nil {
+				t.Error("Expected CombineRecoveryShares to fail")
+			}
+		})
+	}
+}
+
+func TestService_RecoverySlot(t *testing.T) {
+	service := fastService()
+	data := []byte("API_KEY=recoverable")
+
+	key, err := NewRecoveryKey()
+	if err != nil {
+		t.Fatalf("NewRecoveryKey failed: %v", err)
+	}
+	otherKey, err := NewRecoveryKey()
+	if err != nil {
+		t.Fatalf("NewRecoveryKey failed: %v", err)
+	}
+
+	encrypted := encryptStream(t, service, data, "password")
+	header, payload := splitArchive(t, service, encrypted)
+
+	newHeader, err := service.AddKeySlot(header,
+		types.DecryptOptions{Password: secret.FromString("password")},
+		types.EncryptOptions{RecoveryKey: key})
+	if err != nil {
+		t.Fatalf("AddKeySlot failed: %v", err)
+	}
+	updated := append(append([]byte{}, newHeader...), payload...)
+
+	slots, err := service.ListKeySlots(newHeader)
+	if err != nil {
+		t.Fatalf("ListKeySlots failed: %v", err)
+	}
+	if len(slots) != 2 || slots[1].Type != "recovery" || slots[1].Recipient != hex.EncodeToString(RecoveryKeyID(key)) {
+		t.Fatalf("Unexpected key slots %+v, want a recovery slot after the password slot", slots)
+	}
+
+	r, err := service.DecryptStream(bytes.NewReader(updated), types.DecryptOptions{RecoveryKey: key})
+	if err != nil {
+		t.Fatalf("Decrypt with the recovery key failed: %v", err)
+	}
+	decrypted, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Reading decrypted data failed: %v", err)
+	}
+	if !bytes.Equal(data, decrypted) {
+		t.Error("Decrypted data doesn't match original")
+	}
+
+	if _, err := service.DecryptStream(bytes.NewReader(updated), types.DecryptOptions{RecoveryKey: otherKey}); err == nil {
+		t.Error("Expected another recovery key to be rejected")
+	}
+
+	// The recovery key can set a new password without the old one
+	withPassword, err := service.AddKeySlot(newHeader,
+		types.DecryptOptions{RecoveryKey: key},
+		types.EncryptOptions{Password: secret.FromString("new password")})
+	if err != nil {
+		t.Fatalf("AddKeySlot with the recovery key failed: %v", err)
+	}
+	if _, err := decryptStream(service, append(withPassword, payload...), "new password"); err != nil {
+		t.Errorf("Decrypt with the new password failed: %v", err)
+	}
+}
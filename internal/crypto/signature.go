@@ -0,0 +1,167 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"goingenv/pkg/types"
+)
+
+const (
+	// SignatureVersion is the version of the signature trailer format
+	SignatureVersion = 1
+	// SignatureTrailerSize is the size of the signature trailer appended to
+	// signed archives
+	SignatureTrailerSize = 8 + 1 + ed25519.PublicKeySize + ed25519.SignatureSize
+
+	signatureContext = "goingenv archive signature v1\n"
+)
+
+// SignatureMagic starts the signature trailer at the end of a signed archive
+var SignatureMagic = []byte("GOINGSIG")
+
+// signatureWriter passes an archive through to the destination while hashing
+// it, and appends the signature trailer on Close
+type signatureWriter struct {
+	dst  io.Writer
+	key  ed25519.PrivateKey
+	hash hash.Hash
+}
+
+func (w *signatureWriter) Write(p []byte) (int, error) {
+	n, err := w.dst.Write(p)
+	w.hash.Write(p[:n])
+	return n, err
+}
+
+// Close writes the signature trailer. It does not close the destination.
+func (w *signatureWriter) Close() error {
+	signature := ed25519.Sign(w.key, signedMessage(w.hash.Sum(nil)))
+
+	var trailer bytes.Buffer
+	trailer.Write(SignatureMagic)
+	trailer.WriteByte(SignatureVersion)
+	trailer.Write(w.key.Public().(ed25519.PublicKey))
+	trailer.Write(signature)
+
+	if _, err := w.dst.Write(trailer.Bytes()); err != nil {
+		return fmt.Errorf("failed to write signature: %w", err)
+	}
+	return nil
+}
+
+// SignStream returns a writer that copies an archive to w and appends an
+// ed25519 signature over everything written (the header and ciphertext) when
+// it is closed. The signing key is an OpenSSH ed25519 private key file.
+func (s *Service) SignStream(w io.Writer, opts types.SignOptions) (io.WriteCloser, error) {
+	data, err := os.ReadFile(opts.KeyPath)
+	if err != nil {
+		return nil, &types.CryptoError{
+			Operation: "sign",
+			Err:       fmt.Errorf("failed to read signing key: %w", err),
+		}
+	}
+
+	key, err := parseSSHPrivateKey(data, opts.KeyPath, opts.Passphrase)
+	if err != nil {
+		return nil, &types.CryptoError{
+			Operation: "sign",
+			Err:       fmt.Errorf("signing key %s: %w", opts.KeyPath, err),
+		}
+	}
+
+	return &signatureWriter{dst: w, key: key, hash: sha256.New()}, nil
+}
+
+// ReadSignature checks the end of an archive of the given size for a
+// signature trailer and verifies it. It returns the signer, or nil if the
+// archive is not signed, together with the length of the signed content
+// that precedes the trailer. A signature that does not verify is an error.
+func (s *Service) ReadSignature(r io.ReaderAt, size int64) (*types.SignatureInfo, int64, error) {
+	if size < SignatureTrailerSize {
+		return nil, size, nil
+	}
+
+	trailer := make([]byte, SignatureTrailerSize)
+	if _, err := r.ReadAt(trailer, size-SignatureTrailerSize); err != nil {
+		return nil, 0, &types.CryptoError{
+			Operation: "verify signature",
+			Err:       fmt.Errorf("failed to read archive: %w", err),
+		}
+	}
+	if !bytes.HasPrefix(trailer, SignatureMagic) {
+		return nil, size, nil
+	}
+
+	rest := trailer[len(SignatureMagic):]
+	if rest[0] != SignatureVersion {
+		return nil, 0, &types.CryptoError{
+			Operation: "verify signature",
+			Err:       fmt.Errorf("unsupported signature version %d", rest[0]),
+		}
+	}
+	publicKey := ed25519.PublicKey(rest[1 : 1+ed25519.PublicKeySize])
+	signature := rest[1+ed25519.PublicKeySize:]
+
+	signedSize := size - SignatureTrailerSize
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, 0, signedSize)); err != nil {
+		return nil, 0, &types.CryptoError{
+			Operation: "verify signature",
+			Err:       fmt.Errorf("failed to read archive: %w", err),
+		}
+	}
+
+	if !ed25519.Verify(publicKey, signedMessage(h.Sum(nil)), signature) {
+		return nil, 0, &types.CryptoError{
+			Operation: "verify signature",
+			Err:       fmt.Errorf("invalid signature: the archive was modified after it was signed"),
+		}
+	}
+
+	sshKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		return nil, 0, &types.CryptoError{
+			Operation: "verify signature",
+			Err:       fmt.Errorf("invalid signing key: %w", err),
+		}
+	}
+
+	return &types.SignatureInfo{
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshKey))),
+		Fingerprint: ssh.FingerprintSHA256(sshKey),
+	}, signedSize, nil
+}
+
+// FindTrustedSigner looks up the signer of an archive in a list of trusted
+// keys in authorized_keys form ("ssh-ed25519 AAAA... name"). It returns the
+// comment of the matching entry, which names the signer. A nil signer, for an
+// unsigned archive, matches nothing. Every entry is checked, so an invalid
+// key is reported even when an earlier entry matches.
+func FindTrustedSigner(signer *types.SignatureInfo, trusted []string) (string, bool, error) {
+	var comment string
+	var found bool
+	for _, line := range trusted {
+		key, c, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return "", false, fmt.Errorf("invalid trusted signer %q: %w", line, err)
+		}
+		if signer != nil && !found && ssh.FingerprintSHA256(key) == signer.Fingerprint {
+			comment, found = c, true
+		}
+	}
+	return comment, found, nil
+}
+
+// signedMessage binds an archive digest to the goingenv signature context
+func signedMessage(digest []byte) []byte {
+	return append([]byte(signatureContext), digest...)
+}
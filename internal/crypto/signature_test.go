@@ -0,0 +1,174 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"goingenv/pkg/secret"
+	"goingenv/pkg/types"
+)
+
+// signArchive encrypts data under "password" and signs the archive
+func signArchive(t *testing.T, service *Service, data []byte, sign types.SignOptions) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	signed, err := service.SignStream(&buf, sign)
+	if err != nil {
+		t.Fatalf("SignStream failed: %v", err)
+	}
+	w, err := service.EncryptStream(signed, types.EncryptOptions{Password: secret.FromString("password")})
+	if err != nil {
+		t.Fatalf("EncryptStream failed: %v", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := signed.Close(); err != nil {
+		t.Fatalf("Closing the signer failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestService_SignAndVerify(t *testing.T) {
+	service := fastService()
+	data := []byte("API_KEY=signed-secret")
+
+	priv, line := newTestSSHKey(t)
+	archive := signArchive(t, service, data, types.SignOptions{KeyPath: writeSSHKey(t, priv, "")})
+
+	signer, size, err := service.ReadSignature(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatalf("ReadSignature failed: %v", err)
+	}
+	if size != int64(len(archive)-SignatureTrailerSize) {
+		t.Errorf("Signed size = %d, want %d", size, len(archive)-SignatureTrailerSize)
+	}
+
+	sshPub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		t.Fatalf("ParseAuthorizedKey failed: %v", err)
+	}
+	if signer == nil || signer.Fingerprint != ssh.FingerprintSHA256(sshPub) || !strings.HasPrefix(line, signer.PublicKey) {
+		t.Fatalf("Unexpected signer %+v, want %s", signer, ssh.FingerprintSHA256(sshPub))
+	}
+
+	// The content before the trailer is an ordinary archive
+	decrypted, err := decryptStream(service, archive[:size], "password")
+	if err != nil {
+		t.Fatalf("Decrypt of signed content failed: %v", err)
+	}
+	if !bytes.Equal(data, decrypted) {
+		t.Error("Decrypted data doesn't match original")
+	}
+}
+
+func TestService_SignWithProtectedKey(t *testing.T) {
+	service := fastService()
+	priv, _ := newTestSSHKey(t)
+	path := writeSSHKey(t, priv, "ssh passphrase")
+
+	if _, err := service.SignStream(&bytes.Buffer{}, types.SignOptions{KeyPath: path}); err == nil {
+		t.Error("Expected signing with a protected key and no passphrase to fail")
+	}
+
+	archive := signArchive(t, service, []byte("X=1"), types.SignOptions{
+		KeyPath:    path,
+		Passphrase: func(string) (*secret.Buffer, error) { return secret.FromString("ssh passphrase"), nil },
+	})
+	if signer, _, err := service.ReadSignature(bytes.NewReader(archive), int64(len(archive))); err != nil || signer == nil {
+		t.Errorf("ReadSignature = %+v, %v; want a signer", signer, err)
+	}
+}
+
+func TestService_ReadSignatureUnsigned(t *testing.T) {
+	service := fastService()
+	archive := encryptStream(t, service, []byte("X=1"), "password")
+
+	signer, size, err := service.ReadSignature(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatalf("ReadSignature failed: %v", err)
+	}
+	if signer != nil || size != int64(len(archive)) {
+		t.Errorf("ReadSignature = %+v, %d; want no signer and the full size %d", signer, size, len(archive))
+	}
+}
+
+func TestService_ReadSignatureDetectsTampering(t *testing.T) {
+	service := fastService()
+	priv, _ := newTestSSHKey(t)
+	other, _ := newTestSSHKey(t)
+	archive := signArchive(t, service, []byte("API_KEY=signed-secret"), types.SignOptions{KeyPath: writeSSHKey(t, priv, "")})
+
+	tests := map[string]func([]byte) []byte{
+		"header byte": func(b []byte) []byte {
+			b[len(Magic)+2] ^= 0x01
+			return b
+		},
+		"ciphertext byte": func(b []byte) []byte {
+			b[len(b)-SignatureTrailerSize-1] ^= 0x01
+			return b
+		},
+		"signature byte": func(b []byte) []byte {
+			b[len(b)-1] ^= 0x01
+			return b
+		},
+		"signer replaced": func(b []byte) []byte {
+			// Swapping in another public key must not verify either
+			start := len(b) - SignatureTrailerSize + len(SignatureMagic) + 1
+			copy(b[start:], other.Public().(ed25519.PublicKey))
+			return b
+		},
+		"unsupported version": func(b []byte) []byte {
+			b[len(b)-SignatureTrailerSize+len(SignatureMagic)] = SignatureVersion + 1
+			return b
+		},
+	}
+
+	for name, tamper := range tests {
+		t.Run(name, func(t *testing.T) {
+			tampered := tamper(append([]byte{}, archive...))
+			if _, _, err := service.ReadSignature(bytes.NewReader(tampered), int64(len(tampered))); err == nil {
+				t.Error("Expected tampered archive to fail verification")
+			}
+		})
+	}
+}
+
+func TestFindTrustedSigner(t *testing.T) {
+	_, alice := newTestSSHKey(t)
+	_, bob := newTestSSHKey(t)
+	_, mallory := newTestSSHKey(t)
+
+	signerOf := func(line string) *types.SignatureInfo {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			t.Fatalf("ParseAuthorizedKey failed: %v", err)
+		}
+		return &types.SignatureInfo{Fingerprint: ssh.FingerprintSHA256(key)}
+	}
+
+	trusted := []string{
+		strings.Replace(alice, "dev@example.com", "alice", 1),
+		strings.Replace(bob, "dev@example.com", "bob", 1),
+	}
+
+	if comment, ok, err := FindTrustedSigner(signerOf(bob), trusted); err != nil || !ok || comment != "bob" {
+		t.Errorf("FindTrustedSigner(bob) = %q, %v, %v; want bob", comment, ok, err)
+	}
+	if _, ok, err := FindTrustedSigner(signerOf(mallory), trusted); err != nil || ok {
+		t.Errorf("FindTrustedSigner(mallory) = %v, %v; want no match", ok, err)
+	}
+	if _, ok, err := FindTrustedSigner(nil, trusted); err != nil || ok {
+		t.Errorf("FindTrustedSigner(nil) = %v, %v; want no match", ok, err)
+	}
+	if _, _, err := FindTrustedSigner(signerOf(alice), append(trusted, "not a key")); err == nil {
+		t.Error("Expected an invalid trusted signer to be reported")
+	}
+}
@@ -0,0 +1,258 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"goingenv/pkg/secret"
+	"goingenv/pkg/types"
+)
+
+// newTestSSHKey generates an ed25519 key pair and returns the private key
+// together with its authorized_keys line
+func newTestSSHKey(t *testing.T) (ed25519.PrivateKey, string) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey failed: %v", err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("ssh.NewPublicKey failed: %v", err)
+	}
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub))) + " dev@example.com"
+	return priv, line
+}
+
+// writeSSHKey stores an OpenSSH private key file, encrypted if passphrase is set
+func writeSSHKey(t *testing.T, priv ed25519.PrivateKey, passphrase string) string {
+	t.Helper()
+	var block *pem.Block
+	var err error
+	if passphrase == "" {
+		block, err = ssh.MarshalPrivateKey(priv, "dev@example.com")
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, "dev@example.com", []byte(passphrase))
+	}
+	if err != nil {
+		t.Fatalf("Failed to marshal SSH key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("Failed to write SSH key: %v", err)
+	}
+	return path
+}
+
+func TestSSHEd25519_KeyConversion(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		priv, line := newTestSSHKey(t)
+
+		recipient, err := ParseRecipient(line)
+		if err != nil {
+			t.Fatalf("ParseRecipient failed: %v", err)
+		}
+		identity, err := newSSHEd25519Identity(priv)
+		if err != nil {
+			t.Fatalf("newSSHEd25519Identity failed: %v", err)
+		}
+
+		// The public key converted from the Edwards point must match the
+		// public key of the converted private scalar
+		fromPublic := recipient.(*SSHEd25519Recipient).key.Bytes()
+		fromPrivate := identity.key.PublicKey().Bytes()
+		if !bytes.Equal(fromPublic, fromPrivate) {
+			t.Fatalf("Converted keys differ:\n public:  %x\n private: %x", fromPublic, fromPrivate)
+		}
+	}
+}
+
+func TestService_EncryptToSSHRecipient(t *testing.T) {
+	service := fastService()
+	data := []byte("DATABASE_URL=postgres://team@localhost/app")
+
+	priv, line := newTestSSHKey(t)
+	other, _ := newTestSSHKey(t)
+
+	var buf bytes.Buffer
+	w, err := service.EncryptStream(&buf, types.EncryptOptions{Recipients: []string{line}})
+	if err != nil {
+		t.Fatalf("EncryptStream failed: %v", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	encrypted := buf.Bytes()
+
+	decrypt := func(opts types.DecryptOptions) ([]byte, error) {
+		r, err := service.DecryptStream(bytes.NewReader(encrypted), opts)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	}
+
+	t.Run("Unencrypted key", func(t *testing.T) {
+		decrypted, err := decrypt(types.DecryptOptions{Identities: []string{writeSSHKey(t, priv, "")}})
+		if err != nil {
+			t.Fatalf("Decrypt failed: %v", err)
+		}
+		if !bytes.Equal(data, decrypted) {
+			t.Error("Decrypted data doesn't match original")
+		}
+	})
+
+	t.Run("Passphrase-protected key", func(t *testing.T) {
+		path := writeSSHKey(t, priv, "ssh passphrase")
+		var asked []string
+		decrypted, err := decrypt(types.DecryptOptions{
+			Identities: []string{path},
+			Passphrase: func(p string) (*secret.Buffer, error) {
+				asked = append(asked, p)
+				return secret.FromString("ssh passphrase"), nil
+			},
+		})
+		if err != nil {
+			t.Fatalf("Decrypt failed: %v", err)
+		}
+		if !bytes.Equal(data, decrypted) {
+			t.Error("Decrypted data doesn't match original")
+		}
+		if len(asked) != 1 || asked[0] != path {
+			t.Errorf("Passphrase asked for %v, want once for %s", asked, path)
+		}
+	})
+
+	t.Run("Wrong passphrase", func(t *testing.T) {
+		path := writeSSHKey(t, priv, "ssh passphrase")
+		_, err := decrypt(types.DecryptOptions{
+			Identities: []string{path},
+			Passphrase: func(string) (*secret.Buffer, error) { return secret.FromString("wrong"), nil },
+		})
+		if err == nil {
+			t.Error("Expected decrypt with a wrong passphrase to fail")
+		}
+	})
+
+	t.Run("No passphrase callback", func(t *testing.T) {
+		path := writeSSHKey(t, priv, "ssh passphrase")
+		if _, err := decrypt(types.DecryptOptions{Identities: []string{path}}); err == nil {
+			t.Error("Expected decrypt of a protected key without a passphrase to fail")
+		}
+	})
+
+	t.Run("Passphrase prompt fails", func(t *testing.T) {
+		path := writeSSHKey(t, priv, "ssh passphrase")
+		_, err := decrypt(types.DecryptOptions{
+			Identities: []string{path},
+			Passphrase: func(string) (*secret.Buffer, error) { return nil, errors.New("cancelled") },
+		})
+		if err == nil {
+			t.Error("Expected decrypt to fail when the passphrase prompt fails")
+		}
+	})
+
+	t.Run("Other SSH key", func(t *testing.T) {
+		if _, err := decrypt(types.DecryptOptions{Identities: []string{writeSSHKey(t, other, "")}}); err == nil {
+			t.Error("Expected a non-recipient SSH key to fail")
+		}
+	})
+}
+
+func TestService_ListSSHKeySlot(t *testing.T) {
+	service := fastService()
+	_, line := newTestSSHKey(t)
+
+	var buf bytes.Buffer
+	w, err := service.EncryptStream(&buf, types.EncryptOptions{Recipients: []string{line}})
+	if err != nil {
+		t.Fatalf("EncryptStream failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	header, _ := splitArchive(t, service, buf.Bytes())
+
+	slots, err := service.ListKeySlots(header)
+	if err != nil {
+		t.Fatalf("ListKeySlots failed: %v", err)
+	}
+
+	sshPub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		t.Fatalf("ParseAuthorizedKey failed: %v", err)
+	}
+	if len(slots) != 1 || slots[0].Type != "ssh-ed25519" || slots[0].Recipient != ssh.FingerprintSHA256(sshPub) {
+		t.Errorf("Unexpected key slots: %+v, want fingerprint %s", slots, ssh.FingerprintSHA256(sshPub))
+	}
+}
+
+func TestParseRecipient_SSH(t *testing.T) {
+	_, line := newTestSSHKey(t)
+
+	recipient, err := ParseRecipient(line)
+	if err != nil {
+		t.Fatalf("ParseRecipient failed: %v", err)
+	}
+	if !strings.HasPrefix(line, recipient.String()) || !strings.HasPrefix(recipient.String(), SSHEd25519Prefix) {
+		t.Errorf("String() = %q, want the key of %q without the comment", recipient.String(), line)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey failed: %v", err)
+	}
+	ecPub, err := ssh.NewPublicKey(&ecKey.PublicKey)
+	if err != nil {
+		t.Fatalf("ssh.NewPublicKey failed: %v", err)
+	}
+
+	for _, invalid := range []string{
+		strings.TrimSpace(string(ssh.MarshalAuthorizedKey(ecPub))),
+		SSHEd25519Prefix + "AAAA",
+		SSHEd25519Prefix + "not base64!",
+	} {
+		if _, err := ParseRecipient(invalid); err == nil {
+			t.Errorf("Expected ParseRecipient(%q) to fail", invalid)
+		}
+	}
+}
+
+func TestEd25519PublicKeyToX25519_RejectsInvalidPoints(t *testing.T) {
+	// y = 1 is the identity point, which has no Montgomery equivalent
+	identityPoint := make([]byte, 32)
+	identityPoint[0] = 1
+
+	// y = p is not canonical
+	nonCanonical := []byte{
+		0xed, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f,
+	}
+
+	for name, point := range map[string][]byte{
+		"identity":      identityPoint,
+		"non-canonical": nonCanonical,
+		"short":         make([]byte, 31),
+	} {
+		if _, err := ed25519PublicKeyToX25519(point); err == nil {
+			t.Errorf("Expected %s point to be rejected", name)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package crypto
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"goingenv/pkg/types"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// ChunkSize is the amount of plaintext sealed in each stream chunk
+	ChunkSize = 64 * 1024
+	// StreamNonceSize is the size of the random per-archive stream nonce
+	StreamNonceSize = 16
+
+	// lastChunkFlag marks the final chunk in its nonce, so that truncating the
+	// stream at a chunk boundary is detected
+	lastChunkFlag = 0x01
+
+	// MaxIndexSize is the largest index segment accepted, sealed
+	MaxIndexSize = 16 * 1024 * 1024
+
+	headerKeyInfo  = "goingenv header mac"
+	payloadKeyInfo = "goingenv payload"
+	indexKeyInfo   = "goingenv index"
+)
+
+// streamKeys derives the header MAC key and the payload key from a file key.
+// The payload key is bound to the per-archive stream nonce, so chunk nonces
+// only have to be unique within one archive.
+func streamKeys(fileKey []byte, header *Header) (macKey, payloadKey []byte, err error) {
+	macKey = make([]byte, KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, fileKey, nil, []byte(headerKeyInfo)), macKey); err != nil {
+		return nil, nil, fmt.Errorf("failed to derive header key: %w", err)
+	}
+
+	payloadKey = make([]byte, KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, fileKey, header.Nonce, []byte(payloadKeyInfo)), payloadKey); err != nil {
+		return nil, nil, fmt.Errorf("failed to derive payload key: %w", err)
+	}
+
+	return macKey, payloadKey, nil
+}
+
+// indexAEAD returns the AEAD that seals the index segment. Like the payload
+// key, its key is bound to the file key and the stream nonce, so it seals a
+// single message and the index can use an all-zero nonce.
+func indexAEAD(fileKey []byte, header *Header) (cipher.AEAD, error) {
+	indexKey := make([]byte, KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, fileKey, header.Nonce, []byte(indexKeyInfo)), indexKey); err != nil {
+		return nil, fmt.Errorf("failed to derive index key: %w", err)
+	}
+	return newAEAD(header.Cipher, indexKey)
+}
+
+// writeIndex seals index and writes it as a length-prefixed segment
+func writeIndex(w io.Writer, aead cipher.AEAD, index []byte) error {
+	sealed := aead.Seal(nil, make([]byte, aead.NonceSize()), index, nil)
+	if len(sealed) > MaxIndexSize {
+		return fmt.Errorf("archive index is too large (%d bytes)", len(index))
+	}
+
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(sealed)))
+	if _, err := w.Write(length[:]); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	if _, err := w.Write(sealed); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	return nil
+}
+
+// readIndex reads the index segment from r and opens it, consuming exactly
+// the segment's bytes
+func readIndex(r io.Reader, aead cipher.AEAD) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, fmt.Errorf("decryption failed: archive index is truncated")
+	}
+	size := binary.BigEndian.Uint32(length[:])
+	if size < uint32(aead.Overhead()) || size > MaxIndexSize {
+		return nil, fmt.Errorf("decryption failed: invalid archive index length %d", size)
+	}
+
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(r, sealed); err != nil {
+		return nil, fmt.Errorf("decryption failed: archive index is truncated")
+	}
+
+	index, err := aead.Open(sealed[:0], make([]byte, aead.NonceSize()), sealed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed: invalid password or corrupted data")
+	}
+	return index, nil
+}
+
+// headerMAC computes the MAC of every header field except the MAC itself
+func headerMAC(macKey []byte, header *Header) ([]byte, error) {
+	data, err := header.authenticatedBytes()
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+// sealHeader computes the header MAC with the file key and returns the
+// encoded header
+func sealHeader(header *Header, fileKey []byte) ([]byte, error) {
+	macKey, _, err := streamKeys(fileKey, header)
+	if err != nil {
+		return nil, err
+	}
+
+	header.MAC, err = headerMAC(macKey, header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode header: %w", err)
+	}
+
+	headerBytes, err := header.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode header: %w", err)
+	}
+	return headerBytes, nil
+}
+
+// verifyHeader checks the header MAC with the file key
+func verifyHeader(header *Header, fileKey []byte) error {
+	macKey, _, err := streamKeys(fileKey, header)
+	if err != nil {
+		return err
+	}
+
+	expected, err := headerMAC(macKey, header)
+	if err != nil || !hmac.Equal(expected, header.MAC) {
+		return fmt.Errorf("decryption failed: invalid password or corrupted data")
+	}
+	return nil
+}
+
+// chunkNonce builds the nonce of a chunk: a big-endian counter followed by
+// a flag byte that is set only on the last chunk
+func chunkNonce(nonce []byte, counter uint64, last bool) {
+	for i := range nonce {
+		nonce[i] = 0
+	}
+	n := len(nonce)
+	binary.BigEndian.PutUint64(nonce[n-9:n-1], counter)
+	if last {
+		nonce[n-1] = lastChunkFlag
+	}
+}
+
+// streamWriter seals plaintext into fixed-size chunks as it is written.
+// A full chunk is only sealed once more data arrives, so that Close can
+// always mark the real final chunk as last.
+type streamWriter struct {
+	aead    cipher.AEAD
+	dst     io.Writer
+	buf     []byte
+	sealed  []byte
+	nonce   []byte
+	counter uint64
+	err     error
+}
+
+func newStreamWriter(aead cipher.AEAD, dst io.Writer) *streamWriter {
+	return &streamWriter{
+		aead:   aead,
+		dst:    dst,
+		buf:    make([]byte, 0, ChunkSize),
+		sealed: make([]byte, 0, ChunkSize+aead.Overhead()),
+		nonce:  make([]byte, aead.NonceSize()),
+	}
+}
+
+// Write buffers p, sealing and writing every completed chunk
+func (w *streamWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	written := 0
+	for len(p) > 0 {
+		if len(w.buf) == ChunkSize {
+			if err := w.flush(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+// Close seals the buffered data as the final chunk. It does not close the
+// underlying writer.
+func (w *streamWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.flush(true); err != nil {
+		return err
+	}
+	w.err = fmt.Errorf("stream writer already closed")
+	return nil
+}
+
+func (w *streamWriter) flush(last bool) error {
+	chunkNonce(w.nonce, w.counter, last)
+	w.sealed = w.aead.Seal(w.sealed[:0], w.nonce, w.buf, nil)
+	if _, err := w.dst.Write(w.sealed); err != nil {
+		w.err = err
+		return err
+	}
+	w.buf = w.buf[:0]
+	w.counter++
+	return nil
+}
+
+// streamReader opens chunks written by streamWriter, releasing plaintext only
+// after each chunk has been authenticated. A missing final chunk, data after
+// the final chunk, or chunks in the wrong order are reported as errors.
+type streamReader struct {
+	aead    cipher.AEAD
+	src     io.Reader
+	buf     []byte
+	pending int
+	plain   []byte
+	out     []byte
+	nonce   []byte
+	counter uint64
+	done    bool
+	err     error
+}
+
+func newStreamReader(aead cipher.AEAD, src io.Reader) *streamReader {
+	return &streamReader{
+		aead: aead,
+		src:  src,
+		// One byte beyond a full chunk tells us whether another chunk follows
+		buf:   make([]byte, ChunkSize+aead.Overhead()+1),
+		plain: make([]byte, 0, ChunkSize),
+		nonce: make([]byte, aead.NonceSize()),
+	}
+}
+
+// Read returns authenticated plaintext
+func (r *streamReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.readChunk(); err != nil {
+			r.err = err
+		}
+	}
+
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func (r *streamReader) readChunk() error {
+	sealedSize := ChunkSize + r.aead.Overhead()
+
+	n, err := io.ReadFull(r.src, r.buf[r.pending:])
+	total := r.pending + n
+	last := false
+	switch err {
+	case nil:
+	case io.EOF, io.ErrUnexpectedEOF:
+		last = true
+	default:
+		return &types.CryptoError{
+			Operation: "decrypt",
+			Err:       fmt.Errorf("failed to read encrypted data: %w", err),
+		}
+	}
+
+	chunk := r.buf[:sealedSize]
+	if last {
+		chunk = r.buf[:total]
+		if total < r.aead.Overhead() || (total == r.aead.Overhead() && r.counter > 0) {
+			return &types.CryptoError{
+				Operation: "decrypt",
+				Err:       fmt.Errorf("decryption failed: encrypted data is truncated"),
+			}
+		}
+	}
+
+	chunkNonce(r.nonce, r.counter, last)
+	plaintext, err := r.aead.Open(r.plain[:0], r.nonce, chunk, nil)
+	if err != nil {
+		return &types.CryptoError{
+			Operation: "decrypt",
+			Err:       fmt.Errorf("decryption failed: invalid password or corrupted data"),
+		}
+	}
+
+	if last {
+		r.done = true
+	} else {
+		r.buf[0] = r.buf[sealedSize]
+		r.pending = 1
+	}
+
+	r.out = plaintext
+	r.counter++
+	return nil
+}
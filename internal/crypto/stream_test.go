@@ -0,0 +1,272 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"goingenv/pkg/secret"
+	"goingenv/pkg/types"
+)
+
+// payloadOffset returns where the chunked payload of a version 4 archive
+// starts, after the header and the index segment
+func payloadOffset(t *testing.T, encrypted []byte) int {
+	t.Helper()
+	_, headerLen, err := ParseHeader(encrypted)
+	if err != nil {
+		t.Fatalf("ParseHeader failed: %v", err)
+	}
+	indexLen := binary.BigEndian.Uint32(encrypted[headerLen:])
+	return headerLen + 4 + int(indexLen)
+}
+
+// fastService returns a service with a cheap KDF so stream tests stay quick
+func fastService() *Service {
+	return NewServiceWithConfig(&types.Config{
+		KDF: types.KDFConfig{Algorithm: KDFNamePBKDF2, Iterations: 1000},
+		// Uncompressed, so payload sizes map directly onto chunks
+		Compression: CompressionNameNone,
+	})
+}
+
+// encryptStream encrypts data through EncryptStream, writing it in small pieces
+func encryptStream(t *testing.T, service *Service, data []byte, password string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w, err := service.EncryptStream(&buf, types.EncryptOptions{Password: secret.FromString(password)})
+	if err != nil {
+		t.Fatalf("EncryptStream failed: %v", err)
+	}
+	for rest := data; len(rest) > 0; {
+		n := 1000
+		if n > len(rest) {
+			n = len(rest)
+		}
+		if _, err := w.Write(rest[:n]); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		rest = rest[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// decryptStream decrypts data through DecryptStream and reads it to the end
+func decryptStream(service *Service, data []byte, password string) ([]byte, error) {
+	r, err := service.DecryptStream(bytes.NewReader(data), types.DecryptOptions{Password: secret.FromString(password)})
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestService_StreamRoundTrip(t *testing.T) {
+	service := fastService()
+	password := "stream password"
+	sealedSize := ChunkSize + 16
+
+	tests := []struct {
+		name       string
+		size       int
+		wantChunks int
+	}{
+		{"Empty", 0, 1},
+		{"Single byte", 1, 1},
+		{"Exactly one chunk", ChunkSize, 1},
+		{"One chunk and a byte", ChunkSize + 1, 2},
+		{"Several chunks", 3*ChunkSize + 123, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := make([]byte, tt.size)
+			if _, err := rand.Read(data); err != nil {
+				t.Fatalf("rand.Read failed: %v", err)
+			}
+
+			encrypted := encryptStream(t, service, data, password)
+
+			payload := len(encrypted) - payloadOffset(t, encrypted)
+			if chunks := (payload + sealedSize - 1) / sealedSize; chunks != tt.wantChunks {
+				t.Errorf("Payload has %d chunks, want %d", chunks, tt.wantChunks)
+			}
+
+			decrypted, err := decryptStream(service, encrypted, password)
+			if err != nil {
+				t.Fatalf("DecryptStream failed: %v", err)
+			}
+			if !bytes.Equal(data, decrypted) {
+				t.Error("Decrypted stream doesn't match original")
+			}
+		})
+	}
+}
+
+func TestService_StreamDetectsTampering(t *testing.T) {
+	service := fastService()
+	password := "stream password"
+	sealedSize := ChunkSize + 16
+
+	data := make([]byte, 3*ChunkSize+500)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatalf("rand.Read failed: %v", err)
+	}
+	encrypted := encryptStream(t, service, data, password)
+
+	// The header and the index segment that follows it
+	headerLen := payloadOffset(t, encrypted)
+	header := encrypted[:headerLen]
+	chunk := func(i int) []byte {
+		start := headerLen + i*sealedSize
+		end := start + sealedSize
+		if end > len(encrypted) {
+			end = len(encrypted)
+		}
+		return encrypted[start:end]
+	}
+	join := func(parts ...[]byte) []byte {
+		var out []byte
+		for _, part := range parts {
+			out = append(out, part...)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"Truncated at chunk boundary", join(header, chunk(0), chunk(1), chunk(2))},
+		{"Truncated mid chunk", encrypted[:len(encrypted)-10]},
+		{"Header only", header},
+		{"Chunks swapped", join(header, chunk(1), chunk(0), chunk(2), chunk(3))},
+		{"Chunk duplicated", join(header, chunk(0), chunk(0), chunk(1), chunk(2), chunk(3))},
+		{"Final chunk dropped and replaced", join(header, chunk(0), chunk(1), chunk(3))},
+		{"Trailing data", join(encrypted, []byte("extra"))},
+		{"Flipped ciphertext bit", func() []byte {
+			out := join(encrypted)
+			out[headerLen+sealedSize+100] ^= 0x01
+			return out
+		}()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decryptStream(service, tt.data, password); err == nil {
+				t.Error("Expected DecryptStream to detect the modification")
+			}
+		})
+	}
+}
+
+func TestService_StreamReleasesOnlyAuthenticatedChunks(t *testing.T) {
+	service := fastService()
+	password := "stream password"
+
+	data := bytes.Repeat([]byte("A"), 2*ChunkSize+1)
+	encrypted := encryptStream(t, service, data, password)
+	encrypted[len(encrypted)-1] ^= 0x01
+
+	r, err := service.DecryptStream(bytes.NewReader(encrypted), types.DecryptOptions{Password: secret.FromString(password)})
+	if err != nil {
+		t.Fatalf("DecryptStream failed: %v", err)
+	}
+
+	got, err := io.ReadAll(r)
+	if err == nil {
+		t.Fatal("Expected reading a corrupted final chunk to fail")
+	}
+	if len(got) != 2*ChunkSize || !bytes.Equal(got, data[:2*ChunkSize]) {
+		t.Errorf("Got %d bytes before the error, want the %d authenticated bytes", len(got), 2*ChunkSize)
+	}
+}
+
+func TestService_StreamWrongPassword(t *testing.T) {
+	service := fastService()
+	encrypted := encryptStream(t, service, []byte("KEY=value"), "right password")
+
+	if _, err := service.DecryptStream(bytes.NewReader(encrypted), types.DecryptOptions{Password: secret.FromString("wrong password")}); err == nil {
+		t.Error("Expected DecryptStream with wrong password to fail before returning a reader")
+	}
+}
+
+func TestService_DecryptIndex(t *testing.T) {
+	service := fastService()
+	index := []byte(`{"files":[".env"]}`)
+	data := []byte("API_KEY=indexed")
+
+	var buf bytes.Buffer
+	w, err := service.EncryptStream(&buf, types.EncryptOptions{Password: secret.FromString("password"), Index: index})
+	if err != nil {
+		t.Fatalf("EncryptStream failed: %v", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	encrypted := buf.Bytes()
+	offset := payloadOffset(t, encrypted)
+
+	// Only the header and the index are read until the payload is used
+	unreadable := io.MultiReader(bytes.NewReader(encrypted[:offset]), failingReader{})
+	gotIndex, _, err := service.DecryptIndex(unreadable, types.DecryptOptions{Password: secret.FromString("password")})
+	if err != nil {
+		t.Fatalf("DecryptIndex without the payload failed: %v", err)
+	}
+	if !bytes.Equal(gotIndex, index) {
+		t.Errorf("Index = %q, want %q", gotIndex, index)
+	}
+
+	gotIndex, payload, err := service.DecryptIndex(bytes.NewReader(encrypted), types.DecryptOptions{Password: secret.FromString("password")})
+	if err != nil {
+		t.Fatalf("DecryptIndex failed: %v", err)
+	}
+	decrypted, err := io.ReadAll(payload)
+	if err != nil {
+		t.Fatalf("Reading the payload failed: %v", err)
+	}
+	if !bytes.Equal(gotIndex, index) || !bytes.Equal(decrypted, data) {
+		t.Error("Index or payload doesn't match the original")
+	}
+
+	// DecryptStream skips the index
+	decrypted, err = decryptStream(service, encrypted, "password")
+	if err != nil || !bytes.Equal(decrypted, data) {
+		t.Errorf("DecryptStream = %q, %v; want the payload alone", decrypted, err)
+	}
+
+	headerLen := offset - 4 - len(index) - 16
+	for name, tampered := range map[string][]byte{
+		"flipped index bit": func() []byte {
+			out := append([]byte(nil), encrypted...)
+			out[headerLen+4] ^= 0x01
+			return out
+		}(),
+		"truncated index": encrypted[:headerLen+10],
+		"oversized length": func() []byte {
+			out := append([]byte(nil), encrypted...)
+			binary.BigEndian.PutUint32(out[headerLen:], MaxIndexSize+1)
+			return out
+		}(),
+	} {
+		if _, _, err := service.DecryptIndex(bytes.NewReader(tampered), types.DecryptOptions{Password: secret.FromString("password")}); err == nil {
+			t.Errorf("Expected DecryptIndex to reject a %s", name)
+		}
+	}
+}
+
+// failingReader fails every read
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("payload must not be read")
+}
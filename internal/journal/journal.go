@@ -0,0 +1,449 @@
+// Package journal records what each unpack changed in .goingenv/journal, so
+// that the most recent unpack can be undone.
+//
+// Every unpack that writes files adds an entry, a JSON file named after the
+// time of the unpack. It lists the files the unpack created and replaced with
+// the checksums before and after, the backup holding the replaced versions
+// and the directories the unpack created. Undoing an entry marks it as undone,
+// so the next undo goes one unpack further back. Entries of unpacks that
+// replaced files without a backup are kept, but cannot be undone and are
+// passed over.
+package journal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"goingenv/internal/backup"
+	"goingenv/internal/config"
+	"goingenv/pkg/types"
+	"goingenv/pkg/utils"
+)
+
+const (
+	// ActionCreated marks a file that did not exist before the unpack
+	ActionCreated = "created"
+	// ActionReplaced marks a file that the unpack replaced
+	ActionReplaced = "replaced"
+
+	// entryExt is the file extension of journal entries
+	entryExt = ".json"
+	// idFormat is the time layout of entry IDs
+	idFormat = "20060102-150405"
+)
+
+// Entry describes one unpack
+type Entry struct {
+	ID          string       `json:"id"`
+	Operation   string       `json:"operation"`
+	CreatedAt   time.Time    `json:"created_at"`
+	Archive     string       `json:"archive"`
+	TargetDir   string       `json:"target_dir"`
+	Backup      string       `json:"backup,omitempty"` // ID of the backup holding replaced files
+	Files       []FileChange `json:"files"`
+	CreatedDirs []string     `json:"created_dirs,omitempty"`
+	UndoneAt    *time.Time   `json:"undone_at,omitempty"`
+}
+
+// FileChange is a file written by an unpack
+type FileChange struct {
+	RelativePath     string `json:"relative_path"`
+	Action           string `json:"action"`                      // ActionCreated or ActionReplaced
+	Checksum         string `json:"checksum"`                    // SHA-256 after the unpack
+	PreviousChecksum string `json:"previous_checksum,omitempty"` // SHA-256 before, for replaced files
+}
+
+// Journal manages the entries in a directory
+type Journal struct {
+	dir string
+}
+
+// New creates a journal for the entries in dir
+func New(dir string) *Journal {
+	return &Journal{dir: dir}
+}
+
+// DefaultDir returns the journal directory of the current project
+func DefaultDir() string {
+	return filepath.Join(config.GetGoingEnvDir(), "journal")
+}
+
+// List returns all entries, oldest first
+func (j *Journal) List() ([]*Entry, error) {
+	names, err := os.ReadDir(j.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	var entries []*Entry
+	for _, name := range names {
+		if name.IsDir() || !strings.HasSuffix(name.Name(), entryExt) {
+			continue
+		}
+		entry, err := j.load(strings.TrimSuffix(name.Name(), entryExt))
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.Slice(entries, func(a, b int) bool {
+		if !entries[a].CreatedAt.Equal(entries[b].CreatedAt) {
+			return entries[a].CreatedAt.Before(entries[b].CreatedAt)
+		}
+		return entries[a].ID < entries[b].ID
+	})
+	return entries, nil
+}
+
+// Last returns the most recent entry that has not been undone and can be, or
+// nil if there is none, together with the more recent entries passed over
+// because they cannot be undone, newest first
+func (j *Journal) Last() (*Entry, []*Entry, error) {
+	entries, err := j.List()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var skipped []*Entry
+	for i := len(entries) - 1; i >= 0; i-- {
+		switch {
+		case entries[i].UndoneAt != nil:
+		case !entries[i].Reversible():
+			skipped = append(skipped, entries[i])
+		default:
+			return entries[i], skipped, nil
+		}
+	}
+	return nil, skipped, nil
+}
+
+// Reversible reports whether the unpack of entry can be undone, which needs
+// the previous version of every file it replaced in its backup. An unpack run
+// with --backup=false that replaced files cannot be undone.
+func (e *Entry) Reversible() bool {
+	for _, file := range e.Files {
+		if file.Action == ActionReplaced && (e.Backup == "" || file.PreviousChecksum == "") {
+			return false
+		}
+	}
+	return true
+}
+
+// NewUnpack returns a recorder for an unpack of archive whose replaced files
+// are saved in snapshot, which may be nil
+func (j *Journal) NewUnpack(archive string, snapshot *backup.Snapshot) *Recorder {
+	return &Recorder{journal: j, archive: archive, snapshot: snapshot}
+}
+
+// Undo restores the files of entry to what they were before the unpack:
+// created files are removed, replaced files are restored from the backup and
+// directories the unpack created are removed if they are empty. Files that
+// changed since the unpack are only touched with force. Everything undo
+// changes is saved in a new backup in store first. Undo returns that backup,
+// or nil if nothing was saved.
+func (j *Journal) Undo(entry *Entry, store *backup.Store, force bool) (*backup.Snapshot, error) {
+	if entry.UndoneAt != nil {
+		return nil, fmt.Errorf("unpack %s was already undone", entry.ID)
+	}
+	if !entry.Reversible() {
+		return nil, &IrreversibleError{ID: entry.ID}
+	}
+
+	plan, err := j.Check(entry, store)
+	if err != nil {
+		return nil, err
+	}
+	if len(plan.Changed) > 0 && !force {
+		return nil, &ChangedError{Files: plan.Changed}
+	}
+
+	// Save the current versions, so the undo can be undone in turn
+	snapshot := store.NewSnapshot("undo of unpack " + entry.ID)
+	for _, file := range entry.Files {
+		path := filepath.Join(entry.TargetDir, filepath.FromSlash(file.RelativePath))
+		if _, err := os.Lstat(path); err != nil {
+			continue
+		}
+		if err := snapshot.Add(entry.TargetDir, file.RelativePath); err != nil {
+			snapshot.Discard()
+			return nil, fmt.Errorf("failed to back up %s: %w", path, err)
+		}
+	}
+	if err := snapshot.Commit(); err != nil {
+		snapshot.Discard()
+		return nil, fmt.Errorf("failed to save backup: %w", err)
+	}
+
+	if len(plan.Restore) > 0 {
+		if _, err := store.Restore(entry.Backup, plan.Restore, nil); err != nil {
+			return snapshot, err
+		}
+	}
+
+	for _, file := range entry.Files {
+		if file.Action != ActionCreated {
+			continue
+		}
+		path := filepath.Join(entry.TargetDir, filepath.FromSlash(file.RelativePath))
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return snapshot, fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+	}
+
+	// Children first; directories that now hold other files are kept
+	for i := len(entry.CreatedDirs) - 1; i >= 0; i-- {
+		os.Remove(filepath.Join(entry.TargetDir, filepath.FromSlash(entry.CreatedDirs[i])))
+	}
+
+	now := time.Now()
+	entry.UndoneAt = &now
+	if err := j.write(entry); err != nil {
+		return snapshot, err
+	}
+
+	if snapshot.ID() == "" {
+		return nil, nil
+	}
+	return snapshot, nil
+}
+
+// Plan describes what undoing an entry involves
+type Plan struct {
+	Remove  []string // created files that will be removed
+	Restore []string // replaced files that will be restored from the backup
+	Changed []string // files that changed since the unpack
+}
+
+// Check works out what undoing entry involves without changing anything. It
+// fails if a replaced file cannot be restored because its backup is missing
+// or does not match the journal.
+func (j *Journal) Check(entry *Entry, store *backup.Store) (*Plan, error) {
+	plan := &Plan{}
+
+	var saved map[string]string
+	for _, file := range entry.Files {
+		if !filepath.IsLocal(filepath.FromSlash(file.RelativePath)) {
+			return nil, fmt.Errorf("journal entry %s lists unsafe path %q", entry.ID, file.RelativePath)
+		}
+		path := filepath.Join(entry.TargetDir, filepath.FromSlash(file.RelativePath))
+
+		checksum, err := utils.CalculateFileChecksum(path)
+		switch {
+		case err == nil && checksum != file.Checksum:
+			plan.Changed = append(plan.Changed, file.RelativePath)
+		case err != nil && !os.IsNotExist(err):
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+
+		switch file.Action {
+		case ActionCreated:
+			if err == nil {
+				plan.Remove = append(plan.Remove, file.RelativePath)
+			}
+		case ActionReplaced:
+			if saved == nil {
+				saved, err = backupChecksums(entry, store)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if saved[file.RelativePath] != file.PreviousChecksum {
+				return nil, fmt.Errorf("backup %s does not hold the previous version of %s", entry.Backup, file.RelativePath)
+			}
+			plan.Restore = append(plan.Restore, file.RelativePath)
+		default:
+			return nil, fmt.Errorf("journal entry %s has unknown action %q", entry.ID, file.Action)
+		}
+	}
+
+	return plan, nil
+}
+
+// ChangedError reports files that changed since the unpack being undone
+type ChangedError struct {
+	Files []string
+}
+
+func (e *ChangedError) Error() string {
+	return fmt.Sprintf("%d files changed since the unpack: %s", len(e.Files), strings.Join(e.Files, ", "))
+}
+
+// IrreversibleError reports an unpack that replaced files without a backup
+type IrreversibleError struct {
+	ID string
+}
+
+func (e *IrreversibleError) Error() string {
+	return fmt.Sprintf("unpack %s replaced files without a backup and cannot be undone", e.ID)
+}
+
+// backupChecksums returns the checksums of the files in the backup of entry
+func backupChecksums(entry *Entry, store *backup.Store) (map[string]string, error) {
+	if !entry.Reversible() {
+		return nil, &IrreversibleError{ID: entry.ID}
+	}
+	manifest, err := store.Load(entry.Backup)
+	if err != nil {
+		return nil, err
+	}
+
+	checksums := make(map[string]string, len(manifest.Files))
+	for _, file := range manifest.Files {
+		checksums[file.RelativePath] = file.Checksum
+	}
+	return checksums, nil
+}
+
+// load reads the entry with the given ID. It returns nil for an empty file,
+// an ID reserved by an unpack that did not get to write its entry.
+func (j *Journal) load(id string) (*Entry, error) {
+	data, err := os.ReadFile(filepath.Join(j.dir, id+entryExt))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read journal entry %s: %w", id, err)
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("failed to parse journal entry %s: %w", id, err)
+	}
+	entry.ID = id
+
+	return &entry, nil
+}
+
+// write stores entry atomically
+func (j *Journal) write(entry *Entry) error {
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode journal entry: %w", err)
+	}
+	if err := utils.WriteFileAtomic(filepath.Join(j.dir, entry.ID+entryExt), data, 0600); err != nil {
+		return fmt.Errorf("failed to write journal entry: %w", err)
+	}
+	return nil
+}
+
+// create makes the journal directory if necessary and reserves a new entry
+// ID, named after the current time with a counter added if an entry was
+// already written in the same second
+func (j *Journal) create(now time.Time) (string, error) {
+	if err := os.MkdirAll(j.dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create journal directory: %w", err)
+	}
+
+	// The journal holds checksums of secrets and must never be committed
+	gitignore := filepath.Join(j.dir, ".gitignore")
+	if _, err := os.Stat(gitignore); os.IsNotExist(err) {
+		if err := utils.WriteFileAtomic(gitignore, []byte("# The journal lists checksums of environment files\n*\n"), 0644); err != nil {
+			return "", fmt.Errorf("failed to create .gitignore: %w", err)
+		}
+	}
+
+	base := now.Format(idFormat)
+	for n := 1; ; n++ {
+		id := base
+		if n > 1 {
+			id = fmt.Sprintf("%s-%d", base, n)
+		}
+		f, err := os.OpenFile(filepath.Join(j.dir, id+entryExt), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			f.Close()
+			return id, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return "", fmt.Errorf("failed to create journal entry: %w", err)
+		}
+	}
+}
+
+// Recorder writes the journal entry of one unpack. It implements
+// types.UnpackJournal.
+type Recorder struct {
+	journal  *Journal
+	archive  string
+	snapshot *backup.Snapshot
+	entry    *Entry
+}
+
+// Entry returns the entry written, or nil if the unpack wrote no files. Use
+// Entry.Reversible to tell whether the unpack can be undone.
+func (r *Recorder) Entry() *Entry {
+	return r.entry
+}
+
+// Record writes the entry for the changes in record. Nothing is written if
+// no files were written.
+func (r *Recorder) Record(record types.UnpackRecord) error {
+	if len(record.Files) == 0 {
+		return nil
+	}
+
+	entry := &Entry{
+		Operation:   "unpack",
+		CreatedAt:   time.Now(),
+		Archive:     r.archive,
+		TargetDir:   record.TargetDir,
+		CreatedDirs: record.CreatedDirs,
+	}
+
+	previous := make(map[string]string)
+	if r.snapshot != nil {
+		entry.Backup = r.snapshot.ID()
+		for _, file := range r.snapshot.Files() {
+			previous[file.RelativePath] = file.Checksum
+		}
+	}
+
+	for _, file := range record.Files {
+		change := FileChange{
+			RelativePath: file.RelativePath,
+			Action:       ActionCreated,
+			Checksum:     file.Checksum,
+		}
+		if file.Replaced {
+			change.Action = ActionReplaced
+			change.PreviousChecksum = previous[file.RelativePath]
+		}
+		entry.Files = append(entry.Files, change)
+	}
+
+	id, err := r.journal.create(entry.CreatedAt)
+	if err != nil {
+		return err
+	}
+	entry.ID = id
+	if err := r.journal.write(entry); err != nil {
+		os.Remove(filepath.Join(r.journal.dir, id+entryExt))
+		return err
+	}
+
+	r.entry = entry
+	return nil
+}
+
+// Discard removes the entry written by Record
+func (r *Recorder) Discard() error {
+	if r.entry == nil {
+		return nil
+	}
+	if err := os.Remove(filepath.Join(r.journal.dir, r.entry.ID+entryExt)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove journal entry: %w", err)
+	}
+	r.entry = nil
+	return nil
+}
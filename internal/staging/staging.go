@@ -0,0 +1,281 @@
+// Package staging writes a set of files into a directory all or nothing.
+// Files are first written to a staging directory inside the target and only
+// moved into place once every one of them is ready; any failure before or
+// while they are moved rolls the directory back to how it was.
+package staging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"goingenv/pkg/types"
+)
+
+// Transaction writes files into a staging directory inside its root and
+// moves them into place only once every file has been written and verified.
+// Each directory it creates and each rename it makes is recorded, so a
+// failure at any point can be undone and the root left as it was.
+type Transaction struct {
+	root    string
+	staging string
+	files   []*File
+	created []string // directories created, parents first
+	renames []rename // renames made so far, in order
+	backup  types.BackupSnapshot
+	journal types.UnpackJournal
+}
+
+// File is a file written to the staging directory. The caller writes the
+// new contents to Path, which does not exist yet, and sets Checksum.
+type File struct {
+	Path     string // staging path to write the file to
+	Target   string // where the file is moved on commit
+	Checksum string // SHA-256 of the written file, for the journal
+	replaced bool   // set by Commit if Target existed
+}
+
+// rename is a rename made by a transaction
+type rename struct {
+	from, to string
+}
+
+// Begin creates root if necessary and a staging directory in it, named
+// prefix followed by a random suffix. The staging directory is on the same
+// file system as the target, so committing a file is a rename.
+func Begin(root, prefix string) (*Transaction, error) {
+	tx := &Transaction{root: root}
+	if err := tx.mkdirAll(root); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	staging, err := os.MkdirTemp(root, prefix+"*")
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	tx.staging = staging
+
+	return tx, nil
+}
+
+// Root returns the directory the transaction writes to
+func (tx *Transaction) Root() string {
+	return tx.root
+}
+
+// Stage adds a file for target and returns it, to be written to its
+// staging path
+func (tx *Transaction) Stage(target string) *File {
+	file := &File{
+		Path:   filepath.Join(tx.staging, strconv.Itoa(len(tx.files))),
+		Target: target,
+	}
+	tx.files = append(tx.files, file)
+	return file
+}
+
+// Commit moves every staged file to its target. An existing target is
+// saved in backup, if it is not nil, and moved into the staging directory,
+// so that it can be restored until the transaction is done. The changes are
+// then recorded in journal, if it is not nil. On error the caller must roll
+// back.
+func (tx *Transaction) Commit(backup types.BackupSnapshot, journal types.UnpackJournal) error {
+	tx.backup = backup
+
+	for _, file := range tx.files {
+		// The target may have changed since the file was staged
+		if err := CheckNoSymlinks(tx.root, file.Target); err != nil {
+			return fmt.Errorf("refusing to write %s: %w", file.Target, err)
+		}
+		if err := tx.mkdirAll(filepath.Dir(file.Target)); err != nil {
+			return err
+		}
+
+		if _, err := os.Lstat(file.Target); err == nil {
+			if backup != nil {
+				rel, err := filepath.Rel(tx.root, file.Target)
+				if err == nil {
+					err = backup.Add(tx.root, rel)
+				}
+				if err != nil {
+					return fmt.Errorf("failed to back up %s: %w", file.Target, err)
+				}
+			}
+			if err := tx.rename(file.Target, file.Path+".orig"); err != nil {
+				return fmt.Errorf("failed to replace %s: %w", file.Target, err)
+			}
+			file.replaced = true
+		}
+
+		if err := tx.rename(file.Path, file.Target); err != nil {
+			return fmt.Errorf("failed to move %s into place: %w", file.Target, err)
+		}
+	}
+
+	if backup != nil {
+		if err := backup.Commit(); err != nil {
+			return fmt.Errorf("failed to save backup: %w", err)
+		}
+	}
+
+	if journal != nil {
+		record, err := tx.record()
+		if err == nil {
+			err = journal.Record(record)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write journal: %w", err)
+		}
+		tx.journal = journal
+	}
+
+	// Replaced files are only dropped with the staging directory
+	if err := os.RemoveAll(tx.staging); err != nil {
+		return fmt.Errorf("failed to remove staging directory: %w", err)
+	}
+	return nil
+}
+
+// Rollback undoes the renames in reverse order, then removes the staging
+// directory and the directories the transaction created. If a rename cannot
+// be undone, the staging directory is kept, since it may hold the original
+// of a replaced file.
+func (tx *Transaction) Rollback() error {
+	var failed []string
+	for i := len(tx.renames) - 1; i >= 0; i-- {
+		r := tx.renames[i]
+		if err := os.Rename(r.to, r.from); err != nil {
+			failed = append(failed, r.from)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("could not restore %v; originals are kept in %s", failed, tx.staging)
+	}
+
+	// The originals are back, so their backup and journal entry are not needed
+	if tx.journal != nil {
+		if err := tx.journal.Discard(); err != nil {
+			return err
+		}
+	}
+	if tx.backup != nil {
+		if err := tx.backup.Discard(); err != nil {
+			return err
+		}
+	}
+
+	if tx.staging != "" {
+		if err := os.RemoveAll(tx.staging); err != nil {
+			return fmt.Errorf("failed to remove staging directory: %w", err)
+		}
+	}
+
+	// Remove created directories children first; ones that are not empty
+	// were in use before the rollback and are kept
+	for i := len(tx.created) - 1; i >= 0; i-- {
+		os.Remove(tx.created[i])
+	}
+
+	return nil
+}
+
+// record describes the committed changes for the journal
+func (tx *Transaction) record() (types.UnpackRecord, error) {
+	root, err := filepath.Abs(tx.root)
+	if err != nil {
+		return types.UnpackRecord{}, err
+	}
+	record := types.UnpackRecord{TargetDir: root}
+
+	for _, file := range tx.files {
+		rel, err := filepath.Rel(tx.root, file.Target)
+		if err != nil {
+			return types.UnpackRecord{}, err
+		}
+		record.Files = append(record.Files, types.UnpackedFile{
+			RelativePath: filepath.ToSlash(rel),
+			Checksum:     file.Checksum,
+			Replaced:     file.replaced,
+		})
+	}
+
+	// Only directories inside the target; the target itself and its parents
+	// are not the unpack's to remove
+	for _, dir := range tx.created {
+		rel, err := filepath.Rel(tx.root, dir)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		record.CreatedDirs = append(record.CreatedDirs, filepath.ToSlash(rel))
+	}
+
+	return record, nil
+}
+
+// rename renames from to to and records it
+func (tx *Transaction) rename(from, to string) error {
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	tx.renames = append(tx.renames, rename{from: from, to: to})
+	return nil
+}
+
+// mkdirAll creates dir and any missing parents like os.MkdirAll, recording
+// each directory it creates
+func (tx *Transaction) mkdirAll(dir string) error {
+	var missing []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Lstat(d); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+		missing = append(missing, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := os.Mkdir(missing[i], 0755); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+		tx.created = append(tx.created, missing[i])
+	}
+
+	return nil
+}
+
+// CheckNoSymlinks reports an error if an existing component of path below
+// root, including path itself, is a symbolic link. Writing through a link
+// planted in the target directory would otherwise escape it. root itself may
+// be a link, since the user chose it.
+func CheckNoSymlinks(root, path string) error {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return err
+	}
+
+	current := root
+	for _, element := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, element)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			// Nothing below a missing component exists yet either
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%s is a symbolic link", current)
+		}
+	}
+
+	return nil
+}
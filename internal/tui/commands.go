@@ -7,6 +7,9 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 
 	"goingenv/internal/config"
+	"goingenv/internal/crypto"
+	"goingenv/internal/journal"
+	"goingenv/pkg/secret"
 	"goingenv/pkg/types"
 	"goingenv/pkg/utils"
 )
@@ -33,21 +36,30 @@ func ScanFilesCmd(app *types.App) tea.Cmd {
 }
 
 // PackFilesCmd packs files into an encrypted archive asynchronously
-func PackFilesCmd(app *types.App, files []types.EnvFile, password string) tea.Cmd {
+func PackFilesCmd(app *types.App, files []types.EnvFile, password *secret.Buffer) tea.Cmd {
 	return func() tea.Msg {
+		defer password.Destroy()
+
 		// Generate output path
 		outputPath := config.GetDefaultArchivePath()
 
+		// Also encrypt to the project's recipients, if any
+		recipients, err := config.LoadRecipients()
+		if err != nil {
+			return ErrorMsg(fmt.Sprintf("Error reading recipients: %v", err))
+		}
+
 		// Create pack options
 		packOpts := types.PackOptions{
 			Files:       files,
 			OutputPath:  outputPath,
 			Password:    password,
+			Recipients:  recipients,
 			Description: fmt.Sprintf("Environment files archive created on %s", time.Now().Format("2006-01-02 15:04:05")),
 		}
 
 		// Pack files
-		err := app.Archiver.Pack(packOpts)
+		err = app.Archiver.Pack(packOpts)
 		if err != nil {
 			return ErrorMsg(fmt.Sprintf("Error packing files: %v", err))
 		}
@@ -56,32 +68,87 @@ func PackFilesCmd(app *types.App, files []types.EnvFile, password string) tea.Cm
 	}
 }
 
-// UnpackFilesCmd unpacks files from an encrypted archive asynchronously
-func UnpackFilesCmd(app *types.App, password, archivePath string) tea.Cmd {
+// UnpackFilesCmd unpacks the selected files from an encrypted archive
+// asynchronously. A nil selection unpacks every file.
+func UnpackFilesCmd(app *types.App, password *secret.Buffer, archivePath string, selection *types.FileSelection) tea.Cmd {
 	return func() tea.Msg {
+		defer password.Destroy()
+
+		// Only unpack archives from trusted signers, if the project lists any
+		trust, err := trustedSignerCheck()
+		if err != nil {
+			return ErrorMsg(fmt.Sprintf("Error unpacking files: %v", err))
+		}
+
+		// Count the selected files from the index as they are extracted
+		var selected, total int
+		confirm := func(archive *types.Archive) (bool, bool, error) {
+			selected = len(selection.Filter(archive.Files))
+			total = len(archive.Files)
+			return true, false, nil
+		}
+
 		// Create unpack options
 		unpackOpts := types.UnpackOptions{
 			ArchivePath: archivePath,
 			Password:    password,
 			TargetDir:   ".",
 			Overwrite:   false, // Default to safe mode in TUI
-			Backup:      false,
+			Select:      selection,
+			Confirm:     confirm,
+			Trust:       trust,
+			// Existing files are never replaced here, so there is nothing to
+			// back up, but the created files can still be undone
+			Journal: journal.New(journal.DefaultDir()).NewUnpack(archivePath, nil),
 		}
 
 		// Unpack files
-		err := app.Archiver.Unpack(unpackOpts)
+		err = app.Archiver.Unpack(unpackOpts)
 		if err != nil {
 			return ErrorMsg(fmt.Sprintf("Error unpacking files: %v", err))
 		}
 
+		if selected < total {
+			return UnpackCompleteMsg(fmt.Sprintf("%d of %d files successfully unpacked to current directory", selected, total))
+		}
 		return UnpackCompleteMsg("Files successfully unpacked to current directory")
 	}
 }
 
+// trustedSignerCheck returns a TrustFunc that refuses archives that are
+// unsigned or signed by an unknown key when .goingenv/trusted_signers lists
+// any keys, or nil if it lists none. The TUI has no way to override this; use
+// 'goingenv unpack --allow-untrusted' instead.
+func trustedSignerCheck() (types.TrustFunc, error) {
+	trusted, err := config.LoadTrustedSigners()
+	if err != nil {
+		return nil, err
+	}
+	if len(trusted) == 0 {
+		return nil, nil
+	}
+
+	return func(signer *types.SignatureInfo) error {
+		if signer == nil {
+			return fmt.Errorf("archive is not signed by a trusted signer")
+		}
+		_, ok, err := crypto.FindTrustedSigner(signer, trusted)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("archive is signed by untrusted key %s", signer.Fingerprint)
+		}
+		return nil
+	}, nil
+}
+
 // ListFilesCmd lists archive contents asynchronously
-func ListFilesCmd(app *types.App, password, archivePath string) tea.Cmd {
+func ListFilesCmd(app *types.App, password *secret.Buffer, archivePath string) tea.Cmd {
 	return func() tea.Msg {
-		archive, err := app.Archiver.List(archivePath, password)
+		defer password.Destroy()
+
+		archive, err := app.Archiver.List(types.ListOptions{ArchivePath: archivePath, Password: password})
 		if err != nil {
 			return ErrorMsg(fmt.Sprintf("Error listing archive: %v", err))
 		}
@@ -102,10 +169,12 @@ func ProgressCmd(duration time.Duration) tea.Cmd {
 }
 
 // ValidatePasswordCmd validates a password against an archive
-func ValidatePasswordCmd(app *types.App, archivePath, password string) tea.Cmd {
+func ValidatePasswordCmd(app *types.App, archivePath string, password *secret.Buffer) tea.Cmd {
 	return func() tea.Msg {
+		defer password.Destroy()
+
 		// Try to list the archive to validate password
-		_, err := app.Archiver.List(archivePath, password)
+		_, err := app.Archiver.List(types.ListOptions{ArchivePath: archivePath, Password: password})
 		if err != nil {
 			return ErrorMsg("Invalid password or corrupted archive")
 		}
@@ -114,9 +183,11 @@ func ValidatePasswordCmd(app *types.App, archivePath, password string) tea.Cmd {
 }
 
 // CheckArchiveIntegrityCmd checks the integrity of an archive
-func CheckArchiveIntegrityCmd(app *types.App, archivePath, password string) tea.Cmd {
+func CheckArchiveIntegrityCmd(app *types.App, archivePath string, password *secret.Buffer) tea.Cmd {
 	return func() tea.Msg {
-		archive, err := app.Archiver.List(archivePath, password)
+		defer password.Destroy()
+
+		archive, err := app.Archiver.List(types.ListOptions{ArchivePath: archivePath, Password: password})
 		if err != nil {
 			return ErrorMsg(fmt.Sprintf("Archive integrity check failed: %v", err))
 		}
@@ -214,10 +285,17 @@ func formatArchiveContents(archive *types.Archive) string {
 // Batch operations commands
 
 // BatchPackCmd packs multiple directories in sequence
-func BatchPackCmd(app *types.App, directories []string, password string) tea.Cmd {
+func BatchPackCmd(app *types.App, directories []string, password *secret.Buffer) tea.Cmd {
 	return func() tea.Msg {
+		defer password.Destroy()
+
 		var results []string
 
+		recipients, err := config.LoadRecipients()
+		if err != nil {
+			return ErrorMsg(fmt.Sprintf("Error reading recipients: %v", err))
+		}
+
 		for _, dir := range directories {
 			scanOpts := types.ScanOptions{
 				RootPath: dir,
@@ -244,6 +322,7 @@ func BatchPackCmd(app *types.App, directories []string, password string) tea.Cmd
 				Files:       files,
 				OutputPath:  outputPath,
 				Password:    password,
+				Recipients:  recipients,
 				Description: fmt.Sprintf("Batch archive from %s", dir),
 			}
 
@@ -262,8 +341,10 @@ func BatchPackCmd(app *types.App, directories []string, password string) tea.Cmd
 // Utility commands for common operations
 
 // QuickPackCmd performs a quick pack operation with default settings
-func QuickPackCmd(app *types.App, password string) tea.Cmd {
+func QuickPackCmd(app *types.App, password *secret.Buffer) tea.Cmd {
 	return func() tea.Msg {
+		defer password.Destroy()
+
 		// Scan current directory
 		scanOpts := types.ScanOptions{
 			RootPath: ".",
@@ -279,12 +360,18 @@ func QuickPackCmd(app *types.App, password string) tea.Cmd {
 			return ErrorMsg("No environment files found for quick pack")
 		}
 
+		recipients, err := config.LoadRecipients()
+		if err != nil {
+			return ErrorMsg(fmt.Sprintf("Quick pack failed: %v", err))
+		}
+
 		// Pack with default settings
 		outputPath := config.GetDefaultArchivePath()
 		packOpts := types.PackOptions{
 			Files:       files,
 			OutputPath:  outputPath,
 			Password:    password,
+			Recipients:  recipients,
 			Description: "Quick pack archive",
 		}
 
@@ -10,6 +10,7 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 
 	"goingenv/internal/config"
+	"goingenv/pkg/secret"
 	"goingenv/pkg/types"
 )
 
@@ -44,6 +45,7 @@ type Model struct {
 	message         string
 	error           string
 	selectedArchive string
+	pendingPassword *secret.Buffer // first entry of a new password, awaiting confirmation
 
 	// UI components
 	menu       list.Model
@@ -216,6 +218,8 @@ func (m *Model) SetScreen(screen Screen) {
 	// Reset state when changing screens
 	m.message = ""
 	m.error = ""
+	m.pendingPassword.Destroy()
+	m.pendingPassword = nil
 
 	// Focus/blur components as needed
 	switch screen {
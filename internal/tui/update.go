@@ -4,6 +4,9 @@ import (
 	"fmt"
 
 	tea "github.com/charmbracelet/bubbletea"
+
+	"goingenv/pkg/password"
+	"goingenv/pkg/secret"
 	"goingenv/pkg/types"
 )
 
@@ -80,6 +83,14 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if didSelect, path := m.filepicker.DidSelectFile(msg); didSelect {
 			m.selectedArchive = path
 			m.debugLogger.LogFileOperation("file_selected", path, 0)
+			if m.agentHoldsKey(path) {
+				m.debugLogger.LogOperation("key_agent", "using cached key, skipping password entry")
+				if m.currentScreen == ScreenUnpackSelect {
+					m.SetScreen(ScreenUnpacking)
+					return m, UnpackFilesCmd(m.app, nil, path, nil)
+				}
+				return m, ListFilesCmd(m.app, nil, path)
+			}
 			if m.currentScreen == ScreenUnpackSelect {
 				m.SetScreen(ScreenUnpackPassword)
 			} else {
@@ -170,17 +181,46 @@ func (m *Model) handlePackPasswordKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		m.SetScreen(ScreenMenu)
 		return m, nil
 	case "enter":
-		password := m.textInput.Value()
-		if password == "" {
+		if m.textInput.Value() == "" {
 			m.debugLogger.LogError("pack_password", fmt.Errorf("empty password"))
 			m.SetError("Password cannot be empty")
 			return m, nil
 		}
+		key := secret.FromString(m.textInput.Value())
+		m.textInput.SetValue("")
+
+		// First entry: check the password policy, then ask for confirmation
+		if m.pendingPassword.IsEmpty() {
+			if err := password.CheckPolicy(key, m.app.Config.PasswordPolicy); err != nil {
+				key.Destroy()
+				m.debugLogger.LogError("pack_password", fmt.Errorf("password rejected by policy"))
+				m.error = err.Error()
+				return m, nil
+			}
+			m.pendingPassword = key
+			m.error = ""
+			m.debugLogger.LogOperation("pack_password", "password accepted, awaiting confirmation")
+			return m, nil
+		}
+
+		if !key.Equal(m.pendingPassword) {
+			key.Destroy()
+			m.debugLogger.LogError("pack_password", fmt.Errorf("confirmation mismatch"))
+			m.pendingPassword.Destroy()
+			m.pendingPassword = nil
+			m.error = "Passwords do not match, enter the password again"
+			return m, nil
+		}
+
 		m.debugLogger.LogOperation("pack_execute", fmt.Sprintf("starting pack operation with %d files", len(m.scannedFiles)))
 		m.SetScreen(ScreenPacking)
-		return m, PackFilesCmd(m.app, m.scannedFiles, password)
+		return m, PackFilesCmd(m.app, m.scannedFiles, key)
 	}
-	return m, nil
+
+	// Pass other keys to the text input
+	var cmd tea.Cmd
+	m.textInput, cmd = m.textInput.Update(msg)
+	return m, cmd
 }
 
 // handleUnpackPasswordKeys handles keyboard input during unpack password entry
@@ -191,15 +231,16 @@ func (m *Model) handleUnpackPasswordKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		m.SetScreen(ScreenMenu)
 		return m, nil
 	case "enter":
-		password := m.textInput.Value()
-		if password == "" {
+		if m.textInput.Value() == "" {
 			m.debugLogger.LogError("unpack_password", fmt.Errorf("empty password"))
 			m.SetError("Password cannot be empty")
 			return m, nil
 		}
+		password := secret.FromString(m.textInput.Value())
+		m.textInput.SetValue("")
 		m.debugLogger.LogOperation("unpack_execute", fmt.Sprintf("starting unpack operation for %s", m.selectedArchive))
 		m.SetScreen(ScreenUnpacking)
-		return m, UnpackFilesCmd(m.app, password, m.selectedArchive)
+		return m, UnpackFilesCmd(m.app, password, m.selectedArchive, nil)
 	}
 	return m, nil
 }
@@ -212,12 +253,13 @@ func (m *Model) handleListPasswordKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		m.SetScreen(ScreenMenu)
 		return m, nil
 	case "enter":
-		password := m.textInput.Value()
-		if password == "" {
+		if m.textInput.Value() == "" {
 			m.debugLogger.LogError("list_password", fmt.Errorf("empty password"))
 			m.SetError("Password cannot be empty")
 			return m, nil
 		}
+		password := secret.FromString(m.textInput.Value())
+		m.textInput.SetValue("")
 		m.debugLogger.LogOperation("list_execute", fmt.Sprintf("starting list operation for %s", m.selectedArchive))
 		return m, ListFilesCmd(m.app, password, m.selectedArchive)
 	}
@@ -243,3 +285,13 @@ func (m *Model) handleGenericKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	}
 	return m, nil
 }
+
+// agentHoldsKey reports whether the key agent can open the archive, so no
+// password needs to be entered
+func (m *Model) agentHoldsKey(archivePath string) bool {
+	header, err := m.app.Archiver.ReadHeader(archivePath)
+	if err != nil {
+		return false
+	}
+	return m.app.Crypto.HasCachedKey(header)
+}
@@ -6,7 +6,9 @@ import (
 	"path/filepath"
 
 	"goingenv/internal/config"
+	"goingenv/internal/crypto"
 	"goingenv/internal/scanner"
+	"goingenv/pkg/password"
 	"goingenv/pkg/types"
 	"goingenv/pkg/utils"
 )
@@ -87,8 +89,18 @@ func (m *Model) renderPackPassword() string {
 		view += "\n"
 	}
 
-	view += HeaderStyle.Render("Enter encryption password:") + "\n"
-	view += m.textInput.View() + "\n\n"
+	if m.pendingPassword.IsEmpty() {
+		view += HeaderStyle.Render("Enter encryption password:") + "\n"
+		view += m.textInput.View() + "\n"
+		if value := m.textInput.Value(); value != "" {
+			strength := password.EstimateStrength(value)
+			view += DimStyle.Render(fmt.Sprintf("Strength: %s", strength.Label())) + "\n"
+		}
+		view += "\n"
+	} else {
+		view += HeaderStyle.Render("Confirm encryption password:") + "\n"
+		view += m.textInput.View() + "\n\n"
+	}
 	view += "Press Enter to continue, Esc to go back\n"
 
 	if m.error != "" {
@@ -267,6 +279,16 @@ func (m *Model) renderSettings() string {
 
 	view += HeaderStyle.Render("Configuration:") + "\n"
 	view += fmt.Sprintf("  • Max File Size: %s\n", utils.FormatSize(m.app.Config.MaxFileSize))
+	if cipherID, err := crypto.ParseCipherName(m.app.Config.Cipher); err == nil {
+		view += fmt.Sprintf("  • Cipher: %s\n", cipherID)
+	}
+	if compression, err := crypto.ParseCompressionName(m.app.Config.Compression); err == nil {
+		view += fmt.Sprintf("  • Compression: %s\n", compression)
+	}
+	if kdf, params, err := crypto.KDFFromConfig(m.app.Config.KDF); err == nil {
+		view += fmt.Sprintf("  • Key Derivation: %s (%s)\n", kdf, params.String(kdf))
+	}
+	view += fmt.Sprintf("  • Password Policy: %s\n", password.DescribePolicy(m.app.Config.PasswordPolicy))
 	view += fmt.Sprintf("  • goingenv Directory: %s\n", config.GetGoingEnvDir())
 	view += "\n"
 
@@ -300,7 +322,7 @@ func (m *Model) renderHelp() string {
 
 	view += HeaderStyle.Render("Security Features:") + "\n"
 	view += "  • AES-256-GCM encryption\n"
-	view += "  • PBKDF2 key derivation (100,000 iterations)\n"
+	view += "  • Argon2id or PBKDF2 key derivation (parameters stored per archive)\n"
 	view += "  • SHA-256 file integrity checksums\n"
 	view += "  • Secure random salt and nonce generation\n\n"
 
@@ -0,0 +1,272 @@
+// Package dotenv parses environment files in the dotenv format and compares
+// them key by key.
+//
+// A file holds one KEY=VALUE assignment per line, optionally prefixed with
+// "export". Blank lines and lines starting with # are ignored. Values may be
+// unquoted, in which case a # after whitespace starts a comment, single
+// quoted and taken literally, or double quoted with \n, \r, \t, \", \\ and \$
+// escapes. Quoted values may span several lines. When a key is assigned more
+// than once the last assignment wins, as in the shell.
+//
+// Values are returned as byte slices that share no memory with the input or
+// with each other, so callers can clear them with Wipe once done.
+package dotenv
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"goingenv/pkg/secret"
+)
+
+// Kinds of changes reported by Diff
+const (
+	Added   = "added"
+	Removed = "removed"
+	Changed = "changed"
+)
+
+// Change is a key that differs between two files
+type Change struct {
+	Key  string
+	Kind string // Added, Removed or Changed
+	Old  []byte // value before, nil if Added
+	New  []byte // value after, nil if Removed
+}
+
+// Parse returns the variables assigned in data. The data is never copied into
+// a string, so wiping data and the returned values leaves no copy behind.
+func Parse(data []byte) (map[string][]byte, error) {
+	p := &parser{src: bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), line: 1}
+	vars := make(map[string][]byte)
+
+	for {
+		p.skipSpace()
+		if p.done() {
+			return vars, nil
+		}
+		switch p.peek() {
+		case '\n':
+			p.next()
+			continue
+		case '#':
+			p.skipLine()
+			continue
+		}
+
+		line := p.line
+		key, value, err := p.assignment()
+		if err != nil {
+			Wipe(vars)
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if old, ok := vars[key]; ok {
+			secret.Wipe(old)
+		}
+		vars[key] = value
+	}
+}
+
+// Wipe overwrites every value of vars with zeros
+func Wipe(vars map[string][]byte) {
+	for _, value := range vars {
+		secret.Wipe(value)
+	}
+}
+
+// Diff compares two parsed files and returns the keys that were added,
+// removed or changed going from old to new, sorted by key. The changes refer
+// to the values in old and new rather than copies.
+func Diff(old, new map[string][]byte) []Change {
+	var changes []Change
+	for key, oldValue := range old {
+		newValue, ok := new[key]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Key: key, Kind: Removed, Old: oldValue})
+		case !bytes.Equal(newValue, oldValue):
+			changes = append(changes, Change{Key: key, Kind: Changed, Old: oldValue, New: newValue})
+		}
+	}
+	for key, newValue := range new {
+		if _, ok := old[key]; !ok {
+			changes = append(changes, Change{Key: key, Kind: Added, New: newValue})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
+}
+
+// parser reads assignments from src, tracking the line for error messages
+type parser struct {
+	src  []byte
+	pos  int
+	line int
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *parser) peek() byte {
+	return p.src[p.pos]
+}
+
+func (p *parser) next() byte {
+	c := p.src[p.pos]
+	p.pos++
+	if c == '\n' {
+		p.line++
+	}
+	return c
+}
+
+// skipSpace skips blanks, but not line breaks
+func (p *parser) skipSpace() {
+	for !p.done() && (p.peek() == ' ' || p.peek() == '\t' || p.peek() == '\r') {
+		p.pos++
+	}
+}
+
+// skipLine skips to the start of the next line
+func (p *parser) skipLine() {
+	for !p.done() && p.next() != '\n' {
+	}
+}
+
+// assignment reads one KEY=VALUE line
+func (p *parser) assignment() (string, []byte, error) {
+	key := p.word()
+	if key == "export" {
+		p.skipSpace()
+		if !p.done() && p.peek() != '=' {
+			key = p.word()
+		}
+	}
+	if !validKey(key) {
+		return "", nil, fmt.Errorf("invalid variable name %q", key)
+	}
+
+	p.skipSpace()
+	if p.done() || p.peek() != '=' {
+		return "", nil, fmt.Errorf("expected = after %s", key)
+	}
+	p.next()
+	p.skipSpace()
+
+	var value []byte
+	var err error
+	if !p.done() && (p.peek() == '"' || p.peek() == '\'') {
+		value, err = p.quoted(p.next())
+		if err != nil {
+			return "", nil, err
+		}
+		// Only a comment may follow the closing quote
+		p.skipSpace()
+		if !p.done() && p.peek() != '\n' && p.peek() != '#' {
+			secret.Wipe(value)
+			return "", nil, fmt.Errorf("unexpected text after the closing quote of %s", key)
+		}
+		p.skipLine()
+	} else {
+		value = p.unquoted()
+	}
+
+	return key, value, nil
+}
+
+// word reads up to the next blank, = or line break
+func (p *parser) word() string {
+	start := p.pos
+	for !p.done() && bytes.IndexByte([]byte(" \t\r\n="), p.peek()) < 0 {
+		p.pos++
+	}
+	return string(p.src[start:p.pos])
+}
+
+// quoted reads a value up to the closing quote, which may be on a later line.
+// The value is decoded into a buffer of the raw length, which escapes only
+// shorten, so it is never reallocated and leaves no copies behind.
+func (p *parser) quoted(quote byte) ([]byte, error) {
+	line := p.line
+	end := p.pos
+	for end < len(p.src) && p.src[end] != quote {
+		if p.src[end] == '\\' && quote == '"' {
+			end++
+		}
+		end++
+	}
+	if end >= len(p.src) {
+		return nil, fmt.Errorf("unterminated quoted value starting on line %d", line)
+	}
+
+	b := make([]byte, 0, end-p.pos)
+	for p.pos < end {
+		c := p.next()
+		switch {
+		case c == '\\' && quote == '"':
+			escaped := p.next()
+			switch escaped {
+			case 'n':
+				b = append(b, '\n')
+			case 'r':
+				b = append(b, '\r')
+			case 't':
+				b = append(b, '\t')
+			case '"', '\\', '$':
+				b = append(b, escaped)
+			default:
+				b = append(b, '\\', escaped)
+			}
+		case c == '\r' && p.pos < end && p.peek() == '\n':
+			// Windows line endings inside a value are read as \n
+		default:
+			b = append(b, c)
+		}
+	}
+	p.next() // closing quote
+	return b, nil
+}
+
+// unquoted reads a value to the end of the line, dropping a trailing comment
+// and blanks
+func (p *parser) unquoted() []byte {
+	start := p.pos
+	end := -1
+	for !p.done() && p.peek() != '\n' {
+		if p.peek() == '#' && (p.src[p.pos-1] == ' ' || p.src[p.pos-1] == '\t') {
+			end = p.pos
+			break
+		}
+		p.pos++
+	}
+	if end < 0 {
+		end = p.pos
+	}
+	trimmed := bytes.TrimRight(p.src[start:end], " \t\r")
+	value := make([]byte, len(trimmed))
+	copy(value, trimmed)
+	p.skipLine()
+	return value
+}
+
+// validKey reports whether key is a usable variable name. Dots and dashes are
+// accepted, since some tools read such names from dotenv files.
+func validKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i, r := range key {
+		switch {
+		case r == '_', r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z':
+		case i > 0 && (r >= '0' && r <= '9' || r == '.' || r == '-'):
+		default:
+			return false
+		}
+	}
+	return true
+}
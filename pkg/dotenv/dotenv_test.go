@@ -0,0 +1,113 @@
+package dotenv
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]string
+	}{
+		{"Empty", "", map[string]string{}},
+		{"Comments and blank lines", "# comment\n\n  # indented\nA=1\n", map[string]string{"A": "1"}},
+		{"Spaces around =", "A = 1 \n", map[string]string{"A": "1"}},
+		{"Export prefix", "export A=1\nexport=2\n", map[string]string{"A": "1", "export": "2"}},
+		{"Empty value", "A=\nB=''\n", map[string]string{"A": "", "B": ""}},
+		{"Inline comment", "A=1 # one\nB=#not-a-comment\nC=x#y\n", map[string]string{"A": "1", "B": "#not-a-comment", "C": "x#y"}},
+		{"Single quotes are literal", `A='a \n $B # c'`, map[string]string{"A": `a \n $B # c`}},
+		{"Double quote escapes", `A="line\nnext \"q\" \\ \$ \x" # comment`, map[string]string{"A": "line\nnext \"q\" \\ $ \\x"}},
+		{"Multi-line value", "KEY=\"-----BEGIN-----\nabc\n-----END-----\"\nB=2", map[string]string{"KEY": "-----BEGIN-----\nabc\n-----END-----", "B": "2"}},
+		{"Windows line endings", "A=1\r\nB=\"x\r\ny\"\r\n", map[string]string{"A": "1", "B": "x\ny"}},
+		{"Byte order mark", "\xef\xbb\xbfA=1", map[string]string{"A": "1"}},
+		{"Last assignment wins", "A=1\nA=2\n", map[string]string{"A": "2"}},
+		{"Dotted and dashed names", "spring.profile=dev\nmy-key=1\n", map[string]string{"spring.profile": "dev", "my-key": "1"}},
+		{"Value with =", "URL=postgres://u:p@h/db?ssl=true\n", map[string]string{"URL": "postgres://u:p@h/db?ssl=true"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars, err := Parse([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			got := make(map[string]string, len(vars))
+			for key, value := range vars {
+				got[key] = string(value)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"Missing =", "A=1\nJUST_A_WORD\n", "line 2: expected = after JUST_A_WORD"},
+		{"Invalid name", "1A=1", "line 1: invalid variable name"},
+		{"Empty name", "=1", "line 1: invalid variable name"},
+		{"Unterminated quote", "A=1\nB=\"open\nC=3\n", "line 2: unterminated quoted value starting on line 2"},
+		{"Text after quote", "A='x' y", "line 1: unexpected text after the closing quote of A"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.input))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Parse() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := map[string][]byte{"KEEP": []byte("1"), "CHANGE": []byte("a"), "REMOVE": []byte("x"), "EMPTY": {}}
+	new := map[string][]byte{"KEEP": []byte("1"), "CHANGE": []byte("b"), "ADD": []byte("y"), "EMPTY": []byte("set")}
+
+	want := []Change{
+		{Key: "ADD", Kind: Added, New: []byte("y")},
+		{Key: "CHANGE", Kind: Changed, Old: []byte("a"), New: []byte("b")},
+		{Key: "EMPTY", Kind: Changed, Old: []byte{}, New: []byte("set")},
+		{Key: "REMOVE", Kind: Removed, Old: []byte("x")},
+	}
+	if got := Diff(old, new); !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() = %+v, want %+v", got, want)
+	}
+
+	if got := Diff(old, old); len(got) != 0 {
+		t.Errorf("Diff() of identical files = %+v, want none", got)
+	}
+}
+
+func TestWipe(t *testing.T) {
+	data := []byte("A=secret\nB=\"quoted\\nvalue\"\nA=again\n")
+	vars, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	// Values do not share memory with the input
+	a := vars["A"]
+	for i := range data {
+		data[i] = 0
+	}
+	if string(a) != "again" {
+		t.Fatalf("A = %q after wiping the input, want %q", a, "again")
+	}
+
+	Wipe(vars)
+	for key, value := range vars {
+		if !bytes.Equal(value, make([]byte, len(value))) {
+			t.Errorf("%s = %q after Wipe, want zeros", key, value)
+		}
+	}
+}
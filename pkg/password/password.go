@@ -1,83 +1,191 @@
 package password
 
 import (
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"syscall"
 
 	"golang.org/x/term"
+
+	"goingenv/pkg/secret"
+	"goingenv/pkg/types"
 )
 
+// maxNewPasswordAttempts is how often a new password is asked for before
+// GetNewPassword gives up
+const maxNewPasswordAttempts = 3
+
 // Options contains password input configuration
 type Options struct {
-	PasswordEnv string // Environment variable name
+	PasswordEnv string      // Environment variable name
+	Prompt      string      // Interactive prompt (default: "Enter encryption password: ")
+	KeyFile     string      // Key file mixed into key derivation, alone or with the password
+	Cached      func() bool // Reports whether the key agent can open the archive without a password
 }
 
 // GetPassword retrieves password using the specified options
-// Priority order: PasswordEnv -> Interactive prompt
-func GetPassword(opts Options) (string, error) {
-	var password string
-	var err error
-
+// Priority order: PasswordEnv -> Key agent -> Interactive prompt
+// When the key agent already holds the key, no password is read and an
+// empty buffer is returned. The caller destroys the returned buffer.
+func GetPassword(opts Options) (*secret.Buffer, error) {
 	// Try environment variable first
 	if opts.PasswordEnv != "" {
-		password, err = readPasswordFromEnv(opts.PasswordEnv)
+		password, err := readPasswordFromEnv(opts.PasswordEnv)
 		if err != nil {
-			return "", fmt.Errorf("failed to read password from environment: %w", err)
-		}
-		if password != "" {
-			fmt.Fprintf(os.Stderr, "⚠️  Security Warning: Using password from environment variable '%s'\n", opts.PasswordEnv)
-			fmt.Fprintf(os.Stderr, "   Environment variables may be visible to other processes\n")
-			return password, nil
+			return nil, fmt.Errorf("failed to read password from environment: %w", err)
 		}
+		fmt.Fprintf(os.Stderr, "⚠️  Security Warning: Using password from environment variable '%s'\n", opts.PasswordEnv)
+		fmt.Fprintf(os.Stderr, "   Environment variables may be visible to other processes\n")
+		return password, nil
+	}
+
+	// Skip the prompt if the key agent can open the archive
+	if opts.Cached != nil && opts.Cached() {
+		return secret.New(0), nil
 	}
 
 	// Fall back to interactive prompt
-	return readPasswordInteractively()
+	return readPasswordInteractively(opts.Prompt)
+}
+
+// GetNewPassword reads a password for a new archive or key slot and checks
+// it against policy. A typed password that falls short is explained and
+// asked for again, and every typed password must be entered twice. A
+// password from an environment variable is checked but never confirmed. The
+// caller destroys the returned buffer.
+func GetNewPassword(opts Options, policy types.PasswordPolicy) (*secret.Buffer, error) {
+	if opts.PasswordEnv != "" {
+		password, err := GetPassword(opts)
+		if err != nil {
+			return nil, err
+		}
+		if err := CheckPolicy(password, policy); err != nil {
+			password.Destroy()
+			return nil, err
+		}
+		return password, nil
+	}
+
+	for attempt := 1; ; attempt++ {
+		password, err := readPasswordInteractively(opts.Prompt)
+		if err != nil {
+			return nil, err
+		}
+
+		problem := CheckPolicy(password, policy)
+		if problem == nil {
+			confirm, err := readPasswordInteractively("Confirm password: ")
+			if err != nil {
+				password.Destroy()
+				return nil, err
+			}
+			match := confirm.Equal(password)
+			confirm.Destroy()
+			if match {
+				return password, nil
+			}
+			problem = fmt.Errorf("passwords do not match")
+		}
+		password.Destroy()
+
+		if attempt == maxNewPasswordAttempts {
+			return nil, problem
+		}
+		printPasswordProblem(problem)
+	}
+}
+
+// printPasswordProblem explains why a typed password was not accepted
+func printPasswordProblem(err error) {
+	var policyErr *PolicyError
+	if !errors.As(err, &policyErr) {
+		fmt.Printf("❌ %v, try again\n", err)
+		return
+	}
+	fmt.Println("❌ Password rejected:")
+	for _, reason := range policyErr.Reasons {
+		fmt.Printf("   • %s\n", reason)
+	}
 }
 
-// readPasswordFromEnv reads password from environment variable
-func readPasswordFromEnv(envVar string) (string, error) {
+// readPasswordFromEnv reads password from environment variable. The
+// environment keeps its own copy, which cannot be wiped.
+func readPasswordFromEnv(envVar string) (*secret.Buffer, error) {
 	password := os.Getenv(envVar)
 	if password == "" {
-		return "", fmt.Errorf("environment variable '%s' is not set or empty", envVar)
+		return nil, fmt.Errorf("environment variable '%s' is not set or empty", envVar)
 	}
-	return password, nil
+	return secret.FromString(password), nil
 }
 
-// readPasswordInteractively prompts user for password with hidden input
-func readPasswordInteractively() (string, error) {
-	fmt.Print("Enter encryption password: ")
+// readPasswordInteractively prompts user for password with hidden input. The
+// bytes read from the terminal are moved into a secret buffer and wiped, so
+// the password never exists as a string.
+func readPasswordInteractively(prompt string) (*secret.Buffer, error) {
+	if prompt == "" {
+		prompt = "Enter encryption password: "
+	}
+	fmt.Print(prompt)
 	passwordBytes, err := term.ReadPassword(int(syscall.Stdin))
 	fmt.Println() // Add newline after hidden input
 
 	if err != nil {
-		return "", fmt.Errorf("failed to read password: %w", err)
+		secret.Wipe(passwordBytes)
+		return nil, fmt.Errorf("failed to read password: %w", err)
 	}
 
-	password := string(passwordBytes)
-	if password == "" {
-		return "", fmt.Errorf("password cannot be empty")
+	password := secret.FromBytes(passwordBytes)
+	if password.IsEmpty() {
+		return nil, fmt.Errorf("password cannot be empty")
 	}
 
 	return password, nil
 }
 
-// ClearPassword securely clears password from memory
-func ClearPassword(password *string) {
-	if password == nil {
-		return
+// ReadKeyFile reads a key file and returns its SHA-256 digest, which is what
+// gets mixed into key derivation. Any file works as a key file, but it must
+// not be empty, and the whole file must stay unchanged for the archive to
+// open. A file that other users can read is accepted with a warning.
+func ReadKeyFile(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("key file %s is not a regular file", path)
+	}
+	if info.Size() == 0 {
+		return nil, fmt.Errorf("key file %s is empty", path)
 	}
 
-	// Convert to byte slice and clear each byte
-	bytes := []byte(*password)
-	for i := range bytes {
-		bytes[i] = 0
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
 	}
 
-	// Set string to empty
-	*password = ""
+	if info.Mode().Perm()&0077 != 0 {
+		fmt.Fprintf(os.Stderr, "⚠️  Security Warning: Key file '%s' is accessible by other users (mode %04o)\n", path, info.Mode().Perm())
+		fmt.Fprintf(os.Stderr, "   Restrict it with: chmod 600 %s\n", path)
+	}
+
+	return h.Sum(nil), nil
+}
+
+// ClearKeyFile securely clears a key file digest from memory
+func ClearKeyFile(keyFile []byte) {
+	for i := range keyFile {
+		keyFile[i] = 0
+	}
 }
 
 // ValidatePasswordOptions validates the password options
@@ -89,5 +197,12 @@ func ValidatePasswordOptions(opts Options) error {
 		}
 	}
 
+	// Check that the key file exists if specified
+	if opts.KeyFile != "" {
+		if _, err := os.Stat(opts.KeyFile); err != nil {
+			return fmt.Errorf("key file not found: %s", opts.KeyFile)
+		}
+	}
+
 	return nil
 }
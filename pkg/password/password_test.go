@@ -2,6 +2,7 @@ package password
 
 import (
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -58,9 +59,10 @@ func TestGetPasswordFromEnv(t *testing.T) {
 				if err != nil {
 					t.Errorf("Unexpected error: %v", err)
 				}
-				if password != tt.expectedPass {
-					t.Errorf("Expected password '%s', got '%s'", tt.expectedPass, password)
+				if string(password.Bytes()) != tt.expectedPass {
+					t.Errorf("Expected password '%s', got '%s'", tt.expectedPass, password.Bytes())
 				}
+				password.Destroy()
 			}
 		})
 	}
@@ -89,6 +91,12 @@ func TestValidatePasswordOptions(t *testing.T) {
 			expectError:   true,
 			errorContains: "cannot be empty",
 		},
+		{
+			name:          "missing key file",
+			opts:          Options{KeyFile: "/nonexistent/goingenv.key"},
+			expectError:   true,
+			errorContains: "key file not found",
+		},
 	}
 
 	for _, tt := range tests {
@@ -141,45 +149,84 @@ func TestGetPasswordPriority(t *testing.T) {
 				t.Errorf("Unexpected error: %v", err)
 			}
 
-			if password != tt.expectedPass {
-				t.Errorf("Expected password '%s', got '%s'", tt.expectedPass, password)
+			if string(password.Bytes()) != tt.expectedPass {
+				t.Errorf("Expected password '%s', got '%s'", tt.expectedPass, password.Bytes())
 			}
 
 			// Test that password can be cleared
-			ClearPassword(&password)
-			if password != "" {
-				t.Errorf("Password was not cleared, still contains: %s", password)
+			password.Destroy()
+			if !password.IsEmpty() {
+				t.Errorf("Password was not cleared, still contains: %s", password.Bytes())
 			}
 		})
 	}
 }
 
-func TestClearPassword(t *testing.T) {
-	tests := []struct {
-		name     string
-		password *string
-	}{
-		{
-			name:     "clear valid password",
-			password: func() *string { s := "secret123"; return &s }(),
-		},
-		{
-			name:     "clear empty password",
-			password: func() *string { s := ""; return &s }(),
-		},
-		{
-			name:     "clear nil password",
-			password: nil,
-		},
+func TestGetPasswordCached(t *testing.T) {
+	asked := false
+	password, err := GetPassword(Options{Cached: func() bool {
+		asked = true
+		return true
+	}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !asked {
+		t.Error("Expected the key agent to be checked")
 	}
+	if !password.IsEmpty() {
+		t.Error("Expected no password when the key agent holds the key")
+	}
+}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			ClearPassword(tt.password)
+func TestReadKeyFile(t *testing.T) {
+	dir := t.TempDir()
 
-			if tt.password != nil && *tt.password != "" {
-				t.Errorf("Password was not cleared, still contains: %s", *tt.password)
-			}
-		})
+	keyPath := filepath.Join(dir, "ci.key")
+	if err := os.WriteFile(keyPath, []byte("random key material"), 0600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+	otherPath := filepath.Join(dir, "other.key")
+	if err := os.WriteFile(otherPath, []byte("random key materiaL"), 0600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+	emptyPath := filepath.Join(dir, "empty.key")
+	if err := os.WriteFile(emptyPath, nil, 0600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+
+	digest, err := ReadKeyFile(keyPath)
+	if err != nil {
+		t.Fatalf("ReadKeyFile failed: %v", err)
+	}
+	if len(digest) != 32 {
+		t.Errorf("Expected a 32-byte digest, got %d bytes", len(digest))
+	}
+
+	again, err := ReadKeyFile(keyPath)
+	if err != nil || string(again) != string(digest) {
+		t.Error("Expected reading the same key file twice to give the same digest")
+	}
+
+	other, err := ReadKeyFile(otherPath)
+	if err != nil || string(other) == string(digest) {
+		t.Error("Expected different key files to give different digests")
+	}
+
+	for name, path := range map[string]string{
+		"empty file":   emptyPath,
+		"missing file": filepath.Join(dir, "missing.key"),
+		"directory":    dir,
+	} {
+		if _, err := ReadKeyFile(path); err == nil {
+			t.Errorf("Expected ReadKeyFile to reject a %s", name)
+		}
+	}
+
+	ClearKeyFile(digest)
+	for _, b := range digest {
+		if b != 0 {
+			t.Fatal("Key file digest was not cleared")
+		}
 	}
 }
@@ -0,0 +1,434 @@
+package password
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"goingenv/pkg/secret"
+	"goingenv/pkg/types"
+)
+
+const (
+	// DefaultMinScore is the minimum strength score used when the policy
+	// does not set one
+	DefaultMinScore = 3
+	// DefaultMinLength is the minimum password length used when the policy
+	// does not set one
+	DefaultMinLength = 12
+	// MaxScore is the highest strength score
+	MaxScore = 4
+)
+
+// scoreThresholds are the estimated entropy bits needed for scores 1 to 4
+var scoreThresholds = [MaxScore]float64{28, 40, 55, 70}
+
+// scoreNames describes each strength score
+var scoreNames = [MaxScore + 1]string{"very weak", "weak", "fair", "strong", "very strong"}
+
+// keyboardRows are the rows of common keyboard layouts. Runs along a row,
+// forwards or backwards, are as easy to guess as a single key.
+var keyboardRows = []string{
+	"`1234567890-=",
+	"qwertyuiop[]",
+	"asdfghjkl;'",
+	"zxcvbnm,./",
+	"qwertzuiop",
+	"yxcvbnm",
+	"azertyuiop",
+	"qsdfghjklm",
+	"wxcvbn",
+}
+
+// leetSubstitutions maps characters commonly swapped into words back to
+// the letters they replace
+var leetSubstitutions = map[rune]rune{
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't',
+	'@': 'a', '$': 's', '!': 'i', '+': 't',
+}
+
+//go:embed common_words.txt
+var commonWordsFile string
+
+// commonWords holds the embedded word list, keyed by word
+var commonWords = loadWordList(commonWordsFile)
+
+// Strength is the estimated strength of a password
+type Strength struct {
+	Score   int      // 0 (very weak) to MaxScore (very strong)
+	Entropy float64  // estimated bits of entropy
+	Reasons []string // patterns that made the password easier to guess
+}
+
+// Label returns a human-readable name for the score
+func (s Strength) Label() string {
+	return ScoreLabel(s.Score)
+}
+
+// ScoreLabel returns a human-readable name for a strength score
+func ScoreLabel(score int) string {
+	if score < 0 || score > MaxScore {
+		return fmt.Sprintf("score %d", score)
+	}
+	return scoreNames[score]
+}
+
+// PolicyError explains why a password does not meet the password policy
+type PolicyError struct {
+	Strength Strength
+	Reasons  []string
+}
+
+func (e *PolicyError) Error() string {
+	return "password " + strings.Join(e.Reasons, "; ")
+}
+
+// EstimateStrength estimates how hard a password is to guess. Every
+// character adds the entropy of the character classes the password uses,
+// except inside common words, keyboard runs, sequences and repeats, which
+// only add the few bits needed to pick the pattern.
+func EstimateStrength(password string) Strength {
+	runes := []rune(password)
+	defer wipeRunes(runes)
+	return estimateStrength(runes)
+}
+
+// estimateStrength estimates the strength of a password given as runes,
+// without copying it into strings
+func estimateStrength(runes []rune) Strength {
+	charBits := math.Log2(float64(charsetSize(runes)))
+
+	var strength Strength
+	seen := make(map[string]bool)
+	reason := func(r string) {
+		if !seen[r] {
+			seen[r] = true
+			strength.Reasons = append(strength.Reasons, r)
+		}
+	}
+
+	for i := 0; i < len(runes); {
+		if n, bits := matchWord(runes[i:]); n > 0 {
+			strength.Entropy += bits
+			reason("contains a common word")
+			i += n
+			continue
+		}
+		if n := matchRepeat(runes[i:]); n > 0 {
+			strength.Entropy += charBits + math.Log2(float64(n))
+			reason("contains repeated characters")
+			i += n
+			continue
+		}
+		if n, block := matchBlockRepeat(runes[i:]); n > 0 {
+			strength.Entropy += charBits*float64(block) + math.Log2(float64(n/block))
+			reason("repeats a group of characters")
+			i += n
+			continue
+		}
+		if n := matchKeyboard(runes[i:]); n > 0 {
+			strength.Entropy += math.Log2(float64(2*len(keyboardRows)*10)) + math.Log2(float64(n))
+			reason("contains a keyboard pattern")
+			i += n
+			continue
+		}
+		if n := matchSequence(runes[i:]); n > 0 {
+			strength.Entropy += charBits + 1 + math.Log2(float64(n))
+			reason("contains a sequence like abc or 123")
+			i += n
+			continue
+		}
+		strength.Entropy += charBits
+		i++
+	}
+
+	if len(runes) > 0 && charsetSize(runes) <= 26 {
+		reason("uses only one kind of character")
+	}
+
+	for strength.Score < MaxScore && strength.Entropy >= scoreThresholds[strength.Score] {
+		strength.Score++
+	}
+
+	return strength
+}
+
+// CheckPolicy returns a *PolicyError listing every way password falls short
+// of the policy, or nil if it meets it. Unset policy fields use the
+// defaults. Working copies of the password are wiped before it returns.
+func CheckPolicy(password *secret.Buffer, policy types.PasswordPolicy) error {
+	if policy.Disabled {
+		if password.IsEmpty() {
+			return &PolicyError{Reasons: []string{"cannot be empty"}}
+		}
+		return nil
+	}
+
+	minScore, minLength := policyMinimums(policy)
+	runes := bytes.Runes(password.Bytes())
+	defer wipeRunes(runes)
+	strength := estimateStrength(runes)
+	var reasons []string
+
+	if n := len(runes); n < minLength {
+		reasons = append(reasons, fmt.Sprintf("is %d characters long, at least %d are required", n, minLength))
+	}
+
+	lower := bytes.ToLower(password.Bytes())
+	defer secret.Wipe(lower)
+	for _, denied := range policy.DenyList {
+		denied = strings.ToLower(strings.TrimSpace(denied))
+		if denied != "" && bytes.Contains(lower, []byte(denied)) {
+			reasons = append(reasons, "contains a word from the deny list")
+			break
+		}
+	}
+
+	if strength.Score < minScore {
+		reasons = append(reasons, fmt.Sprintf("is %s, at least %s is required", strength.Label(), ScoreLabel(minScore)))
+		reasons = append(reasons, strength.Reasons...)
+	}
+
+	if len(reasons) > 0 {
+		return &PolicyError{Strength: strength, Reasons: reasons}
+	}
+	return nil
+}
+
+// DescribePolicy summarizes what the policy requires of a password
+func DescribePolicy(policy types.PasswordPolicy) string {
+	if policy.Disabled {
+		return "disabled"
+	}
+	minScore, minLength := policyMinimums(policy)
+	description := fmt.Sprintf("%s or better, at least %d characters", ScoreLabel(minScore), minLength)
+	if len(policy.DenyList) > 0 {
+		description += fmt.Sprintf(", %d denied words", len(policy.DenyList))
+	}
+	return description
+}
+
+// policyMinimums returns the minimum score and length of the policy,
+// filling unset values with the defaults
+func policyMinimums(policy types.PasswordPolicy) (int, int) {
+	minScore := policy.MinScore
+	if minScore == 0 {
+		minScore = DefaultMinScore
+	}
+	minLength := policy.MinLength
+	if minLength == 0 {
+		minLength = DefaultMinLength
+	}
+	return minScore, minLength
+}
+
+// ValidatePolicy checks that the policy settings are in range
+func ValidatePolicy(policy types.PasswordPolicy) error {
+	if policy.MinScore < 0 || policy.MinScore > MaxScore {
+		return fmt.Errorf("min_score must be between 0 and %d", MaxScore)
+	}
+	if policy.MinLength < 0 || policy.MinLength > 1024 {
+		return fmt.Errorf("min_length must be between 0 and 1024")
+	}
+	return nil
+}
+
+// charsetSize returns the size of the alphabet implied by the character
+// classes present in the password
+func charsetSize(runes []rune) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII && unicode.IsPrint(r):
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	size := 0
+	if lower {
+		size += 26
+	}
+	if upper {
+		size += 26
+	}
+	if digit {
+		size += 10
+	}
+	if symbol {
+		size += 33
+	}
+	if other {
+		size += 100
+	}
+	if size == 0 {
+		size = 1
+	}
+	return size
+}
+
+// matchWord returns the length and entropy of the longest common word at
+// the start of runes, after undoing capitalization and leet substitutions
+func matchWord(runes []rune) (int, float64) {
+	// Encode the normalized runes once, remembering where each one ends, so
+	// every candidate prefix is looked up without allocating a string
+	normalized := make([]byte, 0, len(runes)*utf8.UTFMax)
+	defer secret.Wipe(normalized)
+	ends := make([]int, len(runes))
+	for i, r := range runes {
+		r = unicode.ToLower(r)
+		if sub, ok := leetSubstitutions[r]; ok {
+			r = sub
+		}
+		normalized = utf8.AppendRune(normalized, r)
+		ends[i] = len(normalized)
+	}
+
+	for n := len(runes); n >= 3; n-- {
+		if !commonWords[string(normalized[:ends[n-1]])] {
+			continue
+		}
+		bits := math.Log2(float64(len(commonWords)))
+		for _, r := range runes[:n] {
+			if unicode.IsUpper(r) {
+				bits++
+				break
+			}
+		}
+		for _, r := range runes[:n] {
+			if _, ok := leetSubstitutions[unicode.ToLower(r)]; ok {
+				bits++
+				break
+			}
+		}
+		return n, bits
+	}
+	return 0, 0
+}
+
+// matchRepeat returns the length of a run of three or more identical
+// characters at the start of runes
+func matchRepeat(runes []rune) int {
+	n := 1
+	for n < len(runes) && runes[n] == runes[0] {
+		n++
+	}
+	if n < 3 {
+		return 0
+	}
+	return n
+}
+
+// matchBlockRepeat returns the length of a group of two to four characters
+// repeated at least twice at the start of runes, and the group size
+func matchBlockRepeat(runes []rune) (int, int) {
+	for block := 2; block <= 4; block++ {
+		n := block
+		for n+block <= len(runes) && runesEqual(runes[n:n+block], runes[:block]) {
+			n += block
+		}
+		if n >= 2*block {
+			return n, block
+		}
+	}
+	return 0, 0
+}
+
+// matchKeyboard returns the length of a run of four or more adjacent keys
+// on one keyboard row at the start of runes
+func matchKeyboard(runes []rune) int {
+	best := 0
+	for _, row := range keyboardRows {
+		for _, line := range []string{row, reverse(row)} {
+			start := strings.IndexRune(line, unicode.ToLower(runes[0]))
+			if start < 0 {
+				continue
+			}
+			n := 1
+			for n < len(runes) && start+n < len(line) && unicode.ToLower(runes[n]) == rune(line[start+n]) {
+				n++
+			}
+			if n > best {
+				best = n
+			}
+		}
+	}
+	if best < 4 {
+		return 0
+	}
+	return best
+}
+
+// matchSequence returns the length of a run of three or more consecutive
+// characters, ascending or descending, at the start of runes
+func matchSequence(runes []rune) int {
+	if len(runes) < 3 {
+		return 0
+	}
+	step := runes[1] - runes[0]
+	if step != 1 && step != -1 {
+		return 0
+	}
+	n := 2
+	for n < len(runes) && runes[n]-runes[n-1] == step {
+		n++
+	}
+	if n < 3 {
+		return 0
+	}
+	return n
+}
+
+// runesEqual reports whether a and b hold the same runes
+func runesEqual(a, b []rune) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// wipeRunes overwrites a decoded password with zeros
+func wipeRunes(runes []rune) {
+	for i := range runes {
+		runes[i] = 0
+	}
+}
+
+// reverse returns s with its bytes in reverse order
+func reverse(s string) string {
+	b := []byte(s)
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return string(b)
+}
+
+// loadWordList parses a word list with one word per line. Blank lines and
+// lines starting with # are ignored.
+func loadWordList(data string) map[string]bool {
+	words := make(map[string]bool)
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words[strings.ToLower(line)] = true
+	}
+	return words
+}
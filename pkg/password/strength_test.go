@@ -0,0 +1,165 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"goingenv/pkg/secret"
+	"goingenv/pkg/types"
+)
+
+func TestEstimateStrength(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		maxScore int
+		minScore int
+		reason   string
+	}{
+		{"common word", "test", 0, 0, "common word"},
+		{"common word with leet and capitals", "P@ssw0rd!", 0, 0, "common word"},
+		{"keyboard run", "qwertyuiop", 0, 0, "keyboard pattern"},
+		{"repeated character", "aaaaaaaaaaaaaaaa", 0, 0, "repeated characters"},
+		{"repeated group", "x9x9x9x9x9x9", 1, 0, "repeats a group"},
+		{"sequence", "abcdefghijklmnop", 1, 0, "sequence"},
+		{"word and digits", "mypassword123", 1, 0, "common word"},
+		{"random characters", "x7#Kp2!vQz9m", MaxScore, 4, ""},
+		{"long passphrase", "correct horse battery staple", MaxScore, 4, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strength := EstimateStrength(tt.password)
+			if strength.Score < tt.minScore || strength.Score > tt.maxScore {
+				t.Errorf("Score = %d (%.1f bits), want %d to %d", strength.Score, strength.Entropy, tt.minScore, tt.maxScore)
+			}
+			if tt.reason != "" && !strings.Contains(strings.Join(strength.Reasons, "; "), tt.reason) {
+				t.Errorf("Reasons %v do not mention %q", strength.Reasons, tt.reason)
+			}
+		})
+	}
+}
+
+func TestEstimateStrength_DoesNotEchoPassword(t *testing.T) {
+	strength := EstimateStrength("Password123qwerty")
+	for _, reason := range strength.Reasons {
+		if strings.Contains(strings.ToLower(reason), "password") || strings.Contains(reason, "qwerty") {
+			t.Errorf("Reason %q repeats part of the password", reason)
+		}
+	}
+}
+
+func TestCheckPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		password    string
+		policy      types.PasswordPolicy
+		expectError bool
+		reason      string
+	}{
+		{
+			name:        "default policy rejects weak password",
+			password:    "test",
+			expectError: true,
+			reason:      "at least 12",
+		},
+		{
+			name:     "default policy accepts strong password",
+			password: "x7#Kp2!vQz9m",
+		},
+		{
+			name:        "minimum length",
+			password:    "x7#Kp2!vQz9m",
+			policy:      types.PasswordPolicy{MinLength: 16},
+			expectError: true,
+			reason:      "at least 16",
+		},
+		{
+			name:        "minimum score",
+			password:    "Summer2024!x",
+			policy:      types.PasswordPolicy{MinScore: MaxScore},
+			expectError: true,
+			reason:      "very strong is required",
+		},
+		{
+			name:        "deny list is case-insensitive",
+			password:    "x7#AcmeCorp!vQz9m",
+			policy:      types.PasswordPolicy{DenyList: []string{"acmecorp"}},
+			expectError: true,
+			reason:      "deny list",
+		},
+		{
+			name:     "disabled policy accepts anything non-empty",
+			password: "test",
+			policy:   types.PasswordPolicy{Disabled: true},
+		},
+		{
+			name:        "disabled policy rejects empty password",
+			password:    "",
+			policy:      types.PasswordPolicy{Disabled: true},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckPolicy(secret.FromString(tt.password), tt.policy)
+			if !tt.expectError {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+
+			var policyErr *PolicyError
+			if !errors.As(err, &policyErr) {
+				t.Fatalf("Expected a *PolicyError, got %v", err)
+			}
+			if tt.reason != "" && !strings.Contains(err.Error(), tt.reason) {
+				t.Errorf("Expected error to contain %q, got: %v", tt.reason, err)
+			}
+		})
+	}
+}
+
+func TestValidatePolicy(t *testing.T) {
+	valid := []types.PasswordPolicy{
+		{},
+		{MinScore: 1, MinLength: 8},
+		{MinScore: MaxScore, MinLength: 64, DenyList: []string{"acme"}},
+	}
+	for _, policy := range valid {
+		if err := ValidatePolicy(policy); err != nil {
+			t.Errorf("ValidatePolicy(%+v) = %v, want nil", policy, err)
+		}
+	}
+
+	invalid := []types.PasswordPolicy{
+		{MinScore: -1},
+		{MinScore: MaxScore + 1},
+		{MinLength: -1},
+	}
+	for _, policy := range invalid {
+		if err := ValidatePolicy(policy); err == nil {
+			t.Errorf("Expected ValidatePolicy(%+v) to fail", policy)
+		}
+	}
+}
+
+func TestGetNewPasswordFromEnv(t *testing.T) {
+	t.Setenv("GOINGENV_TEST_NEW_PASSWORD", "test")
+	if _, err := GetNewPassword(Options{PasswordEnv: "GOINGENV_TEST_NEW_PASSWORD"}, types.PasswordPolicy{}); err == nil {
+		t.Error("Expected a weak password from the environment to be rejected")
+	}
+
+	t.Setenv("GOINGENV_TEST_NEW_PASSWORD", "x7#Kp2!vQz9m")
+	got, err := GetNewPassword(Options{PasswordEnv: "GOINGENV_TEST_NEW_PASSWORD"}, types.PasswordPolicy{})
+	if err != nil {
+		t.Fatalf("GetNewPassword failed: %v", err)
+	}
+	defer got.Destroy()
+	if string(got.Bytes()) != "x7#Kp2!vQz9m" {
+		t.Errorf("GetNewPassword = %q, want the environment value", got.Bytes())
+	}
+}
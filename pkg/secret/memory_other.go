@@ -0,0 +1,12 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+
+package secret
+
+// alloc allocates a secret on the Go heap. Memory locking is not available
+// on this platform, so the secret may be swapped out.
+func alloc(size int) (region []byte, mapped, locked bool) {
+	return make([]byte, size), false, false
+}
+
+// free does nothing; heap memory is wiped by the caller and collected
+func free(region []byte, mapped, locked bool) {}
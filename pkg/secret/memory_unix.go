@@ -0,0 +1,35 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package secret
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// alloc maps whole pages for a secret of size bytes outside the Go heap, so
+// the garbage collector never copies it, and locks them into memory. If the
+// pages cannot be locked, for example because RLIMIT_MEMLOCK is exhausted,
+// the secret is still kept but may be swapped out.
+func alloc(size int) (region []byte, mapped, locked bool) {
+	pageSize := os.Getpagesize()
+	length := (size + pageSize - 1) / pageSize * pageSize
+
+	region, err := unix.Mmap(-1, 0, length, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANON|unix.MAP_PRIVATE)
+	if err != nil {
+		return make([]byte, size), false, false
+	}
+	return region, true, unix.Mlock(region) == nil
+}
+
+// free unlocks and unmaps memory returned by alloc. The caller wipes it
+// first.
+func free(region []byte, mapped, locked bool) {
+	if locked {
+		unix.Munlock(region)
+	}
+	if mapped {
+		unix.Munmap(region)
+	}
+}
@@ -0,0 +1,127 @@
+// Package secret holds passwords and other key material in buffers that are
+// kept out of swap where the OS allows it and wiped as soon as they are no
+// longer needed.
+//
+// Go strings are immutable and copied freely, so a password held in a
+// string cannot be reliably erased. A Buffer owns a single copy of its bytes,
+// allocated outside the Go heap and locked into memory on Unix systems, and
+// Destroy overwrites it with zeros before releasing it.
+package secret
+
+import (
+	"crypto/subtle"
+	"runtime"
+)
+
+// Buffer holds secret bytes. The zero value and a nil *Buffer are both
+// empty buffers, so optional secrets can be left unset. Whoever creates a
+// buffer must Destroy it; its memory is not managed by the garbage
+// collector.
+type Buffer struct {
+	data   []byte // the secret, a prefix of region
+	region []byte // memory allocated for the secret, nil for empty buffers
+	mapped bool   // region was mapped outside the Go heap
+	locked bool   // region is locked into memory
+}
+
+// New allocates a zeroed buffer of size bytes
+func New(size int) *Buffer {
+	b := &Buffer{}
+	if size <= 0 {
+		return b
+	}
+	b.region, b.mapped, b.locked = alloc(size)
+	b.data = b.region[:size]
+	return b
+}
+
+// FromBytes moves src into a new buffer and wipes src, so the buffer holds
+// the only copy
+func FromBytes(src []byte) *Buffer {
+	b := New(len(src))
+	copy(b.data, src)
+	Wipe(src)
+	return b
+}
+
+// FromString copies s into a new buffer. Strings cannot be wiped, so only
+// use this for secrets that already live in a string, such as environment
+// variables and text typed into the interactive mode.
+func FromString(s string) *Buffer {
+	b := New(len(s))
+	copy(b.data, s)
+	return b
+}
+
+// Bytes returns the secret. The slice is only valid until Destroy is called
+// and must not be retained or appended to.
+func (b *Buffer) Bytes() []byte {
+	if b == nil {
+		return nil
+	}
+	return b.data
+}
+
+// Len returns the length of the secret in bytes
+func (b *Buffer) Len() int {
+	if b == nil {
+		return 0
+	}
+	return len(b.data)
+}
+
+// IsEmpty reports whether the buffer holds no secret
+func (b *Buffer) IsEmpty() bool {
+	return b.Len() == 0
+}
+
+// Locked reports whether the buffer is locked into memory, so it cannot be
+// written to swap
+func (b *Buffer) Locked() bool {
+	return b != nil && b.locked
+}
+
+// Equal reports whether two buffers hold the same secret, in constant time
+// for secrets of equal length
+func (b *Buffer) Equal(other *Buffer) bool {
+	return subtle.ConstantTimeCompare(b.Bytes(), other.Bytes()) == 1
+}
+
+// Clone returns a new buffer holding a copy of the secret
+func (b *Buffer) Clone() *Buffer {
+	c := New(b.Len())
+	copy(c.data, b.Bytes())
+	return c
+}
+
+// Destroy wipes the secret and releases its memory. The buffer is empty
+// afterwards. Destroy is safe to call more than once and on a nil buffer.
+func (b *Buffer) Destroy() {
+	if b == nil || b.region == nil {
+		return
+	}
+	Wipe(b.region)
+	free(b.region, b.mapped, b.locked)
+	b.data = nil
+	b.region = nil
+	b.mapped = false
+	b.locked = false
+}
+
+// String hides the secret when a buffer is printed by accident
+func (b *Buffer) String() string {
+	return "[secret]"
+}
+
+// GoString hides the secret from %#v
+func (b *Buffer) GoString() string {
+	return "secret.Buffer{[secret]}"
+}
+
+// Wipe overwrites b with zeros
+func Wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+	runtime.KeepAlive(b)
+}
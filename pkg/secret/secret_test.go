@@ -0,0 +1,98 @@
+package secret
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestFromBytes(t *testing.T) {
+	src := []byte("correct horse battery staple")
+	b := FromBytes(src)
+	defer b.Destroy()
+
+	if string(b.Bytes()) != "correct horse battery staple" {
+		t.Errorf("Bytes() = %q, want the original secret", b.Bytes())
+	}
+	for _, c := range src {
+		if c != 0 {
+			t.Fatal("FromBytes did not wipe the source slice")
+		}
+	}
+}
+
+func TestDestroy(t *testing.T) {
+	b := FromString("secret123")
+
+	b.Destroy()
+	if !b.IsEmpty() || b.Len() != 0 || b.Bytes() != nil || b.Locked() {
+		t.Error("Expected a destroyed buffer to be empty")
+	}
+
+	// Destroying twice, or a nil or zero buffer, is harmless
+	b.Destroy()
+	var nilBuffer *Buffer
+	nilBuffer.Destroy()
+	(&Buffer{}).Destroy()
+}
+
+func TestWipe(t *testing.T) {
+	data := []byte("secret123")
+	Wipe(data)
+	for _, c := range data {
+		if c != 0 {
+			t.Fatal("Wipe left secret bytes behind")
+		}
+	}
+}
+
+func TestNilAndEmptyBuffers(t *testing.T) {
+	var nilBuffer *Buffer
+	for name, b := range map[string]*Buffer{
+		"nil":         nilBuffer,
+		"zero value":  {},
+		"empty bytes": FromBytes(nil),
+		"empty text":  FromString(""),
+	} {
+		if !b.IsEmpty() || b.Len() != 0 || b.Bytes() != nil {
+			t.Errorf("Expected the %s buffer to be empty", name)
+		}
+		if c := b.Clone(); !c.IsEmpty() {
+			t.Errorf("Expected a clone of the %s buffer to be empty", name)
+		}
+	}
+}
+
+func TestEqualAndClone(t *testing.T) {
+	a := FromString("secret123")
+	defer a.Destroy()
+	b := FromString("secret123")
+	defer b.Destroy()
+	c := FromString("secret124")
+	defer c.Destroy()
+
+	if !a.Equal(b) {
+		t.Error("Expected buffers with the same secret to be equal")
+	}
+	if a.Equal(c) || a.Equal(nil) {
+		t.Error("Expected buffers with different secrets to differ")
+	}
+
+	clone := a.Clone()
+	a.Destroy()
+	if string(clone.Bytes()) != "secret123" {
+		t.Error("Expected a clone to outlive the original")
+	}
+	clone.Destroy()
+}
+
+func TestFormattingHidesSecret(t *testing.T) {
+	b := FromString("secret123")
+	defer b.Destroy()
+
+	for _, format := range []string{"%s", "%v", "%+v", "%#v"} {
+		if out := fmt.Sprintf(format, b); strings.Contains(out, "secret123") {
+			t.Errorf("Sprintf(%q) = %q, reveals the secret", format, out)
+		}
+	}
+}
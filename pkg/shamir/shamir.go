@@ -0,0 +1,151 @@
+// Package shamir implements Shamir's secret sharing over GF(256).
+//
+// A secret is split byte by byte: each byte becomes the constant term of a
+// random polynomial of degree threshold-1, and each share holds the value of
+// every polynomial at one non-zero x coordinate. Any threshold shares
+// determine the polynomials and so the secret; fewer reveal nothing about it.
+package shamir
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+const (
+	// MaxShares is the maximum number of shares, one per non-zero element
+	// of GF(256)
+	MaxShares = 255
+	// MinThreshold is the smallest useful threshold; with one share there
+	// is nothing to split
+	MinThreshold = 2
+)
+
+// Split divides secret into parts shares, any threshold of which rebuild it
+// with Combine. Each share is one byte longer than the secret: the y values
+// followed by the x coordinate.
+func Split(secret []byte, parts, threshold int) ([][]byte, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("secret cannot be empty")
+	}
+	if threshold < MinThreshold {
+		return nil, fmt.Errorf("threshold must be at least %d", MinThreshold)
+	}
+	if parts < threshold {
+		return nil, fmt.Errorf("number of shares (%d) cannot be less than the threshold (%d)", parts, threshold)
+	}
+	if parts > MaxShares {
+		return nil, fmt.Errorf("number of shares cannot exceed %d", MaxShares)
+	}
+
+	shares := make([][]byte, parts)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][len(secret)] = byte(i + 1)
+	}
+
+	// coefficients[0] is the secret byte; the rest are random
+	coefficients := make([]byte, threshold)
+	defer clear(coefficients)
+
+	for pos, b := range secret {
+		coefficients[0] = b
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, fmt.Errorf("failed to generate coefficients: %w", err)
+		}
+		for _, share := range shares {
+			share[pos] = evaluate(coefficients, share[len(secret)])
+		}
+	}
+
+	return shares, nil
+}
+
+// Combine rebuilds a secret from at least threshold shares produced by Split.
+// Combining fewer shares than the threshold does not fail; it returns a
+// different, meaningless value, so callers must verify the result.
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) < MinThreshold {
+		return nil, fmt.Errorf("at least %d shares are needed", MinThreshold)
+	}
+
+	size := len(shares[0])
+	if size < 2 {
+		return nil, fmt.Errorf("share is too short")
+	}
+
+	xs := make([]byte, len(shares))
+	seen := make(map[byte]bool, len(shares))
+	for i, share := range shares {
+		if len(share) != size {
+			return nil, fmt.Errorf("shares have different lengths")
+		}
+		x := share[size-1]
+		if x == 0 {
+			return nil, fmt.Errorf("share %d has an invalid x coordinate", i+1)
+		}
+		if seen[x] {
+			return nil, fmt.Errorf("share %d is a duplicate", i+1)
+		}
+		seen[x] = true
+		xs[i] = x
+	}
+
+	// Lagrange interpolation at x = 0. In GF(256) subtraction is XOR, so
+	// the basis polynomial for share i is the product of x_j / (x_i ^ x_j).
+	secret := make([]byte, size-1)
+	for i, share := range shares {
+		basis := byte(1)
+		for j, xj := range xs {
+			if i != j {
+				basis = mul(basis, div(xj, xs[i]^xj))
+			}
+		}
+		for pos := range secret {
+			secret[pos] ^= mul(share[pos], basis)
+		}
+	}
+
+	return secret, nil
+}
+
+// evaluate returns the value of the polynomial with the given coefficients
+// (constant term first) at x, using Horner's method
+func evaluate(coefficients []byte, x byte) byte {
+	var y byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		y = mul(y, x) ^ coefficients[i]
+	}
+	return y
+}
+
+// mul multiplies in GF(256) with the AES polynomial x^8 + x^4 + x^3 + x + 1.
+// It always runs the same eight steps without data-dependent branches, so
+// its timing does not depend on the secret.
+func mul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= a & -(b & 1)
+		carry := -(a >> 7)
+		a = (a << 1) ^ (0x1b & carry)
+		b >>= 1
+	}
+	return p
+}
+
+// inverse returns the multiplicative inverse of a non-zero element as
+// a^254, since a^255 = 1 for every non-zero a
+func inverse(a byte) byte {
+	result := byte(1)
+	for e := 254; e > 0; e >>= 1 {
+		if e&1 == 1 {
+			result = mul(result, a)
+		}
+		a = mul(a, a)
+	}
+	return result
+}
+
+// div divides a by a non-zero b
+func div(a, b byte) byte {
+	return mul(a, inverse(b))
+}
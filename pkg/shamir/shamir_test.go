@@ -0,0 +1,156 @@
+package shamir
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSplitCombine(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+
+	tests := []struct {
+		name      string
+		parts     int
+		threshold int
+	}{
+		{"2 of 2", 2, 2},
+		{"3 of 5", 5, 3},
+		{"5 of 5", 5, 5},
+		{"2 of 255", 255, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shares, err := Split(secret, tt.parts, tt.threshold)
+			if err != nil {
+				t.Fatalf("Split failed: %v", err)
+			}
+			if len(shares) != tt.parts {
+				t.Fatalf("Expected %d shares, got %d", tt.parts, len(shares))
+			}
+			for _, share := range shares {
+				if len(share) != len(secret)+1 {
+					t.Fatalf("Expected shares of %d bytes, got %d", len(secret)+1, len(share))
+				}
+			}
+
+			// Every window of threshold consecutive shares rebuilds the secret
+			for start := 0; start+tt.threshold <= len(shares); start++ {
+				combined, err := Combine(shares[start : start+tt.threshold])
+				if err != nil {
+					t.Fatalf("Combine failed: %v", err)
+				}
+				if !bytes.Equal(combined, secret) {
+					t.Fatalf("Combine of shares %d-%d returned the wrong secret", start, start+tt.threshold-1)
+				}
+			}
+
+			// So do all shares together
+			combined, err := Combine(shares)
+			if err != nil {
+				t.Fatalf("Combine failed: %v", err)
+			}
+			if !bytes.Equal(combined, secret) {
+				t.Error("Combine of all shares returned the wrong secret")
+			}
+		})
+	}
+}
+
+func TestCombine_BelowThreshold(t *testing.T) {
+	secret := []byte("recovery key material")
+	shares, err := Split(secret, 5, 3)
+	if err != nil {
+		t.Fatalf("Split failed: %v", err)
+	}
+
+	combined, err := Combine(shares[:2])
+	if err != nil {
+		t.Fatalf("Combine failed: %v", err)
+	}
+	if bytes.Equal(combined, secret) {
+		t.Error("Two shares of a 3-of-5 split must not rebuild the secret")
+	}
+}
+
+func TestSplit_Randomized(t *testing.T) {
+	secret := []byte{0x00, 0xff, 0x42}
+	first, err := Split(secret, 3, 2)
+	if err != nil {
+		t.Fatalf("Split failed: %v", err)
+	}
+	second, err := Split(secret, 3, 2)
+	if err != nil {
+		t.Fatalf("Split failed: %v", err)
+	}
+	if bytes.Equal(first[0], second[0]) {
+		t.Error("Splitting the same secret twice produced identical shares")
+	}
+}
+
+func TestSplit_Errors(t *testing.T) {
+	tests := []struct {
+		name      string
+		secret    []byte
+		parts     int
+		threshold int
+	}{
+		{"empty secret", nil, 5, 3},
+		{"threshold of one", []byte("s"), 5, 1},
+		{"fewer parts than threshold", []byte("s"), 2, 3},
+		{"too many parts", []byte("s"), 256, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Split(tt.secret, tt.parts, tt.threshold); err == nil {
+				t.Error("Expected Split to fail")
+			}
+		})
+	}
+}
+
+func TestCombine_Errors(t *testing.T) {
+	shares, err := Split([]byte("secret"), 3, 2)
+	if err != nil {
+		t.Fatalf("Split failed: %v", err)
+	}
+
+	zeroX := append([]byte{}, shares[1]...)
+	zeroX[len(zeroX)-1] = 0
+
+	tests := []struct {
+		name   string
+		shares [][]byte
+	}{
+		{"single share", shares[:1]},
+		{"duplicate share", [][]byte{shares[0], shares[0]}},
+		{"different lengths", [][]byte{shares[0], shares[1][1:]}},
+		{"zero x coordinate", [][]byte{shares[0], zeroX}},
+		{"too short", [][]byte{{1}, {2}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Combine(tt.shares); err == nil {
+				t.Error("Expected Combine to fail")
+			}
+		})
+	}
+}
+
+func TestFieldArithmetic(t *testing.T) {
+	// 0x53 * 0xca = 0x01 in the AES field (FIPS-197, section 4.2)
+	if got := mul(0x53, 0xca); got != 0x01 {
+		t.Errorf("mul(0x53, 0xca) = %#x, want 0x01", got)
+	}
+	if got := mul(0x57, 0x83); got != 0xc1 {
+		t.Errorf("mul(0x57, 0x83) = %#x, want 0xc1", got)
+	}
+
+	for a := 1; a < 256; a++ {
+		if got := mul(byte(a), inverse(byte(a))); got != 1 {
+			t.Fatalf("%#x * inverse(%#x) = %#x, want 1", a, a, got)
+		}
+	}
+}
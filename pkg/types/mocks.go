@@ -1,7 +1,10 @@
 package types
 
 import (
+	"io"
 	"time"
+
+	"goingenv/pkg/secret"
 )
 
 // MockScanner implements Scanner interface for testing
@@ -28,8 +31,13 @@ func (m *MockScanner) ValidateFile(path string) error {
 type MockArchiver struct {
 	PackFunc                 func(opts PackOptions) error
 	UnpackFunc               func(opts UnpackOptions) error
-	ListFunc                 func(archivePath, password string) (*Archive, error)
+	ListFunc                 func(opts ListOptions) (*Archive, error)
+	ReadFilesFunc            func(opts ReadOptions) (*Archive, map[string][]byte, error)
 	GetAvailableArchivesFunc func(dir string) ([]string, error)
+	ReadHeaderFunc           func(archivePath string) ([]byte, error)
+	ReplaceHeaderFunc        func(archivePath string, header []byte, sign SignOptions, trust TrustFunc) error
+	RekeyFunc                func(opts RekeyOptions) error
+	VerifySignatureFunc      func(archivePath string) (*SignatureInfo, error)
 }
 
 func (m *MockArchiver) Pack(opts PackOptions) error {
@@ -46,13 +54,20 @@ func (m *MockArchiver) Unpack(opts UnpackOptions) error {
 	return nil
 }
 
-func (m *MockArchiver) List(archivePath, password string) (*Archive, error) {
+func (m *MockArchiver) List(opts ListOptions) (*Archive, error) {
 	if m.ListFunc != nil {
-		return m.ListFunc(archivePath, password)
+		return m.ListFunc(opts)
 	}
 	return &Archive{}, nil
 }
 
+func (m *MockArchiver) ReadFiles(opts ReadOptions) (*Archive, map[string][]byte, error) {
+	if m.ReadFilesFunc != nil {
+		return m.ReadFilesFunc(opts)
+	}
+	return &Archive{}, map[string][]byte{}, nil
+}
+
 func (m *MockArchiver) GetAvailableArchives(dir string) ([]string, error) {
 	if m.GetAvailableArchivesFunc != nil {
 		return m.GetAvailableArchivesFunc(dir)
@@ -60,34 +75,149 @@ func (m *MockArchiver) GetAvailableArchives(dir string) ([]string, error) {
 	return []string{}, nil
 }
 
+func (m *MockArchiver) ReadHeader(archivePath string) ([]byte, error) {
+	if m.ReadHeaderFunc != nil {
+		return m.ReadHeaderFunc(archivePath)
+	}
+	return []byte{}, nil
+}
+
+func (m *MockArchiver) ReplaceHeader(archivePath string, header []byte, sign SignOptions, trust TrustFunc) error {
+	if m.ReplaceHeaderFunc != nil {
+		return m.ReplaceHeaderFunc(archivePath, header, sign, trust)
+	}
+	return nil
+}
+
+func (m *MockArchiver) Rekey(opts RekeyOptions) error {
+	if m.RekeyFunc != nil {
+		return m.RekeyFunc(opts)
+	}
+	return nil
+}
+
+func (m *MockArchiver) VerifySignature(archivePath string) (*SignatureInfo, error) {
+	if m.VerifySignatureFunc != nil {
+		return m.VerifySignatureFunc(archivePath)
+	}
+	return nil, nil
+}
+
 // MockCryptor implements Cryptor interface for testing
 type MockCryptor struct {
-	EncryptFunc          func(data []byte, password string) ([]byte, error)
-	DecryptFunc          func(data []byte, password string) ([]byte, error)
-	ValidatePasswordFunc func(data []byte, password string) error
+	EncryptFunc          func(data []byte, password *secret.Buffer) ([]byte, error)
+	DecryptFunc          func(data []byte, password *secret.Buffer) ([]byte, error)
+	EncryptStreamFunc    func(w io.Writer, opts EncryptOptions) (io.WriteCloser, error)
+	DecryptStreamFunc    func(r io.Reader, opts DecryptOptions) (io.Reader, error)
+	DecryptIndexFunc     func(r io.Reader, opts DecryptOptions) ([]byte, io.Reader, error)
+	ValidatePasswordFunc func(data []byte, password *secret.Buffer) error
+	ReadHeaderFunc       func(r io.Reader) ([]byte, error)
+	ListKeySlotsFunc     func(header []byte) ([]KeySlotInfo, error)
+	AddKeySlotFunc       func(header []byte, unlock DecryptOptions, add EncryptOptions) ([]byte, error)
+	RemoveKeySlotFunc    func(header []byte, unlock DecryptOptions, index int) ([]byte, error)
+	SignStreamFunc       func(w io.Writer, opts SignOptions) (io.WriteCloser, error)
+	ReadSignatureFunc    func(r io.ReaderAt, size int64) (*SignatureInfo, int64, error)
+	HasCachedKeyFunc     func(header []byte) bool
 }
 
-func (m *MockCryptor) Encrypt(data []byte, password string) ([]byte, error) {
+func (m *MockCryptor) Encrypt(data []byte, password *secret.Buffer) ([]byte, error) {
 	if m.EncryptFunc != nil {
 		return m.EncryptFunc(data, password)
 	}
 	return data, nil // Simple mock - just return input
 }
 
-func (m *MockCryptor) Decrypt(data []byte, password string) ([]byte, error) {
+func (m *MockCryptor) Decrypt(data []byte, password *secret.Buffer) ([]byte, error) {
 	if m.DecryptFunc != nil {
 		return m.DecryptFunc(data, password)
 	}
 	return data, nil
 }
 
-func (m *MockCryptor) ValidatePassword(data []byte, password string) error {
+func (m *MockCryptor) EncryptStream(w io.Writer, opts EncryptOptions) (io.WriteCloser, error) {
+	if m.EncryptStreamFunc != nil {
+		return m.EncryptStreamFunc(w, opts)
+	}
+	return nopWriteCloser{w}, nil // Simple mock - pass data through
+}
+
+func (m *MockCryptor) DecryptStream(r io.Reader, opts DecryptOptions) (io.Reader, error) {
+	if m.DecryptStreamFunc != nil {
+		return m.DecryptStreamFunc(r, opts)
+	}
+	return r, nil
+}
+
+func (m *MockCryptor) DecryptIndex(r io.Reader, opts DecryptOptions) ([]byte, io.Reader, error) {
+	if m.DecryptIndexFunc != nil {
+		return m.DecryptIndexFunc(r, opts)
+	}
+	return nil, r, nil
+}
+
+// nopWriteCloser adds a no-op Close to an io.Writer
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func (m *MockCryptor) ValidatePassword(data []byte, password *secret.Buffer) error {
 	if m.ValidatePasswordFunc != nil {
 		return m.ValidatePasswordFunc(data, password)
 	}
 	return nil
 }
 
+func (m *MockCryptor) ReadHeader(r io.Reader) ([]byte, error) {
+	if m.ReadHeaderFunc != nil {
+		return m.ReadHeaderFunc(r)
+	}
+	return []byte{}, nil
+}
+
+func (m *MockCryptor) ListKeySlots(header []byte) ([]KeySlotInfo, error) {
+	if m.ListKeySlotsFunc != nil {
+		return m.ListKeySlotsFunc(header)
+	}
+	return []KeySlotInfo{}, nil
+}
+
+func (m *MockCryptor) AddKeySlot(header []byte, unlock DecryptOptions, add EncryptOptions) ([]byte, error) {
+	if m.AddKeySlotFunc != nil {
+		return m.AddKeySlotFunc(header, unlock, add)
+	}
+	return header, nil
+}
+
+func (m *MockCryptor) RemoveKeySlot(header []byte, unlock DecryptOptions, index int) ([]byte, error) {
+	if m.RemoveKeySlotFunc != nil {
+		return m.RemoveKeySlotFunc(header, unlock, index)
+	}
+	return header, nil
+}
+
+func (m *MockCryptor) SignStream(w io.Writer, opts SignOptions) (io.WriteCloser, error) {
+	if m.SignStreamFunc != nil {
+		return m.SignStreamFunc(w, opts)
+	}
+	return nopWriteCloser{w}, nil
+}
+
+func (m *MockCryptor) ReadSignature(r io.ReaderAt, size int64) (*SignatureInfo, int64, error) {
+	if m.ReadSignatureFunc != nil {
+		return m.ReadSignatureFunc(r, size)
+	}
+	return nil, size, nil
+}
+
+func (m *MockCryptor) HasCachedKey(header []byte) bool {
+	if m.HasCachedKeyFunc != nil {
+		return m.HasCachedKeyFunc(header)
+	}
+	return false
+}
+
 // MockConfigManager implements ConfigManager interface for testing
 type MockConfigManager struct {
 	LoadFunc       func() (*Config, error)
@@ -0,0 +1,106 @@
+package types
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+)
+
+// FileSelection picks the files of an archive to extract by relative path.
+// A file is selected if it is listed in Paths or matches an Include pattern,
+// or if both are empty, and it matches no Exclude pattern. Patterns use
+// filepath.Match syntax and are matched against the whole relative path with
+// forward slashes, so "*" does not match files in subdirectories.
+type FileSelection struct {
+	Paths   []string
+	Include []string
+	Exclude []string
+}
+
+// Validate reports malformed patterns
+func (s *FileSelection) Validate() error {
+	if s == nil {
+		return nil
+	}
+	for _, pattern := range append(append([]string(nil), s.Include...), s.Exclude...) {
+		if _, err := path.Match(filepath.ToSlash(pattern), ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Selects reports whether the file at relativePath is selected. A nil
+// selection selects every file.
+func (s *FileSelection) Selects(relativePath string) bool {
+	if s == nil {
+		return true
+	}
+	name := cleanSelectionPath(relativePath)
+
+	if len(s.Paths) > 0 || len(s.Include) > 0 {
+		selected := false
+		for _, p := range s.Paths {
+			if cleanSelectionPath(p) == name {
+				selected = true
+				break
+			}
+		}
+		if !selected && !matchAny(s.Include, name) {
+			return false
+		}
+	}
+
+	return !matchAny(s.Exclude, name)
+}
+
+// Filter returns the selected files
+func (s *FileSelection) Filter(files []EnvFile) []EnvFile {
+	if s == nil {
+		return files
+	}
+
+	var selected []EnvFile
+	for _, file := range files {
+		if s.Selects(file.RelativePath) {
+			selected = append(selected, file)
+		}
+	}
+	return selected
+}
+
+// MissingPaths returns the entries of Paths that name none of files
+func (s *FileSelection) MissingPaths(files []EnvFile) []string {
+	if s == nil {
+		return nil
+	}
+
+	names := make(map[string]bool, len(files))
+	for _, file := range files {
+		names[cleanSelectionPath(file.RelativePath)] = true
+	}
+
+	var missing []string
+	for _, p := range s.Paths {
+		if !names[cleanSelectionPath(p)] {
+			missing = append(missing, p)
+		}
+	}
+	return missing
+}
+
+// matchAny reports whether name matches one of patterns
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(filepath.ToSlash(pattern), name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanSelectionPath normalizes a relative path for comparison, so that
+// "./config/.env" and "config\.env" on Windows name the same file
+func cleanSelectionPath(name string) string {
+	return path.Clean(filepath.ToSlash(name))
+}
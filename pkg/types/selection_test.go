@@ -0,0 +1,74 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFileSelection_Selects(t *testing.T) {
+	tests := []struct {
+		name      string
+		selection *FileSelection
+		path      string
+		want      bool
+	}{
+		{"Nil selects everything", nil, "api/.env", true},
+		{"Empty selects everything", &FileSelection{}, "api/.env", true},
+		{"Listed path", &FileSelection{Paths: []string{"api/.env"}}, "api/.env", true},
+		{"Listed path is cleaned", &FileSelection{Paths: []string{"./api//.env"}}, "api/.env", true},
+		{"Unlisted path", &FileSelection{Paths: []string{"api/.env"}}, ".env", false},
+		{"Include matches", &FileSelection{Include: []string{".env.*"}}, ".env.local", true},
+		{"Include does not cross directories", &FileSelection{Include: []string{".env.*"}}, "api/.env.local", false},
+		{"Include with directory", &FileSelection{Include: []string{"*/.env.*"}}, "api/.env.local", true},
+		{"Path or include", &FileSelection{Paths: []string{".env"}, Include: []string{"*/.env"}}, "api/.env", true},
+		{"Exclude only", &FileSelection{Exclude: []string{"*.local"}}, ".env.local", false},
+		{"Exclude wins over path", &FileSelection{Paths: []string{".env.local"}, Exclude: []string{"*.local"}}, ".env.local", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.selection.Selects(tt.path); got != tt.want {
+				t.Errorf("Selects(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileSelection_FilterAndMissingPaths(t *testing.T) {
+	files := []EnvFile{
+		{RelativePath: ".env"},
+		{RelativePath: ".env.local"},
+		{RelativePath: "api/.env"},
+	}
+	selection := &FileSelection{Paths: []string{"api/.env", "web/.env"}, Include: []string{".env"}}
+
+	var got []string
+	for _, file := range selection.Filter(files) {
+		got = append(got, file.RelativePath)
+	}
+	if want := []string{".env", "api/.env"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Filter() = %v, want %v", got, want)
+	}
+
+	if missing := selection.MissingPaths(files); !reflect.DeepEqual(missing, []string{"web/.env"}) {
+		t.Errorf("MissingPaths() = %v, want [web/.env]", missing)
+	}
+
+	var none *FileSelection
+	if len(none.Filter(files)) != len(files) || none.MissingPaths(files) != nil {
+		t.Error("A nil selection should select every file and miss none")
+	}
+}
+
+func TestFileSelection_Validate(t *testing.T) {
+	if err := (&FileSelection{Include: []string{".env.*"}, Exclude: []string{"*.local"}}).Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+	if err := (&FileSelection{Exclude: []string{"[.env"}}).Validate(); err == nil {
+		t.Error("Validate() should reject a malformed pattern")
+	}
+	var none *FileSelection
+	if err := none.Validate(); err != nil {
+		t.Errorf("Validate() on nil error = %v", err)
+	}
+}
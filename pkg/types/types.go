@@ -1,7 +1,10 @@
 package types
 
 import (
+	"io"
 	"time"
+
+	"goingenv/pkg/secret"
 )
 
 // EnvFile represents a detected environment file
@@ -24,11 +27,34 @@ type Archive struct {
 
 // Config holds application configuration
 type Config struct {
-	DefaultDepth       int      `json:"default_depth" validate:"min=1,max=10"`
-	EnvPatterns        []string `json:"env_patterns" validate:"required,min=1"`
-	EnvExcludePatterns []string `json:"env_exclude_patterns"`
-	ExcludePatterns    []string `json:"exclude_patterns"`
-	MaxFileSize        int64    `json:"max_file_size"`
+	DefaultDepth       int            `json:"default_depth" validate:"min=1,max=10"`
+	EnvPatterns        []string       `json:"env_patterns" validate:"required,min=1"`
+	EnvExcludePatterns []string       `json:"env_exclude_patterns"`
+	ExcludePatterns    []string       `json:"exclude_patterns"`
+	MaxFileSize        int64          `json:"max_file_size"`
+	Cipher             string         `json:"cipher"`      // "aes-256-gcm" or "xchacha20-poly1305"
+	Compression        string         `json:"compression"` // "gzip" or "none"
+	KDF                KDFConfig      `json:"kdf"`
+	PasswordPolicy     PasswordPolicy `json:"password_policy"`
+}
+
+// KDFConfig selects the key derivation function used when packing archives.
+// Decryption always uses the KDF recorded in the archive header.
+type KDFConfig struct {
+	Algorithm   string `json:"algorithm"`             // "pbkdf2" or "argon2id"
+	Iterations  uint32 `json:"iterations,omitempty"`  // PBKDF2 iteration count
+	Time        uint32 `json:"time,omitempty"`        // Argon2id passes over memory
+	Memory      uint32 `json:"memory,omitempty"`      // Argon2id memory in KiB
+	Parallelism uint8  `json:"parallelism,omitempty"` // Argon2id lanes
+}
+
+// PasswordPolicy sets the strength required of new archive passwords.
+// Zero values use the defaults of the password package.
+type PasswordPolicy struct {
+	MinScore  int      `json:"min_score,omitempty"`  // 1 (weak) to 4 (very strong)
+	MinLength int      `json:"min_length,omitempty"` // minimum number of characters
+	DenyList  []string `json:"deny_list,omitempty"`  // words passwords must not contain, case-insensitive
+	Disabled  bool     `json:"disabled,omitempty"`   // accept any non-empty password
 }
 
 // App holds all the application dependencies
@@ -53,17 +79,163 @@ type ScanOptions struct {
 type PackOptions struct {
 	Files       []EnvFile
 	OutputPath  string
-	Password    string
+	Password    *secret.Buffer // may be empty when KeyFile or Recipients is set
+	KeyFile     []byte         // key file digest from password.ReadKeyFile
+	Recipients  []string       // recipient public keys, e.g. from .goingenv/recipients
 	Description string
+	Sign        SignOptions // signs the archive when KeyPath is set
 }
 
 // UnpackOptions represents options for unpacking files
 type UnpackOptions struct {
 	ArchivePath string
-	Password    string
+	Password    *secret.Buffer
+	KeyFile     []byte   // key file digest from password.ReadKeyFile
+	RecoveryKey []byte   // recovery key rebuilt from shares
+	Identities  []string // paths to identity files
+	Passphrase  PassphraseFunc
 	TargetDir   string
 	Overwrite   bool
-	Backup      bool
+	Backup      BackupSnapshot // optional, saves existing files before they are replaced
+	Journal     UnpackJournal  // optional, records the files written so the unpack can be undone
+	Select      *FileSelection // optional, nil extracts every file
+	Confirm     ConfirmFunc    // optional, called before anything is extracted
+	Trust       TrustFunc      // optional, checks the signer before anything is decrypted
+}
+
+// ListOptions represents options for listing an archive
+type ListOptions struct {
+	ArchivePath string
+	Password    *secret.Buffer
+	KeyFile     []byte   // key file digest from password.ReadKeyFile
+	RecoveryKey []byte   // recovery key rebuilt from shares
+	Identities  []string // paths to identity files
+	Passphrase  PassphraseFunc
+	Trust       TrustFunc // optional, checks the signer before anything is decrypted
+}
+
+// ReadOptions represents options for reading archived files into memory
+type ReadOptions struct {
+	ArchivePath string
+	Password    *secret.Buffer
+	KeyFile     []byte   // key file digest from password.ReadKeyFile
+	RecoveryKey []byte   // recovery key rebuilt from shares
+	Identities  []string // paths to identity files
+	Passphrase  PassphraseFunc
+	Select      *FileSelection // optional, nil reads every file
+	Trust       TrustFunc      // optional, checks the signer before anything is decrypted
+}
+
+// RekeyOptions represents options for re-encrypting an archive with new keys
+type RekeyOptions struct {
+	ArchivePath string
+	Password    *secret.Buffer // current password
+	KeyFile     []byte         // current key file digest
+	Identities  []string       // paths to identity files that open the archive
+	Passphrase  PassphraseFunc
+	NewPassword *secret.Buffer // may be empty when NewKeyFile or Recipients is set
+	NewKeyFile  []byte         // key file digest for the re-encrypted archive, alone or together with NewPassword
+	Recipients  []string       // recipient public keys for the re-encrypted archive
+	Sign        SignOptions    // signs the re-encrypted archive when KeyPath is set
+	Trust       TrustFunc      // optional, checks the signer before anything is decrypted
+}
+
+// EncryptOptions holds the keys a new archive is locked with.
+// At least one of Password, KeyFile, RecoveryKey and Recipients must be set.
+// A password and key file together make a single slot that needs both.
+type EncryptOptions struct {
+	Password    *secret.Buffer
+	KeyFile     []byte // key file digest from password.ReadKeyFile
+	RecoveryKey []byte // adds a recovery slot for this key
+	Recipients  []string
+	Index       []byte // archive metadata, sealed apart from the payload
+}
+
+// DecryptOptions holds the keys tried when opening an archive
+type DecryptOptions struct {
+	Password    *secret.Buffer
+	KeyFile     []byte   // key file digest from password.ReadKeyFile
+	RecoveryKey []byte   // recovery key rebuilt from shares
+	Identities  []string // paths to identity files
+	Passphrase  PassphraseFunc
+}
+
+// SignOptions selects the key an archive is signed with
+type SignOptions struct {
+	KeyPath    string // OpenSSH ed25519 private key file
+	Passphrase PassphraseFunc
+}
+
+// SignatureInfo describes the verified signer of an archive
+type SignatureInfo struct {
+	PublicKey   string // authorized_keys form, e.g. "ssh-ed25519 AAAA..."
+	Fingerprint string // SHA256:... as shown by ssh-keygen -l
+}
+
+// PassphraseFunc returns the passphrase of an encrypted key file, such as a
+// passphrase-protected SSH private key. It is only called for key files that
+// need one. The returned buffer stays owned by the function, which may hand
+// it out again; callers must not destroy it.
+type PassphraseFunc func(path string) (*secret.Buffer, error)
+
+// ConfirmFunc is called by Unpack with the metadata of the unlocked archive
+// before anything is extracted, so the contents can be previewed from the
+// same decryption pass that extracts them. It returns false to stop without
+// extracting anything, and overwrite to replace existing files even though
+// UnpackOptions.Overwrite is not set.
+type ConfirmFunc func(archive *Archive) (proceed, overwrite bool, err error)
+
+// TrustFunc decides whether an archive may be opened, given its verified
+// signer, or nil if it is not signed. It is called on the open file that is
+// then decrypted, so the archive cannot be replaced between the check and
+// the decryption. Returning an error refuses the archive.
+type TrustFunc func(signer *SignatureInfo) error
+
+// BackupSnapshot saves copies of the files Unpack replaces. Unpack adds each
+// existing file, by its path relative to the target directory, before
+// replacing it, commits the snapshot once every file is in place, and
+// discards it if the unpack is rolled back.
+type BackupSnapshot interface {
+	Add(root, relativePath string) error
+	Commit() error
+	Discard() error
+}
+
+// UnpackJournal records what Unpack changed. Unpack calls Record once every
+// file is in place, after the backup is committed, and Discard if the unpack
+// is rolled back after that.
+type UnpackJournal interface {
+	Record(record UnpackRecord) error
+	Discard() error
+}
+
+// UnpackRecord lists the changes of one unpack
+type UnpackRecord struct {
+	TargetDir   string         // absolute target directory
+	Files       []UnpackedFile // files written, in extraction order
+	CreatedDirs []string       // directories created inside TargetDir, relative, parents first
+}
+
+// UnpackedFile is a file written by Unpack
+type UnpackedFile struct {
+	RelativePath string // slash-separated, relative to the target directory
+	Checksum     string // SHA-256 of the written file
+	Replaced     bool   // an existing file was replaced
+}
+
+// KeySlotInfo describes one key slot of an archive header
+type KeySlotInfo struct {
+	Index int
+	// Type is "password", "keyfile", "password+keyfile", "x25519",
+	// "ssh-ed25519" or "recovery"
+	Type string
+	// KDF is set for password and key file slots: the key derivation
+	// function and its cost
+	KDF string
+	// Recipient is set for the other slots: the X25519 public key, the
+	// fingerprint of the SSH key, or the ID of the recovery key the slot is
+	// wrapped for
+	Recipient string
 }
 
 // Interfaces for better testability and decoupling
@@ -78,15 +250,40 @@ type Scanner interface {
 type Archiver interface {
 	Pack(opts PackOptions) error
 	Unpack(opts UnpackOptions) error
-	List(archivePath, password string) (*Archive, error)
+	List(opts ListOptions) (*Archive, error)
+	ReadFiles(opts ReadOptions) (*Archive, map[string][]byte, error)
 	GetAvailableArchives(dir string) ([]string, error)
+	ReadHeader(archivePath string) ([]byte, error)
+	ReplaceHeader(archivePath string, header []byte, sign SignOptions, trust TrustFunc) error
+	Rekey(opts RekeyOptions) error
+	VerifySignature(archivePath string) (*SignatureInfo, error)
 }
 
 // Cryptor interface for encryption operations
 type Cryptor interface {
-	Encrypt(data []byte, password string) ([]byte, error)
-	Decrypt(data []byte, password string) ([]byte, error)
-	ValidatePassword(data []byte, password string) error
+	Encrypt(data []byte, password *secret.Buffer) ([]byte, error)
+	Decrypt(data []byte, password *secret.Buffer) ([]byte, error)
+	EncryptStream(w io.Writer, opts EncryptOptions) (io.WriteCloser, error)
+	DecryptStream(r io.Reader, opts DecryptOptions) (io.Reader, error)
+	DecryptIndex(r io.Reader, opts DecryptOptions) ([]byte, io.Reader, error)
+	ReadHeader(r io.Reader) ([]byte, error)
+	ListKeySlots(header []byte) ([]KeySlotInfo, error)
+	AddKeySlot(header []byte, unlock DecryptOptions, add EncryptOptions) ([]byte, error)
+	RemoveKeySlot(header []byte, unlock DecryptOptions, index int) ([]byte, error)
+	SignStream(w io.Writer, opts SignOptions) (io.WriteCloser, error)
+	ReadSignature(r io.ReaderAt, size int64) (*SignatureInfo, int64, error)
+	ValidatePassword(data []byte, password *secret.Buffer) error
+	HasCachedKey(header []byte) bool
+}
+
+// KeyCache holds keys derived from passwords and key files between commands,
+// so an archive that was opened once does not ask for its password again
+// until the cached key expires. It is implemented by the key agent client.
+type KeyCache interface {
+	// GetKey returns the key cached under id, or nil if there is none
+	GetKey(id string) ([]byte, error)
+	// PutKey caches key under id
+	PutKey(id string, key []byte) error
 }
 
 // ConfigManager interface for configuration management
@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// WriteFileAtomic writes data to path like os.WriteFile, but atomically.
+// See WriteAtomic.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	return WriteAtomic(path, perm, func(w io.Writer) error {
+		_, err := io.Copy(w, bytes.NewReader(data))
+		return err
+	})
+}
+
+// WriteAtomic replaces path with the output of write. The data is written to
+// a temporary file in the same directory, which gets perm, is synced and is
+// then renamed over path, so readers and crashes only ever see the old file
+// or the complete new one. If write or any later step fails, the temporary
+// file is removed and path is left untouched. The error from write is
+// returned as it is.
+func WriteAtomic(path string, perm os.FileMode, write func(w io.Writer) error) error {
+	dir := filepath.Dir(path)
+
+	// The temporary file is created with mode 0600, so data is never
+	// readable by others before perm is applied
+	tmpFile, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tmpPath := tmpFile.Name()
+
+	err = write(tmpFile)
+	if err == nil {
+		err = tmpFile.Chmod(perm)
+	}
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	syncDir(dir)
+	return nil
+}
+
+// syncDir flushes a directory entry change such as a rename to disk. It is
+// best effort: some platforms cannot open or sync directories, and the
+// rename has already happened either way.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	d.Close()
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "archive.enc")
+
+	if err := WriteFileAtomic(path, []byte("first"), 0600); err != nil {
+		t.Fatalf("WriteFileAtomic failed: %v", err)
+	}
+	if err := WriteFileAtomic(path, []byte("second"), 0600); err != nil {
+		t.Fatalf("WriteFileAtomic over an existing file failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if string(data) != "second" {
+		t.Errorf("content = %q, want %q", data, "second")
+	}
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+		if perm := info.Mode().Perm(); perm != 0600 {
+			t.Errorf("permissions = %o, want 600", perm)
+		}
+	}
+
+	assertOnlyFile(t, dir, "archive.enc")
+}
+
+func TestWriteAtomic_FailureKeepsOriginal(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, []byte("original"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	errDiskFull := errors.New("no space left on device")
+	err := WriteAtomic(path, 0644, func(w io.Writer) error {
+		if _, err := w.Write([]byte("half of the new")); err != nil {
+			return err
+		}
+		return errDiskFull
+	})
+	if !errors.Is(err, errDiskFull) {
+		t.Fatalf("WriteAtomic() error = %v, want the error from write", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if string(data) != "original" {
+		t.Errorf("content = %q, want the original to be untouched", data)
+	}
+	assertOnlyFile(t, dir, "config.json")
+}
+
+func TestWriteAtomic_NewFileFailure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "archive.enc")
+
+	err := WriteAtomic(path, 0600, func(w io.Writer) error {
+		return errors.New("encryption failed")
+	})
+	if err == nil {
+		t.Fatal("WriteAtomic() should return the error from write")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Stat() error = %v, want the file not to exist", err)
+	}
+	assertOnlyFile(t, dir)
+}
+
+func TestWriteAtomic_MissingDirectory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "archive.enc")
+	if err := WriteFileAtomic(path, []byte("data"), 0600); err == nil {
+		t.Error("WriteFileAtomic() should fail when the directory does not exist")
+	}
+}
+
+// assertOnlyFile fails unless dir holds exactly the named files, so that
+// leftover temporary files are caught
+func assertOnlyFile(t *testing.T, dir string, names ...string) {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(entries) != len(names) {
+		var got []string
+		for _, entry := range entries {
+			got = append(got, entry.Name())
+		}
+		t.Fatalf("directory holds %v, want %v", got, names)
+	}
+	for i, entry := range entries {
+		if entry.Name() != names[i] {
+			t.Errorf("directory entry %d = %s, want %s", i, entry.Name(), names[i])
+		}
+	}
+}
@@ -1,15 +1,34 @@
 package integration
 
 import (
+	"archive/tar"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
+	"golang.org/x/crypto/ssh"
+
+	"goingenv/internal/agent"
 	"goingenv/internal/archive"
+	"goingenv/internal/backup"
 	"goingenv/internal/config"
 	"goingenv/internal/crypto"
+	"goingenv/internal/journal"
 	"goingenv/internal/scanner"
+	"goingenv/pkg/password"
+	"goingenv/pkg/secret"
 	"goingenv/pkg/types"
 	"goingenv/test/testutils"
 )
@@ -79,7 +98,7 @@ func TestFullWorkflow(t *testing.T) {
 		packOpts := types.PackOptions{
 			Files:       files,
 			OutputPath:  archivePath,
-			Password:    password,
+			Password:    secret.FromString(password),
 			Description: "Integration test archive",
 		}
 
@@ -94,13 +113,16 @@ func TestFullWorkflow(t *testing.T) {
 		if stat.Size() == 0 {
 			t.Error("Archive file is empty")
 		}
+		if runtime.GOOS != "windows" && stat.Mode().Perm() != archive.ArchiveFileMode {
+			t.Errorf("Archive permissions = %o, want %o", stat.Mode().Perm(), archive.ArchiveFileMode)
+		}
 
 		t.Logf("Created archive: %s (%d bytes)", archivePath, stat.Size())
 	})
 
 	// Test listing
 	t.Run("List Archive Contents", func(t *testing.T) {
-		archive, err := archiverService.List(archivePath, password)
+		archive, err := archiverService.List(types.ListOptions{ArchivePath: archivePath, Password: secret.FromString(password)})
 		testutils.AssertNoError(t, err)
 
 		if len(archive.Files) != len(files) {
@@ -134,10 +156,9 @@ func TestFullWorkflow(t *testing.T) {
 
 		unpackOpts := types.UnpackOptions{
 			ArchivePath: archivePath,
-			Password:    password,
+			Password:    secret.FromString(password),
 			TargetDir:   unpackDir,
 			Overwrite:   true,
-			Backup:      false,
 		}
 
 		err = archiverService.Unpack(unpackOpts)
@@ -191,7 +212,7 @@ func TestErrorHandling(t *testing.T) {
 		packOpts := types.PackOptions{
 			Files:      []types.EnvFile{},
 			OutputPath: "/invalid/path/archive.enc",
-			Password:   "test",
+			Password:   secret.FromString("test"),
 		}
 
 		err := archiverService.Pack(packOpts)
@@ -227,7 +248,7 @@ func TestErrorHandling(t *testing.T) {
 		packOpts := types.PackOptions{
 			Files:       files,
 			OutputPath:  archivePath,
-			Password:    correctPassword,
+			Password:    secret.FromString(correctPassword),
 			Description: "Test archive for wrong password test",
 		}
 
@@ -237,7 +258,7 @@ func TestErrorHandling(t *testing.T) {
 		// Try to unpack with wrong password
 		unpackOpts := types.UnpackOptions{
 			ArchivePath: archivePath,
-			Password:    "wrong-password",
+			Password:    secret.FromString("wrong-password"),
 			TargetDir:   tmpDir,
 		}
 
@@ -253,8 +274,35 @@ func TestErrorHandling(t *testing.T) {
 		}
 	})
 
+	t.Run("Failed Pack Leaves No Partial Archive", func(t *testing.T) {
+		tmpDir := testutils.CreateTempEnvFiles(t)
+		defer os.RemoveAll(tmpDir)
+		testutils.CreateTempGoingEnvDir(t, tmpDir)
+
+		archivePath := filepath.Join(tmpDir, ".goingenv", "partial.enc")
+		err := archiverService.Pack(types.PackOptions{
+			Files: []types.EnvFile{
+				{Path: filepath.Join(tmpDir, ".env"), RelativePath: ".env", Size: 1},
+				{Path: filepath.Join(tmpDir, "vanished.env"), RelativePath: "vanished.env", Size: 1},
+			},
+			OutputPath: archivePath,
+			Password:   secret.FromString("test-password-123"),
+		})
+		if err == nil {
+			t.Fatal("Expected packing a missing file to fail")
+		}
+
+		entries, err := os.ReadDir(filepath.Dir(archivePath))
+		testutils.AssertNoError(t, err)
+		for _, entry := range entries {
+			if strings.HasSuffix(entry.Name(), ".enc") || strings.HasSuffix(entry.Name(), ".tmp") {
+				t.Errorf("Failed pack left %s behind", entry.Name())
+			}
+		}
+	})
+
 	t.Run("List Non-existent Archive", func(t *testing.T) {
-		_, err := archiverService.List("/path/to/nonexistent.enc", "password")
+		_, err := archiverService.List(types.ListOptions{ArchivePath: "/path/to/nonexistent.enc", Password: secret.FromString("password")})
 		if err == nil {
 			t.Error("Expected error when listing non-existent archive, got nil")
 		}
@@ -371,6 +419,13 @@ func TestConfigIntegration(t *testing.T) {
 		if err == nil {
 			t.Error("Expected validation error for invalid config, got nil")
 		}
+
+		// Test out-of-range password policy
+		invalidPolicy := *validConfig
+		invalidPolicy.PasswordPolicy = types.PasswordPolicy{MinScore: 5}
+		if err := configManager.Validate(&invalidPolicy); err == nil {
+			t.Error("Expected validation error for invalid password policy, got nil")
+		}
 	})
 }
 
@@ -461,7 +516,7 @@ func TestConcurrentAccess(t *testing.T) {
 				packOpts := types.PackOptions{
 					Files:       files,
 					OutputPath:  archivePath,
-					Password:    "concurrent-test-password",
+					Password:    secret.FromString("concurrent-test-password"),
 					Description: "Concurrent test archive",
 				}
 
@@ -554,7 +609,7 @@ func TestInitializationRequirement(t *testing.T) {
 		packOpts := types.PackOptions{
 			Files:       files,
 			OutputPath:  archivePath,
-			Password:    "test-password",
+			Password:    secret.FromString("test-password"),
 			Description: "Test archive",
 		}
 
@@ -577,7 +632,7 @@ func TestInitializationRequirement(t *testing.T) {
 		packOpts := types.PackOptions{
 			Files:       files,
 			OutputPath:  archivePath,
-			Password:    "test-password",
+			Password:    secret.FromString("test-password"),
 			Description: "Test archive with proper initialization",
 		}
 
@@ -660,3 +715,2070 @@ func TestConfigInitialization(t *testing.T) {
 		testutils.AssertNoError(t, err)
 	})
 }
+
+func TestRecipientWorkflow(t *testing.T) {
+	tmpDir := testutils.CreateTempEnvFiles(t)
+	defer os.RemoveAll(tmpDir)
+	testutils.CreateTempGoingEnvDir(t, tmpDir)
+
+	cfg := testutils.CreateTestConfig()
+	archiverService := archive.NewService(crypto.NewService())
+
+	files, err := scanner.NewService(cfg).ScanFiles(types.ScanOptions{
+		RootPath: tmpDir,
+		MaxDepth: cfg.DefaultDepth,
+	})
+	testutils.AssertNoError(t, err)
+
+	// Two teammates, each with their own identity file
+	var recipients, identityFiles []string
+	for _, name := range []string{"alice", "bob"} {
+		identity, err := crypto.GenerateX25519Identity()
+		testutils.AssertNoError(t, err)
+		path := filepath.Join(tmpDir, name+"-identity.txt")
+		testutils.WriteTestFile(t, path, identity.String()+"\n")
+		recipients = append(recipients, identity.Recipient().String())
+		identityFiles = append(identityFiles, path)
+	}
+
+	archivePath := filepath.Join(tmpDir, ".goingenv", "team.enc")
+	err = archiverService.Pack(types.PackOptions{
+		Files:       files,
+		OutputPath:  archivePath,
+		Recipients:  recipients,
+		Description: "Team archive",
+	})
+	testutils.AssertNoError(t, err)
+
+	for i, identityFile := range identityFiles {
+		archive, err := archiverService.List(types.ListOptions{
+			ArchivePath: archivePath,
+			Identities:  []string{identityFile},
+		})
+		testutils.AssertNoError(t, err)
+		if len(archive.Files) != len(files) {
+			t.Errorf("Recipient %d sees %d files, expected %d", i, len(archive.Files), len(files))
+		}
+
+		unpackDir := filepath.Join(tmpDir, fmt.Sprintf("unpacked-%d", i))
+		testutils.AssertNoError(t, os.MkdirAll(unpackDir, 0755))
+		err = archiverService.Unpack(types.UnpackOptions{
+			ArchivePath: archivePath,
+			Identities:  []string{identityFile},
+			TargetDir:   unpackDir,
+		})
+		testutils.AssertNoError(t, err)
+
+		for _, file := range files {
+			if !testutils.CompareFiles(t, file.Path, filepath.Join(unpackDir, file.RelativePath)) {
+				t.Errorf("Recipient %d unpacked a different %s", i, file.RelativePath)
+			}
+		}
+	}
+
+	// An outsider's identity must not open the archive
+	outsider, err := crypto.GenerateX25519Identity()
+	testutils.AssertNoError(t, err)
+	outsiderFile := filepath.Join(tmpDir, "outsider-identity.txt")
+	testutils.WriteTestFile(t, outsiderFile, outsider.String()+"\n")
+
+	_, err = archiverService.List(types.ListOptions{
+		ArchivePath: archivePath,
+		Identities:  []string{outsiderFile},
+	})
+	if err == nil {
+		t.Error("Expected listing with a non-recipient identity to fail")
+	}
+}
+
+func TestKeySlotWorkflow(t *testing.T) {
+	tmpDir := testutils.CreateTempEnvFiles(t)
+	defer os.RemoveAll(tmpDir)
+	testutils.CreateTempGoingEnvDir(t, tmpDir)
+
+	cfg := testutils.CreateTestConfig()
+	cryptoService := crypto.NewService()
+	archiverService := archive.NewService(cryptoService)
+
+	files, err := scanner.NewService(cfg).ScanFiles(types.ScanOptions{
+		RootPath: tmpDir,
+		MaxDepth: cfg.DefaultDepth,
+	})
+	testutils.AssertNoError(t, err)
+
+	archivePath := filepath.Join(tmpDir, ".goingenv", "slots.enc")
+	err = archiverService.Pack(types.PackOptions{
+		Files:      files,
+		OutputPath: archivePath,
+		Password:   secret.FromString("owner-password"),
+	})
+	testutils.AssertNoError(t, err)
+
+	// Give a second person their own password without re-packing
+	header, err := archiverService.ReadHeader(archivePath)
+	testutils.AssertNoError(t, err)
+	header, err = cryptoService.AddKeySlot(header,
+		types.DecryptOptions{Password: secret.FromString("owner-password")},
+		types.EncryptOptions{Password: secret.FromString("guest-password")})
+	testutils.AssertNoError(t, err)
+	testutils.AssertNoError(t, archiverService.ReplaceHeader(archivePath, header, types.SignOptions{}, nil))
+
+	for _, pass := range []string{"owner-password", "guest-password"} {
+		archive, err := archiverService.List(types.ListOptions{ArchivePath: archivePath, Password: secret.FromString(pass)})
+		testutils.AssertNoError(t, err)
+		if len(archive.Files) != len(files) {
+			t.Errorf("Listing with %q shows %d files, expected %d", pass, len(archive.Files), len(files))
+		}
+	}
+
+	// Revoke the guest password again
+	header, err = archiverService.ReadHeader(archivePath)
+	testutils.AssertNoError(t, err)
+	header, err = cryptoService.RemoveKeySlot(header, types.DecryptOptions{Password: secret.FromString("owner-password")}, 1)
+	testutils.AssertNoError(t, err)
+	testutils.AssertNoError(t, archiverService.ReplaceHeader(archivePath, header, types.SignOptions{}, nil))
+
+	if _, err := archiverService.List(types.ListOptions{ArchivePath: archivePath, Password: secret.FromString("guest-password")}); err == nil {
+		t.Error("Expected the removed password to be rejected")
+	}
+
+	unpackDir := filepath.Join(tmpDir, "unpacked")
+	testutils.AssertNoError(t, os.MkdirAll(unpackDir, 0755))
+	err = archiverService.Unpack(types.UnpackOptions{
+		ArchivePath: archivePath,
+		Password:    secret.FromString("owner-password"),
+		TargetDir:   unpackDir,
+	})
+	testutils.AssertNoError(t, err)
+	for _, file := range files {
+		if !testutils.CompareFiles(t, file.Path, filepath.Join(unpackDir, file.RelativePath)) {
+			t.Errorf("File %s differs after key slot changes", file.RelativePath)
+		}
+	}
+
+	// No temporary files are left next to the archive
+	entries, err := os.ReadDir(filepath.Dir(archivePath))
+	testutils.AssertNoError(t, err)
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".tmp") {
+			t.Errorf("Temporary file %s left behind", entry.Name())
+		}
+	}
+}
+
+func TestRekeyWorkflow(t *testing.T) {
+	tmpDir := testutils.CreateTempEnvFiles(t)
+	defer os.RemoveAll(tmpDir)
+	testutils.CreateTempGoingEnvDir(t, tmpDir)
+
+	cfg := testutils.CreateTestConfig()
+	archiverService := archive.NewService(crypto.NewService())
+
+	files, err := scanner.NewService(cfg).ScanFiles(types.ScanOptions{
+		RootPath: tmpDir,
+		MaxDepth: cfg.DefaultDepth,
+	})
+	testutils.AssertNoError(t, err)
+
+	archivePath := filepath.Join(tmpDir, ".goingenv", "rekey.enc")
+	err = archiverService.Pack(types.PackOptions{
+		Files:       files,
+		OutputPath:  archivePath,
+		Password:    secret.FromString("old-password"),
+		Description: "Before rotation",
+	})
+	testutils.AssertNoError(t, err)
+
+	before, err := archiverService.List(types.ListOptions{ArchivePath: archivePath, Password: secret.FromString("old-password")})
+	testutils.AssertNoError(t, err)
+
+	err = archiverService.Rekey(types.RekeyOptions{
+		ArchivePath: archivePath,
+		Password:    secret.FromString("old-password"),
+		NewPassword: secret.FromString("new-password"),
+	})
+	testutils.AssertNoError(t, err)
+
+	if _, err := archiverService.List(types.ListOptions{ArchivePath: archivePath, Password: secret.FromString("old-password")}); err == nil {
+		t.Error("Expected the old password to be rejected after rekey")
+	}
+
+	after, err := archiverService.List(types.ListOptions{ArchivePath: archivePath, Password: secret.FromString("new-password")})
+	testutils.AssertNoError(t, err)
+
+	if !after.CreatedAt.Equal(before.CreatedAt) {
+		t.Errorf("CreatedAt changed from %v to %v", before.CreatedAt, after.CreatedAt)
+	}
+	if after.Description != before.Description {
+		t.Errorf("Description changed from %q to %q", before.Description, after.Description)
+	}
+	if len(after.Files) != len(before.Files) {
+		t.Fatalf("File count changed from %d to %d", len(before.Files), len(after.Files))
+	}
+	for i := range before.Files {
+		if after.Files[i].RelativePath != before.Files[i].RelativePath || after.Files[i].Checksum != before.Files[i].Checksum {
+			t.Errorf("File %d changed from %+v to %+v", i, before.Files[i], after.Files[i])
+		}
+	}
+
+	// A failed rekey leaves the archive exactly as it was
+	original, err := os.ReadFile(archivePath)
+	testutils.AssertNoError(t, err)
+
+	err = archiverService.Rekey(types.RekeyOptions{
+		ArchivePath: archivePath,
+		Password:    secret.FromString("wrong-password"),
+		NewPassword: secret.FromString("other-password"),
+	})
+	if err == nil {
+		t.Error("Expected rekey with a wrong password to fail")
+	}
+
+	corrupted := append([]byte{}, original...)
+	corrupted[len(corrupted)-1] ^= 0x01
+	testutils.AssertNoError(t, os.WriteFile(archivePath, corrupted, 0644))
+
+	err = archiverService.Rekey(types.RekeyOptions{
+		ArchivePath: archivePath,
+		Password:    secret.FromString("new-password"),
+		NewPassword: secret.FromString("other-password"),
+	})
+	if err == nil {
+		t.Error("Expected rekey of a corrupted archive to fail")
+	}
+
+	current, err := os.ReadFile(archivePath)
+	testutils.AssertNoError(t, err)
+	if string(current) != string(corrupted) {
+		t.Error("Failed rekey modified the archive")
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(archivePath))
+	testutils.AssertNoError(t, err)
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".tmp") {
+			t.Errorf("Temporary file %s left behind", entry.Name())
+		}
+	}
+}
+
+func TestSignatureWorkflow(t *testing.T) {
+	tmpDir := testutils.CreateTempEnvFiles(t)
+	defer os.RemoveAll(tmpDir)
+	testutils.CreateTempGoingEnvDir(t, tmpDir)
+
+	cfg := testutils.CreateTestConfig()
+	archiverService := archive.NewService(crypto.NewService())
+
+	files, err := scanner.NewService(cfg).ScanFiles(types.ScanOptions{
+		RootPath: tmpDir,
+		MaxDepth: cfg.DefaultDepth,
+	})
+	testutils.AssertNoError(t, err)
+
+	// An OpenSSH ed25519 signing key
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	testutils.AssertNoError(t, err)
+	block, err := ssh.MarshalPrivateKey(priv, "release@example.com")
+	testutils.AssertNoError(t, err)
+	keyPath := filepath.Join(tmpDir, "id_ed25519")
+	testutils.WriteTestFile(t, keyPath, string(pem.EncodeToMemory(block)))
+	sshPub, err := ssh.NewPublicKey(pub)
+	testutils.AssertNoError(t, err)
+
+	archivePath := filepath.Join(tmpDir, ".goingenv", "signed.enc")
+	err = archiverService.Pack(types.PackOptions{
+		Files:       files,
+		OutputPath:  archivePath,
+		Password:    secret.FromString("team-password"),
+		Description: "Signed archive",
+		Sign:        types.SignOptions{KeyPath: keyPath},
+	})
+	testutils.AssertNoError(t, err)
+
+	signer, err := archiverService.VerifySignature(archivePath)
+	testutils.AssertNoError(t, err)
+	if signer == nil || signer.Fingerprint != ssh.FingerprintSHA256(sshPub) {
+		t.Fatalf("Unexpected signer %+v, want %s", signer, ssh.FingerprintSHA256(sshPub))
+	}
+
+	trusted := []string{string(ssh.MarshalAuthorizedKey(sshPub))}
+	if _, ok, err := crypto.FindTrustedSigner(signer, trusted); err != nil || !ok {
+		t.Errorf("Signer not found in trusted signers: %v", err)
+	}
+
+	// Signed archives open like any other
+	listed, err := archiverService.List(types.ListOptions{ArchivePath: archivePath, Password: secret.FromString("team-password")})
+	testutils.AssertNoError(t, err)
+	if len(listed.Files) != len(files) {
+		t.Errorf("Expected %d files, got %d", len(files), len(listed.Files))
+	}
+
+	extractDir := filepath.Join(tmpDir, "extract")
+	err = archiverService.Unpack(types.UnpackOptions{
+		ArchivePath: archivePath,
+		Password:    secret.FromString("team-password"),
+		TargetDir:   extractDir,
+	})
+	testutils.AssertNoError(t, err)
+
+	// Re-signing a rewritten header keeps the signature valid; rewriting it
+	// without a key drops the signature
+	header, err := archiverService.ReadHeader(archivePath)
+	testutils.AssertNoError(t, err)
+	testutils.AssertNoError(t, archiverService.ReplaceHeader(archivePath, header, types.SignOptions{KeyPath: keyPath}, nil))
+	if signer, err := archiverService.VerifySignature(archivePath); err != nil || signer == nil {
+		t.Errorf("Expected the re-signed archive to verify, got %+v, %v", signer, err)
+	}
+
+	signed, err := os.ReadFile(archivePath)
+	testutils.AssertNoError(t, err)
+
+	testutils.AssertNoError(t, archiverService.ReplaceHeader(archivePath, header, types.SignOptions{}, nil))
+	if signer, err := archiverService.VerifySignature(archivePath); err != nil || signer != nil {
+		t.Errorf("Expected an unsigned archive, got %+v, %v", signer, err)
+	}
+	if _, err := archiverService.List(types.ListOptions{ArchivePath: archivePath, Password: secret.FromString("team-password")}); err != nil {
+		t.Errorf("List of the unsigned archive failed: %v", err)
+	}
+
+	// Trust is checked on the file that is decrypted, so an archive replaced
+	// after the caller checked its signature is still refused
+	unsigned, err := os.ReadFile(archivePath)
+	testutils.AssertNoError(t, err)
+	testutils.AssertNoError(t, os.WriteFile(archivePath, signed, 0644))
+
+	var seen *types.SignatureInfo
+	trustSigner := func(opened *types.SignatureInfo) error {
+		seen = opened
+		if opened == nil || opened.Fingerprint != ssh.FingerprintSHA256(sshPub) {
+			return fmt.Errorf("not signed by the release key")
+		}
+		return nil
+	}
+	err = archiverService.Unpack(types.UnpackOptions{
+		ArchivePath: archivePath,
+		Password:    secret.FromString("team-password"),
+		TargetDir:   filepath.Join(tmpDir, "trusted"),
+		Trust:       trustSigner,
+	})
+	testutils.AssertNoError(t, err)
+	if seen == nil || seen.PublicKey != signer.PublicKey {
+		t.Errorf("Trust saw signer %+v, want %+v", seen, signer)
+	}
+
+	testutils.AssertNoError(t, os.WriteFile(archivePath, unsigned, 0644))
+	untrustedDir := filepath.Join(tmpDir, "untrusted")
+	err = archiverService.Unpack(types.UnpackOptions{
+		ArchivePath: archivePath,
+		Password:    secret.FromString("team-password"),
+		TargetDir:   untrustedDir,
+		Trust:       trustSigner,
+	})
+	if err == nil || !strings.Contains(err.Error(), "not signed by the release key") {
+		t.Errorf("Expected Unpack of the replaced archive to be refused, got %v", err)
+	}
+	if _, err := os.Stat(untrustedDir); !os.IsNotExist(err) {
+		t.Error("Refused archive was extracted")
+	}
+	if _, err := archiverService.List(types.ListOptions{ArchivePath: archivePath, Password: secret.FromString("team-password"), Trust: trustSigner}); err == nil {
+		t.Error("Expected List of the replaced archive to be refused")
+	}
+	if _, _, err := archiverService.ReadFiles(types.ReadOptions{ArchivePath: archivePath, Password: secret.FromString("team-password"), Trust: trustSigner}); err == nil {
+		t.Error("Expected ReadFiles of the replaced archive to be refused")
+	}
+
+	// Rewrites of a refused archive leave it untouched
+	err = archiverService.ReplaceHeader(archivePath, header, types.SignOptions{KeyPath: keyPath}, trustSigner)
+	if err == nil {
+		t.Error("Expected ReplaceHeader of the replaced archive to be refused")
+	}
+	err = archiverService.Rekey(types.RekeyOptions{
+		ArchivePath: archivePath,
+		Password:    secret.FromString("team-password"),
+		NewPassword: secret.FromString("new-team-password"),
+		Sign:        types.SignOptions{KeyPath: keyPath},
+		Trust:       trustSigner,
+	})
+	if err == nil {
+		t.Error("Expected Rekey of the replaced archive to be refused")
+	}
+	if data, err := os.ReadFile(archivePath); err != nil || !bytes.Equal(data, unsigned) {
+		t.Errorf("Refused rewrites changed the archive: %v", err)
+	}
+
+	// Rekeying without a signing key removes the signature; the trust check
+	// sees the signer that was removed, and signing again restores it
+	testutils.AssertNoError(t, os.WriteFile(archivePath, signed, 0644))
+	seen = nil
+	err = archiverService.Rekey(types.RekeyOptions{
+		ArchivePath: archivePath,
+		Password:    secret.FromString("team-password"),
+		NewPassword: secret.FromString("rekeyed-password"),
+		Trust:       trustSigner,
+	})
+	testutils.AssertNoError(t, err)
+	if seen == nil || seen.PublicKey != signer.PublicKey {
+		t.Errorf("Rekey reported signer %+v, want %+v", seen, signer)
+	}
+	if rekeyed, err := archiverService.VerifySignature(archivePath); err != nil || rekeyed != nil {
+		t.Errorf("Expected the rekeyed archive to be unsigned, got %+v, %v", rekeyed, err)
+	}
+
+	err = archiverService.Rekey(types.RekeyOptions{
+		ArchivePath: archivePath,
+		Password:    secret.FromString("rekeyed-password"),
+		NewPassword: secret.FromString("team-password"),
+		Sign:        types.SignOptions{KeyPath: keyPath},
+	})
+	testutils.AssertNoError(t, err)
+	if rekeyed, err := archiverService.VerifySignature(archivePath); err != nil || rekeyed == nil || rekeyed.PublicKey != signer.PublicKey {
+		t.Errorf("Expected the archive rekeyed with --sign to be signed, got %+v, %v", rekeyed, err)
+	}
+
+	// Any modification of a signed archive is refused before decryption
+	tampered := append([]byte{}, signed...)
+	tampered[len(tampered)-crypto.SignatureTrailerSize-1] ^= 0x01
+	testutils.AssertNoError(t, os.WriteFile(archivePath, tampered, 0644))
+
+	if _, err := archiverService.VerifySignature(archivePath); err == nil {
+		t.Error("Expected a tampered archive to fail signature verification")
+	}
+	if _, err := archiverService.List(types.ListOptions{ArchivePath: archivePath, Password: secret.FromString("team-password")}); err == nil {
+		t.Error("Expected List of a tampered archive to fail")
+	}
+	err = archiverService.Unpack(types.UnpackOptions{
+		ArchivePath: archivePath,
+		Password:    secret.FromString("team-password"),
+		TargetDir:   filepath.Join(tmpDir, "tampered"),
+	})
+	if err == nil {
+		t.Error("Expected Unpack of a tampered archive to fail")
+	}
+}
+
+func TestKeyFileWorkflow(t *testing.T) {
+	tmpDir := testutils.CreateTempEnvFiles(t)
+	defer os.RemoveAll(tmpDir)
+	testutils.CreateTempGoingEnvDir(t, tmpDir)
+
+	cfg := testutils.CreateTestConfig()
+	archiverService := archive.NewService(crypto.NewService())
+
+	files, err := scanner.NewService(cfg).ScanFiles(types.ScanOptions{
+		RootPath: tmpDir,
+		MaxDepth: cfg.DefaultDepth,
+	})
+	testutils.AssertNoError(t, err)
+
+	// A key file mounted into a CI runner
+	keyPath := filepath.Join(tmpDir, "ci.key")
+	keyMaterial := make([]byte, 64)
+	_, err = rand.Read(keyMaterial)
+	testutils.AssertNoError(t, err)
+	testutils.AssertNoError(t, os.WriteFile(keyPath, keyMaterial, 0600))
+
+	keyFile, err := password.ReadKeyFile(keyPath)
+	testutils.AssertNoError(t, err)
+
+	t.Run("Key file alone", func(t *testing.T) {
+		archivePath := filepath.Join(tmpDir, ".goingenv", "ci.enc")
+		err := archiverService.Pack(types.PackOptions{
+			Files:       files,
+			OutputPath:  archivePath,
+			KeyFile:     keyFile,
+			Description: "CI archive",
+		})
+		testutils.AssertNoError(t, err)
+
+		archive, err := archiverService.List(types.ListOptions{ArchivePath: archivePath, KeyFile: keyFile})
+		testutils.AssertNoError(t, err)
+		if len(archive.Files) != len(files) {
+			t.Errorf("Expected %d files, got %d", len(files), len(archive.Files))
+		}
+
+		extractDir := filepath.Join(tmpDir, "extract-ci")
+		err = archiverService.Unpack(types.UnpackOptions{
+			ArchivePath: archivePath,
+			KeyFile:     keyFile,
+			TargetDir:   extractDir,
+		})
+		testutils.AssertNoError(t, err)
+		for _, file := range files {
+			if _, err := os.Stat(filepath.Join(extractDir, file.RelativePath)); err != nil {
+				t.Errorf("Extracted file missing: %s", file.RelativePath)
+			}
+		}
+	})
+
+	t.Run("Key file as second factor", func(t *testing.T) {
+		archivePath := filepath.Join(tmpDir, ".goingenv", "two-factor.enc")
+		err := archiverService.Pack(types.PackOptions{
+			Files:       files,
+			OutputPath:  archivePath,
+			Password:    secret.FromString("typed-password"),
+			KeyFile:     keyFile,
+			Description: "Two-factor archive",
+		})
+		testutils.AssertNoError(t, err)
+
+		if _, err := archiverService.List(types.ListOptions{ArchivePath: archivePath, Password: secret.FromString("typed-password")}); err == nil {
+			t.Error("Expected the password alone to be rejected")
+		}
+		if _, err := archiverService.List(types.ListOptions{ArchivePath: archivePath, KeyFile: keyFile}); err == nil {
+			t.Error("Expected the key file alone to be rejected")
+		}
+
+		// Changing a single byte of the key file locks the archive
+		changed := append([]byte{}, keyMaterial...)
+		changed[0] ^= 0x01
+		changedPath := filepath.Join(tmpDir, "changed.key")
+		testutils.AssertNoError(t, os.WriteFile(changedPath, changed, 0600))
+		changedKeyFile, err := password.ReadKeyFile(changedPath)
+		testutils.AssertNoError(t, err)
+		if _, err := archiverService.List(types.ListOptions{ArchivePath: archivePath, Password: secret.FromString("typed-password"), KeyFile: changedKeyFile}); err == nil {
+			t.Error("Expected a modified key file to be rejected")
+		}
+
+		_, err = archiverService.List(types.ListOptions{ArchivePath: archivePath, Password: secret.FromString("typed-password"), KeyFile: keyFile})
+		testutils.AssertNoError(t, err)
+
+		// Rekeying keeps the key file as a factor
+		err = archiverService.Rekey(types.RekeyOptions{
+			ArchivePath: archivePath,
+			Password:    secret.FromString("typed-password"),
+			KeyFile:     keyFile,
+			NewPassword: secret.FromString("rotated-password"),
+			NewKeyFile:  keyFile,
+		})
+		testutils.AssertNoError(t, err)
+		if _, err := archiverService.List(types.ListOptions{ArchivePath: archivePath, Password: secret.FromString("rotated-password")}); err == nil {
+			t.Error("Expected the new password alone to be rejected after rekeying")
+		}
+		_, err = archiverService.List(types.ListOptions{ArchivePath: archivePath, Password: secret.FromString("rotated-password"), KeyFile: keyFile})
+		testutils.AssertNoError(t, err)
+	})
+
+	t.Run("Rekey a key file archive", func(t *testing.T) {
+		archivePath := filepath.Join(tmpDir, ".goingenv", "ci.enc")
+		newKeyPath := filepath.Join(tmpDir, "ci-2.key")
+		testutils.AssertNoError(t, os.WriteFile(newKeyPath, []byte("rotated key file material"), 0600))
+		newKeyFile, err := password.ReadKeyFile(newKeyPath)
+		testutils.AssertNoError(t, err)
+
+		err = archiverService.Rekey(types.RekeyOptions{
+			ArchivePath: archivePath,
+			KeyFile:     keyFile,
+			NewKeyFile:  newKeyFile,
+		})
+		testutils.AssertNoError(t, err)
+
+		if _, err := archiverService.List(types.ListOptions{ArchivePath: archivePath, KeyFile: keyFile}); err == nil {
+			t.Error("Expected the old key file to be rejected after rekeying")
+		}
+		_, err = archiverService.List(types.ListOptions{ArchivePath: archivePath, KeyFile: newKeyFile})
+		testutils.AssertNoError(t, err)
+	})
+}
+
+func TestRecoveryWorkflow(t *testing.T) {
+	tmpDir := testutils.CreateTempEnvFiles(t)
+	defer os.RemoveAll(tmpDir)
+	testutils.CreateTempGoingEnvDir(t, tmpDir)
+
+	cfg := testutils.CreateTestConfig()
+	cryptoService := crypto.NewService()
+	archiverService := archive.NewService(cryptoService)
+
+	files, err := scanner.NewService(cfg).ScanFiles(types.ScanOptions{
+		RootPath: tmpDir,
+		MaxDepth: cfg.DefaultDepth,
+	})
+	testutils.AssertNoError(t, err)
+
+	archivePath := filepath.Join(tmpDir, ".goingenv", "team.enc")
+	err = archiverService.Pack(types.PackOptions{
+		Files:      files,
+		OutputPath: archivePath,
+		Password:   secret.FromString("forgotten-password"),
+	})
+	testutils.AssertNoError(t, err)
+
+	// Add a recovery slot and split the recovery key 3-of-5
+	recoveryKey, err := crypto.NewRecoveryKey()
+	testutils.AssertNoError(t, err)
+	shares, err := crypto.SplitRecoveryKey(recoveryKey, 5, 3)
+	testutils.AssertNoError(t, err)
+
+	header, err := archiverService.ReadHeader(archivePath)
+	testutils.AssertNoError(t, err)
+	header, err = cryptoService.AddKeySlot(header,
+		types.DecryptOptions{Password: secret.FromString("forgotten-password")},
+		types.EncryptOptions{RecoveryKey: recoveryKey})
+	testutils.AssertNoError(t, err)
+	testutils.AssertNoError(t, archiverService.ReplaceHeader(archivePath, header, types.SignOptions{}, nil))
+
+	t.Run("Two shares are not enough", func(t *testing.T) {
+		if _, err := crypto.CombineRecoveryShares(shares[1:3]); err == nil {
+			t.Error("Expected two of three required shares to be rejected")
+		}
+	})
+
+	t.Run("Three shares unpack the archive", func(t *testing.T) {
+		rebuilt, err := crypto.CombineRecoveryShares([]string{shares[3], shares[0], shares[2]})
+		testutils.AssertNoError(t, err)
+
+		extractDir := filepath.Join(tmpDir, "recovered")
+		err = archiverService.Unpack(types.UnpackOptions{
+			ArchivePath: archivePath,
+			RecoveryKey: rebuilt,
+			TargetDir:   extractDir,
+		})
+		testutils.AssertNoError(t, err)
+		for _, file := range files {
+			if _, err := os.Stat(filepath.Join(extractDir, file.RelativePath)); err != nil {
+				t.Errorf("Extracted file missing: %s", file.RelativePath)
+			}
+		}
+	})
+
+	t.Run("Recovery key sets a new password", func(t *testing.T) {
+		rebuilt, err := crypto.CombineRecoveryShares(shares[2:])
+		testutils.AssertNoError(t, err)
+
+		header, err := archiverService.ReadHeader(archivePath)
+		testutils.AssertNoError(t, err)
+		header, err = cryptoService.AddKeySlot(header,
+			types.DecryptOptions{RecoveryKey: rebuilt},
+			types.EncryptOptions{Password: secret.FromString("new-team-password")})
+		testutils.AssertNoError(t, err)
+		testutils.AssertNoError(t, archiverService.ReplaceHeader(archivePath, header, types.SignOptions{}, nil))
+
+		archive, err := archiverService.List(types.ListOptions{ArchivePath: archivePath, Password: secret.FromString("new-team-password")})
+		testutils.AssertNoError(t, err)
+		if len(archive.Files) != len(files) {
+			t.Errorf("Expected %d files, got %d", len(files), len(archive.Files))
+		}
+	})
+}
+
+func TestArchiveIndexWorkflow(t *testing.T) {
+	tmpDir := testutils.CreateTempEnvFiles(t)
+	defer os.RemoveAll(tmpDir)
+	testutils.CreateTempGoingEnvDir(t, tmpDir)
+
+	cfg := testutils.CreateTestConfig()
+	cryptoService := crypto.NewService()
+	archiverService := archive.NewService(cryptoService)
+
+	files, err := scanner.NewService(cfg).ScanFiles(types.ScanOptions{
+		RootPath: tmpDir,
+		MaxDepth: cfg.DefaultDepth,
+	})
+	testutils.AssertNoError(t, err)
+
+	archivePath := filepath.Join(tmpDir, ".goingenv", "indexed.enc")
+	err = archiverService.Pack(types.PackOptions{
+		Files:       files,
+		OutputPath:  archivePath,
+		Password:    secret.FromString("index-password"),
+		Description: "Indexed archive",
+	})
+	testutils.AssertNoError(t, err)
+
+	t.Run("List reads only the index", func(t *testing.T) {
+		// Corrupt the end of the payload; listing must not notice
+		data, err := os.ReadFile(archivePath)
+		testutils.AssertNoError(t, err)
+		data[len(data)-1] ^= 0x01
+		corruptPath := filepath.Join(tmpDir, ".goingenv", "corrupt.enc")
+		testutils.AssertNoError(t, os.WriteFile(corruptPath, data, 0600))
+
+		archive, err := archiverService.List(types.ListOptions{
+			ArchivePath: corruptPath,
+			Password:    secret.FromString("index-password"),
+		})
+		testutils.AssertNoError(t, err)
+		if archive.Description != "Indexed archive" || len(archive.Files) != len(files) {
+			t.Errorf("Unexpected index: %q with %d files", archive.Description, len(archive.Files))
+		}
+
+		err = archiverService.Unpack(types.UnpackOptions{
+			ArchivePath: corruptPath,
+			Password:    secret.FromString("index-password"),
+			TargetDir:   filepath.Join(tmpDir, "extract-corrupt"),
+		})
+		if err == nil {
+			t.Error("Expected unpacking a corrupted payload to fail")
+		}
+	})
+
+	t.Run("Confirm previews before extracting", func(t *testing.T) {
+		extractDir := filepath.Join(tmpDir, "extract-declined")
+		var previewed *types.Archive
+		err := archiverService.Unpack(types.UnpackOptions{
+			ArchivePath: archivePath,
+			Password:    secret.FromString("index-password"),
+			TargetDir:   extractDir,
+			Confirm: func(archive *types.Archive) (bool, bool, error) {
+				previewed = archive
+				return false, false, nil
+			},
+		})
+		testutils.AssertNoError(t, err)
+		if previewed == nil || len(previewed.Files) != len(files) {
+			t.Fatal("Expected Confirm to see the archive's files")
+		}
+		if _, err := os.Stat(extractDir); !os.IsNotExist(err) {
+			t.Error("Expected nothing to be extracted when Confirm declines")
+		}
+	})
+
+	t.Run("Confirm can allow overwriting", func(t *testing.T) {
+		extractDir := filepath.Join(tmpDir, "extract-overwrite")
+		target := filepath.Join(extractDir, files[0].RelativePath)
+		testutils.AssertNoError(t, os.MkdirAll(filepath.Dir(target), 0755))
+		testutils.AssertNoError(t, os.WriteFile(target, []byte("STALE=1\n"), 0644))
+
+		err := archiverService.Unpack(types.UnpackOptions{
+			ArchivePath: archivePath,
+			Password:    secret.FromString("index-password"),
+			TargetDir:   extractDir,
+			Confirm: func(*types.Archive) (bool, bool, error) {
+				return true, true, nil
+			},
+		})
+		testutils.AssertNoError(t, err)
+
+		want, err := os.ReadFile(files[0].Path)
+		testutils.AssertNoError(t, err)
+		got, err := os.ReadFile(target)
+		testutils.AssertNoError(t, err)
+		if string(got) != string(want) {
+			t.Error("Expected the existing file to be overwritten")
+		}
+	})
+
+	t.Run("Rekey keeps the index", func(t *testing.T) {
+		err := archiverService.Rekey(types.RekeyOptions{
+			ArchivePath: archivePath,
+			Password:    secret.FromString("index-password"),
+			NewPassword: secret.FromString("rotated-index-password"),
+		})
+		testutils.AssertNoError(t, err)
+
+		archive, err := archiverService.List(types.ListOptions{
+			ArchivePath: archivePath,
+			Password:    secret.FromString("rotated-index-password"),
+		})
+		testutils.AssertNoError(t, err)
+		if archive.Description != "Indexed archive" {
+			t.Errorf("Expected the index to survive rekeying, got %q", archive.Description)
+		}
+	})
+
+	t.Run("Archives without an index still open", func(t *testing.T) {
+		// Before the index segment, metadata.json led the payload tar
+		legacyPath := filepath.Join(tmpDir, ".goingenv", "legacy.enc")
+		out, err := os.Create(legacyPath)
+		testutils.AssertNoError(t, err)
+		w, err := cryptoService.EncryptStream(out, types.EncryptOptions{Password: secret.FromString("legacy-password")})
+		testutils.AssertNoError(t, err)
+		metadata := `{"files":[{"relative_path":".env","size":8}],"description":"Legacy archive","version":"1.0.0"}`
+		tw := tar.NewWriter(w)
+		for _, entry := range []struct{ name, body string }{
+			{"metadata.json", metadata},
+			{".env", "LEGACY=1"},
+		} {
+			testutils.AssertNoError(t, tw.WriteHeader(&tar.Header{Name: entry.name, Mode: 0600, Size: int64(len(entry.body))}))
+			_, err := tw.Write([]byte(entry.body))
+			testutils.AssertNoError(t, err)
+		}
+		testutils.AssertNoError(t, tw.Close())
+		testutils.AssertNoError(t, w.Close())
+		testutils.AssertNoError(t, out.Close())
+
+		archive, err := archiverService.List(types.ListOptions{
+			ArchivePath: legacyPath,
+			Password:    secret.FromString("legacy-password"),
+		})
+		testutils.AssertNoError(t, err)
+		if archive.Description != "Legacy archive" || len(archive.Files) != 1 {
+			t.Errorf("Unexpected legacy metadata: %+v", archive)
+		}
+
+		extractDir := filepath.Join(tmpDir, "extract-legacy")
+		err = archiverService.Unpack(types.UnpackOptions{
+			ArchivePath: legacyPath,
+			Password:    secret.FromString("legacy-password"),
+			TargetDir:   extractDir,
+		})
+		testutils.AssertNoError(t, err)
+		if _, err := os.Stat(filepath.Join(extractDir, "metadata.json")); !os.IsNotExist(err) {
+			t.Error("Expected the legacy metadata entry not to be extracted")
+		}
+		if got, err := os.ReadFile(filepath.Join(extractDir, ".env")); err != nil || string(got) != "LEGACY=1" {
+			t.Errorf("Unexpected legacy file contents: %q, %v", got, err)
+		}
+	})
+}
+
+// tarEntry is one entry of a hand-made archive payload
+type tarEntry struct {
+	header    *tar.Header
+	body      string
+	checksum  string // recorded in the index if set
+	indexSize int64  // recorded in the index instead of the body length if set
+	indexOnly bool   // listed in the index but missing from the payload
+}
+
+// writeCraftedArchive encrypts a tar of the given entries with an index that
+// lists them, the way Pack would, so tests can feed Unpack entries and
+// metadata that Pack never writes
+func writeCraftedArchive(t *testing.T, cryptor types.Cryptor, path, password string, entries []tarEntry) {
+	t.Helper()
+
+	var files []string
+	for _, entry := range entries {
+		size := int64(len(entry.body))
+		if entry.indexSize != 0 {
+			size = entry.indexSize
+		}
+		files = append(files, fmt.Sprintf(`{"relative_path":%q,"size":%d,"checksum":%q}`, entry.header.Name, size, entry.checksum))
+	}
+	index := fmt.Sprintf(`{"files":[%s],"description":"Hostile archive","version":"1.0.0"}`, strings.Join(files, ","))
+
+	out, err := os.Create(path)
+	testutils.AssertNoError(t, err)
+	defer out.Close()
+
+	w, err := cryptor.EncryptStream(out, types.EncryptOptions{
+		Password: secret.FromString(password),
+		Index:    []byte(index),
+	})
+	testutils.AssertNoError(t, err)
+
+	tw := tar.NewWriter(w)
+	for _, entry := range entries {
+		if entry.indexOnly {
+			continue
+		}
+		entry.header.Size = int64(len(entry.body))
+		testutils.AssertNoError(t, tw.WriteHeader(entry.header))
+		_, err := tw.Write([]byte(entry.body))
+		testutils.AssertNoError(t, err)
+	}
+	testutils.AssertNoError(t, tw.Close())
+	testutils.AssertNoError(t, w.Close())
+}
+
+func TestUnpackRejectsHostileEntries(t *testing.T) {
+	cryptoService := crypto.NewService()
+	archiverService := archive.NewService(cryptoService)
+	password := "hostile-password"
+
+	regular := func(name string) *tar.Header {
+		return &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0600}
+	}
+
+	tests := []struct {
+		name  string
+		entry tarEntry
+	}{
+		{"Parent directory", tarEntry{header: regular("../escape.env"), body: "ESCAPED=1"}},
+		{"Nested parent directory", tarEntry{header: regular("config/../../escape.env"), body: "ESCAPED=1"}},
+		{"Backslash parent directory", tarEntry{header: regular(`..\escape.env`), body: "ESCAPED=1"}},
+		{"Absolute path", tarEntry{header: regular("/tmp/goingenv-escape.env"), body: "ESCAPED=1"}},
+		{"Symlink", tarEntry{header: &tar.Header{Name: "link.env", Typeflag: tar.TypeSymlink, Linkname: "../escape.env", Mode: 0777}, body: ""}},
+		{"Hardlink", tarEntry{header: &tar.Header{Name: "hard.env", Typeflag: tar.TypeLink, Linkname: "/etc/passwd", Mode: 0600}, body: ""}},
+		{"Character device", tarEntry{header: &tar.Header{Name: "null.env", Typeflag: tar.TypeChar, Devmajor: 1, Devminor: 3, Mode: 0666}, body: ""}},
+		{"FIFO", tarEntry{header: &tar.Header{Name: "pipe.env", Typeflag: tar.TypeFifo, Mode: 0600}, body: ""}},
+		{"Directory", tarEntry{header: &tar.Header{Name: "config/", Typeflag: tar.TypeDir, Mode: 0755}, body: ""}},
+		{"Trusted signers file", tarEntry{header: regular(".goingenv/trusted_signers"), body: "ssh-ed25519 AAAA attacker"}},
+		{"Goingenv journal", tarEntry{header: regular("./.goingenv/journal/entry.json"), body: "{}"}},
+		{"Git hook", tarEntry{header: &tar.Header{Name: ".git/hooks/pre-commit", Typeflag: tar.TypeReg, Mode: 0755}, body: "#!/bin/sh\n"}},
+		{"Nested git directory", tarEntry{header: regular("vendor/lib/.git/config"), body: "[core]"}},
+		{"Git directory in upper case", tarEntry{header: regular(".GIT/hooks/post-checkout"), body: "#!/bin/sh\n"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			extractDir := filepath.Join(tmpDir, "extract")
+			testutils.AssertNoError(t, os.Mkdir(extractDir, 0755))
+
+			archivePath := filepath.Join(tmpDir, "hostile.enc")
+			writeCraftedArchive(t, cryptoService, archivePath, password, []tarEntry{tt.entry})
+
+			err := archiverService.Unpack(types.UnpackOptions{
+				ArchivePath: archivePath,
+				Password:    secret.FromString(password),
+				TargetDir:   extractDir,
+				Overwrite:   true,
+			})
+			if err == nil {
+				t.Fatal("Expected Unpack to refuse the entry")
+			}
+			if !strings.Contains(err.Error(), "refusing to extract") {
+				t.Errorf("Unexpected error: %v", err)
+			}
+
+			if _, err := os.Lstat(filepath.Join(tmpDir, "escape.env")); !os.IsNotExist(err) {
+				t.Error("Entry was written outside the target directory")
+			}
+			entries, err := os.ReadDir(extractDir)
+			testutils.AssertNoError(t, err)
+			if len(entries) != 0 {
+				t.Errorf("Expected nothing to be extracted, found %d entries", len(entries))
+			}
+		})
+	}
+
+	t.Run("Symlinked directory in the target", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		extractDir := filepath.Join(tmpDir, "extract")
+		outsideDir := filepath.Join(tmpDir, "outside")
+		testutils.AssertNoError(t, os.Mkdir(extractDir, 0755))
+		testutils.AssertNoError(t, os.Mkdir(outsideDir, 0755))
+		if err := os.Symlink(outsideDir, filepath.Join(extractDir, "config")); err != nil {
+			t.Skipf("Symlinks not supported: %v", err)
+		}
+
+		archivePath := filepath.Join(tmpDir, "hostile.enc")
+		writeCraftedArchive(t, cryptoService, archivePath, password, []tarEntry{
+			{header: regular("config/.env"), body: "ESCAPED=1"},
+		})
+
+		err := archiverService.Unpack(types.UnpackOptions{
+			ArchivePath: archivePath,
+			Password:    secret.FromString(password),
+			TargetDir:   extractDir,
+		})
+		if err == nil {
+			t.Fatal("Expected Unpack to refuse writing through a symlink")
+		}
+		if _, err := os.Stat(filepath.Join(outsideDir, ".env")); !os.IsNotExist(err) {
+			t.Error("Entry was written through the symlink")
+		}
+	})
+
+	t.Run("Symlinked file in the target", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		extractDir := filepath.Join(tmpDir, "extract")
+		testutils.AssertNoError(t, os.Mkdir(extractDir, 0755))
+		victim := filepath.Join(tmpDir, "victim")
+		testutils.AssertNoError(t, os.WriteFile(victim, []byte("untouched"), 0600))
+		if err := os.Symlink(victim, filepath.Join(extractDir, ".env")); err != nil {
+			t.Skipf("Symlinks not supported: %v", err)
+		}
+
+		archivePath := filepath.Join(tmpDir, "hostile.enc")
+		writeCraftedArchive(t, cryptoService, archivePath, password, []tarEntry{
+			{header: regular(".env"), body: "OVERWRITTEN=1"},
+		})
+
+		err := archiverService.Unpack(types.UnpackOptions{
+			ArchivePath: archivePath,
+			Password:    secret.FromString(password),
+			TargetDir:   extractDir,
+			Overwrite:   true,
+		})
+		if err == nil {
+			t.Fatal("Expected Unpack to refuse overwriting a symlink")
+		}
+		data, err := os.ReadFile(victim)
+		testutils.AssertNoError(t, err)
+		if string(data) != "untouched" {
+			t.Errorf("Symlink target was overwritten: %q", data)
+		}
+	})
+
+	t.Run("Safe nested entries still extract", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		extractDir := filepath.Join(tmpDir, "extract")
+
+		archivePath := filepath.Join(tmpDir, "nested.enc")
+		writeCraftedArchive(t, cryptoService, archivePath, password, []tarEntry{
+			{header: regular("./.env"), body: "ROOT=1"},
+			{header: &tar.Header{Name: "services/api/.env", Typeflag: tar.TypeReg, Mode: 04755}, body: "API=1"},
+		})
+
+		err := archiverService.Unpack(types.UnpackOptions{
+			ArchivePath: archivePath,
+			Password:    secret.FromString(password),
+			TargetDir:   extractDir,
+		})
+		testutils.AssertNoError(t, err)
+
+		testutils.AssertFileExists(t, filepath.Join(extractDir, ".env"))
+		info, err := os.Stat(filepath.Join(extractDir, "services", "api", ".env"))
+		testutils.AssertNoError(t, err)
+		if runtime.GOOS != "windows" && info.Mode()&os.ModeSetuid != 0 {
+			t.Error("Extracted file kept the setuid bit")
+		}
+	})
+}
+
+func TestTransactionalUnpack(t *testing.T) {
+	cryptoService := crypto.NewService()
+	archiverService := archive.NewService(cryptoService)
+	password := "transaction-password"
+
+	regular := func(name string) *tar.Header {
+		return &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0600}
+	}
+	checksum := func(body string) string {
+		return fmt.Sprintf("%x", sha256.Sum256([]byte(body)))
+	}
+
+	// setup creates a target holding the original versions of the files and
+	// a backup store next to it
+	setup := func(t *testing.T) (string, map[string]string, *backup.Store) {
+		tmpDir := t.TempDir()
+		extractDir := filepath.Join(tmpDir, "extract")
+		originals := map[string]string{
+			".env":         "ORIGINAL_ROOT=1",
+			".env.local":   "ORIGINAL_LOCAL=1",
+			"api/.env":     "ORIGINAL_API=1",
+			"api/.env.dev": "ORIGINAL_DEV=1",
+		}
+		for name, content := range originals {
+			path := filepath.Join(extractDir, filepath.FromSlash(name))
+			testutils.AssertNoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+			testutils.AssertNoError(t, os.WriteFile(path, []byte(content), 0600))
+		}
+		return extractDir, originals, backup.NewStore(filepath.Join(tmpDir, "backups"))
+	}
+
+	// assertUntouched fails unless dir holds exactly the original files and
+	// no backup was kept
+	assertUntouched := func(t *testing.T, dir string, originals map[string]string, store *backup.Store) {
+		t.Helper()
+		found := 0
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			rel, _ := filepath.Rel(dir, path)
+			want, ok := originals[filepath.ToSlash(rel)]
+			if !ok {
+				t.Errorf("Unexpected file left behind: %s", rel)
+				return nil
+			}
+			found++
+			data, err := os.ReadFile(path)
+			testutils.AssertNoError(t, err)
+			if string(data) != want {
+				t.Errorf("%s = %q, want the original %q", rel, data, want)
+			}
+			return nil
+		})
+		testutils.AssertNoError(t, err)
+		if found != len(originals) {
+			t.Errorf("Found %d of %d original files", found, len(originals))
+		}
+		if _, err := os.Stat(filepath.Join(dir, "new")); !os.IsNotExist(err) {
+			t.Error("Directory created by the failed unpack was left behind")
+		}
+		snapshots, err := os.ReadDir(store.Dir())
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatalf("ReadDir failed: %v", err)
+		}
+		for _, snapshot := range snapshots {
+			if snapshot.IsDir() {
+				t.Errorf("Backup %s of the failed unpack was left behind", snapshot.Name())
+			}
+		}
+	}
+
+	unpack := func(archivePath, targetDir string, store *backup.Store) error {
+		return archiverService.Unpack(types.UnpackOptions{
+			ArchivePath: archivePath,
+			Password:    secret.FromString(password),
+			TargetDir:   targetDir,
+			Overwrite:   true,
+			Backup:      store.NewSnapshot("unpack of " + archivePath),
+		})
+	}
+
+	good := []tarEntry{
+		{header: regular(".env"), body: "NEW_ROOT=1", checksum: checksum("NEW_ROOT=1")},
+		{header: regular("api/.env"), body: "NEW_API=1", checksum: checksum("NEW_API=1")},
+		{header: regular("new/deep/.env"), body: "NEW_DEEP=1", checksum: checksum("NEW_DEEP=1")},
+	}
+
+	t.Run("Checksum mismatch rolls back", func(t *testing.T) {
+		extractDir, originals, store := setup(t)
+		archivePath := filepath.Join(t.TempDir(), "corrupt.enc")
+		entries := append(append([]tarEntry(nil), good...), tarEntry{
+			header:   regular("api/.env.dev"),
+			body:     "NEW_DEV=1",
+			checksum: checksum("something else"),
+		})
+		writeCraftedArchive(t, cryptoService, archivePath, password, entries)
+
+		err := unpack(archivePath, extractDir, store)
+		if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+			t.Fatalf("Expected a checksum mismatch, got %v", err)
+		}
+		assertUntouched(t, extractDir, originals, store)
+	})
+
+	t.Run("Oversized entry rolls back", func(t *testing.T) {
+		extractDir, originals, store := setup(t)
+		archivePath := filepath.Join(t.TempDir(), "oversized.enc")
+		entries := append(append([]tarEntry(nil), good...), tarEntry{
+			header:    regular("api/.env.dev"),
+			body:      strings.Repeat("X", 1<<20),
+			indexSize: 4,
+		})
+		writeCraftedArchive(t, cryptoService, archivePath, password, entries)
+
+		err := unpack(archivePath, extractDir, store)
+		if err == nil || !strings.Contains(err.Error(), "size mismatch") {
+			t.Fatalf("Expected a size mismatch, got %v", err)
+		}
+		assertUntouched(t, extractDir, originals, store)
+	})
+
+	t.Run("Missing file rolls back", func(t *testing.T) {
+		extractDir, originals, store := setup(t)
+		archivePath := filepath.Join(t.TempDir(), "incomplete.enc")
+		entries := append(append([]tarEntry(nil), good...), tarEntry{
+			header:    regular(".env.local"),
+			indexOnly: true,
+		})
+		writeCraftedArchive(t, cryptoService, archivePath, password, entries)
+
+		err := unpack(archivePath, extractDir, store)
+		if err == nil || !strings.Contains(err.Error(), "missing") {
+			t.Fatalf("Expected a missing file error, got %v", err)
+		}
+		assertUntouched(t, extractDir, originals, store)
+	})
+
+	t.Run("Truncated archive rolls back", func(t *testing.T) {
+		extractDir, originals, store := setup(t)
+		archivePath := filepath.Join(t.TempDir(), "truncated.enc")
+		writeCraftedArchive(t, cryptoService, archivePath, password, good)
+
+		data, err := os.ReadFile(archivePath)
+		testutils.AssertNoError(t, err)
+		testutils.AssertNoError(t, os.WriteFile(archivePath, data[:len(data)-16], 0600))
+
+		if err := unpack(archivePath, extractDir, store); err == nil {
+			t.Fatal("Expected a truncated archive to fail")
+		}
+		assertUntouched(t, extractDir, originals, store)
+	})
+
+	t.Run("Missing target is removed again", func(t *testing.T) {
+		archivePath := filepath.Join(t.TempDir(), "corrupt.enc")
+		writeCraftedArchive(t, cryptoService, archivePath, password, []tarEntry{
+			{header: regular("config/.env"), body: "NEW=1", checksum: checksum("OTHER=1")},
+		})
+
+		extractDir := filepath.Join(t.TempDir(), "fresh", "target")
+		if err := unpack(archivePath, extractDir, backup.NewStore(t.TempDir())); err == nil {
+			t.Fatal("Expected a checksum mismatch")
+		}
+		if _, err := os.Stat(filepath.Dir(extractDir)); !os.IsNotExist(err) {
+			t.Error("Target directory created by the failed unpack was left behind")
+		}
+	})
+
+	t.Run("Commit replaces files and keeps backups", func(t *testing.T) {
+		extractDir, originals, store := setup(t)
+		archivePath := filepath.Join(t.TempDir(), "good.enc")
+		writeCraftedArchive(t, cryptoService, archivePath, password, good)
+
+		testutils.AssertNoError(t, unpack(archivePath, extractDir, store))
+
+		for _, entry := range good {
+			path := filepath.Join(extractDir, filepath.FromSlash(entry.header.Name))
+			data, err := os.ReadFile(path)
+			testutils.AssertNoError(t, err)
+			if string(data) != entry.body {
+				t.Errorf("%s = %q, want %q", entry.header.Name, data, entry.body)
+			}
+		}
+		manifests, err := store.List()
+		testutils.AssertNoError(t, err)
+		if len(manifests) != 1 || len(manifests[0].Files) != 2 {
+			t.Fatalf("Expected one backup of the 2 replaced files, got %+v", manifests)
+		}
+		for _, file := range manifests[0].Files {
+			data, err := os.ReadFile(filepath.Join(store.Dir(), manifests[0].ID, "files", filepath.FromSlash(file.RelativePath)))
+			testutils.AssertNoError(t, err)
+			if string(data) != originals[file.RelativePath] {
+				t.Errorf("Backup of %s = %q, want %q", file.RelativePath, data, originals[file.RelativePath])
+			}
+		}
+		if _, err := os.Stat(filepath.Join(extractDir, ".env.backup")); !os.IsNotExist(err) {
+			t.Error("Backups should not be written next to the files")
+		}
+
+		staging, err := filepath.Glob(filepath.Join(extractDir, ".goingenv-unpack-*"))
+		testutils.AssertNoError(t, err)
+		if len(staging) != 0 {
+			t.Errorf("Staging directory left behind: %v", staging)
+		}
+	})
+}
+
+func TestSelectiveUnpack(t *testing.T) {
+	cryptoService := crypto.NewService()
+	archiverService := archive.NewService(cryptoService)
+	password := "selection-password"
+
+	var entries []tarEntry
+	for _, name := range []string{".env", ".env.local", "api/.env", "api/.env.local"} {
+		body := "NAME=" + name
+		entries = append(entries, tarEntry{
+			header:   &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0600},
+			body:     body,
+			checksum: fmt.Sprintf("%x", sha256.Sum256([]byte(body))),
+		})
+	}
+	archivePath := filepath.Join(t.TempDir(), "selection.enc")
+	writeCraftedArchive(t, cryptoService, archivePath, password, entries)
+
+	// extracted lists the files under dir with forward slashes
+	extracted := func(t *testing.T, dir string) []string {
+		t.Helper()
+		var names []string
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			rel, _ := filepath.Rel(dir, path)
+			names = append(names, filepath.ToSlash(rel))
+			return nil
+		})
+		testutils.AssertNoError(t, err)
+		sort.Strings(names)
+		return names
+	}
+
+	tests := []struct {
+		name      string
+		selection *types.FileSelection
+		want      []string
+	}{
+		{"Everything", nil, []string{".env", ".env.local", "api/.env", "api/.env.local"}},
+		{"Paths", &types.FileSelection{Paths: []string{".env", "./api/.env"}}, []string{".env", "api/.env"}},
+		{"Include", &types.FileSelection{Include: []string{".env*"}}, []string{".env", ".env.local"}},
+		{"Include and exclude", &types.FileSelection{Include: []string{"*/.env*"}, Exclude: []string{"*/*.local"}}, []string{"api/.env"}},
+		{"Exclude only", &types.FileSelection{Exclude: []string{"api/*"}}, []string{".env", ".env.local"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			extractDir := filepath.Join(t.TempDir(), "extract")
+			var previewed []types.EnvFile
+			err := archiverService.Unpack(types.UnpackOptions{
+				ArchivePath: archivePath,
+				Password:    secret.FromString(password),
+				TargetDir:   extractDir,
+				Select:      tt.selection,
+				Confirm: func(archive *types.Archive) (bool, bool, error) {
+					previewed = tt.selection.Filter(archive.Files)
+					return true, false, nil
+				},
+			})
+			testutils.AssertNoError(t, err)
+
+			got := extracted(t, extractDir)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Extracted %v, want %v", got, tt.want)
+			}
+			if len(previewed) != len(got) {
+				t.Errorf("Preview listed %d files but %d were extracted", len(previewed), len(got))
+			}
+		})
+	}
+
+	t.Run("Unknown path", func(t *testing.T) {
+		extractDir := filepath.Join(t.TempDir(), "extract")
+		err := archiverService.Unpack(types.UnpackOptions{
+			ArchivePath: archivePath,
+			Password:    secret.FromString(password),
+			TargetDir:   extractDir,
+			Select:      &types.FileSelection{Paths: []string{".env", "web/.env"}},
+		})
+		if err == nil || !strings.Contains(err.Error(), "web/.env") {
+			t.Fatalf("Expected an error naming the missing file, got %v", err)
+		}
+		if _, err := os.Stat(extractDir); !os.IsNotExist(err) {
+			t.Error("Expected nothing to be extracted")
+		}
+	})
+
+	t.Run("Malformed pattern", func(t *testing.T) {
+		err := archiverService.Unpack(types.UnpackOptions{
+			ArchivePath: archivePath,
+			Password:    secret.FromString(password),
+			TargetDir:   filepath.Join(t.TempDir(), "extract"),
+			Select:      &types.FileSelection{Include: []string{"[.env"}},
+		})
+		if err == nil {
+			t.Error("Expected a malformed pattern to be rejected")
+		}
+	})
+}
+
+func TestReadFiles(t *testing.T) {
+	cryptoService := crypto.NewService()
+	archiverService := archive.NewService(cryptoService)
+	password := "read-password"
+	tmpDir := t.TempDir()
+
+	bodies := map[string]string{
+		".env":     "A=1\nB=archived\n",
+		"api/.env": "API_KEY=abc\n",
+	}
+	var entries []tarEntry
+	for _, name := range []string{".env", "api/.env"} {
+		entries = append(entries, tarEntry{
+			header:   &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0600},
+			body:     bodies[name],
+			checksum: fmt.Sprintf("%x", sha256.Sum256([]byte(bodies[name]))),
+		})
+	}
+	archivePath := filepath.Join(tmpDir, "read.enc")
+	writeCraftedArchive(t, cryptoService, archivePath, password, entries)
+
+	read := func(path string, selection *types.FileSelection) (map[string][]byte, error) {
+		_, contents, err := archiverService.ReadFiles(types.ReadOptions{
+			ArchivePath: path,
+			Password:    secret.FromString(password),
+			Select:      selection,
+		})
+		return contents, err
+	}
+
+	t.Run("Every file", func(t *testing.T) {
+		contents, err := read(archivePath, nil)
+		testutils.AssertNoError(t, err)
+		if len(contents) != len(bodies) {
+			t.Fatalf("Read %d files, want %d", len(contents), len(bodies))
+		}
+		for name, body := range bodies {
+			if string(contents[name]) != body {
+				t.Errorf("%s = %q, want %q", name, contents[name], body)
+			}
+		}
+
+		// Nothing is written next to the archive
+		files, err := os.ReadDir(tmpDir)
+		testutils.AssertNoError(t, err)
+		if len(files) != 1 {
+			t.Errorf("Expected only the archive in %s, found %d entries", tmpDir, len(files))
+		}
+	})
+
+	t.Run("Selected files", func(t *testing.T) {
+		contents, err := read(archivePath, &types.FileSelection{Paths: []string{"api/.env"}})
+		testutils.AssertNoError(t, err)
+		if len(contents) != 1 || string(contents["api/.env"]) != bodies["api/.env"] {
+			t.Errorf("Unexpected contents: %q", contents)
+		}
+
+		if _, err := read(archivePath, &types.FileSelection{Paths: []string{"web/.env"}}); err == nil {
+			t.Error("Expected an error for a file that is not in the archive")
+		}
+	})
+
+	t.Run("Checksum mismatch", func(t *testing.T) {
+		corrupt := filepath.Join(tmpDir, "corrupt.enc")
+		writeCraftedArchive(t, cryptoService, corrupt, password, []tarEntry{{
+			header:   &tar.Header{Name: ".env", Typeflag: tar.TypeReg, Mode: 0600},
+			body:     "A=2\n",
+			checksum: fmt.Sprintf("%x", sha256.Sum256([]byte("A=1\n"))),
+		}})
+		if _, err := read(corrupt, nil); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+			t.Errorf("Expected a checksum mismatch, got %v", err)
+		}
+	})
+
+	t.Run("Tampered sizes in the index", func(t *testing.T) {
+		for _, size := range []int64{-5, 1 << 40} {
+			tampered := filepath.Join(tmpDir, "tampered.enc")
+			writeCraftedArchive(t, cryptoService, tampered, password, []tarEntry{{
+				header:    &tar.Header{Name: ".env", Typeflag: tar.TypeReg, Mode: 0600},
+				body:      "A=1\n",
+				indexSize: size,
+			}})
+			if _, err := read(tampered, nil); err == nil {
+				t.Errorf("Expected an index size of %d to be refused", size)
+			}
+		}
+	})
+
+	t.Run("Hostile entry", func(t *testing.T) {
+		hostile := filepath.Join(tmpDir, "hostile.enc")
+		writeCraftedArchive(t, cryptoService, hostile, password, []tarEntry{{
+			header: &tar.Header{Name: "../.env", Typeflag: tar.TypeReg, Mode: 0600},
+			body:   "A=1\n",
+		}})
+		if _, err := read(hostile, nil); err == nil || !strings.Contains(err.Error(), "refusing to read") {
+			t.Errorf("Expected the entry to be refused, got %v", err)
+		}
+	})
+
+	t.Run("Wrong password", func(t *testing.T) {
+		_, _, err := archiverService.ReadFiles(types.ReadOptions{
+			ArchivePath: archivePath,
+			Password:    secret.FromString("wrong-password"),
+		})
+		if err == nil {
+			t.Error("Expected an error with the wrong password")
+		}
+	})
+}
+
+func TestBackupStore(t *testing.T) {
+	cryptoService := crypto.NewService()
+	archiverService := archive.NewService(cryptoService)
+	password := "backup-password"
+
+	tmpDir := t.TempDir()
+	extractDir := filepath.Join(tmpDir, "project")
+	store := backup.NewStore(filepath.Join(tmpDir, "backups"))
+
+	testutils.AssertNoError(t, os.MkdirAll(filepath.Join(extractDir, "api"), 0755))
+	testutils.AssertNoError(t, os.WriteFile(filepath.Join(extractDir, ".env"), []byte("VERSION=0"), 0640))
+	testutils.AssertNoError(t, os.WriteFile(filepath.Join(extractDir, "api", ".env"), []byte("API_VERSION=0"), 0600))
+
+	readEnv := func(name string) string {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(extractDir, filepath.FromSlash(name)))
+		testutils.AssertNoError(t, err)
+		return string(data)
+	}
+
+	// Unpack three versions in a row, each replacing the previous one
+	for version := 1; version <= 3; version++ {
+		var entries []tarEntry
+		for _, name := range []string{".env", "api/.env"} {
+			body := fmt.Sprintf("VERSION=%d", version)
+			if name == "api/.env" {
+				body = "API_" + body
+			}
+			entries = append(entries, tarEntry{
+				header:   &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0600},
+				body:     body,
+				checksum: fmt.Sprintf("%x", sha256.Sum256([]byte(body))),
+			})
+		}
+		archivePath := filepath.Join(tmpDir, fmt.Sprintf("v%d.enc", version))
+		writeCraftedArchive(t, cryptoService, archivePath, password, entries)
+
+		err := archiverService.Unpack(types.UnpackOptions{
+			ArchivePath: archivePath,
+			Password:    secret.FromString(password),
+			TargetDir:   extractDir,
+			Overwrite:   true,
+			Backup:      store.NewSnapshot("unpack of " + archivePath),
+		})
+		testutils.AssertNoError(t, err)
+	}
+
+	manifests, err := store.List()
+	testutils.AssertNoError(t, err)
+	if len(manifests) != 3 {
+		t.Fatalf("Expected a backup per unpack, got %d", len(manifests))
+	}
+	for i, manifest := range manifests {
+		if len(manifest.Files) != 2 || !strings.HasSuffix(manifest.Source, fmt.Sprintf("v%d.enc", i+1)) {
+			t.Errorf("Backup %d = %+v", i, manifest)
+		}
+		data, err := os.ReadFile(filepath.Join(store.Dir(), manifest.ID, "files", ".env"))
+		testutils.AssertNoError(t, err)
+		if want := fmt.Sprintf("VERSION=%d", i); string(data) != want {
+			t.Errorf("Backup %d holds %q, want %q", i, data, want)
+		}
+	}
+
+	if data, err := os.ReadFile(filepath.Join(store.Dir(), ".gitignore")); err != nil || !strings.Contains(string(data), "*") {
+		t.Errorf("Backup directory should be ignored by git: %q, %v", data, err)
+	}
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(filepath.Join(store.Dir(), manifests[0].ID))
+		testutils.AssertNoError(t, err)
+		if info.Mode().Perm() != 0700 {
+			t.Errorf("Backup directory permissions = %o, want 700", info.Mode().Perm())
+		}
+	}
+
+	t.Run("Restore the newest backup", func(t *testing.T) {
+		restoreSnapshot := store.NewSnapshot("restore")
+		files, err := store.Restore(manifests[2].ID, nil, restoreSnapshot)
+		testutils.AssertNoError(t, err)
+		if len(files) != 2 || readEnv(".env") != "VERSION=2" || readEnv("api/.env") != "API_VERSION=2" {
+			t.Errorf("Restore gave %q and %q", readEnv(".env"), readEnv("api/.env"))
+		}
+
+		// The restore saved the version it replaced
+		saved, err := store.Load(restoreSnapshot.ID())
+		testutils.AssertNoError(t, err)
+		if len(saved.Files) != 2 {
+			t.Errorf("Expected the restore to back up 2 files, got %d", len(saved.Files))
+		}
+	})
+
+	t.Run("Restore selected files of the oldest backup", func(t *testing.T) {
+		files, err := store.Restore(manifests[0].ID, []string{"./.env"}, nil)
+		testutils.AssertNoError(t, err)
+		if len(files) != 1 || readEnv(".env") != "VERSION=0" || readEnv("api/.env") != "API_VERSION=2" {
+			t.Errorf("Restore gave %q and %q", readEnv(".env"), readEnv("api/.env"))
+		}
+		if runtime.GOOS != "windows" {
+			info, err := os.Stat(filepath.Join(extractDir, ".env"))
+			testutils.AssertNoError(t, err)
+			if info.Mode().Perm() != 0640 {
+				t.Errorf("Restored permissions = %o, want 640", info.Mode().Perm())
+			}
+		}
+
+		if _, err := store.Restore(manifests[0].ID, []string{"web/.env"}, nil); err == nil {
+			t.Error("Expected restoring a file the backup does not hold to fail")
+		}
+	})
+
+	t.Run("Corrupted backup is not restored", func(t *testing.T) {
+		copyPath := filepath.Join(store.Dir(), manifests[1].ID, "files", "api", ".env")
+		testutils.AssertNoError(t, os.WriteFile(copyPath, []byte("TAMPERED=1"), 0600))
+
+		if _, err := store.Restore(manifests[1].ID, nil, nil); err == nil {
+			t.Fatal("Expected a corrupted backup to be refused")
+		}
+		if readEnv(".env") != "VERSION=0" || readEnv("api/.env") != "API_VERSION=2" {
+			t.Error("Files were changed by a refused restore")
+		}
+	})
+
+	// assertRestoreLeftNothing checks that a failed restore kept the files
+	// as they were and left no backup or staging directory behind
+	assertRestoreLeftNothing := func(t *testing.T) {
+		t.Helper()
+		if readEnv(".env") != "VERSION=0" {
+			t.Errorf("Failed restore changed .env to %q", readEnv(".env"))
+		}
+		staging, err := filepath.Glob(filepath.Join(extractDir, ".goingenv-restore-*"))
+		testutils.AssertNoError(t, err)
+		if len(staging) != 0 {
+			t.Errorf("Staging directories left behind: %v", staging)
+		}
+		all, err := store.List()
+		testutils.AssertNoError(t, err)
+		if len(all) != 4 {
+			t.Errorf("Expected the backup of a failed restore to be discarded, have %d backups", len(all))
+		}
+	}
+
+	t.Run("Symbolic link in the path is refused", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("Symbolic links need privileges on Windows")
+		}
+		outsideDir := filepath.Join(tmpDir, "outside")
+		testutils.AssertNoError(t, os.Mkdir(outsideDir, 0755))
+		apiDir := filepath.Join(extractDir, "api")
+		testutils.AssertNoError(t, os.Rename(apiDir, apiDir+".real"))
+		testutils.AssertNoError(t, os.Symlink(outsideDir, apiDir))
+		defer func() {
+			os.Remove(apiDir)
+			os.Rename(apiDir+".real", apiDir)
+		}()
+
+		_, err := store.Restore(manifests[2].ID, nil, store.NewSnapshot("restore"))
+		if err == nil || !strings.Contains(err.Error(), "symbolic link") {
+			t.Fatalf("Expected the link to be refused, got %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(outsideDir, ".env")); !os.IsNotExist(err) {
+			t.Error("Restore wrote through the symbolic link")
+		}
+		assertRestoreLeftNothing(t)
+	})
+
+	t.Run("Failed restore rolls back", func(t *testing.T) {
+		// A directory where a file is restored fails to be backed up, after
+		// .env is already in place
+		apiEnv := filepath.Join(extractDir, "api", ".env")
+		testutils.AssertNoError(t, os.Rename(apiEnv, apiEnv+".saved"))
+		testutils.AssertNoError(t, os.MkdirAll(filepath.Join(apiEnv, "sub"), 0755))
+		defer func() {
+			os.RemoveAll(apiEnv)
+			os.Rename(apiEnv+".saved", apiEnv)
+		}()
+
+		if _, err := store.Restore(manifests[2].ID, nil, store.NewSnapshot("restore")); err == nil {
+			t.Fatal("Expected the restore to fail")
+		}
+		if info, err := os.Stat(apiEnv); err != nil || !info.IsDir() {
+			t.Errorf("Rollback did not put the directory back: %v", err)
+		}
+		assertRestoreLeftNothing(t)
+	})
+
+	t.Run("Invalid backup IDs", func(t *testing.T) {
+		for _, id := range []string{"", "..", "../backups", "missing"} {
+			if _, err := store.Load(id); err == nil {
+				t.Errorf("Load(%q) should fail", id)
+			}
+		}
+	})
+
+	t.Run("Prune", func(t *testing.T) {
+		all, err := store.List()
+		testutils.AssertNoError(t, err)
+		if len(all) != 4 {
+			t.Fatalf("Expected 4 backups before pruning, got %d", len(all))
+		}
+
+		removed, err := store.Prune(2, 0, time.Now())
+		testutils.AssertNoError(t, err)
+		if len(removed) != 2 || removed[0].ID != all[0].ID || removed[1].ID != all[1].ID {
+			t.Errorf("Prune removed %+v, want the 2 oldest", removed)
+		}
+
+		removed, err = store.Prune(10, time.Hour, time.Now().Add(2*time.Hour))
+		testutils.AssertNoError(t, err)
+		if len(removed) != 2 {
+			t.Errorf("Expected the old backups to be pruned, removed %d", len(removed))
+		}
+
+		left, err := store.List()
+		testutils.AssertNoError(t, err)
+		if len(left) != 0 {
+			t.Errorf("Expected no backups left, got %d", len(left))
+		}
+	})
+}
+
+func TestUndoUnpack(t *testing.T) {
+	cryptoService := crypto.NewService()
+	archiverService := archive.NewService(cryptoService)
+	password := "undo-password"
+
+	tmpDir := t.TempDir()
+	projectDir := filepath.Join(tmpDir, "project")
+	store := backup.NewStore(filepath.Join(tmpDir, "backups"))
+	j := journal.New(filepath.Join(tmpDir, "journal"))
+
+	writeEnv := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(projectDir, filepath.FromSlash(name))
+		testutils.AssertNoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		testutils.AssertNoError(t, os.WriteFile(path, []byte(content), 0600))
+	}
+	readEnv := func(name string) string {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(projectDir, filepath.FromSlash(name)))
+		if os.IsNotExist(err) {
+			return "<missing>"
+		}
+		testutils.AssertNoError(t, err)
+		return string(data)
+	}
+
+	// unpack extracts an archive of the given files over the project,
+	// recording it in the journal
+	unpack := func(name string, files map[string]string, checksums map[string]string, withBackup bool) error {
+		t.Helper()
+		var names []string
+		for file := range files {
+			names = append(names, file)
+		}
+		sort.Strings(names)
+
+		var entries []tarEntry
+		for _, file := range names {
+			checksum := fmt.Sprintf("%x", sha256.Sum256([]byte(files[file])))
+			if override, ok := checksums[file]; ok {
+				checksum = override
+			}
+			entries = append(entries, tarEntry{
+				header:   &tar.Header{Name: file, Typeflag: tar.TypeReg, Mode: 0600},
+				body:     files[file],
+				checksum: checksum,
+			})
+		}
+		archivePath := filepath.Join(tmpDir, name)
+		writeCraftedArchive(t, cryptoService, archivePath, password, entries)
+
+		opts := types.UnpackOptions{
+			ArchivePath: archivePath,
+			Password:    secret.FromString(password),
+			TargetDir:   projectDir,
+			Overwrite:   true,
+		}
+		var snapshot *backup.Snapshot
+		if withBackup {
+			snapshot = store.NewSnapshot("unpack of " + name)
+			opts.Backup = snapshot
+		}
+		opts.Journal = j.NewUnpack(archivePath, snapshot)
+		return archiverService.Unpack(opts)
+	}
+
+	writeEnv(".env", "VERSION=0")
+	writeEnv("api/.env", "API_VERSION=0")
+
+	testutils.AssertNoError(t, unpack("first.enc", map[string]string{
+		".env":          "VERSION=1",
+		"api/.env":      "API_VERSION=1",
+		"new/deep/.env": "DEEP=1",
+	}, nil, true))
+	testutils.AssertNoError(t, unpack("second.enc", map[string]string{
+		".env": "VERSION=2",
+	}, nil, true))
+
+	t.Run("Journal records created and replaced files", func(t *testing.T) {
+		entries, err := j.List()
+		testutils.AssertNoError(t, err)
+		if len(entries) != 2 {
+			t.Fatalf("Expected 2 journal entries, got %d", len(entries))
+		}
+
+		first := entries[0]
+		if first.Backup == "" || len(first.Files) != 3 {
+			t.Fatalf("Unexpected entry: %+v", first)
+		}
+		actions := map[string]string{}
+		for _, file := range first.Files {
+			actions[file.RelativePath] = file.Action
+			if file.Action == journal.ActionReplaced && file.PreviousChecksum == "" {
+				t.Errorf("%s has no previous checksum", file.RelativePath)
+			}
+		}
+		want := map[string]string{".env": journal.ActionReplaced, "api/.env": journal.ActionReplaced, "new/deep/.env": journal.ActionCreated}
+		if !reflect.DeepEqual(actions, want) {
+			t.Errorf("Actions = %v, want %v", actions, want)
+		}
+		if !reflect.DeepEqual(first.CreatedDirs, []string{"new", "new/deep"}) {
+			t.Errorf("CreatedDirs = %v", first.CreatedDirs)
+		}
+	})
+
+	t.Run("Failed unpack leaves no journal entry", func(t *testing.T) {
+		err := unpack("corrupt.enc", map[string]string{".env": "BROKEN=1"}, map[string]string{".env": "0000"}, true)
+		if err == nil {
+			t.Fatal("Expected a checksum mismatch")
+		}
+		entries, err := j.List()
+		testutils.AssertNoError(t, err)
+		if len(entries) != 2 {
+			t.Errorf("Expected the failed unpack not to be journaled, got %d entries", len(entries))
+		}
+	})
+
+	t.Run("Undo the unpacks one by one", func(t *testing.T) {
+		last, _, err := j.Last()
+		testutils.AssertNoError(t, err)
+		if !strings.HasSuffix(last.Archive, "second.enc") {
+			t.Fatalf("Last() = %s, want the second unpack", last.Archive)
+		}
+		_, err = j.Undo(last, store, false)
+		testutils.AssertNoError(t, err)
+		if readEnv(".env") != "VERSION=1" || readEnv("api/.env") != "API_VERSION=1" {
+			t.Errorf("After the first undo: %q, %q", readEnv(".env"), readEnv("api/.env"))
+		}
+
+		last, _, err = j.Last()
+		testutils.AssertNoError(t, err)
+		if !strings.HasSuffix(last.Archive, "first.enc") {
+			t.Fatalf("Last() = %s, want the first unpack", last.Archive)
+		}
+		plan, err := j.Check(last, store)
+		testutils.AssertNoError(t, err)
+		if len(plan.Restore) != 2 || len(plan.Remove) != 1 || len(plan.Changed) != 0 {
+			t.Errorf("Unexpected plan: %+v", plan)
+		}
+
+		_, err = j.Undo(last, store, false)
+		testutils.AssertNoError(t, err)
+		if readEnv(".env") != "VERSION=0" || readEnv("api/.env") != "API_VERSION=0" || readEnv("new/deep/.env") != "<missing>" {
+			t.Errorf("After the second undo: %q, %q, %q", readEnv(".env"), readEnv("api/.env"), readEnv("new/deep/.env"))
+		}
+		if _, err := os.Stat(filepath.Join(projectDir, "new")); !os.IsNotExist(err) {
+			t.Error("Directory created by the unpack was not removed")
+		}
+
+		if _, err := j.Undo(last, store, false); err == nil {
+			t.Error("Expected undoing the same unpack twice to fail")
+		}
+		last, _, err = j.Last()
+		testutils.AssertNoError(t, err)
+		if last != nil {
+			t.Errorf("Expected nothing left to undo, got %s", last.ID)
+		}
+	})
+
+	t.Run("Changed files need force", func(t *testing.T) {
+		testutils.AssertNoError(t, unpack("third.enc", map[string]string{".env": "VERSION=3"}, nil, true))
+		writeEnv(".env", "VERSION=3_EDITED")
+
+		last, _, err := j.Last()
+		testutils.AssertNoError(t, err)
+		_, err = j.Undo(last, store, false)
+		var changed *journal.ChangedError
+		if !errors.As(err, &changed) || len(changed.Files) != 1 {
+			t.Fatalf("Expected a ChangedError, got %v", err)
+		}
+		if readEnv(".env") != "VERSION=3_EDITED" {
+			t.Error("Refused undo changed a file")
+		}
+
+		saved, err := j.Undo(last, store, true)
+		testutils.AssertNoError(t, err)
+		if readEnv(".env") != "VERSION=0" {
+			t.Errorf("After a forced undo: %q", readEnv(".env"))
+		}
+
+		// The edited version is kept in the backup the undo took
+		if saved == nil {
+			t.Fatal("Expected undo to back up the files it replaced")
+		}
+		data, err := os.ReadFile(filepath.Join(store.Dir(), saved.ID(), "files", ".env"))
+		testutils.AssertNoError(t, err)
+		if string(data) != "VERSION=3_EDITED" {
+			t.Errorf("Backup of the undo holds %q", data)
+		}
+	})
+
+	t.Run("Unpack without a backup is skipped", func(t *testing.T) {
+		testutils.AssertNoError(t, unpack("fourth.enc", map[string]string{"extra/.env": "EXTRA=1"}, nil, true))
+		testutils.AssertNoError(t, unpack("fifth.enc", map[string]string{".env": "VERSION=5"}, nil, false))
+
+		entries, err := j.List()
+		testutils.AssertNoError(t, err)
+		irreversible := entries[len(entries)-1]
+		if irreversible.Reversible() {
+			t.Fatal("Expected an unpack that replaced files without a backup to be irreversible")
+		}
+		var irreversibleErr *journal.IrreversibleError
+		if _, err := j.Undo(irreversible, store, true); !errors.As(err, &irreversibleErr) {
+			t.Errorf("Expected an IrreversibleError, got %v", err)
+		}
+		if _, err := j.Check(irreversible, store); !errors.As(err, &irreversibleErr) {
+			t.Errorf("Expected Check to report an IrreversibleError, got %v", err)
+		}
+
+		// The unpack before it can still be undone
+		last, skipped, err := j.Last()
+		testutils.AssertNoError(t, err)
+		if len(skipped) != 1 || skipped[0].ID != irreversible.ID {
+			t.Fatalf("Expected the unpack without a backup to be skipped, got %v", skipped)
+		}
+		if last == nil || !strings.HasSuffix(last.Archive, "fourth.enc") {
+			t.Fatalf("Last() = %v, want the unpack of fourth.enc", last)
+		}
+		_, err = j.Undo(last, store, false)
+		testutils.AssertNoError(t, err)
+		if readEnv("extra/.env") != "<missing>" || readEnv(".env") != "VERSION=5" {
+			t.Errorf("After undo: %q, %q", readEnv("extra/.env"), readEnv(".env"))
+		}
+
+		last, skipped, err = j.Last()
+		testutils.AssertNoError(t, err)
+		if last != nil || len(skipped) != 1 {
+			t.Errorf("Expected only the skipped unpack to be left, got %v and %d skipped", last, len(skipped))
+		}
+	})
+
+	t.Run("Unpack without a backup that only creates files can be undone", func(t *testing.T) {
+		testutils.AssertNoError(t, unpack("sixth.enc", map[string]string{"new/.env": "NEW=1"}, nil, false))
+
+		last, _, err := j.Last()
+		testutils.AssertNoError(t, err)
+		if last == nil || !strings.HasSuffix(last.Archive, "sixth.enc") {
+			t.Fatalf("Last() = %v, want the unpack of sixth.enc", last)
+		}
+		_, err = j.Undo(last, store, false)
+		testutils.AssertNoError(t, err)
+		if readEnv("new/.env") != "<missing>" {
+			t.Error("Created file was not removed")
+		}
+	})
+}
+
+// startTestAgent runs a key agent on a socket in a temporary directory and
+// stops it when the test ends
+func startTestAgent(t *testing.T, ttl time.Duration) *agent.Client {
+	t.Helper()
+
+	socketPath := filepath.Join(t.TempDir(), "agent", "agent.sock")
+	listener, err := agent.Listen(socketPath)
+	testutils.AssertNoError(t, err)
+
+	server := agent.NewServer(ttl)
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	client := agent.NewClient(socketPath)
+	if _, err := client.Status(); err != nil {
+		t.Fatalf("Agent did not answer: %v", err)
+	}
+	return client
+}
+
+func TestAgentWorkflow(t *testing.T) {
+	tmpDir := testutils.CreateTempEnvFiles(t)
+	defer os.RemoveAll(tmpDir)
+	testutils.CreateTempGoingEnvDir(t, tmpDir)
+
+	cfg := testutils.CreateTestConfig()
+	client := startTestAgent(t, agent.DefaultTTL)
+	cryptoService := crypto.NewService()
+	cryptoService.SetKeyCache(client)
+	archiverService := archive.NewService(cryptoService)
+
+	files, err := scanner.NewService(cfg).ScanFiles(types.ScanOptions{
+		RootPath: tmpDir,
+		MaxDepth: cfg.DefaultDepth,
+	})
+	testutils.AssertNoError(t, err)
+
+	archivePath := filepath.Join(tmpDir, ".goingenv", "team.enc")
+	err = archiverService.Pack(types.PackOptions{
+		Files:      files,
+		OutputPath: archivePath,
+		Password:   secret.FromString("team-password"),
+	})
+	testutils.AssertNoError(t, err)
+
+	header, err := archiverService.ReadHeader(archivePath)
+	testutils.AssertNoError(t, err)
+
+	t.Run("Nothing is cached before the first unlock", func(t *testing.T) {
+		if cryptoService.HasCachedKey(header) {
+			t.Error("Expected no cached key before the archive was opened")
+		}
+		if _, err := archiverService.List(types.ListOptions{ArchivePath: archivePath}); err == nil {
+			t.Error("Expected listing without a password to fail")
+		}
+	})
+
+	t.Run("Password unlock caches the key", func(t *testing.T) {
+		_, err := archiverService.List(types.ListOptions{ArchivePath: archivePath, Password: secret.FromString("team-password")})
+		testutils.AssertNoError(t, err)
+
+		if !cryptoService.HasCachedKey(header) {
+			t.Fatal("Expected the agent to hold a key after a password unlock")
+		}
+		status, err := client.Status()
+		testutils.AssertNoError(t, err)
+		if status.Keys != 1 {
+			t.Errorf("Expected 1 cached key, got %d", status.Keys)
+		}
+	})
+
+	t.Run("Cached key opens the archive without a password", func(t *testing.T) {
+		extractDir := filepath.Join(tmpDir, "extracted")
+		err := archiverService.Unpack(types.UnpackOptions{
+			ArchivePath: archivePath,
+			TargetDir:   extractDir,
+		})
+		testutils.AssertNoError(t, err)
+		for _, file := range files {
+			if _, err := os.Stat(filepath.Join(extractDir, file.RelativePath)); err != nil {
+				t.Errorf("Extracted file missing: %s", file.RelativePath)
+			}
+		}
+
+		// GetPassword must not prompt when the agent holds the key
+		key, err := password.GetPassword(password.Options{
+			Cached: func() bool { return cryptoService.HasCachedKey(header) },
+		})
+		testutils.AssertNoError(t, err)
+		if !key.IsEmpty() {
+			t.Error("Expected no password when the agent holds the key")
+		}
+	})
+
+	t.Run("Cached key does not open other archives", func(t *testing.T) {
+		otherPath := filepath.Join(tmpDir, ".goingenv", "other.enc")
+		err := archive.NewService(crypto.NewService()).Pack(types.PackOptions{
+			Files:      files,
+			OutputPath: otherPath,
+			Password:   secret.FromString("team-password"),
+		})
+		testutils.AssertNoError(t, err)
+
+		otherHeader, err := archiverService.ReadHeader(otherPath)
+		testutils.AssertNoError(t, err)
+		if cryptoService.HasCachedKey(otherHeader) {
+			t.Error("Expected the cached key to be tied to one archive")
+		}
+	})
+
+	t.Run("Lock wipes cached keys", func(t *testing.T) {
+		testutils.AssertNoError(t, client.Lock())
+
+		if cryptoService.HasCachedKey(header) {
+			t.Error("Expected no cached key after locking the agent")
+		}
+		if _, err := archiverService.List(types.ListOptions{ArchivePath: archivePath}); err == nil {
+			t.Error("Expected listing without a password to fail after locking the agent")
+		}
+	})
+
+	t.Run("Keys expire after the TTL", func(t *testing.T) {
+		shortLived := startTestAgent(t, 50*time.Millisecond)
+		testutils.AssertNoError(t, shortLived.PutKey("id", []byte("key material")))
+
+		key, err := shortLived.GetKey("id")
+		testutils.AssertNoError(t, err)
+		if string(key) != "key material" {
+			t.Fatalf("Expected the key back before the TTL, got %q", key)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+		key, err = shortLived.GetKey("id")
+		testutils.AssertNoError(t, err)
+		if key != nil {
+			t.Error("Expected the key to be gone after the TTL")
+		}
+	})
+
+	t.Run("Second agent on the same socket is refused", func(t *testing.T) {
+		if _, err := agent.Listen(client.Path()); err == nil {
+			t.Error("Expected listening on a socket in use to fail")
+		}
+	})
+
+	t.Run("Socket directory open to other users is refused", func(t *testing.T) {
+		dir := filepath.Dir(client.Path())
+		testutils.AssertNoError(t, os.Chmod(dir, 0755))
+		defer os.Chmod(dir, 0700)
+
+		if _, err := client.Status(); err == nil || err == agent.ErrNotRunning {
+			t.Errorf("Expected a permission error, got %v", err)
+		}
+	})
+
+	t.Run("Socket directory owned by another user is refused", func(t *testing.T) {
+		if runtime.GOOS == "windows" || os.Getuid() != 0 {
+			t.Skip("Changing the owner of a directory needs root")
+		}
+		dir := filepath.Dir(client.Path())
+		testutils.AssertNoError(t, os.Chown(dir, 65534, 65534))
+		defer os.Chown(dir, 0, 0)
+
+		if _, err := client.Status(); err == nil || !strings.Contains(err.Error(), "owned by another user") {
+			t.Errorf("Expected an ownership error, got %v", err)
+		}
+	})
+
+	t.Run("Stopped agent behaves like an empty cache", func(t *testing.T) {
+		testutils.AssertNoError(t, client.Stop())
+		time.Sleep(50 * time.Millisecond)
+
+		if _, err := client.Status(); err != agent.ErrNotRunning {
+			t.Errorf("Expected ErrNotRunning after stopping, got %v", err)
+		}
+		_, err := archiverService.List(types.ListOptions{ArchivePath: archivePath, Password: secret.FromString("team-password")})
+		testutils.AssertNoError(t, err)
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"goingenv/internal/config"
+	"goingenv/pkg/secret"
 	"goingenv/pkg/types"
 )
 
@@ -276,7 +277,7 @@ func CreateValidArchiveOptions(files []types.EnvFile, outputPath, password strin
 	return types.PackOptions{
 		Files:       files,
 		OutputPath:  outputPath,
-		Password:    password,
+		Password:    secret.FromString(password),
 		Description: "Test archive created by test suite",
 	}
 }
@@ -285,10 +286,9 @@ func CreateValidArchiveOptions(files []types.EnvFile, outputPath, password strin
 func CreateValidUnpackOptions(archivePath, password, targetDir string) types.UnpackOptions {
 	return types.UnpackOptions{
 		ArchivePath: archivePath,
-		Password:    password,
+		Password:    secret.FromString(password),
 		TargetDir:   targetDir,
 		Overwrite:   true,
-		Backup:      false,
 	}
 }
 